@@ -0,0 +1,101 @@
+package replicator
+
+import (
+	"errors"
+
+	"github.com/goydb/replicator/client"
+)
+
+// adaptiveWindowChanges is the window the shrinking starts with if
+// neither the ChangesLimit nor the changes of the failed window are known
+const adaptiveWindowChanges = 1000
+
+// adaptiveWindow shrinks the windows of changes and the bulk
+// batches after timeouts, see Config.AdaptiveWindow
+type adaptiveWindow struct {
+	limit  int // changes limit while shrunk, 0 if not shrunk
+	max    int // limit the window grows back to
+	factor int // the bulk batch sizes are divided by the factor
+}
+
+// shrink halves the window of the given number of changes,
+// false is returned if the window can't be shrunk any further
+func (w *adaptiveWindow) shrink(changes int) bool {
+	if w.limit == 0 {
+		if changes <= 0 {
+			changes = adaptiveWindowChanges
+		}
+		w.limit, w.max, w.factor = changes, changes, 1
+	}
+	if w.limit <= 1 {
+		return false
+	}
+	w.limit /= 2
+	w.factor *= 2
+	return true
+}
+
+// grow doubles the window after it was replicated successfully
+func (w *adaptiveWindow) grow() {
+	if w.limit == 0 {
+		return
+	}
+	w.limit *= 2
+	w.factor /= 2
+	if w.limit >= w.max || w.factor <= 1 {
+		*w = adaptiveWindow{}
+	}
+}
+
+// shrinkWindow shrinks the window if it failed with a timeout and
+// AdaptiveWindow is enabled, true is returned if the window is retried
+func (r *Replicator) shrinkWindow(err error) bool {
+	if !r.job.AdaptiveWindow || !errors.Is(err, client.ErrTimeout) {
+		return false
+	}
+
+	changes := r.windowChanges
+	if r.job.ChangesLimit > 0 && (changes == 0 || changes > r.job.ChangesLimit) {
+		changes = r.job.ChangesLimit
+	}
+	if !r.adaptive.shrink(changes) {
+		return false
+	}
+
+	r.logger.Warningf("Window timed out, retrying with %d changes: %v", r.adaptive.limit, err)
+	r.result.WindowsShrunk++
+	return true
+}
+
+// changesLimit returns the limit of the changes of the window
+func (r *Replicator) changesLimit() int {
+	if r.adaptive.limit > 0 {
+		return r.rangeLimit(r.adaptive.limit)
+	}
+	return r.rangeLimit(r.job.ChangesLimit)
+}
+
+// bulkGetBatchSize returns the documents fetched with one _bulk_get
+func (r *Replicator) bulkGetBatchSize() int {
+	size := r.job.BulkGetBatchSizeOrFallback()
+	if r.adaptive.factor > 1 {
+		size /= r.adaptive.factor
+	}
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
+// batchFull returns true if the stack should be written to the
+// target, the batch is smaller while the window is shrunk
+func (r *Replicator) batchFull(stack client.Stack) bool {
+	if r.adaptive.factor <= 1 {
+		return r.job.batchFull(stack)
+	}
+	factor := r.adaptive.factor
+	if r.job.BatchDocLimit > 0 && len(stack) >= r.job.BatchDocLimit/factor {
+		return true
+	}
+	return stack.Size() >= r.job.BatchSizeBytesOrFallback()/int64(factor)
+}
@@ -0,0 +1,131 @@
+package replicator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/goydb/replicator/client"
+)
+
+// AncestryStrategy defines how much of the replication logs is trusted
+type AncestryStrategy string
+
+const (
+	// AncestryDefault uses the last session and falls back to the latest
+	// common session in the history, as described by the protocol
+	AncestryDefault AncestryStrategy = ""
+	// AncestryLastSession only trusts the last session, if it doesn't
+	// match a full replication is started
+	AncestryLastSession AncestryStrategy = "last_session"
+)
+
+// AncestryReason explains the ancestry decision
+type AncestryReason string
+
+const (
+	AncestryNoLog               AncestryReason = "no replication log"
+	AncestryForced              AncestryReason = "forced full replication"
+	AncestrySinceSeq            AncestryReason = "since_seq of the job"
+	AncestrySessionMatch        AncestryReason = "last session matches"
+	AncestryHistoryMatch        AncestryReason = "common session in history"
+	AncestryInsufficientHistory AncestryReason = "not enough common sessions in history"
+	AncestryNoCommonHistory     AncestryReason = "no common history"
+)
+
+// Ancestry is the decision where the replication starts
+type Ancestry struct {
+	Reason AncestryReason
+	// Seq the replication starts since, NoVersion for full replication
+	Seq string
+	// SessionID of the common session, if any
+	SessionID string
+	// CommonSessions number of sessions found in both histories
+	CommonSessions int
+}
+
+// Full returns true if the replication starts from the beginning
+func (a Ancestry) Full() bool {
+	return a.Seq == NoVersion
+}
+
+func (a Ancestry) String() string {
+	if a.SessionID == "" {
+		return fmt.Sprintf("%s (since %q)", a.Reason, a.Seq)
+	}
+	return fmt.Sprintf("%s (since %q, session %q)", a.Reason, a.Seq, a.SessionID)
+}
+
+// 2.4.2.3.3. Compare Replication Logs
+func (r *Replicator) CompareReplicationLogs(ctx context.Context, source, target *client.ReplicationLog) (Ancestry, error) {
+	// 	If the Replication Logs are successfully retrieved from both Source and Target then the Replicator MUST determine their common ancestry by following the next algorithm:
+	if source == nil || target == nil || source.SessionID == "" || target.SessionID == "" {
+		return Ancestry{Reason: AncestryNoLog, Seq: NoVersion}, nil
+	}
+
+	//     Compare session_id values for the chronological last session - if they match both Source and Target have a common Replication history and it seems to be valid. Use 	source_last_seq value for the startup Checkpoint
+	if source.SessionID == target.SessionID && source.SourceLastSeq != "" {
+		return Ancestry{
+			Reason:    AncestrySessionMatch,
+			Seq:       source.SourceLastSeq,
+			SessionID: source.SessionID,
+		}, nil
+	}
+
+	if r.job.AncestryStrategy == AncestryLastSession {
+		return Ancestry{Reason: AncestryNoCommonHistory, Seq: NoVersion}, nil
+	}
+
+	//     In case of mismatch, iterate over the history collection to search for the latest (chronologically) common session_id for Source and Target. Use value of recorded_seq field as startup Checkpoint
+	targetSessions := make(map[string]bool, len(target.History))
+	for _, tl := range target.History {
+		targetSessions[tl.SessionID] = true
+	}
+
+	var common *client.History
+	var commonSessions int
+	for _, sl := range source.History {
+		if !targetSessions[sl.SessionID] || sl.RecordedSeq == "" {
+			continue
+		}
+		if common == nil {
+			common = sl
+		}
+		commonSessions++
+	}
+
+	// If Source and Target has no common ancestry, the Replicator MUST run Full Replication.
+	if common == nil {
+		return Ancestry{Reason: AncestryNoCommonHistory, Seq: NoVersion}, nil
+	}
+
+	minSessions := r.job.AncestryMinSessions
+	if minSessions < 1 {
+		minSessions = 1
+	}
+	if commonSessions < minSessions {
+		return Ancestry{
+			Reason:         AncestryInsufficientHistory,
+			Seq:            NoVersion,
+			CommonSessions: commonSessions,
+		}, nil
+	}
+
+	return Ancestry{
+		Reason:         AncestryHistoryMatch,
+		Seq:            common.RecordedSeq,
+		SessionID:      common.SessionID,
+		CommonSessions: commonSessions,
+	}, nil
+}
+
+// newSessionID returns a random id for the replication session
+func newSessionID() string {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,68 @@
+package replicator_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareReplicationLogs(t *testing.T) {
+	ctx := context.Background()
+	newReplicator := func(config replicator.Config) *replicator.Replicator {
+		r, err := replicator.NewReplicator("test", &replicator.Job{
+			Source: &client.Remote{URL: "http://localhost:5984/source/"},
+			Target: &client.Remote{URL: "http://localhost:5984/target/"},
+			Config: config,
+		})
+		assert.NoError(t, err)
+		return r
+	}
+
+	source := &client.ReplicationLog{
+		SessionID:     "c",
+		SourceLastSeq: "30",
+		History: []*client.History{
+			{SessionID: "c", RecordedSeq: "30"},
+			{SessionID: "b", RecordedSeq: "20"},
+			{SessionID: "a", RecordedSeq: "10"},
+		},
+	}
+	target := &client.ReplicationLog{
+		SessionID:     "x",
+		SourceLastSeq: "25",
+		History: []*client.History{
+			{SessionID: "x", RecordedSeq: "25"},
+			{SessionID: "b", RecordedSeq: "20"},
+			{SessionID: "a", RecordedSeq: "10"},
+		},
+	}
+
+	a, err := newReplicator(replicator.Config{}).CompareReplicationLogs(ctx, source, source)
+	assert.NoError(t, err)
+	assert.Equal(t, replicator.AncestrySessionMatch, a.Reason)
+	assert.Equal(t, "30", a.Seq)
+
+	a, err = newReplicator(replicator.Config{}).CompareReplicationLogs(ctx, source, target)
+	assert.NoError(t, err)
+	assert.Equal(t, replicator.AncestryHistoryMatch, a.Reason)
+	assert.Equal(t, "20", a.Seq)
+	assert.Equal(t, "b", a.SessionID)
+	assert.Equal(t, 2, a.CommonSessions)
+
+	a, err = newReplicator(replicator.Config{AncestryMinSessions: 3}).CompareReplicationLogs(ctx, source, target)
+	assert.NoError(t, err)
+	assert.Equal(t, replicator.AncestryInsufficientHistory, a.Reason)
+	assert.True(t, a.Full())
+
+	a, err = newReplicator(replicator.Config{AncestryStrategy: replicator.AncestryLastSession}).CompareReplicationLogs(ctx, source, target)
+	assert.NoError(t, err)
+	assert.Equal(t, replicator.AncestryNoCommonHistory, a.Reason)
+	assert.True(t, a.Full())
+
+	a, err = newReplicator(replicator.Config{}).CompareReplicationLogs(ctx, new(client.ReplicationLog), target)
+	assert.NoError(t, err)
+	assert.Equal(t, replicator.AncestryNoLog, a.Reason)
+}
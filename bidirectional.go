@@ -0,0 +1,199 @@
+package replicator
+
+import (
+	"context"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/logger"
+)
+
+// Peer is a database that is source and target, e.g. one
+// side of a bidirectional replication
+type Peer interface {
+	Source
+	Target
+}
+
+// Bidirectional syncs two databases with a pair of replications, Push
+// replicates the source of the job to its target and Pull the target
+// back to the source. Both directions run concurrently and share the
+// options of the job, a filter or selector has to work on both sides.
+type Bidirectional struct {
+	Push *Replicator
+	Pull *Replicator
+}
+
+// reverse returns a copy of the job replicating the target to the
+// source, a start sequence of the source doesn't apply to the target
+func (j *Job) reverse() *Job {
+	r := *j
+	r.Source, r.Target = j.Target, j.Source
+	if r.SinceSeq != client.SinceNow {
+		r.SinceSeq = ""
+	}
+	return &r
+}
+
+// NewBidirectional creates the replications from the source of the job
+// to its target and back
+func NewBidirectional(name string, job *Job) (*Bidirectional, error) {
+	// both directions share the rate limiters of the profile
+	job, err := job.withProfile()
+	if err != nil {
+		return nil, err
+	}
+	push, err := newClientReplicator(name, job)
+	if err != nil {
+		return nil, err
+	}
+	pull, err := newClientReplicator(name, job.reverse())
+	if err != nil {
+		return nil, err
+	}
+	return &Bidirectional{Push: push, Pull: pull}, nil
+}
+
+// NewBidirectionalWithPeers creates the replications between the
+// given peers, a is the source and b the target of the job
+func NewBidirectionalWithPeers(name string, job *Job, a, b Peer) (*Bidirectional, error) {
+	job, err := job.withProfile()
+	if err != nil {
+		return nil, err
+	}
+	push, err := newReplicator(name, job, a, b)
+	if err != nil {
+		return nil, err
+	}
+	pull, err := newReplicator(name, job.reverse(), b, a)
+	if err != nil {
+		return nil, err
+	}
+	return &Bidirectional{Push: push, Pull: pull}, nil
+}
+
+func (b *Bidirectional) replicators() []*Replicator {
+	return []*Replicator{b.Push, b.Pull}
+}
+
+// SetLogger sets the logger of both directions, the entries
+// have a "direction" field (push or pull)
+func (b *Bidirectional) SetLogger(l logger.Logger) {
+	b.Push.SetLogger(l.With("direction", "push"))
+	b.Pull.SetLogger(l.With("direction", "pull"))
+}
+
+// SetHooks sets the hooks of both directions, conflicts
+// of either direction are reported to the same hooks
+func (b *Bidirectional) SetHooks(hooks Hooks) {
+	for _, r := range b.replicators() {
+		r.SetHooks(hooks)
+	}
+}
+
+// Run runs both replications until they completed (one-shot) or the
+// context is done (continuous). If one direction fails the other is
+// stopped and the error of the failed one returned.
+func (b *Bidirectional) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	for _, r := range b.replicators() {
+		go func(r *Replicator) {
+			err := r.Run(ctx)
+			errs <- err
+			if err != nil {
+				cancel()
+			}
+		}(r)
+	}
+
+	var first error
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Reset removes the replication logs of both directions
+func (b *Bidirectional) Reset(ctx context.Context) error {
+	for _, r := range b.replicators() {
+		err := r.Reset(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel requests a graceful stop of both replications
+func (b *Bidirectional) Cancel() {
+	for _, r := range b.replicators() {
+		r.Cancel()
+	}
+}
+
+// Stop gracefully stops both replications and waits until they returned
+func (b *Bidirectional) Stop(ctx context.Context) (Result, error) {
+	b.Cancel()
+	for _, r := range b.replicators() {
+		_, err := r.Stop(ctx)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	return b.Result(), nil
+}
+
+// Result combines the results of both directions, the
+// checkpoint and timing are the ones of the push direction
+func (b *Bidirectional) Result() Result {
+	res := b.Push.Result()
+	pull := b.Pull.Result()
+
+	res.Stopped = res.Stopped || pull.Stopped
+	res.DocsSkipped += pull.DocsSkipped
+	res.Skipped = append(res.Skipped, pull.Skipped...)
+	res.AttachmentsSkipped += pull.AttachmentsSkipped
+	res.ConflictsFound += pull.ConflictsFound
+	res.Conflicts = append(res.Conflicts, pull.Conflicts...)
+	res.ConflictsResolved += pull.ConflictsResolved
+	res.DocsMissing += pull.DocsMissing
+	res.EstimatedBytes += pull.EstimatedBytes
+	res.Checkpoint.Failures += pull.Checkpoint.Failures
+	if res.Checkpoint.Err == nil {
+		res.Checkpoint.Err = pull.Checkpoint.Err
+	}
+	return res
+}
+
+// Stats combines the progress of both directions, the
+// sequences are the ones of the push direction
+func (b *Bidirectional) Stats() Stats {
+	s := b.Push.Stats()
+	pull := b.Pull.Stats()
+
+	s.DocsRead += pull.DocsRead
+	s.DocsWritten += pull.DocsWritten
+	s.DocWriteFailures += pull.DocWriteFailures
+	s.MissingChecked += pull.MissingChecked
+	s.MissingFound += pull.MissingFound
+	s.DocsPending += pull.DocsPending
+	s.DocsVerified += pull.DocsVerified
+	s.DocsDivergent += pull.DocsDivergent
+	s.CheckpointFailures += pull.CheckpointFailures
+	s.BytesRead += pull.BytesRead
+	s.BytesWritten += pull.BytesWritten
+	s.DocsReadPerSec += pull.DocsReadPerSec
+	s.DocsWrittenPerSec += pull.DocsWrittenPerSec
+	s.BytesReadPerSec += pull.BytesReadPerSec
+	s.BytesWrittenPerSec += pull.BytesWrittenPerSec
+	return s
+}
+
+func (b *Bidirectional) continuous() bool {
+	return b.Push.continuous()
+}
@@ -0,0 +1,73 @@
+package replicator_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/memdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBidirectional(t *testing.T) {
+	a, b := memdb.New("a"), memdb.New("b")
+	_, err := a.Put("x", map[string]interface{}{"side": "a"})
+	assert.NoError(t, err)
+	_, err = b.Put("y", map[string]interface{}{"side": "b"})
+	assert.NoError(t, err)
+
+	job := &replicator.Job{
+		Source:        &client.Remote{URL: "mem://a"},
+		Target:        &client.Remote{URL: "mem://b"},
+		Bidirectional: true,
+	}
+	r, err := replicator.NewBidirectionalWithPeers("mem", job, a, b)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, r.Run(ctx))
+
+	for _, db := range []*memdb.DB{a, b} {
+		for _, id := range []string{"x", "y"} {
+			_, err := db.Get(id)
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, 2, r.Stats().DocsWritten)
+}
+
+func TestBidirectionalSinceSeq(t *testing.T) {
+	a, b := memdb.New("a"), memdb.New("b")
+	for _, id := range []string{"x1", "x2"} {
+		_, err := a.Put(id, map[string]interface{}{"side": "a"})
+		assert.NoError(t, err)
+	}
+	for _, id := range []string{"y1", "y2"} {
+		_, err := b.Put(id, map[string]interface{}{"side": "b"})
+		assert.NoError(t, err)
+	}
+
+	// the start sequence only applies to the source
+	job := &replicator.Job{
+		Source:        &client.Remote{URL: "mem://a"},
+		Target:        &client.Remote{URL: "mem://b"},
+		Bidirectional: true,
+		SinceSeq:      "1",
+	}
+	r, err := replicator.NewBidirectionalWithPeers("mem", job, a, b)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, r.Run(ctx))
+
+	_, err = b.Get("x1")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	for _, id := range []string{"x2", "y1", "y2"} {
+		_, err := a.Get(id)
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,261 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// seqTracker follows which changes of a batch are replicated, to find
+// the sequence an intermediate checkpoint can safely be recorded at
+type seqTracker struct {
+	changes []client.Results
+	pending map[string]int    // revisions (or chunks) to replicate per document
+	seqs    map[string]string // sequence of the last change of a document
+}
+
+func newSeqTracker(changes []client.Results, diff client.DiffResponse) *seqTracker {
+	t := &seqTracker{
+		changes: changes,
+		pending: make(map[string]int, len(diff)),
+		seqs:    make(map[string]string, len(changes)),
+	}
+	for docID := range diff {
+		t.pending[docID] = 1
+	}
+	for _, change := range changes {
+		if change.Seq != "" {
+			t.seqs[change.ID] = change.Seq
+		}
+	}
+	return t
+}
+
+// seqOf returns the sequence of the change the document came from,
+// empty if unknown (seq_interval or not part of the changes)
+func (t *seqTracker) seqOf(docID string) string {
+	if t == nil {
+		return ""
+	}
+	return t.seqs[docID]
+}
+
+// expect sets the number of revisions the pending document is
+// replicated in, every one is marked done separately
+func (t *seqTracker) expect(docID string, n int) {
+	if t == nil || t.pending[docID] == 0 {
+		return
+	}
+	t.pending[docID] = n
+}
+
+// done marks the document (or one of its expected revisions)
+// as replicated (written or skipped)
+func (t *seqTracker) done(docID string) {
+	if t == nil {
+		return
+	}
+	t.pending[docID]--
+	if t.pending[docID] <= 0 {
+		delete(t.pending, docID)
+	}
+}
+
+// remaining returns the number of pending documents
+func (t *seqTracker) remaining() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.pending)
+}
+
+// seq returns the sequence of the last change before the first pending
+// document, an empty string if no change is replicated completely.
+// Changes without sequence (seq_interval) keep the previous one.
+func (t *seqTracker) seq() string {
+	if t == nil {
+		return ""
+	}
+	var seq string
+	for _, change := range t.changes {
+		if t.pending[change.ID] > 0 {
+			break
+		}
+		if change.Seq != "" {
+			seq = change.Seq
+		}
+	}
+	return seq
+}
+
+// order returns the pending documents in the order of the changes feed
+func (t *seqTracker) order() []string {
+	ids := make([]string, 0, len(t.pending))
+	seen := make(map[string]bool, len(t.pending))
+	for _, change := range t.changes {
+		if t.pending[change.ID] > 0 && !seen[change.ID] {
+			seen[change.ID] = true
+			ids = append(ids, change.ID)
+		}
+	}
+
+	// documents without change are appended sorted
+	var rest []string
+	for docID := range t.pending {
+		if !seen[docID] {
+			rest = append(rest, docID)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(ids, rest...)
+}
+
+// checkpointDue returns true if an intermediate checkpoint
+// should be recorded based on the CheckpointInterval
+// and CheckpointDocs of the job
+func (r *Replicator) checkpointDue(now time.Time) bool {
+	if interval := r.job.checkpointInterval(); interval > 0 && now.Sub(r.lastCheckpoint) >= interval {
+		return true
+	}
+	if r.job.CheckpointDocs > 0 && r.currentHistory.DocsWritten-r.checkpointDocs >= r.job.CheckpointDocs {
+		return true
+	}
+	return false
+}
+
+// intermediateCheckpoint writes the stacked documents and records a
+// checkpoint at the sequence up to which all changes are replicated
+func (r *Replicator) intermediateCheckpoint(ctx context.Context, stack *client.Stack) error {
+	if !r.checkpointDue(time.Now()) {
+		return nil
+	}
+
+	if len(*stack) > 0 {
+		err := r.replicateChangesBulk(ctx, *stack)
+		if err != nil {
+			return err
+		}
+		*stack = nil
+	}
+
+	seq := r.tracker.seq()
+	if seq == "" || seq == r.checkpointSeq {
+		return nil
+	}
+
+	r.logger.Debugf("Intermediate checkpoint at %q", seq)
+	return r.checkpoint(ctx, seq)
+}
+
+// partialCheckpointTimeout limits the checkpoint recorded after the
+// replication failed, the context of the run may be done already
+const partialCheckpointTimeout = 10 * time.Second
+
+// partialCheckpoint records a checkpoint at the sequence up to which
+// the changes of the failed batch were written, so the next run doesn't
+// replicate them again. The cause is returned in any case.
+func (r *Replicator) partialCheckpoint(cause error) error {
+	seq := r.tracker.seq()
+	if seq == "" || seq == r.checkpointSeq {
+		return cause
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), partialCheckpointTimeout)
+	defer cancel()
+
+	r.logger.Infof("Replication failed, recording a checkpoint at %q", seq)
+	err := r.checkpoint(ctx, seq)
+	if err != nil {
+		r.logger.Warningf("Recording the checkpoint at %q failed: %v", seq, err)
+	}
+	return cause
+}
+
+// checkpoint records the sequence on the source and target
+func (r *Replicator) checkpoint(ctx context.Context, seq string) (err error) {
+	ctx, end := r.tracer.Start(ctx, "Checkpoint")
+	defer func() { end(err) }()
+
+	start := time.Now()
+	defer func() { r.window.Checkpoint += time.Since(start) }()
+
+	r.currentHistory.EndLastSeq = seq
+	r.currentHistory.RecordedSeq = seq
+	r.currentHistory.EndTime = time.Now()
+
+	// buffering sinks have to persist the documents first
+	if cp, ok := r.job.Sink.(SinkCheckpointer); ok && r.target == nil {
+		err := cp.Checkpoint(ctx, seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	// without checkpoints the progress is only kept in memory
+	recorded := r.job.useCheckpoints()
+	if recorded {
+		err = r.recordSourceCheckpoint(ctx, seq)
+		if err == nil && r.target != nil {
+			err = r.recordReplicationCheckpoint(ctx, r.target, r.targetRepLog, seq)
+		}
+		if err != nil {
+			return r.checkpointFailed(seq, err)
+		}
+	}
+
+	r.checkpointSeq = seq
+	r.lastCheckpoint = time.Now()
+	r.checkpointDocs = r.currentHistory.DocsWritten
+	r.updateStats(true)
+	r.checkpointed(seq)
+	if recorded {
+		r.result.Checkpoint.Seq = seq
+		r.result.Checkpoint.Time = r.lastCheckpoint
+		r.result.Checkpoint.Err = nil
+		r.hooks.OnCheckpoint(seq)
+	}
+	return nil
+}
+
+// recordSourceCheckpoint records the checkpoint on the source, with
+// SourceReadOnly a refused write is tolerated and the following
+// checkpoints of the run are only recorded on the target
+func (r *Replicator) recordSourceCheckpoint(ctx context.Context, seq string) error {
+	if r.sourceRefused {
+		return nil
+	}
+	err := r.recordReplicationCheckpoint(ctx, r.source, r.sourceRepLog, seq)
+	refused := errors.Is(err, client.ErrUnauthorized) || errors.Is(err, client.ErrForbidden)
+	if refused && r.job.SourceReadOnly && r.target != nil {
+		r.logger.Infof("Source refused the checkpoint, recording the checkpoints on the target only: %v", err)
+		r.sourceRefused = true
+		return nil
+	}
+	return err
+}
+
+// checkpointFailed reports the failed checkpoint write, depending on the
+// CheckpointPolicy the replication fails or continues. The documents are
+// replicated, a restarted replication starts at the previous checkpoint.
+func (r *Replicator) checkpointFailed(seq string, err error) error {
+	r.result.Checkpoint.Failures++
+	r.result.Checkpoint.Err = err
+	r.stats.update(func(s *stats) {
+		s.checkpointFailures++
+	})
+	r.hooks.OnCheckpointError(seq, err)
+
+	if r.job.CheckpointPolicy != CheckpointWarn {
+		return err
+	}
+
+	r.logger.Warningf("Recording the checkpoint at %q failed, continuing: %v", seq, err)
+	// try again with the next checkpoint
+	r.lastCheckpoint = time.Now()
+	r.checkpointDocs = r.currentHistory.DocsWritten
+	return nil
+}
@@ -0,0 +1,54 @@
+package replicator
+
+import (
+	"testing"
+
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeqTracker(t *testing.T) {
+	changes := []client.Results{
+		{Seq: "1", ID: "a"},
+		{Seq: "2", ID: "b"},
+		{Seq: "3", ID: "c"},
+		{Seq: "4", ID: "d"},
+	}
+	tracker := newSeqTracker(changes, client.DiffResponse{
+		"a": &client.Diff{},
+		"c": &client.Diff{},
+		"d": &client.Diff{},
+	})
+
+	assert.Equal(t, []string{"a", "c", "d"}, tracker.order())
+	assert.Equal(t, "", tracker.seq())
+	assert.Equal(t, "3", tracker.seqOf("c"))
+
+	// b needs no replication
+	tracker.done("a")
+	assert.Equal(t, "2", tracker.seq())
+
+	// d is written before c
+	tracker.done("d")
+	assert.Equal(t, "2", tracker.seq())
+
+	tracker.done("c")
+	assert.Equal(t, "4", tracker.seq())
+	assert.Empty(t, tracker.order())
+}
+
+func TestSeqTrackerSeqInterval(t *testing.T) {
+	// with seq_interval only every nth change has a sequence
+	changes := []client.Results{
+		{Seq: "1", ID: "a"},
+		{ID: "b"},
+		{ID: "c"},
+	}
+	tracker := newSeqTracker(changes, client.DiffResponse{
+		"b": &client.Diff{},
+		"c": &client.Diff{},
+	})
+
+	tracker.done("b")
+	assert.Equal(t, "1", tracker.seq())
+}
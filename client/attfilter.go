@@ -0,0 +1,108 @@
+package client
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+)
+
+// AttachmentFilter selects the attachments that are replicated by their
+// name or content type, e.g. Deny "*.mp4" or AllowContentTypes "image/*".
+// Patterns use the path.Match syntax and are case insensitive. Filtered
+// attachments are discarded and removed from the document, stubs
+// included.
+type AttachmentFilter struct {
+	// Allow and AllowContentTypes replicate only the matching
+	// attachments, all attachments if both are empty
+	Allow             []string `json:"allow,omitempty"`
+	AllowContentTypes []string `json:"allow_content_types,omitempty"`
+
+	// Deny and DenyContentTypes never replicate the matching
+	// attachments, even if allowed
+	Deny             []string `json:"deny,omitempty"`
+	DenyContentTypes []string `json:"deny_content_types,omitempty"`
+}
+
+// Validate checks the syntax of the patterns
+func (f *AttachmentFilter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	for _, patterns := range [][]string{f.Allow, f.AllowContentTypes, f.Deny, f.DenyContentTypes} {
+		for _, pattern := range patterns {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return fmt.Errorf("invalid attachment pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Match returns true if the attachment is replicated,
+// a nil filter replicates all attachments
+func (f *AttachmentFilter) Match(name, contentType string) bool {
+	if f == nil {
+		return true
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+
+	if matchAny(f.Deny, name) || matchAny(f.DenyContentTypes, contentType) {
+		return false
+	}
+	if len(f.Allow) == 0 && len(f.AllowContentTypes) == 0 {
+		return true
+	}
+	return matchAny(f.Allow, name) || matchAny(f.AllowContentTypes, contentType)
+}
+
+func matchAny(patterns []string, s string) bool {
+	s = strings.ToLower(s)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAttachments removes the attachments rejected by the
+// AttachmentFilter of the options from the document data
+func (d *CompleteDoc) filterAttachments() {
+	atts, ok := d.Data["_attachments"].(map[string]interface{})
+	if !ok || d.opts.AttachmentFilter == nil {
+		return
+	}
+	for name, att := range atts {
+		meta, _ := att.(map[string]interface{})
+		contentType, _ := meta["content_type"].(string)
+		if !d.opts.AttachmentFilter.Match(name, contentType) {
+			delete(atts, name)
+			d.filtered = append(d.filtered, name)
+		}
+	}
+	sort.Strings(d.filtered)
+	if len(atts) == 0 {
+		delete(d.Data, "_attachments")
+	}
+}
+
+// FilteredAttachments returns the names of the attachments
+// that were removed by the AttachmentFilter
+func (d *CompleteDoc) FilteredAttachments() []string {
+	return d.filtered
+}
+
+// isFiltered returns true if the attachment was removed
+func (d *CompleteDoc) isFiltered(name string) bool {
+	for _, filtered := range d.filtered {
+		if filtered == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"compress/gzip"
+	"crypto/md5" // nolint: gosec
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// AttachmentProcessor rewrites the data of an attachment while the
+// document is read, e.g. to recompress images or strip EXIF data. It
+// reads the (decompressed) data from r and writes the new data to w,
+// the returned content type replaces the one of the attachment if set.
+type AttachmentProcessor interface {
+	Process(name, contentType string, r io.Reader, w io.Writer) (string, error)
+}
+
+// AttachmentProcessorFunc is a function used as AttachmentProcessor
+type AttachmentProcessorFunc func(name, contentType string, r io.Reader, w io.Writer) (string, error)
+
+func (f AttachmentProcessorFunc) Process(name, contentType string, r io.Reader, w io.Writer) (string, error) {
+	return f(name, contentType, r, w)
+}
+
+// AttachmentProcessors are the processors by content type, either the
+// exact media type or a path.Match pattern like "image/*"
+type AttachmentProcessors map[string]AttachmentProcessor
+
+// lookup returns the processor of the content type, exact
+// matches take precedence over the patterns
+func (p AttachmentProcessors) lookup(contentType string) AttachmentProcessor {
+	if len(p) == 0 {
+		return nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	if processor, ok := p[contentType]; ok {
+		return processor
+	}
+
+	patterns := make([]string, 0, len(p))
+	for pattern := range p {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, contentType); ok {
+			return p[pattern]
+		}
+	}
+	return nil
+}
+
+// attachmentMeta returns the name and the metadata of the
+// attachment of the part in the document
+func (d *CompleteDoc) attachmentMeta(part *multipart.Part) (string, map[string]interface{}) {
+	matches := dispositionFilename.FindStringSubmatch(part.Header.Get("Content-Disposition"))
+	if len(matches) != 2 {
+		return "", nil
+	}
+	atts, _ := d.Data["_attachments"].(map[string]interface{})
+	meta, _ := atts[matches[1]].(map[string]interface{})
+	return matches[1], meta
+}
+
+// processAttachment passes the attachment data through the processor
+// and updates the metadata (content type, length and digest) of the
+// attachment in the document and the part header
+func (d *CompleteDoc) processAttachment(part *multipart.Part, r io.Reader, processor AttachmentProcessor, name string, meta map[string]interface{}) (attachmentMultipartData, error) {
+	contentType, _ := meta["content_type"].(string)
+	if contentType == "" {
+		contentType = part.Header.Get("Content-Type")
+	}
+
+	// processors get the decompressed data, the result isn't compressed
+	if meta["encoding"] == "gzip" || part.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return attachmentMultipartData{Part: part}, fmt.Errorf("unable to decompress attachment %q: %w", name, err)
+		}
+		r = gz
+	}
+
+	pr, pw := io.Pipe()
+	result := make(chan string, 1)
+	go func() {
+		newType, err := processor.Process(name, contentType, r, pw)
+		result <- newType
+		pw.CloseWithError(err) // nolint: errcheck
+	}()
+
+	hash := md5.New() // nolint: gosec
+	attachment, err := d.bufferAttachment(part, io.TeeReader(pr, hash))
+	pr.CloseWithError(io.ErrClosedPipe) // nolint: errcheck, stops the processor on error
+	newType := <-result
+	if err != nil {
+		return attachment, fmt.Errorf("processing attachment %q failed: %w", name, err)
+	}
+
+	if newType != "" {
+		contentType = newType
+	}
+	digest := "md5-" + base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	meta["content_type"] = contentType
+	meta["length"] = float64(attachment.Size)
+	meta["digest"] = digest
+	delete(meta, "encoding")
+	delete(meta, "encoded_length")
+
+	part.Header.Set("Content-Type", contentType)
+	part.Header.Del("Content-Encoding")
+	part.Header.Del("Content-MD5")
+	if part.Header.Get("Content-Length") != "" {
+		part.Header.Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	}
+
+	return attachment, nil
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Auth applies credentials to the requests of a client
+type Auth interface {
+	// Authenticate adds the credentials to the request, the http client
+	// and server (database) url can be used to acquire a session
+	Authenticate(ctx context.Context, hc *http.Client, server *url.URL, req *http.Request) error
+	// Update is called with every response, it returns true if the
+	// credentials were rejected and the request should be retried
+	// with renewed credentials
+	Update(resp *http.Response) bool
+}
+
+// BasicAuth sends the credentials with every request
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a *BasicAuth) Authenticate(ctx context.Context, hc *http.Client, server *url.URL, req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+func (a *BasicAuth) Update(resp *http.Response) bool {
+	return false
+}
+
+// AuthSessionCookie is the name of the CouchDB session cookie
+const AuthSessionCookie = "AuthSession"
+
+// CookieAuth uses the CouchDB session (POST /_session), the session
+// is renewed if CouchDB refreshes the cookie or rejects it
+type CookieAuth struct {
+	Username string
+	Password string
+	// SessionURL overrides the session endpoint, by default
+	// /_session on the host of the database is used
+	SessionURL string
+
+	mu     sync.Mutex
+	cookie *http.Cookie
+}
+
+func (a *CookieAuth) Authenticate(ctx context.Context, hc *http.Client, server *url.URL, req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cookie == nil {
+		err := a.login(ctx, hc, server)
+		if err != nil {
+			return err
+		}
+	}
+
+	req.Header.Del("Cookie")
+	req.AddCookie(a.cookie)
+
+	return nil
+}
+
+func (a *CookieAuth) Update(resp *http.Response) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// session expired, login again on retry
+		retry := a.cookie != nil
+		a.cookie = nil
+		return retry
+	}
+
+	// CouchDB sends a fresh cookie before the session times out
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == AuthSessionCookie && cookie.Value != "" {
+			a.cookie = &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+		}
+	}
+
+	return false
+}
+
+func (a *CookieAuth) login(ctx context.Context, hc *http.Client, server *url.URL) error {
+	u := a.SessionURL
+	if u == "" {
+		u = server.ResolveReference(&url.URL{Path: "/_session"}).String()
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"name":     a.Username,
+		"password": a.Password,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError("session", resp)
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == AuthSessionCookie {
+			a.cookie = &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("session request failed: no %s cookie", AuthSessionCookie)
+}
+
+// JWTAuth sends a JWT as bearer token with every request
+type JWTAuth struct {
+	// Token returns the token, it is called for every
+	// request and can be used to refresh expired tokens
+	Token func(ctx context.Context) (string, error)
+}
+
+// NewJWTAuth returns an authentication using the static token
+func NewJWTAuth(token string) *JWTAuth {
+	return &JWTAuth{Token: func(ctx context.Context) (string, error) {
+		return token, nil
+	}}
+}
+
+func (a *JWTAuth) Authenticate(ctx context.Context, hc *http.Client, server *url.URL, req *http.Request) error {
+	token, err := a.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("jwt token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *JWTAuth) Update(resp *http.Response) bool {
+	return false
+}
+
+// ProxyAuth authenticates as the user with the roles by CouchDB proxy
+// authentication, e.g. to write as a user with the _admin role. The
+// token is the HMAC-SHA256 of the username with the secret of the
+// server (CouchDB 3.3+), it is omitted without secret.
+type ProxyAuth struct {
+	Username string
+	Roles    []string
+	Secret   string
+}
+
+func (a *ProxyAuth) Authenticate(ctx context.Context, hc *http.Client, server *url.URL, req *http.Request) error {
+	req.Header.Set("X-Auth-CouchDB-UserName", a.Username)
+	req.Header.Set("X-Auth-CouchDB-Roles", strings.Join(a.Roles, ","))
+	if a.Secret != "" {
+		req.Header.Set("X-Auth-CouchDB-Token", hex.EncodeToString(hmacSHA256([]byte(a.Secret), a.Username)))
+	}
+	return nil
+}
+
+func (a *ProxyAuth) Update(resp *http.Response) bool {
+	return false
+}
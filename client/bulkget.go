@@ -0,0 +1,273 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+// BulkGetRequest is a document revision requested from _bulk_get
+type BulkGetRequest struct {
+	ID  string `json:"id"`
+	Rev string `json:"rev"`
+}
+
+// BulkGetResult is a document revision of a _bulk_get response,
+// Doc is nil if the revision couldn't be read
+type BulkGetResult struct {
+	ID  string
+	Rev string
+	Doc *CompleteDoc
+	Err error
+}
+
+// BulkGet fetches the revisions of multiple documents with a single
+// request including their revision history and attachments. The
+// documents are read completely and have to be closed to release
+// spilled attachments. Revisions that failed, e.g. with ErrNotFound,
+// ErrDocTooLarge or ErrAttachmentTooLarge, have the error in the
+// result. ErrNotFound is returned if the server doesn't support the
+// endpoint.
+// https://docs.couchdb.org/en/stable/api/database/bulk-api.html#db-bulk-get
+func (c *Client) BulkGet(ctx context.Context, docs []BulkGetRequest) ([]BulkGetResult, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(struct {
+		Docs []BulkGetRequest `json:"docs"`
+	}{docs})
+	if err != nil {
+		return nil, err
+	}
+
+	q := c.docOptions.query()
+	q.Set("attachments", "true")
+	u := c.dbURL("_bulk_get", q)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "multipart/mixed")
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	// older servers respond with bad request or method not allowed,
+	// as the path is interpreted as a document id
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusBadRequest, http.StatusMethodNotAllowed:
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("bulk get", resp)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	// servers ignoring the accept header respond with JSON,
+	// the attachments are inlined
+	if mediaType == "application/json" {
+		return c.readBulkGetJSON(resp.Body)
+	}
+	if mediaType != "multipart/mixed" {
+		return nil, fmt.Errorf("invalid content type: %q", mediaType)
+	}
+
+	return c.readRevisions(resp.Body, params["boundary"])
+}
+
+// GetDocumentRevisions fetches the revisions of the document (open_revs)
+// including their revision history and attachments, every revision is
+// a separate document. Missing revisions have ErrNotFound in the result.
+// ErrURLTooLong is returned if the revisions exceed the URL length.
+func (c *Client) GetDocumentRevisions(ctx context.Context, docid string, revs []string) ([]BulkGetResult, error) {
+	openRevs, err := json.Marshal(revs)
+	if err != nil {
+		return nil, err
+	}
+
+	q := c.docOptions.query()
+	q.Set("open_revs", string(openRevs))
+	u := c.dbURL(docPath(docid), q)
+	err = c.checkURLLength(u)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "multipart/mixed")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("get document", resp)
+	}
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	results, err := c.readRevisions(resp.Body, params["boundary"])
+	if err != nil {
+		return nil, err
+	}
+
+	// missing revisions are reported without id
+	for i := range results {
+		if results[i].ID == "" {
+			results[i].ID = docid
+		}
+	}
+	return results, nil
+}
+
+// readRevisions reads the document revisions of a multipart/mixed
+// response of _bulk_get or open_revs
+func (c *Client) readRevisions(body io.Reader, boundary string) ([]BulkGetResult, error) {
+	var results []BulkGetResult
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			closeBulkGetResults(results)
+			return nil, err
+		}
+
+		result, err := c.readRevisionPart(part)
+		if err != nil {
+			closeBulkGetResults(results)
+			return nil, err
+		}
+		results = append(results, result)
+	}
+}
+
+// readRevisionPart reads a document revision of the response, either
+// the plain document, the document with attachments or an error
+func (c *Client) readRevisionPart(part *multipart.Part) (BulkGetResult, error) {
+	d := &CompleteDoc{opts: c.docOptions}
+
+	var body io.Reader = part
+	if c.docOptions.MaxDocSize > 0 {
+		body = &docSizeReader{r: body, n: c.docOptions.MaxDocSize}
+	}
+	r := io.TeeReader(body, &d.size)
+
+	mediaType, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil {
+		return BulkGetResult{}, err
+	}
+	switch mediaType {
+	case "application/json":
+		err = d.parseDocument(io.NopCloser(r))
+		if err == nil && (params["error"] == "true" || d.Data["_id"] == nil) {
+			return bulkGetError(d.Data), nil
+		}
+	case "multipart/related":
+		err = d.parseStageTwo(multipart.NewReader(r, params["boundary"]))
+	default:
+		return BulkGetResult{}, fmt.Errorf("invalid content type: %q", mediaType)
+	}
+
+	d.ID, _ = d.Data["_id"].(string)
+	result := BulkGetResult{ID: d.ID, Rev: d.Rev(), Doc: d}
+	if err != nil {
+		d.Close() // nolint: errcheck
+		result.Doc = nil
+		result.Err = err
+
+		// the revision can only be skipped if it is known
+		if d.ID == "" || !docLimitError(err) {
+			return BulkGetResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// readBulkGetJSON reads the JSON response of _bulk_get
+func (c *Client) readBulkGetJSON(r io.Reader) ([]BulkGetResult, error) {
+	var resp struct {
+		Results []struct {
+			Docs []struct {
+				OK    map[string]interface{} `json:"ok"`
+				Error map[string]interface{} `json:"error"`
+			} `json:"docs"`
+		} `json:"results"`
+	}
+	err := c.decodeJSON(r, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []BulkGetResult
+	for _, result := range resp.Results {
+		for _, doc := range result.Docs {
+			if doc.OK == nil {
+				results = append(results, bulkGetError(doc.Error))
+				continue
+			}
+			d := NewDoc(doc.OK)
+			d.opts = c.docOptions
+			d.filterAttachments()
+			results = append(results, BulkGetResult{ID: d.ID, Rev: d.Rev(), Doc: d})
+		}
+	}
+	return results, nil
+}
+
+// bulkGetError converts the error of a revision, missing
+// revisions are reported as ErrNotFound
+func bulkGetError(data map[string]interface{}) BulkGetResult {
+	id, _ := data["id"].(string)
+	rev, _ := data["rev"].(string)
+	reason, _ := data["reason"].(string)
+	result := BulkGetResult{ID: id, Rev: rev}
+	if missing, ok := data["missing"].(string); ok {
+		// open_revs
+		result.Rev = missing
+		result.Err = fmt.Errorf("%w: revision %s", ErrNotFound, missing)
+	} else if data["error"] == "not_found" {
+		result.Err = fmt.Errorf("%w: %s", ErrNotFound, reason)
+	} else {
+		result.Err = fmt.Errorf("%w: %v: %s", ErrFailed, data["error"], reason)
+	}
+	return result
+}
+
+// docLimitError returns true if the error is caused by the
+// limits of the DocOptions
+func docLimitError(err error) bool {
+	return errors.Is(err, ErrDocTooLarge) || errors.Is(err, ErrAttachmentTooLarge) ||
+		errors.Is(err, ErrTooManyParts) || errors.Is(err, ErrPartHeaderTooLarge)
+}
+
+func closeBulkGetResults(results []BulkGetResult) {
+	for _, result := range results {
+		result.Doc.Close() // nolint: errcheck
+	}
+}
@@ -9,22 +9,29 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/goydb/replicator/logger"
 )
 
-var (
-	ErrNotFound = errors.New("not found")
-	ErrFailed   = errors.New("operation failed")
-)
+// LocalDocPrefix is the id prefix of non-replicating documents
+const LocalDocPrefix = "_local/"
 
 type Client struct {
-	remote *Remote
-	client *http.Client
-	logger logger.Logger
-	base   *url.URL
+	remote     *Remote
+	client     *http.Client
+	logger     logger.Logger
+	base       *url.URL
+	docOptions DocOptions
+	retry      RetryPolicy
+	retryHook  RetryHook
+	slow       time.Duration
+	maxURL     int
+	timeouts   Timeouts
+	limits     ResponseLimits
 }
 
 func NewClient(r *Remote) (*Client, error) {
@@ -32,10 +39,14 @@ func NewClient(r *Remote) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	hc, err := r.httpClient()
+	if err != nil {
+		return nil, err
+	}
 
 	return &Client{
 		remote: r,
-		client: http.DefaultClient,
+		client: hc,
 		logger: new(logger.Noop),
 		base:   base,
 	}, nil
@@ -45,21 +56,182 @@ func (c *Client) SetLogger(logger logger.Logger) {
 	c.logger = logger
 }
 
+// SetHTTPClient sets the http client used for the requests,
+// defaults to the HTTPClient of the remote
+func (c *Client) SetHTTPClient(hc *http.Client) {
+	c.client = hc
+}
+
+// SetRetryPolicy sets the policy used to retry failed requests
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
+// SetRetryHook sets a function that is called before every retry
+func (c *Client) SetRetryHook(hook RetryHook) {
+	c.retryHook = hook
+}
+
+// SetDocOptions sets the options used to read documents
+func (c *Client) SetDocOptions(opts DocOptions) {
+	c.docOptions = opts
+}
+
+// SetSlowRequestThreshold logs requests that took longer than the
+// threshold to respond as warning, 0 disables it
+func (c *Client) SetSlowRequestThreshold(threshold time.Duration) {
+	c.slow = threshold
+}
+
+// SetResponseLimits sets the size and nesting limits of JSON responses
+func (c *Client) SetResponseLimits(limits ResponseLimits) {
+	c.limits = limits
+}
+
+// SetTimeouts sets the connect, request and changes read timeouts,
+// the defaults apply otherwise
+func (c *Client) SetTimeouts(timeouts Timeouts) {
+	c.timeouts = timeouts
+}
+
+// SetMaxURLLength limits the URL of document requests, longer requests
+// fail with ErrURLTooLong without being sent, 0 or negative disables it
+func (c *Client) SetMaxURLLength(n int) {
+	c.maxURL = n
+}
+
+// checkURLLength returns ErrURLTooLong if the URL exceeds the limit
+func (c *Client) checkURLLength(u string) error {
+	if c.maxURL > 0 && len(u) > c.maxURL {
+		return fmt.Errorf("%w: %d bytes", ErrURLTooLong, len(u))
+	}
+	return nil
+}
+
 func (c *Client) request(req *http.Request) (*http.Response, error) {
 	for key, value := range c.remote.Headers {
 		req.Header.Add(key, value)
 	}
 
-	resp, err := c.client.Do(req)
+	maxRetries := c.retry.maxRetries(req.Method)
+	for attempt := 0; ; attempt++ {
+		resp, err := c.authorized(req)
+		if attempt >= maxRetries || !retryable(req, resp, err) || !rewindable(req) {
+			return resp, err
+		}
+
+		wait := c.retry.backoff(attempt, resp)
+		if err != nil {
+			c.logger.Warningf("HTTP [%s] %s failed, retry in %s: %v", req.Method, req.URL, wait, err)
+		} else {
+			c.logger.Warningf("HTTP [%s] %s failed, retry in %s: %s", req.Method, req.URL, wait, resp.Status)
+			if c.retryHook != nil {
+				err = newHTTPError(req.Method, resp)
+			}
+			resp.Body.Close() // nolint: errcheck
+		}
+		if c.retryHook != nil {
+			c.retryHook(req, attempt+1, wait, err)
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+
+		req, err = rewind(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authorized sends the request, if the credentials were
+// renewed the request is sent again
+func (c *Client) authorized(req *http.Request) (*http.Response, error) {
+	resp, err := c.do(req)
+	if err != nil || c.remote.Auth == nil || !c.remote.Auth.Update(resp) {
+		return resp, err
+	}
+
+	// credentials were renewed, retry if the body can be sent again
+	if !rewindable(req) {
+		return resp, nil
+	}
+	resp.Body.Close() // nolint: errcheck
+
+	retry, err := rewind(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = c.do(retry)
+	if err == nil {
+		c.remote.Auth.Update(resp)
+	}
+	return resp, err
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	release, err := c.remote.Limiter.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if c.remote.Auth != nil {
+		err := c.remote.Auth.Authenticate(req.Context(), c.client, c.base, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.remote.Signer != nil {
+		err := c.remote.Signer.Sign(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	resp, err := c.doWithTimeouts(req)
 	if err != nil {
 		c.logger.Debugf("HTTP [%s] %s -> %s", req.Method, req.URL, err)
 	} else {
 		c.logger.Debugf("HTTP [%s] %s -> %d", req.Method, req.URL, resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests && c.remote.Limiter != nil {
+			if rate := c.remote.Limiter.throttle(time.Now()); rate > 0 {
+				c.logger.Warningf("HTTP [%s] %s was rate limited by the server, reducing to %.2f requests per second", req.Method, req.URL, rate)
+			}
+		}
+	}
+	if c.slow > 0 {
+		if took := time.Since(start); took > c.slow {
+			c.logSlowRequest(req, resp, took)
+		}
 	}
 
 	return resp, err
 }
 
+// logSlowRequest logs the endpoint, sizes and the request id of a
+// request that took longer than the threshold until the response
+// headers were received. The sizes are -1 if unknown.
+func (c *Client) logSlowRequest(req *http.Request, resp *http.Response, took time.Duration) {
+	var (
+		size int64 = -1
+		id         = req.Header.Get("X-Request-ID")
+	)
+	if resp != nil {
+		size = resp.ContentLength
+		if couchID := resp.Header.Get("X-Couch-Request-ID"); couchID != "" {
+			id = couchID
+		}
+	}
+	c.logger.Warningf("Slow HTTP [%s] %s took %s (request %d bytes, response %d bytes, request id %q)",
+		req.Method, req.URL.Redacted(), took.Round(time.Millisecond), req.ContentLength, size, id)
+}
+
 func (c *Client) Check(ctx context.Context) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.remote.URL, nil)
 	if err != nil {
@@ -80,14 +252,42 @@ func (c *Client) Check(ctx context.Context) error {
 		return ErrNotFound
 	}
 
-	return fmt.Errorf("check request failed: %s", resp.Status)
+	return newHTTPError("check", resp)
 }
 
 func (c *Client) Create(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.remote.URL, nil)
+	return c.CreateWithOptions(ctx, CreateOptions{})
+}
+
+// CreateOptions are passed to the database creation
+type CreateOptions struct {
+	// Params are added to the query, e.g. q (shards), n (replicas),
+	// partitioned or placement
+	Params map[string]string
+	// Headers are added to the request
+	Headers map[string]string
+}
+
+// CreateWithOptions creates the database, if the database was created
+// concurrently (412 Precondition Failed) no error is returned.
+func (c *Client) CreateWithOptions(ctx context.Context, opts CreateOptions) error {
+	u := c.remote.URL
+	if len(opts.Params) > 0 {
+		q := make(url.Values)
+		for key, value := range opts.Params {
+			q.Set(key, value)
+		}
+		u += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, nil)
 	if err != nil {
 		return err
 	}
+	req.Header.Add("Accept", "application/json")
+	for key, value := range opts.Headers {
+		req.Header.Set(key, value)
+	}
 
 	resp, err := c.request(req)
 	if err != nil {
@@ -95,18 +295,36 @@ func (c *Client) Create(ctx context.Context) error {
 	}
 	defer resp.Body.Close() // nolint: errcheck
 
-	var info struct {
-		Error       string `json:"error"`
-		ErrorReason string `json:"reason"`
-		OK          bool   `json:"ok"`
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusPreconditionFailed:
+		c.logger.Debugf("Database %q already exists", c.remote.URL)
+		return nil
+	}
+
+	return newHTTPError("create", resp)
+}
+
+// Delete deletes the database
+func (c *Client) Delete(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.remote.URL, nil)
+	if err != nil {
+		return err
 	}
-	err = json.NewDecoder(resp.Body).Decode(&info)
+
+	resp, err := c.request(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close() // nolint: errcheck
 
-	if !info.OK {
-		return fmt.Errorf("%w: %s: %s", ErrFailed, info.Error, info.ErrorReason)
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return newHTTPError("delete", resp)
 	}
 
 	return nil
@@ -129,11 +347,11 @@ func (c *Client) Info(ctx context.Context) (*Info, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("info request failed: %s", resp.Status)
+		return nil, newHTTPError("info", resp)
 	}
 
 	var i Info
-	err = json.NewDecoder(resp.Body).Decode(&i)
+	err = c.decodeJSON(resp.Body, &i)
 	if err != nil {
 		return nil, err
 	}
@@ -165,8 +383,32 @@ func urlJoin(parts ...string) string {
 	return strings.Join(parts, "/")
 }
 
+// dbURL returns the URL of the path in the database with the encoded
+// query, the path has to be escaped already (see docPath)
+func (c *Client) dbURL(escapedPath string, q url.Values) string {
+	u := *c.base
+	rawPath := strings.TrimRight(u.EscapedPath(), "/") + "/" + escapedPath
+	p, err := url.PathUnescape(rawPath)
+	if err == nil {
+		u.Path, u.RawPath = p, rawPath
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// docPath escapes the document id for the url, the slash
+// of local and design documents is kept
+func docPath(id string) string {
+	for _, prefix := range []string{LocalDocPrefix, "_design/"} {
+		if strings.HasPrefix(id, prefix) {
+			return prefix + url.PathEscape(strings.TrimPrefix(id, prefix))
+		}
+	}
+	return url.PathEscape(id)
+}
+
 func (c *Client) GetReplicationLog(ctx context.Context, id string) (*ReplicationLog, error) {
-	u := urlJoin(c.remote.URL, "_local", id)
+	u := c.dbURL(docPath(LocalDocPrefix+id), nil)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
@@ -183,11 +425,11 @@ func (c *Client) GetReplicationLog(ctx context.Context, id string) (*Replication
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("replication log request failed: %s", resp.Status)
+		return nil, newHTTPError("replication log", resp)
 	}
 
 	var rl ReplicationLog
-	err = json.NewDecoder(resp.Body).Decode(&rl)
+	err = c.decodeJSON(resp.Body, &rl)
 	if err != nil {
 		return nil, err
 	}
@@ -202,51 +444,186 @@ type ReplicationLog struct {
 	ReplicationIDVersion int        `json:"replication_id_version"` // Replication protocol version. Defines Replication ID calculation algorithm, HTTP API calls and the others routines. Required
 	SessionID            string     `json:"session_id"`             // Unique ID of the last session. Shortcut to the session_id field of the latest history object. Required
 	SourceLastSeq        string     `json:"source_last_seq"`        // Last processed Checkpoint. Shortcut to the recorded_seq field of the latest history object. Required
+	SeqRanges            []SeqRange `json:"seq_ranges,omitempty"`   // Completed ranges of replications in time slices, oldest first. Optional
+
+	SourceCapabilities *Capabilities `json:"source_capabilities,omitempty"` // Detected features of the source server. Optional
+}
+
+// Capabilities are the detected features of a server, they are kept in
+// the replication logs so short-lived runs don't have to probe it again
+type Capabilities struct {
+	Server    *ServerInfo `json:"server"`
+	NoBulkGet bool        `json:"no_bulk_get,omitempty"` // _bulk_get isn't available
+	Detected  time.Time   `json:"detected"`
+}
+
+// SeqRange is a range of update sequences replicated by one
+// run of a replication that is spread over time
+type SeqRange struct {
+	Start     string    `json:"start"` // sequence the range started after
+	End       string    `json:"end"`   // last replicated sequence
+	Completed time.Time `json:"completed"`
 }
 
 type History struct {
-	DocWriteFailures int    `json:"doc_write_failures"` // Number of failed writes
-	DocsRead         int    `json:"docs_read"`          // Number of read documents
-	DocsWritten      int    `json:"docs_written"`       // Number of written documents
-	EndLastSeq       string `json:"end_last_seq"`       // Last processed Update Sequence ID
-	EndTime          Time   `json:"end_time"`           // Replication completion timestamp in RFC 5322 format
-	MissingChecked   int    `json:"missing_checked"`    // Number of checked revisions on Source
-	MissingFound     int    `json:"missing_found"`      // Number of missing revisions found on Target
-	RecordedSeq      string `json:"recorded_seq"`       // Recorded intermediate Checkpoint. Required
-	SessionID        string `json:"session_id"`         // Unique session ID. Commonly, a random UUID value is used. Required
-	StartLastSeq     string `json:"start_last_seq"`     // Start update Sequence ID
-	StartTime        Time   `json:"start_time"`         // Replication start timestamp in RFC 5322 format
+	DocWriteFailures int       `json:"doc_write_failures"` // Number of failed writes
+	DocsRead         int       `json:"docs_read"`          // Number of read documents
+	DocsWritten      int       `json:"docs_written"`       // Number of written documents
+	EndLastSeq       string    `json:"end_last_seq"`       // Last processed Update Sequence ID
+	EndTime          time.Time `json:"end_time"`           // Replication completion timestamp in RFC 5322 format
+	MissingChecked   int       `json:"missing_checked"`    // Number of revisions of the source checked against the target
+	MissingFound     int       `json:"missing_found"`      // Number of revisions found missing on the target
+	RecordedSeq      string    `json:"recorded_seq"`       // Recorded intermediate Checkpoint. Required
+	SessionID        string    `json:"session_id"`         // Unique session ID. Commonly, a random UUID value is used. Required
+	StartLastSeq     string    `json:"start_last_seq"`     // Start update Sequence ID
+	StartTime        time.Time `json:"start_time"`         // Replication start timestamp in RFC 5322 format
 }
 
-type Time time.Time
+// Duration returns the time the session took
+func (h *History) Duration() time.Duration {
+	return h.EndTime.Sub(h.StartTime)
+}
 
-func (t Time) MarshalJSON() ([]byte, error) {
-	tstr := time.Time(t).Format(time.RFC822)
-	return json.Marshal(tstr)
+// historyJSON is the wire representation of the history
+type historyJSON struct {
+	*historyAlias
+	EndTime   string `json:"end_time"`
+	StartTime string `json:"start_time"`
 }
 
-func (t *Time) UnmarshalJSON(data []byte) error {
-	var tstr string
-	err := json.Unmarshal(data, &tstr)
+type historyAlias History
+
+func (h History) MarshalJSON() ([]byte, error) {
+	return json.Marshal(historyJSON{
+		historyAlias: (*historyAlias)(&h),
+		EndTime:      formatTime(h.EndTime),
+		StartTime:    formatTime(h.StartTime),
+	})
+}
+
+func (h *History) UnmarshalJSON(data []byte) error {
+	hj := historyJSON{historyAlias: (*historyAlias)(h)}
+	err := json.Unmarshal(data, &hj)
+	if err != nil {
+		return err
+	}
+
+	h.EndTime, err = parseTime(hj.EndTime)
 	if err != nil {
 		return err
 	}
-	ti, err := time.Parse(time.RFC822, tstr)
+	h.StartTime, err = parseTime(hj.StartTime)
 	if err != nil {
 		return err
 	}
-	*t = Time(ti)
+
 	return nil
 }
 
+// TimeFormat is the RFC 5322 time format used by CouchDB in the
+// replication history, e.g. "Thu, 10 Oct 2013 05:56:38 GMT"
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// timeFormats are accepted when reading the replication history, as
+// other implementations (and older versions of this package) write
+// different formats
+var timeFormats = []string{
+	TimeFormat,
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339Nano,
+	time.RFC822Z,
+	time.RFC822,
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(TimeFormat)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range timeFormats {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid history time %q", s)
+}
+
 func (c *Client) Changes(ctx context.Context, opts ChangeOptions) (*ChangesResponse, error) {
-	path := fmt.Sprintf("_changes?feed=normal&style=all_docs&heartbeat=%d&since=%s",
-		opts.Heartbeat.Milliseconds(), opts.Since)
-	u := urlJoin(c.remote.URL, path)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if opts.Heartbeat > 0 && opts.Timeout > 0 {
+		return nil, ErrHeartbeatAndTimeout
+	}
+
+	// the parameters of the filter function don't replace the options
+	q := make(url.Values)
+	if opts.Filter != "" {
+		for key, value := range opts.QueryParams {
+			q.Set(key, value)
+		}
+		q.Set("filter", opts.Filter)
+	}
+	q.Set("feed", "normal")
+	q.Set("style", "all_docs")
+	if opts.Timeout > 0 {
+		q.Set("timeout", strconv.FormatInt(opts.Timeout.Milliseconds(), 10))
+	} else {
+		q.Set("heartbeat", strconv.FormatInt(opts.Heartbeat.Milliseconds(), 10))
+	}
+	// descending changes start at the last change without since
+	if opts.Since != "" || !opts.Descending {
+		q.Set("since", opts.Since)
+	}
+	if opts.Descending {
+		q.Set("descending", "true")
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.SeqInterval > 0 {
+		q.Set("seq_interval", strconv.Itoa(opts.SeqInterval))
+	}
+
+	// the selector is posted as body
+	method := http.MethodGet
+	var body io.Reader
+	if len(opts.Selector) > 0 {
+		q.Set("filter", SelectorFilter)
+		data, err := json.Marshal(map[string]json.RawMessage{"selector": opts.Selector})
+		if err != nil {
+			return nil, err
+		}
+		method = http.MethodPost
+		body = bytes.NewReader(data)
+	} else if len(opts.DocIDs) > 0 {
+		q.Set("filter", DocIDsFilter)
+		data, err := json.Marshal(map[string][]string{"doc_ids": opts.DocIDs})
+		if err != nil {
+			return nil, err
+		}
+		method = http.MethodPost
+		body = bytes.NewReader(data)
+	}
+
+	// the feed is idle up to the heartbeat or timeout
+	ctx = withReadTimeout(ctx, c.timeouts.changesRead(opts.Heartbeat+opts.Timeout))
+
+	u := c.dbURL("_changes", q)
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
 	if err != nil {
 		return nil, err
 	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
 
 	resp, err := c.request(req)
 	if err != nil {
@@ -259,11 +636,11 @@ func (c *Client) Changes(ctx context.Context, opts ChangeOptions) (*ChangesRespo
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("replication log request failed: %s", resp.Status)
+		return nil, newHTTPError("changes", resp)
 	}
 
 	var changes ChangesResponse
-	err = json.NewDecoder(resp.Body).Decode(&changes)
+	err = c.decodeJSON(resp.Body, &changes)
 	if err != nil {
 		return nil, err
 	}
@@ -271,11 +648,83 @@ func (c *Client) Changes(ctx context.Context, opts ChangeOptions) (*ChangesRespo
 	return &changes, nil
 }
 
+// EachChange pages through the changes feed calling fn for every change,
+// only one page is held in memory. The Limit of the options is used as
+// page size, every page starts after the last sequence of the previous
+// one. Combined with a SeqInterval of the page size the server only
+// computes the sequences needed to continue. Iteration stops at the
+// first error returned by fn, the last sequence of the completed pages
+// is returned to resume from.
+func (c *Client) EachChange(ctx context.Context, opts ChangeOptions, fn func(change Results) error) (string, error) {
+	if opts.Descending {
+		return "", fmt.Errorf("%w: descending changes can't be paged", ErrFailed)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultChangesPageSize
+	}
+
+	for {
+		changes, err := c.Changes(ctx, opts)
+		if err != nil {
+			return opts.Since, err
+		}
+
+		for _, change := range changes.Results {
+			err = fn(change)
+			if err != nil {
+				return opts.Since, err
+			}
+		}
+		if changes.LastSeq != "" {
+			opts.Since = changes.LastSeq
+		}
+
+		if len(changes.Results) < opts.Limit {
+			return opts.Since, nil
+		}
+	}
+}
+
+// DefaultChangesPageSize page size used by EachChange
+const DefaultChangesPageSize = 1000
+
+// SinceNow can be used as since value to start at the current update sequence
+const SinceNow = "now"
+
 type ChangeOptions struct {
+	// Heartbeat keeps the request alive with empty lines, Timeout instead
+	// ends the request after the duration, they are mutually exclusive
 	Heartbeat time.Duration
-	Since     string
+	Timeout   time.Duration
+	Since     string // sequence to start after, or SinceNow
+
+	Filter      string            // filter function, e.g. "ddoc/name"
+	QueryParams map[string]string // passed to the filter function
+
+	Selector json.RawMessage // mango selector, can't be combined with Filter
+	DocIDs   []string        // only changes of the documents, can't be combined with Filter
+
+	// Descending returns the newest changes first, intended for
+	// diagnostics, e.g. to show the last changes of a database
+	Descending bool
+	// Limit the number of changes, 0 is unlimited
+	Limit int
+
+	// SeqInterval only computes the sequence of every nth change, the
+	// other changes have an empty Seq. Reduces the load of clustered
+	// servers, see ServerInfo.SupportsSeqInterval.
+	SeqInterval int
 }
 
+// ErrHeartbeatAndTimeout is returned if both heartbeat and timeout are requested
+var ErrHeartbeatAndTimeout = errors.New("changes heartbeat and timeout are mutually exclusive")
+
+// SelectorFilter is the builtin filter of the changes feed using a selector
+const SelectorFilter = "_selector"
+
+// DocIDsFilter is the builtin filter of the changes feed using document ids
+const DocIDsFilter = "_doc_ids"
+
 type ChangesResponse struct {
 	Results []Results `json:"results"`
 	LastSeq string    `json:"last_seq"`
@@ -298,7 +747,7 @@ func (c *Client) RevDiff(ctx context.Context, r RevDiffRequest) (DiffResponse, e
 		return nil, err
 	}
 
-	u := urlJoin(c.remote.URL, "_revs_diff")
+	u := c.dbURL("_revs_diff", nil)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &buf)
 	if err != nil {
 		return nil, err
@@ -314,11 +763,11 @@ func (c *Client) RevDiff(ctx context.Context, r RevDiffRequest) (DiffResponse, e
 	defer resp.Body.Close() // nolint: errcheck
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("rev diff request failed: %s", resp.Status)
+		return nil, newHTTPError("rev diff", resp)
 	}
 
 	var diffResp DiffResponse
-	err = json.NewDecoder(resp.Body).Decode(&diffResp)
+	err = c.decodeJSON(resp.Body, &diffResp)
 	if err != nil {
 		return nil, err
 	}
@@ -328,22 +777,174 @@ func (c *Client) RevDiff(ctx context.Context, r RevDiffRequest) (DiffResponse, e
 
 type RevDiffRequest map[string][]string
 
+// Revisions returns the number of revisions asked about
+func (r RevDiffRequest) Revisions() int {
+	var n int
+	for _, revs := range r {
+		n += len(revs)
+	}
+	return n
+}
+
 type DiffResponse map[string]*Diff
 
+// Missing returns the number of missing revisions
+func (r DiffResponse) Missing() int {
+	var n int
+	for _, diff := range r {
+		if diff != nil {
+			n += len(diff.Missing)
+		}
+	}
+	return n
+}
+
 type Diff struct {
 	// Missing contains missing revisions
 	Missing []string `json:"missing"`
 }
 
+// PurgedInfos returns the purged document revisions known to the database,
+// with since only the ones purged after the purge sequence. Servers that
+// ignore since return all of them, purging them again is a no-op.
+// ErrNotFound is returned if the server doesn't support the endpoint.
+func (c *Client) PurgedInfos(ctx context.Context, since string) (*PurgedInfosResponse, error) {
+	var q url.Values
+	if since != "" {
+		q = url.Values{"since": {since}}
+	}
+	u := c.dbURL("_purged_infos", q)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	// older servers respond with bad request, as the path is
+	// interpreted as a document id starting with an underscore
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest {
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("purged infos", resp)
+	}
+
+	var infos PurgedInfosResponse
+	err = c.decodeJSON(resp.Body, &infos)
+	if err != nil {
+		return nil, err
+	}
+
+	return &infos, nil
+}
+
+type PurgedInfosResponse struct {
+	PurgedInfos []PurgedInfo `json:"purged_infos"`
+}
+
+type PurgedInfo struct {
+	ID   string   `json:"id"`
+	Revs []string `json:"revs"`
+}
+
+// Purge permanently removes the given document revisions
+// https://docs.couchdb.org/en/stable/api/database/misc.html#db-purge
+func (c *Client) Purge(ctx context.Context, r PurgeRequest) (PurgeResponse, error) {
+	var buf bytes.Buffer
+
+	err := json.NewEncoder(&buf).Encode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	u := c.dbURL("_purge", nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &buf)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
+		return nil, newHTTPError("purge", resp)
+	}
+
+	var purgeResp struct {
+		Purged PurgeResponse `json:"purged"`
+	}
+	err = c.decodeJSON(resp.Body, &purgeResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return purgeResp.Purged, nil
+}
+
+// PurgeRequest maps document ids to the revisions to purge
+type PurgeRequest map[string][]string
+
+// PurgeResponse maps document ids to the purged revisions
+type PurgeResponse map[string][]string
+
+// DocumentSize returns the size of the document revision json in bytes
+// without fetching the document, attachments are not included.
+func (c *Client) DocumentSize(ctx context.Context, docid, rev string) (int64, error) {
+	u := c.dbURL(docPath(docid), url.Values{"rev": {rev}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, newHTTPError("document size", resp)
+	}
+
+	if resp.ContentLength < 0 {
+		return 0, ErrSizeUnknown
+	}
+	return resp.ContentLength, nil
+}
+
 // GetDocumentComplete
 // 2.4.2.5.1. Fetch Changed Documents
 func (c *Client) GetDocumentComplete(ctx context.Context, docid string, diff *Diff) (*CompleteDoc, error) {
-	for i, rev := range diff.Missing {
-		diff.Missing[i] = "%22" + rev + "%22"
+	openRevs, err := json.Marshal(diff.Missing)
+	if err != nil {
+		return nil, err
 	}
 
-	u := urlJoin(c.remote.URL, docid+"?revs=true&latest=true&open_revs=[")
-	u += strings.Join(diff.Missing, ",") + "]"
+	q := c.docOptions.query()
+	q.Set("open_revs", string(openRevs))
+	u := c.dbURL(docPath(docid), q)
+	err = c.checkURLLength(u)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
@@ -354,19 +955,34 @@ func (c *Client) GetDocumentComplete(ctx context.Context, docid string, diff *Di
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close() // nolint: errcheck
+		return nil, ErrNotFound
+	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("rev diff request failed: %s", resp.Status)
+		defer resp.Body.Close() // nolint: errcheck
+		return nil, newHTTPError("get document", resp)
 	}
 
-	return NewCompleteDoc(docid, resp)
+	// the response of a single revision stays open if
+	// the attachments are streamed
+	doc, err := newCompleteDoc(docid, resp, c.docOptions, len(diff.Missing) == 1)
+	if doc == nil || doc.stream == nil {
+		resp.Body.Close() // nolint: errcheck
+	}
+	return doc, err
 }
 
 // UploadDocumentWithAttachments
 // 2.4.2.5.3. Upload Document with Attachments
 func (c *Client) UploadDocumentWithAttachments(ctx context.Context, doc *CompleteDoc) error {
-	u := urlJoin(c.remote.URL, doc.ID+"?new_edits=false")
+	if doc.stream != nil {
+		return c.uploadStream(ctx, doc)
+	}
+
+	u := c.dbURL(docPath(doc.ID), url.Values{"new_edits": {"false"}})
 	r, boundary, err := doc.Reader()
 	if err != nil {
 		return err
@@ -375,20 +991,45 @@ func (c *Client) UploadDocumentWithAttachments(ctx context.Context, doc *Complet
 
 	// we need to copy the returned document with attachments into a buffer
 	// to get the total size when sending, as otherwise couchdb will block
-	// on the request.
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, r)
-	if err != nil {
-		return err
+	// on the request. Spilled attachments are buffered on disk.
+	var body io.Reader
+	var size int64
+	if doc.spilled() {
+		f, err := os.CreateTemp(doc.opts.SpillDir, "replicator-upload-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name()) // nolint: errcheck
+		defer f.Close()           // nolint: errcheck
+
+		size, err = io.Copy(f, r)
+		if err != nil {
+			return err
+		}
+		body = io.NewSectionReader(f, 0, size)
+	} else {
+		var buf bytes.Buffer
+		size, err = io.Copy(&buf, r)
+		if err != nil {
+			return err
+		}
+		body = &buf
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, &buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, body)
 	if err != nil {
 		return err
 	}
+	if sr, ok := body.(*io.SectionReader); ok {
+		req.ContentLength = size
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(sr, 0, size)), nil
+		}
+	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", `multipart/related; boundary="`+boundary+`"`)
+	req.Header.Add(IdempotencyKeyHeader, IdempotencyKey(doc))
 
 	resp, err := c.request(req)
 	if err != nil {
@@ -397,7 +1038,7 @@ func (c *Client) UploadDocumentWithAttachments(ctx context.Context, doc *Complet
 	defer resp.Body.Close() // nolint: errcheck
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("upload document with attachment request failed: %s", resp.Status)
+		return newHTTPError("upload document with attachment", resp)
 	}
 
 	return nil
@@ -405,13 +1046,16 @@ func (c *Client) UploadDocumentWithAttachments(ctx context.Context, doc *Complet
 
 // BulkDocs
 // 2.4.2.5.2. Upload Batch of Changed Documents
-func (c *Client) BulkDocs(ctx context.Context, stack *Stack) error {
-	u := urlJoin(c.remote.URL, "_bulk_docs")
+// The returned failures contain the documents the target refused to store
+// (e.g. forbidden by a validate_doc_update function), the upload of all
+// other documents succeeded.
+func (c *Client) BulkDocs(ctx context.Context, stack *Stack) ([]BulkDocsResult, error) {
+	u := c.dbURL("_bulk_docs", nil)
 
 	// documents
 	r, err := stack.Reader()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer r.Close()
 
@@ -421,35 +1065,66 @@ func (c *Client) BulkDocs(ctx context.Context, stack *Stack) error {
 	var buf bytes.Buffer
 	_, err = io.Copy(&buf, r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(buf.Bytes()))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("X-Couch-Full-Commit", "false")
+	req.Header.Add(IdempotencyKeyHeader, IdempotencyKey(*stack...))
 
 	resp, err := c.request(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close() // nolint: errcheck
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("bulk upload request failed: %s", resp.Status)
+		return nil, newHTTPError("bulk upload", resp)
+	}
+	if resp.Header.Get(IdempotentReplayHeader) != "" {
+		c.logger.Debugf("Bulk upload of %d documents was already written", len(*stack))
 	}
 
-	return nil
+	// with new_edits=false only failed documents are reported
+	var results []BulkDocsResult
+	err = c.decodeJSON(resp.Body, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []BulkDocsResult
+	for _, result := range results {
+		if result.Error != "" {
+			failures = append(failures, result)
+		}
+	}
+
+	return failures, nil
+}
+
+// BulkDocsResult is the result of a single document of a bulk upload
+type BulkDocsResult struct {
+	ID     string `json:"id"`
+	Rev    string `json:"rev,omitempty"`
+	OK     bool   `json:"ok,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (r BulkDocsResult) Err() error {
+	return fmt.Errorf("%w: %s: %s", ErrFailed, r.Error, r.Reason)
 }
 
 // EnsureFullCommit
 // 2.4.2.5.4. Ensure In Commit
 func (c *Client) EnsureFullCommit(ctx context.Context) error {
-	u := urlJoin(c.remote.URL, "_ensure_full_commit")
+	u := c.dbURL("_ensure_full_commit", nil)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader("{}"))
 	if err != nil {
 		return err
@@ -463,56 +1138,100 @@ func (c *Client) EnsureFullCommit(ctx context.Context) error {
 	}
 	defer resp.Body.Close() // nolint: errcheck
 
+	if resp.StatusCode != http.StatusCreated {
+		return newHTTPError("ensure full commit", resp)
+	}
+
 	var respBody struct {
 		InstanceStartTime string `json:"instance_start_time"`
 		OK                bool   `json:"ok"`
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	err = c.decodeJSON(resp.Body, &respBody)
 	if err != nil {
 		return err
 	}
 
-	if resp.StatusCode != http.StatusCreated || !respBody.OK {
-		return fmt.Errorf("rev diff request failed: %s", resp.Status)
+	if !respBody.OK {
+		return fmt.Errorf("%w: ensure full commit not ok", ErrFailed)
 	}
 
 	return nil
 }
 
+// maxCheckpointConflicts limits the retries of a checkpoint write
+// if the replication log was changed concurrently
+const maxCheckpointConflicts = 3
+
 // RecordReplicationCheckpoint
 // 2.4.2.5.5. Record Replication Checkpoint
-func (c *Client) RecordReplicationCheckpoint(ctx context.Context, repLog *ReplicationLog, replicationID string) error {
+// The revision of the replication log is updated and returned. On a
+// conflict the current revision is fetched and the write retried, up
+// to maxCheckpointConflicts times.
+func (c *Client) RecordReplicationCheckpoint(ctx context.Context, repLog *ReplicationLog, replicationID string) (string, error) {
+	rev, err := c.putReplicationLog(ctx, repLog, replicationID)
+	for i := 0; i < maxCheckpointConflicts && errors.Is(err, ErrConflict); i++ {
+		// the log was changed in between, e.g. by a previous attempt
+		// that succeeded without us receiving the response or by
+		// another replicator racing us
+		current, gerr := c.GetReplicationLog(ctx, replicationID)
+		if gerr != nil && !errors.Is(gerr, ErrNotFound) {
+			return "", gerr
+		}
+		repLog.Rev = ""
+		if current != nil {
+			repLog.Rev = current.Rev
+		}
+
+		rev, err = c.putReplicationLog(ctx, repLog, replicationID)
+	}
+
+	return rev, err
+}
+
+// putReplicationLog writes the replication log and updates its revision
+func (c *Client) putReplicationLog(ctx context.Context, repLog *ReplicationLog, replicationID string) (string, error) {
 	rl, err := json.Marshal(repLog)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	u := urlJoin(c.remote.URL, "_local", replicationID)
+	u := c.dbURL(docPath(LocalDocPrefix+replicationID), nil)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(rl))
 	if err != nil {
-		return err
+		return "", err
 	}
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
 	resp, err := c.request(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close() // nolint: errcheck
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+		return "", newHTTPError("replication checkpoint", resp)
+	}
 
-		return fmt.Errorf("rev diff request failed: %s (%s)", resp.Status, string(body))
+	var result BulkDocsResult
+	err = c.decodeJSON(resp.Body, &result)
+	if err != nil {
+		return "", err
 	}
+	repLog.Rev = result.Rev
 
-	return nil
+	return result.Rev, nil
 }
 
-func (c *Client) RemoveReplicationCheckpoint(ctx context.Context, replicationID string) error {
-	u := urlJoin(c.remote.URL, "_local", replicationID)
+// RemoveReplicationCheckpoint deletes the replication log, if the rev is
+// unknown an empty string can be passed
+func (c *Client) RemoveReplicationCheckpoint(ctx context.Context, replicationID, rev string) error {
+	q := make(url.Values)
+	if rev != "" {
+		q.Set("rev", rev)
+	}
+	u := c.dbURL(docPath(LocalDocPrefix+replicationID), q)
 	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
 	if err != nil {
 		return err
@@ -527,9 +1246,304 @@ func (c *Client) RemoveReplicationCheckpoint(ctx context.Context, replicationID
 	defer resp.Body.Close() // nolint: errcheck
 
 	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+		return newHTTPError("delete replication checkpoint", resp)
+	}
+
+	return nil
+}
+
+// LocalDocs lists the non-replicating documents of the database
+// https://docs.couchdb.org/en/stable/api/local.html#db-local-docs
+func (c *Client) LocalDocs(ctx context.Context, opts LocalDocsOptions) (*LocalDocsResponse, error) {
+	return c.listDocs(ctx, "_local_docs", opts.query(), "local docs")
+}
+
+// AllDocs lists the documents of the database with their winning revision,
+// deleted documents aren't listed
+// https://docs.couchdb.org/en/stable/api/database/bulk-api.html#db-all-docs
+func (c *Client) AllDocs(ctx context.Context, opts AllDocsOptions) (*LocalDocsResponse, error) {
+	q := opts.query()
+	if opts.Conflicts {
+		q.Set("conflicts", "true")
+	}
+	return c.listDocs(ctx, "_all_docs", q, "all docs")
+}
+
+// DocConflicts are the conflicting revisions of a document
+type DocConflicts struct {
+	ID        string   // document id
+	Rev       string   // winning revision
+	Conflicts []string // losing leaf revisions
+}
+
+// FindConflicts returns the documents with conflicting revisions,
+// documents without conflicts or that don't exist are omitted
+func (c *Client) FindConflicts(ctx context.Context, ids []string) ([]DocConflicts, error) {
+	body, err := json.Marshal(map[string][]string{"keys": ids})
+	if err != nil {
+		return nil, err
+	}
+
+	u := c.dbURL("_all_docs", url.Values{"include_docs": {"true"}, "conflicts": {"true"}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("find conflicts", resp)
+	}
+
+	var res struct {
+		Rows []struct {
+			ID    string `json:"id"`
+			Value struct {
+				Rev string `json:"rev"`
+			} `json:"value"`
+			Doc *struct {
+				Conflicts []string `json:"_conflicts"`
+			} `json:"doc"`
+		} `json:"rows"`
+	}
+	err = c.decodeJSON(resp.Body, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []DocConflicts
+	for _, row := range res.Rows {
+		if row.Doc == nil || len(row.Doc.Conflicts) == 0 {
+			continue
+		}
+		conflicts = append(conflicts, DocConflicts{
+			ID:        row.ID,
+			Rev:       row.Value.Rev,
+			Conflicts: row.Doc.Conflicts,
+		})
+	}
+	return conflicts, nil
+}
+
+func (c *Client) listDocs(ctx context.Context, path string, q url.Values, op string) (*LocalDocsResponse, error) {
+	u := c.dbURL(path, q)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(op, resp)
+	}
+
+	var ld LocalDocsResponse
+	err = c.decodeJSON(resp.Body, &ld)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ld, nil
+}
+
+// EachLocalDoc pages through the non-replicating documents of the database
+// calling fn for every row. The Limit of the options is used as page size,
+// Skip is only applied to the first page. Iteration stops at the first
+// error returned by fn.
+func (c *Client) EachLocalDoc(ctx context.Context, opts LocalDocsOptions, fn func(row LocalDocsRow) error) error {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultLocalDocsPageSize
+	}
+
+	for {
+		ld, err := c.LocalDocs(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range ld.Rows {
+			err = fn(row)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(ld.Rows) < opts.Limit {
+			return nil
+		}
+
+		// continue after the last key of the page
+		opts.StartKey = ld.Rows[len(ld.Rows)-1].Key
+		opts.Skip = 1
+	}
+}
+
+// DefaultLocalDocsPageSize page size used by EachLocalDoc
+const DefaultLocalDocsPageSize = 100
+
+type LocalDocsOptions struct {
+	StartKey    string // include documents starting with the key
+	EndKey      string // include documents up to the key
+	Limit       int    // number of rows to return, 0 = unlimited
+	Skip        int    // number of rows to skip
+	Descending  bool   // return the documents in descending order by key
+	IncludeDocs bool   // include the document in the row
+}
+
+// AllDocsOptions are the options of AllDocs, the rows
+// have the same format as the rows of LocalDocs
+type AllDocsOptions struct {
+	LocalDocsOptions
+	Conflicts bool // include the conflicting revisions, requires IncludeDocs
+}
+
+func (o LocalDocsOptions) query() url.Values {
+	q := make(url.Values)
+	if o.StartKey != "" {
+		q.Set("startkey", jsonString(o.StartKey))
+	}
+	if o.EndKey != "" {
+		q.Set("endkey", jsonString(o.EndKey))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Skip > 0 {
+		q.Set("skip", strconv.Itoa(o.Skip))
+	}
+	if o.Descending {
+		q.Set("descending", "true")
+	}
+	if o.IncludeDocs {
+		q.Set("include_docs", "true")
+	}
+	return q
+}
+
+func jsonString(s string) string {
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(err) // strings can always be marshaled
+	}
+	return string(data)
+}
+
+type LocalDocsResponse struct {
+	Rows []LocalDocsRow `json:"rows"`
+}
+
+type LocalDocsRow struct {
+	ID    string `json:"id"`
+	Key   string `json:"key"`
+	Value struct {
+		Rev string `json:"rev"`
+	} `json:"value"`
+	Doc json.RawMessage `json:"doc,omitempty"` // only with IncludeDocs
+}
+
+// GetDoc reads the revision of the document into v, the current
+// revision if rev is empty. ErrNotFound is returned if the document
+// or revision doesn't exist.
+func (c *Client) GetDoc(ctx context.Context, id, rev string, v interface{}) error {
+	q := make(url.Values)
+	if rev != "" {
+		q.Set("rev", rev)
+	}
+	u := c.dbURL(docPath(id), q)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError("get document", resp)
+	}
+
+	return c.decodeJSON(resp.Body, v)
+}
+
+// PutDoc stores v as new revision of the document and returns the
+// revision, v has to contain the current revision (_rev) of existing
+// documents, otherwise ErrConflict is returned
+func (c *Client) PutDoc(ctx context.Context, id string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	u := c.dbURL(docPath(id), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
+		return "", newHTTPError("put document", resp)
+	}
+
+	var result BulkDocsResult
+	err = c.decodeJSON(resp.Body, &result)
+	if err != nil {
+		return "", err
+	}
+
+	return result.Rev, nil
+}
+
+// DeleteDoc deletes the revision of the document, it
+// is not an error if the document doesn't exist
+func (c *Client) DeleteDoc(ctx context.Context, id, rev string) error {
+	u := c.dbURL(docPath(id), url.Values{"rev": {rev}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
 
-		return fmt.Errorf("delete replication checkpoint request failed: %s (%s)", resp.Status, string(body))
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return newHTTPError("delete document", resp)
 	}
 
 	return nil
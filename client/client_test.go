@@ -0,0 +1,1138 @@
+package client_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEachLocalDoc(t *testing.T) {
+	var ids []string
+	for i := 0; i < 25; i++ {
+		ids = append(ids, fmt.Sprintf("_local/doc-%02d", i))
+	}
+	sort.Strings(ids)
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/db/_local_docs", r.URL.Path)
+
+		q := r.URL.Query()
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		skip, _ := strconv.Atoi(q.Get("skip"))
+
+		start := 0
+		if sk := q.Get("startkey"); sk != "" {
+			var key string
+			assert.NoError(t, json.Unmarshal([]byte(sk), &key))
+			start = sort.SearchStrings(ids, key)
+		}
+		start += skip
+
+		var resp client.LocalDocsResponse
+		for i := start; i < len(ids) && len(resp.Rows) < limit; i++ {
+			resp.Rows = append(resp.Rows, client.LocalDocsRow{ID: ids[i], Key: ids[i]})
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	var seen []string
+	err = c.EachLocalDoc(context.Background(), client.LocalDocsOptions{Limit: 10}, func(row client.LocalDocsRow) error {
+		seen = append(seen, row.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ids, seen)
+	assert.Equal(t, 3, requests)
+}
+
+func TestEachChange(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/db/_changes", r.URL.Path)
+
+		q := r.URL.Query()
+		assert.Equal(t, "10", q.Get("seq_interval"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		since, _ := strconv.Atoi(q.Get("since"))
+
+		var resp client.ChangesResponse
+		for seq := since + 1; seq <= 25 && len(resp.Results) < limit; seq++ {
+			resp.Results = append(resp.Results, client.Results{ID: fmt.Sprintf("doc-%02d", seq)})
+			resp.LastSeq = strconv.Itoa(seq)
+		}
+		if resp.LastSeq == "" {
+			resp.LastSeq = strconv.Itoa(since)
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	var seen int
+	lastSeq, err := c.EachChange(context.Background(), client.ChangeOptions{Since: "0", Limit: 10, SeqInterval: 10}, func(change client.Results) error {
+		seen++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "25", lastSeq)
+	assert.Equal(t, 25, seen)
+	assert.Equal(t, 3, requests)
+}
+
+func TestDocumentURLEscaping(t *testing.T) {
+	var paths, openRevs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		openRevs = append(openRevs, r.URL.Query().Get("open_revs"))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db/"})
+	assert.NoError(t, err)
+
+	for _, id := range []string{"a b/ü?", "_design/x y"} {
+		diff := &client.Diff{Missing: []string{"1-a"}}
+		_, err = c.GetDocumentComplete(context.Background(), id, diff)
+		assert.ErrorIs(t, err, client.ErrNotFound)
+		assert.Equal(t, []string{"1-a"}, diff.Missing)
+	}
+	assert.Equal(t, []string{"/db/a%20b%2F%C3%BC%3F", "/db/_design/x%20y"}, paths)
+	assert.Equal(t, []string{`["1-a"]`, `["1-a"]`}, openRevs)
+}
+
+func TestDocumentSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/db/a" {
+			w.Header().Set("Content-Length", "42")
+		}
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db/"})
+	assert.NoError(t, err)
+
+	size, err := c.DocumentSize(context.Background(), "a", "1-a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), size)
+
+	// chunked responses have no length
+	_, err = c.DocumentSize(context.Background(), "b", "1-b")
+	assert.ErrorIs(t, err, client.ErrSizeUnknown)
+}
+
+func TestBulkDocsFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/db/_bulk_docs", r.URL.Path)
+
+		var body struct {
+			Docs     []map[string]interface{} `json:"docs"`
+			NewEdits bool                     `json:"new_edits"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.False(t, body.NewEdits)
+		assert.Len(t, body.Docs, 2)
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`[{"id":"b","error":"forbidden","reason":"not allowed"}]`))
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	stack := client.Stack{
+		{ID: "a", Data: map[string]interface{}{"_id": "a", "_rev": "1-a"}},
+		{ID: "b", Data: map[string]interface{}{"_id": "b", "_rev": "1-b"}},
+	}
+	failures, err := c.BulkDocs(context.Background(), &stack)
+	assert.NoError(t, err)
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "b", failures[0].ID)
+		assert.ErrorIs(t, failures[0].Err(), client.ErrFailed)
+	}
+}
+
+func TestRecordReplicationCheckpointConflict(t *testing.T) {
+	current := "1-a"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/db/_local/rep", r.URL.Path)
+
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprintf(w, `{"_id":"_local/rep","_rev":%q}`, current)
+		case http.MethodPut:
+			var rl client.ReplicationLog
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&rl))
+			if rl.Rev != current {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, `{"error":"conflict","reason":"Document update conflict."}`)
+				return
+			}
+			current = "0-2"
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"ok":true,"id":"_local/rep","rev":%q}`, current)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	repLog := &client.ReplicationLog{ID: "_local/rep", Rev: "0-1"}
+	rev, err := c.RecordReplicationCheckpoint(context.Background(), repLog, "rep")
+	assert.NoError(t, err)
+	assert.Equal(t, "0-2", rev)
+	assert.Equal(t, "0-2", repLog.Rev)
+}
+
+func TestRecordReplicationCheckpointConflictLimit(t *testing.T) {
+	puts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprint(w, `{"_id":"_local/rep","_rev":"0-1"}`)
+		case http.MethodPut:
+			// another replicator always wins the race
+			puts++
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"error":"conflict","reason":"Document update conflict."}`)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	_, err = c.RecordReplicationCheckpoint(context.Background(), &client.ReplicationLog{ID: "_local/rep"}, "rep")
+	assert.ErrorIs(t, err, client.ErrConflict)
+	assert.Equal(t, 4, puts)
+}
+
+func TestFindConflicts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/db/_all_docs", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("conflicts"))
+		var body map[string][]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, []string{"a", "b", "c"}, body["keys"])
+		fmt.Fprint(w, `{"rows":[
+			{"id":"a","key":"a","value":{"rev":"2-a"},"doc":{"_id":"a","_rev":"2-a","_conflicts":["2-b"]}},
+			{"id":"b","key":"b","value":{"rev":"1-b"},"doc":{"_id":"b","_rev":"1-b"}},
+			{"key":"c","error":"not_found"}
+		]}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	conflicts, err := c.FindConflicts(context.Background(), []string{"a", "b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []client.DocConflicts{{ID: "a", Rev: "2-a", Conflicts: []string{"2-b"}}}, conflicts)
+}
+
+func TestRateLimiter(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db", Limiter: client.NewRateLimiter(20, 2)})
+	assert.NoError(t, err)
+
+	// the burst is sent at once, the others every 50ms
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, c.Check(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 140*time.Millisecond)
+	assert.Equal(t, 5, requests)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, c.Check(ctx), context.Canceled)
+	assert.Equal(t, 5, requests)
+}
+
+func TestRateLimiterInFlight(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxInFlight, requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		throttle := requests == 1
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if throttle {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	limiter := client.NewRateLimiter(1000, 10)
+	limiter.SetMaxInFlight(2)
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db", Limiter: limiter})
+	assert.NoError(t, err)
+
+	// the first response reduces the rate
+	assert.Error(t, c.Check(context.Background()))
+	assert.Equal(t, 500.0, limiter.Rate())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, c.Check(context.Background()))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 7, requests)
+	assert.Equal(t, 2, maxInFlight)
+}
+
+func TestHistoryTime(t *testing.T) {
+	start := time.Date(2013, 10, 10, 5, 56, 38, 0, time.UTC)
+	h := client.History{SessionID: "s", StartTime: start, EndTime: start.Add(time.Minute)}
+
+	data, err := json.Marshal(h)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"start_time":"Thu, 10 Oct 2013 05:56:38 GMT"`)
+
+	var got client.History
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, "s", got.SessionID)
+	assert.True(t, start.Equal(got.StartTime))
+	assert.Equal(t, time.Minute, got.Duration())
+
+	err = json.Unmarshal([]byte(`{"start_time":"2013-10-10T05:56:38Z","end_time":""}`), &got)
+	assert.NoError(t, err)
+	assert.True(t, start.Equal(got.StartTime))
+	assert.True(t, got.EndTime.IsZero())
+}
+
+func TestCookieAuth(t *testing.T) {
+	var logins int
+	session := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_session" {
+			var creds map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&creds))
+			if creds["name"] != "admin" || creds["password"] != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			logins++
+			session = fmt.Sprintf("session-%d", logins)
+			http.SetCookie(w, &http.Cookie{Name: client.AuthSessionCookie, Value: session})
+			fmt.Fprint(w, `{"ok":true}`)
+			return
+		}
+
+		cookie, err := r.Cookie(client.AuthSessionCookie)
+		if err != nil || cookie.Value != session {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"db_name":"db"}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{
+		URL:  srv.URL + "/db/",
+		Auth: &client.CookieAuth{Username: "admin", Password: "secret"},
+	})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	info, err := c.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "db", info.DbName)
+	assert.Equal(t, 1, logins)
+
+	// session expired on the server
+	session = "expired"
+	_, err = c.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, logins)
+}
+
+func TestRevDiffCounters(t *testing.T) {
+	// changes of the source with two conflicting leafs for "a", the
+	// target already has "b" and "c", CouchDB records missing_checked: 4
+	// and missing_found: 2 for this batch
+	req := client.RevDiffRequest{
+		"a": {"2-a", "2-b"},
+		"b": {"1-b"},
+		"c": {"1-c"},
+	}
+	resp := client.DiffResponse{
+		"a": {Missing: []string{"2-a", "2-b"}},
+	}
+
+	assert.Equal(t, 4, req.Revisions())
+	assert.Equal(t, 2, resp.Missing())
+}
+
+func TestChangesSelector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, client.SelectorFilter, r.URL.Query().Get("filter"))
+
+		var body map[string]map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "order", body["selector"]["type"])
+
+		fmt.Fprint(w, `{"results":[{"seq":"1","id":"a","changes":[{"rev":"1-a"}]}],"last_seq":"1"}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	changes, err := c.Changes(context.Background(), client.ChangeOptions{
+		Since:    "0",
+		Selector: json.RawMessage(`{"type": "order"}`),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, changes.Results, 1)
+}
+
+func TestChangesTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "5000", r.URL.Query().Get("timeout"))
+		assert.False(t, r.URL.Query().Has("heartbeat"))
+		fmt.Fprint(w, `{"results":[],"last_seq":"1"}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	_, err = c.Changes(context.Background(), client.ChangeOptions{Since: "0", Timeout: 5 * time.Second})
+	assert.NoError(t, err)
+
+	_, err = c.Changes(context.Background(), client.ChangeOptions{Since: "0", Timeout: time.Second, Heartbeat: time.Second})
+	assert.ErrorIs(t, err, client.ErrHeartbeatAndTimeout)
+}
+
+func TestChangesDescending(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		assert.Equal(t, "true", q.Get("descending"))
+		assert.Equal(t, "2", q.Get("limit"))
+		assert.False(t, q.Has("since"))
+		fmt.Fprint(w, `{"results":[{"seq":"2","id":"b"},{"seq":"1","id":"a"}],"last_seq":"1"}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	changes, err := c.Changes(context.Background(), client.ChangeOptions{Descending: true, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", changes.Results[0].ID)
+}
+
+func TestCreateWithOptions(t *testing.T) {
+	exists := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "8", r.URL.Query().Get("q"))
+		assert.Equal(t, "zone-a", r.Header.Get("X-Placement"))
+
+		if exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `{"error":"file_exists","reason":"The database could not be created, the file already exists."}`)
+			return
+		}
+		exists = true
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	opts := client.CreateOptions{
+		Params:  map[string]string{"q": "8"},
+		Headers: map[string]string{"X-Placement": "zone-a"},
+	}
+	assert.NoError(t, c.CreateWithOptions(context.Background(), opts))
+	assert.NoError(t, c.CreateWithOptions(context.Background(), opts))
+}
+
+func TestHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":"forbidden","reason":"You are not allowed to access this db."}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	_, err = c.Info(context.Background())
+	var httpErr *client.HTTPError
+	if assert.ErrorAs(t, err, &httpErr) {
+		assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+		assert.Equal(t, "forbidden", httpErr.Err)
+		assert.Equal(t, "You are not allowed to access this db.", httpErr.Reason)
+	}
+	assert.ErrorIs(t, err, client.ErrFailed)
+	assert.NotErrorIs(t, err, client.ErrNotFound)
+}
+
+// attachmentServer serves the document "a" with the attachment
+// file.txt as open_revs response
+func attachmentServer(data string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc := map[string]interface{}{
+			"_id":  "a",
+			"_rev": "1-a",
+			"_attachments": map[string]interface{}{
+				"file.txt": map[string]interface{}{"follows": true, "length": len(data)},
+			},
+		}
+		var body bytes.Buffer
+		related := multipart.NewWriter(&body)
+		pw, _ := related.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+		_ = json.NewEncoder(pw).Encode(doc)
+		pw, _ = related.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {`attachment; filename="file.txt"`},
+			"Content-Type":        {"text/plain"},
+		})
+		fmt.Fprint(pw, data)
+		_ = related.Close()
+
+		mixed := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mixed.Boundary()+`"`)
+		pw, _ = mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {`multipart/related; boundary="` + related.Boundary() + `"`},
+		})
+		_, _ = io.Copy(pw, &body)
+		_ = mixed.Close()
+	}))
+}
+
+func TestSpillAttachments(t *testing.T) {
+	dir := t.TempDir()
+	data := strings.Repeat("x", 1024)
+
+	srv := attachmentServer(data)
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	c.SetDocOptions(client.DocOptions{SpillThreshold: 100, SpillDir: dir})
+
+	doc, err := c.GetDocumentComplete(context.Background(), "a", &client.Diff{Missing: []string{"1-a"}})
+	assert.NoError(t, err)
+
+	files, _ := os.ReadDir(dir)
+	assert.Len(t, files, 1)
+
+	atts, err := doc.Attachments()
+	assert.NoError(t, err)
+	if assert.Len(t, atts, 1) {
+		read, err := io.ReadAll(atts[0])
+		assert.NoError(t, err)
+		assert.Equal(t, data, string(read))
+		assert.EqualValues(t, len(data), atts[0].Length)
+	}
+
+	assert.NoError(t, doc.Close())
+	files, _ = os.ReadDir(dir)
+	assert.Len(t, files, 0)
+}
+
+func TestMaxAttachmentSize(t *testing.T) {
+	srv := attachmentServer(strings.Repeat("x", 1024))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	c.SetDocOptions(client.DocOptions{MaxAttachmentSize: 100})
+	_, err = c.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	assert.ErrorIs(t, err, client.ErrAttachmentTooLarge)
+
+	c.SetDocOptions(client.DocOptions{MaxAttachmentSize: 100, SkipLargeAttachments: true})
+	doc, err := c.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file.txt"}, doc.SkippedAttachments())
+	assert.False(t, doc.HasChangedAttachments())
+	assert.NotContains(t, doc.Data, "_attachments")
+}
+
+func TestMultipartLimits(t *testing.T) {
+	srv := attachmentServer(strings.Repeat("x", 1024))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	c.SetDocOptions(client.DocOptions{MaxDocSize: 512})
+	_, err = c.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	assert.ErrorIs(t, err, client.ErrDocTooLarge)
+
+	c.SetDocOptions(client.DocOptions{MaxParts: 2})
+	_, err = c.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	assert.ErrorIs(t, err, client.ErrTooManyParts)
+
+	c.SetDocOptions(client.DocOptions{MaxPartHeaderBytes: 20})
+	_, err = c.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	assert.ErrorIs(t, err, client.ErrPartHeaderTooLarge)
+
+	c.SetDocOptions(client.DocOptions{MaxDocSize: 4096, MaxParts: 3})
+	doc, err := c.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	assert.NoError(t, err)
+	assert.True(t, doc.HasChangedAttachments())
+	assert.NoError(t, doc.Close())
+}
+
+func TestRetry(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, `{"a":["1-a"]}`, strings.TrimSpace(string(body)))
+
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"a":{"missing":["1-a"]}}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	c.SetRetryPolicy(client.RetryPolicy{MaxRetries: 3, MinBackoff: time.Millisecond})
+
+	diff, err := c.RevDiff(context.Background(), client.RevDiffRequest{"a": {"1-a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1-a"}, diff["a"].Missing)
+	assert.Equal(t, 3, requests)
+
+	// retries are exhausted
+	requests = 0
+	c.SetRetryPolicy(client.RetryPolicy{MaxRetries: 1, MinBackoff: time.Millisecond})
+	_, err = c.RevDiff(context.Background(), client.RevDiffRequest{"a": {"1-a"}})
+	var httpErr *client.HTTPError
+	if assert.ErrorAs(t, err, &httpErr) {
+		assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+		assert.Equal(t, "POST "+srv.URL+"/db/_revs_diff", httpErr.Endpoint)
+	}
+	assert.Equal(t, 2, requests)
+}
+
+type warningLogger struct {
+	logger.Noop
+	warnings []string
+}
+
+func (l *warningLogger) Warningf(format string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func TestSlowRequestThreshold(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/slow") {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Header().Set("X-Couch-Request-ID", "a1b2c3")
+		fmt.Fprint(w, `{"_id":"a","_rev":"1-a"}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	l := new(warningLogger)
+	c.SetLogger(l)
+	c.SetSlowRequestThreshold(20 * time.Millisecond)
+
+	var doc map[string]interface{}
+	assert.NoError(t, c.GetDoc(context.Background(), "a", "", &doc))
+	assert.Empty(t, l.warnings)
+
+	assert.NoError(t, c.GetDoc(context.Background(), "slow", "", &doc))
+	if assert.Len(t, l.warnings, 1) {
+		assert.Contains(t, l.warnings[0], "Slow HTTP [GET]")
+		assert.Contains(t, l.warnings[0], `request id "a1b2c3"`)
+	}
+}
+
+func TestChangesDocIDs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, client.DocIDsFilter, r.URL.Query().Get("filter"))
+		data, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"doc_ids":["a"]}`, string(data))
+		fmt.Fprint(w, `{"results":[{"seq":"1","id":"a"}],"last_seq":"1"}`)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	changes, err := c.Changes(context.Background(), client.ChangeOptions{Since: "0", DocIDs: []string{"a"}})
+	assert.NoError(t, err)
+	assert.Len(t, changes.Results, 1)
+}
+
+func TestServerInfo(t *testing.T) {
+	version := "3.3.2"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprintf(w, `{"couchdb":"Welcome","version":%q,"features":["access-ready","partitioned"],"vendor":{"name":"The Apache Software Foundation"}}`, version)
+		case r.URL.Path == "/_up" && version != "1.6.1":
+			fmt.Fprint(w, `{"status":"ok","seeds":{}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db/"})
+	assert.NoError(t, err)
+
+	info, err := c.ServerInfo(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, info.MajorVersion())
+		assert.Equal(t, "ok", info.Status)
+		assert.True(t, info.HasFeature("partitioned"))
+		assert.True(t, info.SupportsBulkGet())
+		assert.True(t, info.SupportsSeqInterval())
+	}
+
+	version = "1.6.1"
+	info, err = c.ServerInfo(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "", info.Status)
+		assert.False(t, info.SupportsBulkGet())
+		assert.False(t, info.SupportsSeqInterval())
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.URL.Host)
+	}))
+	defer proxy.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: "http://couchdb.invalid/db", ProxyURL: proxy.URL})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Check(context.Background()))
+	assert.Equal(t, []string{"couchdb.invalid"}, hosts)
+
+	_, err = client.NewClient(&client.Remote{URL: "http://couchdb.invalid/db", ProxyURL: "://"})
+	assert.Error(t, err)
+}
+
+func TestTLSConfig(t *testing.T) {
+	var clientCerts int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCerts = len(r.TLS.PeerCertificates)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	// unknown authority
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	assert.Error(t, c.Check(context.Background()))
+
+	c, err = client.NewClient(&client.Remote{URL: srv.URL + "/db", TLS: &client.TLSConfig{InsecureSkipVerify: true}})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Check(context.Background()))
+	assert.Equal(t, 0, clientCerts)
+
+	// mutual TLS
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	c, err = client.NewClient(&client.Remote{URL: srv.URL + "/db", TLS: &client.TLSConfig{
+		CAPEM:   ca,
+		CertPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		KeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Check(context.Background()))
+	assert.Equal(t, 1, clientCerts)
+
+	_, err = client.NewClient(&client.Remote{URL: srv.URL + "/db", TLS: &client.TLSConfig{CAPEM: "invalid"}})
+	assert.ErrorIs(t, err, client.ErrInvalidCert)
+}
+
+func TestTimeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_changes") {
+			// the feed stalls after the first result
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"results":[{"seq":"1","id":"a","changes":[{"rev":"1-a"}]},`)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	c.SetTimeouts(client.Timeouts{Request: 50 * time.Millisecond, ChangesRead: 50 * time.Millisecond})
+
+	start := time.Now()
+	err = c.Check(context.Background())
+	assert.ErrorIs(t, err, client.ErrTimeout)
+
+	_, err = c.Changes(context.Background(), client.ChangeOptions{})
+	assert.ErrorIs(t, err, client.ErrTimeout)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// the read timeout is at least twice the heartbeat
+	start = time.Now()
+	_, err = c.Changes(context.Background(), client.ChangeOptions{Heartbeat: 100 * time.Millisecond})
+	assert.ErrorIs(t, err, client.ErrTimeout)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+}
+
+func TestAttachmentFilter(t *testing.T) {
+	filter := &client.AttachmentFilter{Deny: []string{"*.mp4"}, DenyContentTypes: []string{"video/*"}}
+	assert.True(t, filter.Match("a.txt", "text/plain"))
+	assert.False(t, filter.Match("movie.MP4", "application/octet-stream"))
+	assert.False(t, filter.Match("movie", "video/webm; codecs=vp9"))
+
+	allow := &client.AttachmentFilter{Allow: []string{"*.txt"}, AllowContentTypes: []string{"image/*"}}
+	assert.True(t, allow.Match("a.txt", ""))
+	assert.True(t, allow.Match("logo", "image/png"))
+	assert.False(t, allow.Match("a.pdf", "application/pdf"))
+	assert.Error(t, (&client.AttachmentFilter{Deny: []string{"["}}).Validate())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var related bytes.Buffer
+		rw := multipart.NewWriter(&related)
+		pw, _ := rw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+		fmt.Fprint(pw, `{"_id":"doc","_rev":"2-a","_attachments":{
+			"a.txt":{"content_type":"text/plain","follows":true,"length":5},
+			"v.mp4":{"content_type":"video/mp4","follows":true,"length":5},
+			"s.mp4":{"content_type":"video/mp4","stub":true,"revpos":1}}}`)
+		for _, name := range []string{"a.txt", "v.mp4"} {
+			pw, _ = rw.CreatePart(textproto.MIMEHeader{"Content-Disposition": []string{`attachment; filename="` + name + `"`}})
+			fmt.Fprint(pw, "hello")
+		}
+		_ = rw.Close()
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+		pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{`multipart/related; boundary="` + rw.Boundary() + `"`}})
+		_, _ = related.WriteTo(pw)
+		_ = mw.Close()
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	c.SetDocOptions(client.DocOptions{AttachmentFilter: filter})
+
+	doc, err := c.GetDocumentComplete(context.Background(), "doc", &client.Diff{Missing: []string{"2-a"}})
+	if assert.NoError(t, err) {
+		defer doc.Close() // nolint: errcheck
+		assert.Equal(t, []string{"s.mp4", "v.mp4"}, doc.FilteredAttachments())
+		assert.Len(t, doc.Data["_attachments"], 1)
+		atts, err := doc.Attachments()
+		assert.NoError(t, err)
+		if assert.Len(t, atts, 1) {
+			assert.Equal(t, "a.txt", atts[0].Filename)
+		}
+	}
+}
+
+func TestAttachmentProcessors(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte("world"))
+	_ = zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var related bytes.Buffer
+		rw := multipart.NewWriter(&related)
+		pw, _ := rw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+		fmt.Fprintf(pw, `{"_id":"doc","_rev":"2-a","_attachments":{
+			"a.txt":{"content_type":"text/plain","follows":true,"length":5,"digest":"md5-old"},
+			"b.txt":{"content_type":"text/plain","follows":true,"length":5,"encoding":"gzip","encoded_length":%d},
+			"c.bin":{"content_type":"application/octet-stream","follows":true,"length":3}}}`, gz.Len())
+		for name, data := range map[string][]byte{"a.txt": []byte("hello"), "b.txt": gz.Bytes(), "c.bin": []byte("bin")} {
+			pw, _ = rw.CreatePart(textproto.MIMEHeader{"Content-Disposition": []string{`attachment; filename="` + name + `"`}})
+			_, _ = pw.Write(data)
+		}
+		_ = rw.Close()
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+		pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{`multipart/related; boundary="` + rw.Boundary() + `"`}})
+		_, _ = related.WriteTo(pw)
+		_ = mw.Close()
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	c.SetDocOptions(client.DocOptions{AttachmentProcessors: client.AttachmentProcessors{
+		"text/*": client.AttachmentProcessorFunc(func(name, contentType string, r io.Reader, w io.Writer) (string, error) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return "", err
+			}
+			_, err = w.Write(bytes.ToUpper(append(data, '!')))
+			return "text/x-upper", err
+		}),
+	}})
+
+	doc, err := c.GetDocumentComplete(context.Background(), "doc", &client.Diff{Missing: []string{"2-a"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer doc.Close() // nolint: errcheck
+
+	data := make(map[string]string)
+	atts, err := doc.Attachments()
+	assert.NoError(t, err)
+	for _, att := range atts {
+		b, _ := io.ReadAll(att)
+		data[att.Filename] = att.ContentType + ":" + string(b)
+	}
+	assert.Equal(t, map[string]string{"a.txt": "text/x-upper:HELLO!", "b.txt": "text/x-upper:WORLD!", "c.bin": ":bin"}, data)
+
+	sum := md5.Sum([]byte("HELLO!"))
+	meta := doc.Data["_attachments"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"content_type": "text/x-upper",
+		"follows":      true,
+		"length":       6.0,
+		"digest":       "md5-" + base64.StdEncoding.EncodeToString(sum[:]),
+	}, meta["a.txt"])
+	assert.NotContains(t, meta["b.txt"], "encoding")
+}
+
+func TestInlineEncodedAttachments(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte("hello"))
+	_ = zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("att_encoding_info"))
+
+		var related bytes.Buffer
+		rw := multipart.NewWriter(&related)
+		pw, _ := rw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+		fmt.Fprintf(pw, `{"_id":"doc","_rev":"1-a","_attachments":{"a.txt":{"content_type":"text/plain","follows":true,"length":5,"encoding":"gzip","encoded_length":%d}}}`, gz.Len())
+		pw, _ = rw.CreatePart(textproto.MIMEHeader{"Content-Disposition": []string{`attachment; filename="a.txt"`}})
+		_, _ = pw.Write(gz.Bytes())
+		_ = rw.Close()
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+		pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{`multipart/related; boundary="` + rw.Boundary() + `"`}})
+		_, _ = related.WriteTo(pw)
+		_ = mw.Close()
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	c.SetDocOptions(client.DocOptions{EncodedAttachments: true})
+
+	doc, err := c.GetDocumentComplete(context.Background(), "doc", &client.Diff{Missing: []string{"1-a"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer doc.Close() // nolint: errcheck
+	assert.True(t, doc.HasEncodedAttachments())
+
+	assert.NoError(t, doc.InlineEncodedAttachments())
+	assert.Equal(t, map[string]interface{}{
+		"content_type":   "text/plain",
+		"data":           base64.StdEncoding.EncodeToString(gz.Bytes()),
+		"length":         5.0,
+		"encoding":       "gzip",
+		"encoded_length": float64(gz.Len()),
+	}, doc.Data["_attachments"].(map[string]interface{})["a.txt"])
+}
+
+func TestStreamAttachments(t *testing.T) {
+	data := strings.Repeat("x", 1024)
+
+	// the source sends the attachment data once the upload started
+	started := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="mixed"`)
+		mw := multipart.NewWriter(w)
+		_ = mw.SetBoundary("mixed")
+		pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{`multipart/related; boundary="related"`}})
+		rw := multipart.NewWriter(pw)
+		_ = rw.SetBoundary("related")
+		dw, _ := rw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+		fmt.Fprintf(dw, `{"_id":"a","_rev":"1-a","_attachments":{"file.txt":{"content_type":"text/plain","follows":true,"length":%d}}}`, len(data))
+		aw, _ := rw.CreatePart(textproto.MIMEHeader{"Content-Disposition": []string{`attachment; filename="file.txt"`}})
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+		}
+		fmt.Fprint(aw, data)
+		_ = rw.Close()
+		_ = mw.Close()
+	}))
+	defer source.Close()
+
+	var uploaded *client.CompleteDoc
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.EqualValues(t, len(body), r.ContentLength)
+		uploaded, err = client.ParseCompleteDoc("a", r.Header.Get("Content-Type"), bytes.NewReader(body))
+		assert.NoError(t, err)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer target.Close()
+
+	src, err := client.NewClient(&client.Remote{URL: source.URL + "/db"})
+	assert.NoError(t, err)
+	src.SetDocOptions(client.DocOptions{StreamThreshold: 100})
+	dst, err := client.NewClient(&client.Remote{URL: target.URL + "/db"})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	begin := time.Now()
+	doc, err := src.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Less(t, int64(time.Since(begin)), int64(time.Second), "attachment not streamed")
+	assert.True(t, doc.HasChangedAttachments())
+	assert.Greater(t, doc.Size(), int64(len(data)))
+	assert.NoError(t, dst.UploadDocumentWithAttachments(ctx, doc))
+	assert.NoError(t, doc.Close())
+
+	if assert.NotNil(t, uploaded) {
+		atts, err := uploaded.Attachments()
+		assert.NoError(t, err)
+		if assert.Len(t, atts, 1) {
+			read, _ := io.ReadAll(atts[0])
+			assert.Equal(t, data, string(read))
+			assert.Equal(t, "file.txt", atts[0].Filename)
+		}
+	}
+
+	// streamed attachments are read if needed
+	started = make(chan struct{})
+	close(started)
+	doc, err = src.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer doc.Close() // nolint: errcheck
+	assert.NoError(t, doc.InlineAttachments())
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(data)),
+		doc.Data["_attachments"].(map[string]interface{})["file.txt"].(map[string]interface{})["data"])
+}
+
+func TestResponseLimits(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+	c.SetResponseLimits(client.ResponseLimits{MaxSize: 200, MaxJSONDepth: 5})
+	ctx := context.Background()
+
+	// brackets in strings aren't nested
+	body = `{"db_name":"[[[[{{{{\"[[[[","doc_count":1}`
+	info, err := c.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "[[[[{{{{\"[[[[", info.DbName)
+
+	body = `{"db_name":"db","x":[[[[[[1]]]]]]}`
+	_, err = c.Info(ctx)
+	assert.ErrorIs(t, err, client.ErrJSONTooDeep)
+
+	body = `{"db_name":"` + strings.Repeat("x", 200) + `"}`
+	_, err = c.Info(ctx)
+	assert.ErrorIs(t, err, client.ErrResponseTooLarge)
+
+	c.SetResponseLimits(client.ResponseLimits{MaxSize: -1, MaxJSONDepth: -1})
+	_, err = c.Info(ctx)
+	assert.NoError(t, err)
+}
@@ -5,13 +5,19 @@ import (
 	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+	"net/url"
+	"os"
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 var boundaryMixedRegexp = regexp.MustCompile(`multipart/mixed; boundary="([^"]+)"`)
@@ -26,11 +32,166 @@ type CompleteDoc struct {
 	resp        *http.Response
 	attachments []attachmentMultipartData
 	size        sizeWriter
+	opts        DocOptions
+	skipped     []string // attachments removed because of their size
+	filtered    []string // attachments removed by the AttachmentFilter
+	parts       int      // multipart parts read
+	streamable  bool     // single revision response, see StreamThreshold
+	stream      *docStream
+}
+
+// DocOptions control how documents with attachments are read
+type DocOptions struct {
+	// SpillThreshold attachments bigger than the threshold (in bytes)
+	// are buffered in temporary files instead of memory, 0 disables it
+	SpillThreshold int64
+	// SpillDir is the directory of the temporary files,
+	// defaults to os.TempDir
+	SpillDir string
+
+	// MaxAttachmentSize attachments bigger than the size (in bytes) fail
+	// the document with ErrAttachmentTooLarge, or if SkipLargeAttachments
+	// is set are removed from the document. 0 disables the limit.
+	MaxAttachmentSize    int64
+	SkipLargeAttachments bool
+
+	// AttachmentFilter removes the rejected attachments from the
+	// documents, their data is discarded while reading
+	AttachmentFilter *AttachmentFilter
+
+	// AttachmentProcessors rewrite the attachments of the matching
+	// content types while they are read
+	AttachmentProcessors AttachmentProcessors
+
+	// EncodedAttachments requests the attachments as stored
+	// (att_encoding_info), compressed attachments are
+	// transferred with their gzip encoding
+	EncodedAttachments bool
+
+	// StreamThreshold documents fetched by revision whose attachments
+	// exceed the threshold (in bytes) aren't read, the attachments are
+	// piped from the source response to the target upload instead.
+	// 0 disables streaming.
+	StreamThreshold int64
+
+	// MaxParts limits the multipart parts of a document,
+	// defaults to DefaultMaxParts
+	MaxParts int
+	// MaxPartHeaderBytes limits the size of the headers of a part,
+	// defaults to DefaultMaxPartHeaderBytes
+	MaxPartHeaderBytes int
+	// MaxDocSize limits the size (in bytes) of the complete document
+	// response including the attachments, 0 disables the limit
+	MaxDocSize int64
+	// ParseTimeout limits the time to read the document,
+	// 0 disables the timeout
+	ParseTimeout time.Duration
+	// MaxJSONDepth limits the nesting of the document JSON, defaults
+	// to DefaultMaxJSONDepth, negative values disable the limit
+	MaxJSONDepth int
+}
+
+const (
+	// DefaultMaxParts is the default limit of multipart parts per document
+	DefaultMaxParts = 10000
+	// DefaultMaxPartHeaderBytes is the default limit of the part headers
+	DefaultMaxPartHeaderBytes = 64 * 1024
+)
+
+// query returns the query parameters of the document requests
+func (o DocOptions) query() url.Values {
+	q := url.Values{"revs": {"true"}, "latest": {"true"}}
+	if o.EncodedAttachments {
+		q.Set("att_encoding_info", "true")
+	}
+	return q
+}
+
+// MaxPartsOrFallback returns the part limit or the default
+func (o DocOptions) MaxPartsOrFallback() int {
+	if o.MaxParts <= 0 {
+		return DefaultMaxParts
+	}
+	return o.MaxParts
+}
+
+// MaxPartHeaderBytesOrFallback returns the header limit or the default
+func (o DocOptions) MaxPartHeaderBytesOrFallback() int {
+	if o.MaxPartHeaderBytes <= 0 {
+		return DefaultMaxPartHeaderBytes
+	}
+	return o.MaxPartHeaderBytes
+}
+
+var (
+	// ErrAttachmentTooLarge is returned if an attachment exceeds the
+	// MaxAttachmentSize of the DocOptions
+	ErrAttachmentTooLarge = errors.New("attachment too large")
+	// ErrTooManyParts is returned if the document has more
+	// multipart parts than MaxParts
+	ErrTooManyParts = errors.New("too many multipart parts")
+	// ErrPartHeaderTooLarge is returned if the headers of a
+	// part exceed MaxPartHeaderBytes
+	ErrPartHeaderTooLarge = errors.New("multipart header too large")
+	// ErrDocTooLarge is returned if the document exceeds MaxDocSize
+	ErrDocTooLarge = errors.New("document too large")
+	// ErrParseTimeout is returned if reading the document
+	// takes longer than the ParseTimeout
+	ErrParseTimeout = errors.New("document parse timeout")
+)
+
+// docSizeReader fails the read with ErrDocTooLarge after n bytes
+type docSizeReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *docSizeReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrDocTooLarge
+	}
+	// read one byte more than allowed to detect the excess
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		l.exceeded = true
+		n, l.n = int(l.n), 0
+		return n, ErrDocTooLarge
+	}
+	l.n -= int64(n)
+	return n, err
 }
 
 type attachmentMultipartData struct {
 	Part *multipart.Part
 	Data []byte
+	File *os.File // attachment data spilled to disk, instead of Data
+	Size int64
+}
+
+// reader returns the attachment data
+func (a *attachmentMultipartData) reader() io.Reader {
+	if a.File != nil {
+		return io.NewSectionReader(a.File, 0, a.Size)
+	}
+	return bytes.NewReader(a.Data)
+}
+
+// release removes the spilled attachment data
+func (a *attachmentMultipartData) release() error {
+	if a.File == nil {
+		return nil
+	}
+	f := a.File
+	a.File = nil
+	err := f.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
 }
 
 type sizeWriter int
@@ -42,21 +203,94 @@ func (sw *sizeWriter) Write(p []byte) (n int, err error) {
 }
 
 func NewCompleteDoc(docid string, resp *http.Response) (*CompleteDoc, error) {
+	return NewCompleteDocWithOptions(docid, resp, DocOptions{})
+}
+
+// NewCompleteDocWithOptions reads the document from the open_revs
+// response, big attachments can be spilled to disk using the options.
+// The document has to be closed to release the temporary files.
+func NewCompleteDocWithOptions(docid string, resp *http.Response, opts DocOptions) (*CompleteDoc, error) {
+	return newCompleteDoc(docid, resp, opts, false)
+}
+
+// newCompleteDoc reads the document, if streamable the attachments
+// may be left in the response (see StreamThreshold)
+func newCompleteDoc(docid string, resp *http.Response, opts DocOptions, streamable bool) (*CompleteDoc, error) {
 	d := &CompleteDoc{
-		ID:   docid,
-		resp: resp,
+		ID:         docid,
+		resp:       resp,
+		opts:       opts,
+		streamable: streamable,
 	}
 
-	// FIXME: Attachments and a document can be very large.
-	// A reader that would swap to disk after a certain size
-	// will slow down the process but use less memory.
+	// a stalled response is closed to abort the parsing
+	var timedOut int32
+	if opts.ParseTimeout > 0 {
+		t := time.AfterFunc(opts.ParseTimeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			d.resp.Body.Close() // nolint: errcheck
+		})
+		defer t.Stop()
+	}
 
-	r := io.TeeReader(d.resp.Body, &d.size)
+	var body io.Reader = d.resp.Body
+	var limit *docSizeReader
+	if opts.MaxDocSize > 0 {
+		limit = &docSizeReader{r: body, n: opts.MaxDocSize}
+		body = limit
+	}
+
+	r := io.TeeReader(body, &d.size)
 	mr, err := getMultipart(boundaryMixedRegexp, r, d.resp.Header)
 	if err != nil {
 		return nil, err
 	}
 	err = d.parseStageOne(mr)
+	if err != nil {
+		d.Close() // nolint: errcheck
+		switch {
+		case atomic.LoadInt32(&timedOut) == 1:
+			return nil, fmt.Errorf("%w after %v: %v", ErrParseTimeout, opts.ParseTimeout, err)
+		case limit != nil && limit.exceeded && !errors.Is(err, ErrDocTooLarge):
+			return nil, fmt.Errorf("%w: %v", ErrDocTooLarge, err)
+		}
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// NewDoc creates a document without attachments from the document data
+func NewDoc(data map[string]interface{}) *CompleteDoc {
+	id, _ := data["_id"].(string)
+	return &CompleteDoc{
+		ID:   id,
+		Data: data,
+	}
+}
+
+// ParseCompleteDoc parses a document upload with attachments
+// (multipart/related) or without (application/json) as it
+// is sent to the target of a replication
+func ParseCompleteDoc(docid, contentType string, body io.Reader) (*CompleteDoc, error) {
+	d := &CompleteDoc{
+		ID: docid,
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	r := io.TeeReader(body, &d.size)
+	switch mediaType {
+	case "application/json":
+		err = d.parseDocument(io.NopCloser(r))
+	case "multipart/related":
+		err = d.parseStageTwo(multipart.NewReader(r, params["boundary"]))
+	default:
+		err = fmt.Errorf("invalid content type: %q", contentType)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -65,20 +299,81 @@ func NewCompleteDoc(docid string, resp *http.Response) (*CompleteDoc, error) {
 }
 
 func (d *CompleteDoc) HasChangedAttachments() bool {
-	return len(d.attachments) > 0
+	return len(d.attachments) > 0 || d.stream != nil
 }
 
+// Close releases the response and the spilled attachments
 func (d *CompleteDoc) Close() error {
-	return d.resp.Body.Close()
+	if d == nil {
+		return nil
+	}
+
+	var err error
+	for i := range d.attachments {
+		if rerr := d.attachments[i].release(); err == nil {
+			err = rerr
+		}
+	}
+	if d.resp != nil {
+		if cerr := d.resp.Body.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// spilled returns true if attachment data is stored on disk
+func (d *CompleteDoc) spilled() bool {
+	for _, attachment := range d.attachments {
+		if attachment.File != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Rev returns the revision of the document
+func (d *CompleteDoc) Rev() string {
+	rev, _ := d.Data["_rev"].(string)
+	return rev
 }
 
 func (d *CompleteDoc) Size() int64 {
+	if d.stream != nil {
+		return int64(d.size) + d.stream.length
+	}
 	return int64(d.size)
 }
 
+// nextPart returns the next part of the reader and enforces the
+// part count and header size limits
+func (d *CompleteDoc) nextPart(reader *multipart.Reader) (*multipart.Part, error) {
+	part, err := reader.NextPart()
+	if err != nil {
+		return nil, err
+	}
+
+	d.parts++
+	if limit := d.opts.MaxPartsOrFallback(); d.parts > limit {
+		return nil, fmt.Errorf("%w: more than %d", ErrTooManyParts, limit)
+	}
+
+	var size int
+	for key, values := range part.Header {
+		for _, value := range values {
+			size += len(key) + len(value)
+		}
+	}
+	if limit := d.opts.MaxPartHeaderBytesOrFallback(); size > limit {
+		return nil, fmt.Errorf("%w: %d bytes exceed %d", ErrPartHeaderTooLarge, size, limit)
+	}
+
+	return part, nil
+}
+
 func (d *CompleteDoc) parseStageOne(reader *multipart.Reader) error {
 	for {
-		part, err := reader.NextPart()
+		part, err := d.nextPart(reader)
 		if err == io.EOF {
 			break
 		}
@@ -101,6 +396,10 @@ func (d *CompleteDoc) parseStageOne(reader *multipart.Reader) error {
 				return err
 			}
 			err = d.parseStageTwo(mr)
+			if err == errStreamed {
+				// the attachments are read by the upload
+				return nil
+			}
 			if err != nil {
 				return err
 			}
@@ -115,7 +414,7 @@ func (d *CompleteDoc) parseStageOne(reader *multipart.Reader) error {
 
 func (d *CompleteDoc) parseStageTwo(reader *multipart.Reader) error {
 	for {
-		part, err := reader.NextPart()
+		part, err := d.nextPart(reader)
 		if err == io.EOF {
 			break
 		}
@@ -125,6 +424,19 @@ func (d *CompleteDoc) parseStageTwo(reader *multipart.Reader) error {
 
 		contentDisposition := part.Header.Get("Content-Disposition")
 		switch {
+		case contentDisposition == "" && d.streamable:
+			// main document, the attachments may be streamed
+			raw, err := io.ReadAll(part)
+			if err != nil {
+				return err
+			}
+			err = d.parseDocument(io.NopCloser(bytes.NewReader(raw)))
+			if err != nil {
+				return err
+			}
+			if d.startStream(reader, raw) {
+				return errStreamed
+			}
 		case contentDisposition == "":
 			// main document
 			err = d.parseDocument(part)
@@ -133,15 +445,25 @@ func (d *CompleteDoc) parseStageTwo(reader *multipart.Reader) error {
 			}
 		case strings.HasPrefix(contentDisposition, "attachment"):
 			// mutlipart attachments
-			data, err := io.ReadAll(part)
+			if matches := dispositionFilename.FindStringSubmatch(contentDisposition); len(matches) == 2 && d.isFiltered(matches[1]) {
+				_, err = io.Copy(io.Discard, part)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			attachment, err := d.readAttachment(part)
+			if errors.Is(err, ErrAttachmentTooLarge) && d.opts.SkipLargeAttachments {
+				err = d.skipAttachment(part)
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			if err != nil {
-				return fmt.Errorf("failed to read %s", contentDisposition)
+				return fmt.Errorf("failed to read %s: %w", contentDisposition, err)
 			}
-
-			d.attachments = append(d.attachments, attachmentMultipartData{
-				Part: part,
-				Data: data,
-			})
+			d.attachments = append(d.attachments, attachment)
 		default:
 			// unknown type
 			return fmt.Errorf("invalid content disposition: %q", contentDisposition)
@@ -151,13 +473,140 @@ func (d *CompleteDoc) parseStageTwo(reader *multipart.Reader) error {
 	return nil
 }
 
+// readAttachment reads the attachment into memory or, if it is bigger
+// than the spill threshold, into a temporary file
+func (d *CompleteDoc) readAttachment(part *multipart.Part) (attachmentMultipartData, error) {
+	attachment := attachmentMultipartData{Part: part}
+
+	if limit := d.opts.MaxAttachmentSize; limit > 0 {
+		// the announced length is checked before reading,
+		// the data is limited in case the length is wrong
+		if d.attachmentLength(part) > limit {
+			return attachment, ErrAttachmentTooLarge
+		}
+		lr := &io.LimitedReader{R: part, N: limit + 1}
+		attachment, err := d.readAttachmentData(part, lr)
+		if err == nil && lr.N <= 0 {
+			attachment.release() // nolint: errcheck
+			return attachment, ErrAttachmentTooLarge
+		}
+		return attachment, err
+	}
+
+	return d.readAttachmentData(part, part)
+}
+
+// attachmentLength returns the (encoded) length of the attachment
+// announced in the document or -1 if unknown
+func (d *CompleteDoc) attachmentLength(part *multipart.Part) int64 {
+	matches := dispositionFilename.FindStringSubmatch(part.Header.Get("Content-Disposition"))
+	if len(matches) != 2 {
+		return -1
+	}
+	atts, _ := d.Data["_attachments"].(map[string]interface{})
+	att, _ := atts[matches[1]].(map[string]interface{})
+	for _, key := range []string{"encoded_length", "length"} {
+		if length, ok := att[key].(float64); ok {
+			return int64(length)
+		}
+	}
+	return -1
+}
+
+// skipAttachment discards the data of the attachment and removes
+// it from the document
+func (d *CompleteDoc) skipAttachment(part *multipart.Part) error {
+	_, err := io.Copy(io.Discard, part)
+	if err != nil {
+		return err
+	}
+
+	matches := dispositionFilename.FindStringSubmatch(part.Header.Get("Content-Disposition"))
+	if len(matches) != 2 {
+		return fmt.Errorf("invalid attachment, filename missing")
+	}
+	if atts, ok := d.Data["_attachments"].(map[string]interface{}); ok {
+		delete(atts, matches[1])
+		if len(atts) == 0 {
+			delete(d.Data, "_attachments")
+		}
+	}
+	d.skipped = append(d.skipped, matches[1])
+
+	return nil
+}
+
+// SkippedAttachments returns the names of the attachments that were
+// removed from the document as they exceeded the MaxAttachmentSize
+func (d *CompleteDoc) SkippedAttachments() []string {
+	return d.skipped
+}
+
+// readAttachmentData reads the attachment, passing it through
+// the processor of its content type if any
+func (d *CompleteDoc) readAttachmentData(part *multipart.Part, r io.Reader) (attachmentMultipartData, error) {
+	name, meta := d.attachmentMeta(part)
+	if meta != nil {
+		contentType, _ := meta["content_type"].(string)
+		if processor := d.opts.AttachmentProcessors.lookup(contentType); processor != nil {
+			return d.processAttachment(part, r, processor, name, meta)
+		}
+	}
+	return d.bufferAttachment(part, r)
+}
+
+// bufferAttachment reads the data into memory or a temporary file
+func (d *CompleteDoc) bufferAttachment(part *multipart.Part, r io.Reader) (attachmentMultipartData, error) {
+	attachment := attachmentMultipartData{Part: part}
+
+	if d.opts.SpillThreshold <= 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return attachment, err
+		}
+		attachment.Data = data
+		attachment.Size = int64(len(data))
+		return attachment, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, d.opts.SpillThreshold+1))
+	if err != nil {
+		return attachment, err
+	}
+	if int64(len(data)) <= d.opts.SpillThreshold {
+		attachment.Data = data
+		attachment.Size = int64(len(data))
+		return attachment, nil
+	}
+
+	f, err := os.CreateTemp(d.opts.SpillDir, "replicator-attachment-")
+	if err != nil {
+		return attachment, err
+	}
+	attachment.File = f
+
+	n, err := io.Copy(f, io.MultiReader(bytes.NewReader(data), r))
+	if err != nil {
+		attachment.release() // nolint: errcheck
+		return attachment, err
+	}
+	attachment.Size = n
+
+	return attachment, nil
+}
+
 func (d *CompleteDoc) parseDocument(r io.ReadCloser) error {
 	defer r.Close() // nolint: errcheck
 
-	err := json.NewDecoder(r).Decode(&d.Data)
+	var body io.Reader = r
+	if limit := jsonDepthOrDefault(d.opts.MaxJSONDepth); limit > 0 {
+		body = &jsonDepthReader{r: r, limit: limit}
+	}
+	err := json.NewDecoder(body).Decode(&d.Data)
 	if err != nil {
 		return err
 	}
+	d.filterAttachments()
 
 	return nil
 }
@@ -177,7 +626,45 @@ func getMultipart(re *regexp.Regexp, r io.Reader, header http.Header) (*multipar
 // InlineAttachments
 // inline the attachments using the base64 encoding.
 func (d *CompleteDoc) InlineAttachments() error {
-	for _, attachment := range d.attachments {
+	return d.inlineAttachments(false)
+}
+
+// InlineEncodedAttachments inlines the attachments like InlineAttachments
+// but keeps gzip encoded attachments compressed, the encoding and
+// encoded_length fields are preserved. Only for targets storing the
+// encoding of inline attachments, CouchDB only accepts it for
+// multipart uploads.
+func (d *CompleteDoc) InlineEncodedAttachments() error {
+	return d.inlineAttachments(true)
+}
+
+// HasEncodedAttachments returns true if a changed attachment is
+// transferred compressed (e.g. gzip)
+func (d *CompleteDoc) HasEncodedAttachments() bool {
+	if d.stream != nil {
+		for _, meta := range d.streamedAttachments() {
+			if meta["encoding"] != nil {
+				return true
+			}
+		}
+	}
+	for i := range d.attachments {
+		_, meta := d.attachmentMeta(d.attachments[i].Part)
+		if meta["encoding"] != nil || d.attachments[i].Part.Header.Get("Content-Encoding") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *CompleteDoc) inlineAttachments(keepEncoding bool) error {
+	err := d.readStream()
+	if err != nil {
+		return err
+	}
+
+	for i := range d.attachments {
+		attachment := &d.attachments[i]
 		disposition := attachment.Part.Header.Get("Content-Disposition")
 		matches := dispositionFilename.FindStringSubmatch(disposition)
 
@@ -198,37 +685,89 @@ func (d *CompleteDoc) InlineAttachments() error {
 			return fmt.Errorf("invalid attachment data in json for %q", filename)
 		}
 
+		raw, err := io.ReadAll(attachment.reader())
+		if err != nil {
+			return fmt.Errorf("unable to read attachment %q: %w", filename, err)
+		}
+
 		// if encoded via gzip, decode
-		if attObj["encoding"] == "gzip" {
-			r := io.Reader(bytes.NewReader(attachment.Data))
-			r, err := gzip.NewReader(r)
+		if attObj["encoding"] == "gzip" && !keepEncoding {
+			r, err := gzip.NewReader(bytes.NewReader(raw))
 			if err != nil {
 				return fmt.Errorf("unable to create attachment from gzip: %w", err)
 			}
-			data, err := io.ReadAll(r)
+			raw, err = io.ReadAll(r)
 			if err != nil {
 				return fmt.Errorf("unable to decompress attachment from gzip: %w", err)
 			}
-			attachment.Data = data
 			delete(attObj, "encoding")
 			delete(attObj, "encoded_length")
 		}
 
 		// inline attachment
-		data := base64.StdEncoding.EncodeToString(attachment.Data)
+		data := base64.StdEncoding.EncodeToString(raw)
 		attObj["data"] = data
 
+		// the inlined data is part of the document now
+		err = attachment.release()
+		if err != nil {
+			return err
+		}
+
 		delete(attObj, "stub")
 		delete(attObj, "digest")
-		delete(attObj, "length")
 		delete(attObj, "follows")
+		if attObj["encoding"] == nil {
+			delete(attObj, "length")
+		}
 	}
 
 	return nil
 }
 
+// Attachment is a changed attachment transferred with the document
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Encoding    string // content encoding of the data e.g. gzip
+	Length      int64  // length of the (encoded) data
+	io.Reader
+}
+
+// Attachments returns the changed attachments of the document, unchanged
+// attachments are only referenced as stubs in the document data
+func (d *CompleteDoc) Attachments() ([]Attachment, error) {
+	err := d.readStream()
+	if err != nil {
+		return nil, err
+	}
+
+	atts := make([]Attachment, 0, len(d.attachments))
+	for _, attachment := range d.attachments {
+		disposition := attachment.Part.Header.Get("Content-Disposition")
+		matches := dispositionFilename.FindStringSubmatch(disposition)
+		if len(matches) != 2 {
+			return nil, fmt.Errorf("invalid attachment, filename missing")
+		}
+
+		atts = append(atts, Attachment{
+			Filename:    matches[1],
+			ContentType: attachment.Part.Header.Get("Content-Type"),
+			Encoding:    attachment.Part.Header.Get("Content-Encoding"),
+			Length:      attachment.Size,
+			Reader:      attachment.reader(),
+		})
+	}
+	return atts, nil
+}
+
 // Reader returns a multipart mime representation of the complete doc
 func (d *CompleteDoc) Reader() (io.ReadCloser, string, error) {
+	err := d.readStream()
+	if err != nil {
+		return nil, "", err
+	}
+
 	r, w := io.Pipe()
 	mr := multipart.NewWriter(w)
 
@@ -239,23 +778,28 @@ func (d *CompleteDoc) Reader() (io.ReadCloser, string, error) {
 		})
 		if err != nil {
 			w.CloseWithError(err)
+			return
 		}
 
 		err = json.NewEncoder(dw).Encode(d.Data)
 		if err != nil {
 			w.CloseWithError(err)
+			return
 		}
 
 		// write attachments
-		for _, attachment := range d.attachments {
+		for i := range d.attachments {
+			attachment := &d.attachments[i]
 			aw, err := mr.CreatePart(attachment.Part.Header)
 			if err != nil {
 				w.CloseWithError(err)
+				return
 			}
 
-			_, err = aw.Write(attachment.Data)
+			_, err = io.Copy(aw, attachment.reader())
 			if err != nil {
 				w.CloseWithError(err)
+				return
 			}
 		}
 
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrFailed   = errors.New("operation failed")
+	ErrConflict = errors.New("document update conflict")
+
+	// ErrURLTooLong is returned for requests exceeding the maximum
+	// URL length of the client or the server (414)
+	ErrURLTooLong = errors.New("url too long")
+
+	// ErrUnauthorized (401) and ErrForbidden (403) are returned
+	// if the credentials lack access to the database
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+
+	// ErrSizeUnknown is returned by DocumentSize if the
+	// response has no content length, e.g. if chunked
+	ErrSizeUnknown = errors.New("size unknown")
+)
+
+// maxErrorBody limits the error body that is read
+const maxErrorBody = 64 * 1024
+
+// HTTPError is returned for unexpected responses, it contains the
+// error and reason reported by CouchDB
+type HTTPError struct {
+	Op         string // request that failed, e.g. "bulk upload"
+	StatusCode int
+	Status     string
+	Err        string // CouchDB error, e.g. "forbidden"
+	Reason     string // CouchDB reason or the plain body
+	Endpoint   string // method and URL (password redacted) of the request
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s request failed: %s", e.Op, e.Status)
+	switch {
+	case e.Err != "" && e.Reason != "":
+		msg += fmt.Sprintf(" (%s: %s)", e.Err, e.Reason)
+	case e.Err != "" || e.Reason != "":
+		msg += fmt.Sprintf(" (%s%s)", e.Err, e.Reason)
+	}
+	return msg
+}
+
+// Is allows to use errors.Is with ErrFailed, ErrUnauthorized (401),
+// ErrForbidden (403), ErrNotFound (404), ErrConflict (409) and
+// ErrURLTooLong (414)
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrFailed:
+		return true
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrURLTooLong:
+		return e.StatusCode == http.StatusRequestURITooLong
+	}
+	return false
+}
+
+// newHTTPError reads the CouchDB error body of the response
+func newHTTPError(op string, resp *http.Response) error {
+	e := &HTTPError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+	if resp.Request != nil {
+		e.Endpoint = resp.Request.Method + " " + resp.Request.URL.Redacted()
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var couchErr struct {
+		Error  string `json:"error"`
+		Reason string `json:"reason"`
+	}
+	if json.Unmarshal(body, &couchErr) == nil {
+		e.Err = couchErr.Error
+		e.Reason = couchErr.Reason
+	} else {
+		e.Reason = strings.TrimSpace(string(body))
+	}
+
+	return e
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// IdempotencyKeyHeader identifies the written revisions of a _bulk_docs
+// request or document upload. Replays of a request, e.g. after the
+// response was lost, have the same key and can be deduplicated by the
+// target (see server.TargetHandler).
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader is set by targets that answered a replayed
+// request from their cache instead of writing the documents again
+const IdempotentReplayHeader = "Idempotent-Replayed"
+
+// IdempotencyKey returns the deterministic key of the document revisions
+func IdempotencyKey(docs ...*CompleteDoc) string {
+	h := sha256.New()
+	for _, doc := range docs {
+		_, _ = io.WriteString(h, doc.ID+"\n"+doc.Rev()+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrResponseTooLarge is returned if a JSON response
+	// exceeds the MaxSize of the ResponseLimits
+	ErrResponseTooLarge = errors.New("response too large")
+	// ErrJSONTooDeep is returned if objects and arrays of a
+	// JSON response are nested deeper than allowed
+	ErrJSONTooDeep = errors.New("json nested too deep")
+)
+
+// ResponseLimits protect the client against pathological JSON responses
+// of a broken or malicious peer. Zero values use the default, negative
+// values disable the limit.
+type ResponseLimits struct {
+	// MaxSize limits the size (in bytes) of a JSON response,
+	// defaults to DefaultMaxResponseSize
+	MaxSize int64
+	// MaxJSONDepth limits the nesting of objects and arrays, documents
+	// included, defaults to DefaultMaxJSONDepth
+	MaxJSONDepth int
+}
+
+const (
+	// DefaultMaxResponseSize is the default limit of JSON responses
+	DefaultMaxResponseSize = 1 << 30
+	// DefaultMaxJSONDepth is the default nesting limit of JSON responses
+	DefaultMaxJSONDepth = 1000
+)
+
+func (l ResponseLimits) maxSize() int64 {
+	if l.MaxSize == 0 {
+		return DefaultMaxResponseSize
+	}
+	return l.MaxSize
+}
+
+func (l ResponseLimits) maxJSONDepth() int {
+	return jsonDepthOrDefault(l.MaxJSONDepth)
+}
+
+func jsonDepthOrDefault(depth int) int {
+	if depth == 0 {
+		return DefaultMaxJSONDepth
+	}
+	return depth
+}
+
+// reader applies the limits to the response body
+func (l ResponseLimits) reader(r io.Reader) io.Reader {
+	if size := l.maxSize(); size > 0 {
+		r = &responseSizeReader{r: r, limit: size, n: size}
+	}
+	if depth := l.maxJSONDepth(); depth > 0 {
+		r = &jsonDepthReader{r: r, limit: depth}
+	}
+	return r
+}
+
+// decodeJSON decodes the JSON response body within the limits of the client
+func (c *Client) decodeJSON(r io.Reader, v interface{}) error {
+	return json.NewDecoder(c.limits.reader(r)).Decode(v)
+}
+
+// responseSizeReader fails the read with ErrResponseTooLarge after n bytes
+type responseSizeReader struct {
+	r     io.Reader
+	limit int64
+	n     int64 // remaining bytes
+}
+
+func (l *responseSizeReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, l.limit)
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// jsonDepthReader fails the read with ErrJSONTooDeep if objects and
+// arrays are nested deeper than the limit, the JSON is scanned while read
+type jsonDepthReader struct {
+	r        io.Reader
+	limit    int
+	depth    int
+	inString bool
+	escaped  bool
+	err      error // the limit was exceeded
+}
+
+func (d *jsonDepthReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.r.Read(p)
+	for i, c := range p[:n] {
+		switch {
+		case d.escaped:
+			d.escaped = false
+		case d.inString:
+			if c == '\\' {
+				d.escaped = true
+			} else if c == '"' {
+				d.inString = false
+			}
+		case c == '"':
+			d.inString = true
+		case c == '{' || c == '[':
+			d.depth++
+			if d.depth > d.limit {
+				d.err = fmt.Errorf("%w: more than %d levels", ErrJSONTooDeep, d.limit)
+				return i, d.err
+			}
+		case c == '}' || c == ']':
+			d.depth--
+		}
+	}
+	return n, err
+}
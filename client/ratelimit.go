@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the requests per second using a token bucket, e.g.
+// to respect the request quotas of hosted CouchDB providers, and the
+// requests in flight. A limiter can be shared by the remotes of the same
+// account or server. Responses with 429 (too many requests) temporarily
+// reduce the rate.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	limit     float64 // configured rate, rate is lower while throttled
+	burst     float64
+	tokens    float64
+	last      time.Time
+	throttled time.Time // last reduction or recovery of the rate
+
+	inFlight chan struct{} // nil if unlimited
+}
+
+const (
+	// throttleRecovery is the time without 429 response
+	// after which the throttled rate is doubled again
+	throttleRecovery = 10 * time.Second
+	// maxThrottle is the factor the rate is reduced by at most
+	maxThrottle = 16
+)
+
+// NewRateLimiter creates a limiter allowing rps requests per second,
+// burst requests can be sent at once (at least 1). A rps of 0 only
+// limits the requests in flight.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rps,
+		limit:  rps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// SetMaxInFlight limits the requests waiting for their response at the
+// same time, 0 disables the limit. It has to be set before the limiter
+// is used.
+func (l *RateLimiter) SetMaxInFlight(n int) {
+	l.inFlight = nil
+	if n > 0 {
+		l.inFlight = make(chan struct{}, n)
+	}
+}
+
+// Rate returns the current requests per second, lower
+// than the configured rate while throttled
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Wait blocks until the request may be sent or the context is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil || l.limit <= 0 {
+		return nil
+	}
+
+	wait := l.reserve(time.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// acquire waits for a token and a free in-flight slot, release
+// has to be called once the response headers are received
+func (l *RateLimiter) acquire(ctx context.Context) (release func(), err error) {
+	err = l.Wait(ctx)
+	if err != nil || l == nil || l.inFlight == nil {
+		return func() {}, err
+	}
+
+	select {
+	case l.inFlight <- struct{}{}:
+		return func() { <-l.inFlight }, nil
+	case <-ctx.Done():
+		return func() {}, ctx.Err()
+	}
+}
+
+// reserve takes a token and returns the time to wait until it is available
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the throttled rate recovers step by step
+	if l.rate < l.limit && now.Sub(l.throttled) >= throttleRecovery {
+		l.rate *= 2
+		if l.rate > l.limit {
+			l.rate = l.limit
+		}
+		l.throttled = now
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// throttle halves the rate after a 429 response, down to 1/maxThrottle
+// of the configured rate, and returns the new rate
+func (l *RateLimiter) throttle(now time.Time) float64 {
+	if l == nil || l.limit <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate /= 2
+	if floor := l.limit / maxThrottle; l.rate < floor {
+		l.rate = floor
+	}
+	// the requests sent at once are limited as well
+	if l.tokens > 0 {
+		l.tokens = 0
+	}
+	l.throttled = now
+	return l.rate
+}
+
+// cancel returns the token of a request that wasn't sent
+func (l *RateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+}
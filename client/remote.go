@@ -2,12 +2,41 @@ package client
 
 import (
 	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
 	"sort"
 )
 
 type Remote struct {
 	URL     string            `json:"url"`
 	Headers map[string]string `json:"headers"`
+
+	// Auth is applied to every request, credentials
+	// are not part of the replication id
+	Auth Auth `json:"-"`
+
+	// Limiter limits the requests per second and in flight sent to
+	// the remote, unlimited if nil
+	Limiter *RateLimiter `json:"-"`
+
+	// Signer signs every request, e.g. with HMAC or AWS SigV4 for
+	// gateways in front of the database
+	Signer Signer `json:"-"`
+
+	// ProxyURL is the HTTP(S) or SOCKS5 proxy the requests are sent
+	// through, it is part of the replication id like in CouchDB
+	ProxyURL string `json:"proxy,omitempty"`
+
+	// TLS configures custom CAs, client certificates or disables the
+	// verification, it isn't part of the replication id
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// HTTPClient sends the requests, e.g. with a custom transport (dial
+	// timeouts, TLS config, keep-alive). Defaults to http.DefaultClient,
+	// ProxyURL and TLS are ignored if set.
+	HTTPClient *http.Client `json:"-"`
 }
 
 func (r Remote) GenerateReplicationID(b *bufio.Writer) {
@@ -45,4 +74,58 @@ func (r Remote) GenerateReplicationID(b *bufio.Writer) {
 			panic(err)
 		}
 	}
+
+	// without proxy the id is the one of previous versions
+	if r.ProxyURL != "" {
+		_, err = b.WriteString("proxy|" + r.ProxyURL + "|")
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// httpClient returns the client used for the requests to the remote
+func (r *Remote) httpClient() (*http.Client, error) {
+	if r.HTTPClient != nil {
+		return r.HTTPClient, nil
+	}
+	if r.ProxyURL == "" && r.TLS == nil {
+		return http.DefaultClient, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if r.ProxyURL != "" {
+		proxy, err := url.Parse(r.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if r.TLS != nil {
+		cfg, err := r.TLS.Config()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls config: %w", err)
+		}
+		transport.TLSClientConfig = cfg
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// UnmarshalJSON accepts the url as string in addition to the
+// object, like the source and target of the _replicator database
+func (r *Remote) UnmarshalJSON(data []byte) error {
+	var u string
+	if json.Unmarshal(data, &u) == nil {
+		*r = Remote{URL: u}
+		return nil
+	}
+
+	type remote Remote // without UnmarshalJSON
+	var rr remote
+	err := json.Unmarshal(data, &rr)
+	if err != nil {
+		return err
+	}
+	*r = Remote(rr)
+	return nil
 }
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy defines how requests that failed with a network error or
+// a transient status (429, 500, 502, 503, 504) are retried. Requests are
+// retried with exponential backoff and jitter, a Retry-After header of
+// the server is honored.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries, 0 or a negative value
+	// disables retries. Negative values aren't replaced by the
+	// retries of a configuration profile.
+	MaxRetries int
+	// MaxRetriesByMethod overrides MaxRetries for the HTTP method
+	MaxRetriesByMethod map[string]int
+	// MinBackoff is the wait before the first retry, defaults to 250ms
+	MinBackoff time.Duration
+	// MaxBackoff limits the wait between retries, defaults to 30s
+	MaxBackoff time.Duration
+}
+
+// RetryHook is called before a failed request is retried, err is the
+// network error or the HTTPError of the response
+type RetryHook func(req *http.Request, attempt int, wait time.Duration, err error)
+
+const (
+	defaultMinBackoff = 250 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+func (p RetryPolicy) maxRetries(method string) int {
+	if n, ok := p.MaxRetriesByMethod[method]; ok {
+		return n
+	}
+	return p.MaxRetries
+}
+
+// backoff returns the wait before the retry
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	minWait, maxWait := p.MinBackoff, p.MaxBackoff
+	if minWait <= 0 {
+		minWait = defaultMinBackoff
+	}
+	if maxWait <= 0 {
+		maxWait = defaultMaxBackoff
+	}
+
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if wait > maxWait {
+				return maxWait
+			}
+			return wait
+		}
+	}
+
+	wait := minWait << uint(attempt)
+	if wait > maxWait || wait <= 0 {
+		wait = maxWait
+	}
+
+	// jitter between half and the full backoff
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1)) // nolint: gosec
+}
+
+// retryAfter parses the Retry-After header (seconds or HTTP date)
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		wait := time.Until(t)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// retryable returns true for network errors and transient status codes
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil &&
+			!errors.Is(err, context.Canceled) &&
+			!errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rewindable returns true if the request body can be sent again
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind returns a copy of the request to send it again
+func rewind(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return retry, nil
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ServerInfo is the welcome message of the server root and the
+// status of _up, used to detect the supported features
+type ServerInfo struct {
+	CouchDB  string   `json:"couchdb"`
+	Version  string   `json:"version"`
+	Features []string `json:"features"`
+	Vendor   struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"vendor"`
+
+	// Status reported by _up, e.g. "ok" or "nolb" (maintenance mode),
+	// empty if the server doesn't expose _up
+	Status string `json:"-"`
+}
+
+// MajorVersion returns the major version of the server, 0 if unknown
+func (s *ServerInfo) MajorVersion() int {
+	major, _ := strconv.Atoi(strings.SplitN(s.Version, ".", 2)[0])
+	return major
+}
+
+// HasFeature returns true if the server lists the feature,
+// e.g. "partitioned" or "access-ready"
+func (s *ServerInfo) HasFeature(name string) bool {
+	for _, feature := range s.Features {
+		if feature == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsBulkGet returns false for CouchDB 1.x, other servers are
+// expected to have _bulk_get (CouchDB 2.0, PouchDB)
+func (s *ServerInfo) SupportsBulkGet() bool {
+	return s.CouchDB == "" || s.MajorVersion() != 1
+}
+
+// SupportsSeqInterval returns true if the changes feed accepts
+// seq_interval (CouchDB 2.0)
+func (s *ServerInfo) SupportsSeqInterval() bool {
+	return s.CouchDB != "" && s.MajorVersion() >= 2
+}
+
+// ServerInfo queries the root and _up of the server the database is
+// located on. The server is the parent path of the database URL.
+func (c *Client) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	server := *c.base
+	server.Path = path.Dir("/" + strings.Trim(server.Path, "/"))
+	server.RawPath = ""
+	server.RawQuery = ""
+
+	var info ServerInfo
+	err := c.getServerJSON(ctx, server.String(), "server info", &info)
+	if err != nil {
+		return nil, err
+	}
+
+	var up struct {
+		Status string `json:"status"`
+	}
+	err = c.getServerJSON(ctx, urlJoin(server.String(), "_up"), "up", &up)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	info.Status = up.Status
+
+	return &info, nil
+}
+
+// getServerJSON reads the JSON response of the server endpoint, _up
+// responds with 404 on older servers and 503 in maintenance mode
+func (c *Client) getServerJSON(ctx context.Context, u, op string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := c.request(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusServiceUnavailable:
+	case http.StatusNotFound, http.StatusBadRequest:
+		return ErrNotFound
+	default:
+		return newHTTPError(op, resp)
+	}
+
+	return c.decodeJSON(resp.Body, v)
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer signs the requests sent to a remote, e.g. for gateways in front
+// of CouchDB that require HMAC or AWS SigV4 signed requests. Requests are
+// signed after the Auth and Headers are applied, retries are signed again.
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// unsignedPayload is used as payload hash if the body can't be read twice
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// payloadHash returns the hex encoded SHA-256 of the request body
+func payloadHash(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return emptyPayloadHash, nil
+	}
+	if req.GetBody == nil {
+		return unsignedPayload, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close() // nolint: errcheck
+
+	h := sha256.New()
+	_, err = io.Copy(h, body)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = io.WriteString(mac, data)
+	return mac.Sum(nil)
+}
+
+// HMACSigner signs the requests with a shared secret. The signature is
+// the hex encoded HMAC-SHA256 of the string
+//
+//	METHOD \n PATH?QUERY \n TIMESTAMP \n SHA256(BODY)
+//
+// sent in the X-Signature header together with the X-Key-ID and the
+// X-Timestamp (unix seconds) headers.
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+
+	now func() time.Time // for tests
+}
+
+func (s *HMACSigner) Sign(req *http.Request) error {
+	hash, err := payloadHash(req)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+
+	data := req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + hash
+	req.Header.Set("X-Key-ID", s.KeyID)
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", hex.EncodeToString(hmacSHA256(s.Secret, data)))
+	return nil
+}
+
+// SigV4Signer signs the requests with AWS Signature Version 4, e.g. for
+// CouchDB behind an Amazon API Gateway with IAM authorization. The
+// Authorization header is used, the Auth of the remote must not set it.
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+type SigV4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // temporary credentials, optional
+	Region          string // e.g. "eu-central-1"
+	Service         string // e.g. "execute-api"
+
+	now func() time.Time // for tests
+}
+
+func (s *SigV4Signer) Sign(req *http.Request) error {
+	hash, err := payloadHash(req)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	// S3 requires the payload hash as header
+	if s.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", hash)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		headers["host"] = req.Host
+	}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, "x-amz-") {
+			headers[key] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalPath(req),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+	return nil
+}
+
+// canonicalPath encodes the path segments, twice for all
+// services except S3 as required by SigV4
+func (s *SigV4Signer) canonicalPath(req *http.Request) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	if s.Service == "s3" {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the query parameters sorted by key and value
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsEscape percent encodes everything except the unreserved characters
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return b.String()
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigV4Signer(t *testing.T) {
+	// get-vanilla of the AWS SigV4 test suite
+	s := &SigV4Signer{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		now:             func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Sign(req))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func TestHMACSigner(t *testing.T) {
+	s := &HMACSigner{KeyID: "key", Secret: []byte("secret"), now: func() time.Time { return time.Unix(1600000000, 0) }}
+
+	req, err := http.NewRequest(http.MethodPost, "https://gateway/db/_bulk_docs?x=1", strings.NewReader("{}"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Sign(req))
+	assert.Equal(t, "key", req.Header.Get("X-Key-ID"))
+	assert.Equal(t, "1600000000", req.Header.Get("X-Timestamp"))
+
+	// the signature covers the body
+	signature := req.Header.Get("X-Signature")
+	req, _ = http.NewRequest(http.MethodPost, "https://gateway/db/_bulk_docs?x=1", strings.NewReader("[]"))
+	assert.NoError(t, s.Sign(req))
+	assert.NotEqual(t, signature, req.Header.Get("X-Signature"))
+	assert.Len(t, signature, 64)
+}
@@ -0,0 +1,267 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+)
+
+// errStreamed stops reading the document once the
+// attachments are left in the response for streaming
+var errStreamed = errors.New("attachments streamed")
+
+// docStream are the attachments of a document that are still
+// in the response of the source
+type docStream struct {
+	reader *multipart.Reader
+	raw    []byte // document json as received
+	length int64  // announced length of the attachments
+}
+
+// startStream leaves the attachments in the response if they exceed
+// the StreamThreshold. Attachments that are processed, exceed the
+// MaxAttachmentSize or have no announced length are read as usual.
+func (d *CompleteDoc) startStream(reader *multipart.Reader, raw []byte) bool {
+	if d.opts.StreamThreshold <= 0 {
+		return false
+	}
+
+	var length int64
+	for _, meta := range d.streamedAttachments() {
+		contentType, _ := meta["content_type"].(string)
+		if d.opts.AttachmentProcessors.lookup(contentType) != nil {
+			return false
+		}
+		size := announcedLength(meta)
+		if size < 0 || (d.opts.MaxAttachmentSize > 0 && size > d.opts.MaxAttachmentSize) {
+			return false
+		}
+		length += size
+	}
+	if length <= d.opts.StreamThreshold {
+		return false
+	}
+
+	d.streamable = false
+	d.stream = &docStream{reader: reader, raw: raw, length: length}
+	return true
+}
+
+// streamedAttachments returns the metadata of the
+// attachments that follow the document by name
+func (d *CompleteDoc) streamedAttachments() map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{})
+	atts, _ := d.Data["_attachments"].(map[string]interface{})
+	for name, att := range atts {
+		meta, _ := att.(map[string]interface{})
+		if follows, _ := meta["follows"].(bool); follows {
+			result[name] = meta
+		}
+	}
+	return result
+}
+
+// announcedLength returns the (encoded) length of
+// the attachment metadata or -1 if unknown
+func announcedLength(meta map[string]interface{}) int64 {
+	for _, key := range []string{"encoded_length", "length"} {
+		if length, ok := meta[key].(float64); ok {
+			return int64(length)
+		}
+	}
+	return -1
+}
+
+// readStream reads the streamed attachments like the
+// attachments of documents that aren't streamed
+func (d *CompleteDoc) readStream() error {
+	if d.stream == nil {
+		return nil
+	}
+	s := d.stream
+	d.stream = nil
+	return d.parseStageTwo(s.reader)
+}
+
+// streamPartHeader returns the part header of the attachment
+func streamPartHeader(name string, meta map[string]interface{}) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{
+		"Content-Disposition": []string{`attachment; filename="` + name + `"`},
+	}
+	if contentType, ok := meta["content_type"].(string); ok {
+		header.Set("Content-Type", contentType)
+	}
+	if encoding, ok := meta["encoding"].(string); ok {
+		header.Set("Content-Encoding", encoding)
+	}
+	return header
+}
+
+// uploadStream uploads the document while its attachments are read
+// from the source response, the data isn't buffered. The parts are
+// written with a new boundary and headers derived from the document,
+// so the content length is known before the attachments are read.
+func (c *Client) uploadStream(ctx context.Context, doc *CompleteDoc) error {
+	s := doc.stream
+	doc.stream = nil
+
+	// the received json keeps the order of the attachments
+	raw := s.raw
+	if len(doc.filtered) > 0 {
+		var err error
+		raw, err = json.Marshal(doc.Data)
+		if err != nil {
+			return err
+		}
+	}
+	atts := doc.streamedAttachments()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	length, err := streamLength(mw.Boundary(), raw, atts)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(doc.writeStream(mw, s.reader, raw, atts)) // nolint: errcheck
+	}()
+	// the source response isn't read anymore once returned
+	defer func() {
+		pr.Close() // nolint: errcheck
+		<-done
+	}()
+
+	u := c.dbURL(docPath(doc.ID), url.Values{"new_edits": {"false"}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, pr)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", `multipart/related; boundary="`+mw.Boundary()+`"`)
+	req.Header.Add(IdempotencyKeyHeader, IdempotencyKey(doc))
+
+	resp, err := c.request(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return newHTTPError("upload document with attachment", resp)
+	}
+
+	return nil
+}
+
+// streamLength returns the size of the multipart body
+// written by writeStream with the boundary
+func streamLength(boundary string, raw []byte, atts map[string]map[string]interface{}) (int64, error) {
+	var size sizeWriter
+	mw := multipart.NewWriter(&size)
+	err := mw.SetBoundary(boundary)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": []string{"application/json"},
+	})
+	if err != nil {
+		return 0, err
+	}
+	length := int64(len(raw))
+
+	names := make([]string, 0, len(atts))
+	for name := range atts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err = mw.CreatePart(streamPartHeader(name, atts[name]))
+		if err != nil {
+			return 0, err
+		}
+		length += announcedLength(atts[name])
+	}
+
+	err = mw.Close()
+	if err != nil {
+		return 0, err
+	}
+	return int64(size) + length, nil
+}
+
+// writeStream writes the document and copies the attachments from the
+// source parts, attachments not matching their announced length fail
+// the upload
+func (d *CompleteDoc) writeStream(mw *multipart.Writer, reader *multipart.Reader, raw []byte, atts map[string]map[string]interface{}) error {
+	w, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": []string{"application/json"},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(raw)
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]bool, len(atts))
+	for {
+		part, err := d.nextPart(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		matches := dispositionFilename.FindStringSubmatch(part.Header.Get("Content-Disposition"))
+		if len(matches) != 2 {
+			return fmt.Errorf("invalid attachment, filename missing")
+		}
+		name := matches[1]
+		if d.isFiltered(name) {
+			_, err = io.Copy(io.Discard, part)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		meta, ok := atts[name]
+		if !ok || written[name] {
+			return fmt.Errorf("unexpected attachment %q", name)
+		}
+
+		w, err := mw.CreatePart(streamPartHeader(name, meta))
+		if err != nil {
+			return err
+		}
+		length := announcedLength(meta)
+		n, err := io.Copy(w, io.LimitReader(part, length+1))
+		if err != nil {
+			return fmt.Errorf("failed to stream attachment %q: %w", name, err)
+		}
+		if n != length {
+			return fmt.Errorf("attachment %q has %d bytes, announced %d", name, n, length)
+		}
+		written[name] = true
+	}
+
+	if len(written) != len(atts) {
+		return fmt.Errorf("%d of %d attachments missing in the response", len(atts)-len(written), len(atts))
+	}
+	return mw.Close()
+}
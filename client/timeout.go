@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned if a request exceeded one of the Timeouts,
+// the request is retried according to the RetryPolicy
+var ErrTimeout = errors.New("timeout")
+
+// Timeouts limit the phases of a request, so a hung server doesn't stall
+// the replication. Zero values use the default, negative values disable
+// the timeout.
+type Timeouts struct {
+	// Connect limits establishing the connection including the
+	// TLS handshake, defaults to 30s
+	Connect time.Duration
+	// Request limits the time from the connection until the response
+	// headers are received, defaults to 5m
+	Request time.Duration
+	// ChangesRead limits the time between two reads of the changes
+	// feed, defaults to 2m and is at least twice the heartbeat
+	// or timeout of the feed
+	ChangesRead time.Duration
+}
+
+const (
+	defaultConnectTimeout     = 30 * time.Second
+	defaultRequestTimeout     = 5 * time.Minute
+	defaultChangesReadTimeout = 2 * time.Minute
+)
+
+func (t Timeouts) connect() time.Duration {
+	return timeoutOrDefault(t.Connect, defaultConnectTimeout)
+}
+
+func (t Timeouts) request() time.Duration {
+	return timeoutOrDefault(t.Request, defaultRequestTimeout)
+}
+
+// changesRead returns the read timeout of a changes feed that is
+// idle up to the interval
+func (t Timeouts) changesRead(idle time.Duration) time.Duration {
+	d := timeoutOrDefault(t.ChangesRead, defaultChangesReadTimeout)
+	if d > 0 && d < 2*idle {
+		return 2 * idle
+	}
+	return d
+}
+
+func timeoutOrDefault(d, fallback time.Duration) time.Duration {
+	if d == 0 {
+		return fallback
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// readTimeoutKey is the context key of the read timeout of the response
+type readTimeoutKey struct{}
+
+// withReadTimeout limits the time between two reads of the response body
+func withReadTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, readTimeoutKey{}, d)
+}
+
+// watchdog cancels the request if the current phase isn't finished in time
+type watchdog struct {
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired string // phase that timed out
+}
+
+// arm starts the timer of the phase, replacing the previous one,
+// a duration of 0 only stops the previous timer
+func (w *watchdog) arm(phase string, d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if d <= 0 {
+		return
+	}
+	w.timer = time.AfterFunc(d, func() {
+		w.mu.Lock()
+		w.expired = fmt.Sprintf("%s after %s", phase, d)
+		w.mu.Unlock()
+		w.cancel()
+	})
+}
+
+// err converts the error caused by an expired timer into ErrTimeout
+func (w *watchdog) err(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err == nil || w.expired == "" || err == io.EOF {
+		return err
+	}
+	return fmt.Errorf("%w: %s: %v", ErrTimeout, w.expired, err)
+}
+
+// doWithTimeouts sends the request with the connect and request
+// timeouts, the read timeout applies to the response body
+func (c *Client) doWithTimeouts(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	wd := &watchdog{cancel: cancel}
+
+	connect, request := c.timeouts.connect(), c.timeouts.request()
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { wd.arm("connect", connect) },
+		GotConn: func(httptrace.GotConnInfo) { wd.arm("request", request) },
+	})
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		wd.arm("", 0)
+		cancel()
+		return nil, wd.err(err)
+	}
+
+	read, _ := req.Context().Value(readTimeoutKey{}).(time.Duration)
+	wd.arm("read", read)
+	resp.Body = &timeoutBody{ReadCloser: resp.Body, wd: wd, read: read}
+	return resp, nil
+}
+
+// timeoutBody restarts the read timeout with every read and
+// releases the watchdog once closed
+type timeoutBody struct {
+	io.ReadCloser
+	wd   *watchdog
+	read time.Duration
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.wd.arm("read", b.read)
+	}
+	return n, b.wd.err(err)
+}
+
+func (b *timeoutBody) Close() error {
+	b.wd.arm("", 0)
+	err := b.ReadCloser.Close()
+	b.wd.cancel()
+	return err
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrInvalidCert is returned if a certificate of the TLSConfig
+// can't be parsed
+var ErrInvalidCert = errors.New("invalid certificate")
+
+// TLSConfig configures the TLS connections to a remote, e.g. a server
+// behind an internal PKI. Certificates and keys are PEM encoded, either
+// inline or read from a file.
+type TLSConfig struct {
+	// CA certificates trusted in addition to the system pool
+	CAFile string `json:"ca_file,omitempty"`
+	CAPEM  string `json:"ca_pem,omitempty"`
+
+	// client certificate and key for mutual TLS
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	CertPEM  string `json:"cert_pem,omitempty"`
+	KeyPEM   string `json:"key_pem,omitempty"`
+
+	// ServerName overrides the host name the certificate is verified
+	// against, e.g. if the server is reached by IP
+	ServerName string `json:"server_name,omitempty"`
+
+	// InsecureSkipVerify disables the certificate verification,
+	// only use it for test clusters
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// Config returns the tls.Config for the transport
+func (t *TLSConfig) Config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify, // nolint: gosec
+	}
+
+	ca, err := pemData(t.CAPEM, t.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(ca) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%w: no CA certificate found", ErrInvalidCert)
+		}
+		cfg.RootCAs = pool
+	}
+
+	cert, err := pemData(t.CertPEM, t.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := pemData(t.KeyPEM, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(cert) > 0 || len(key) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCert, err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	return cfg, nil
+}
+
+// pemData returns the inline data or the content of the file
+func pemData(inline, file string) ([]byte, error) {
+	if inline != "" || file == "" {
+		return []byte(inline), nil
+	}
+	return os.ReadFile(file)
+}
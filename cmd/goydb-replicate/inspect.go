@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/goydb/replicator/client"
+)
+
+// inspect shows the last changes of the source, with a target it
+// shows which of them are missing on the target
+func inspect(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	sourceURL := fs.String("source", "", "url of the source database")
+	targetURL := fs.String("target", "", "url of the target database, optional")
+	limit := fs.Int("limit", 100, "number of changes")
+	filter := fs.String("filter", "", "filter function of the changes, e.g. ddoc/name")
+	_ = fs.Parse(args)
+
+	if *sourceURL == "" {
+		fs.Usage()
+		return errors.New("source is required")
+	}
+
+	source, err := client.NewClient(&client.Remote{URL: *sourceURL})
+	if err != nil {
+		return err
+	}
+
+	changes, err := source.Changes(ctx, client.ChangeOptions{
+		Descending: true,
+		Limit:      *limit,
+		Filter:     *filter,
+	})
+	if err != nil {
+		return err
+	}
+
+	var diff client.DiffResponse
+	if *targetURL != "" {
+		target, err := client.NewClient(&client.Remote{URL: *targetURL})
+		if err != nil {
+			return err
+		}
+
+		req := make(client.RevDiffRequest, len(changes.Results))
+		for _, change := range changes.Results {
+			for _, rev := range change.Changes {
+				req[change.ID] = append(req[change.ID], rev.Rev)
+			}
+		}
+		diff, err = target.RevDiff(ctx, req)
+		if err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SEQ\tID\tREVS\tDELETED\tMISSING")
+	for _, change := range changes.Results {
+		revs := make([]string, 0, len(change.Changes))
+		for _, rev := range change.Changes {
+			revs = append(revs, rev.Rev)
+		}
+
+		missing := "-"
+		if diff != nil {
+			missing = "no"
+			if d, ok := diff[change.ID]; ok && len(d.Missing) > 0 {
+				missing = strings.Join(d.Missing, ",")
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%s\n", change.Seq, change.ID, strings.Join(revs, ","), change.Deleted, missing)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/logger"
+	"gopkg.in/yaml.v2"
+)
+
+// runConfig configures a replication, it is read from the
+// YAML config file, flags override the values of the file
+type runConfig struct {
+	Name         string            `yaml:"name"` // used for the replication id, defaults to the hostname
+	Source       remoteConfig      `yaml:"source"`
+	Target       remoteConfig      `yaml:"target"`
+	Continuous   bool              `yaml:"continuous"`
+	CreateTarget bool              `yaml:"create_target"`
+	Since        string            `yaml:"since"`
+	Filter       string            `yaml:"filter"`
+	QueryParams  map[string]string `yaml:"query_params"`
+	Selector     string            `yaml:"selector"`    // mango selector as JSON
+	DesignDocs   string            `yaml:"design_docs"` // exclude or only
+
+	Profile            string        `yaml:"profile"` // e.g. conservative-hosted
+	BatchSizeBytes     int64         `yaml:"batch_size_bytes"`
+	BatchDocs          int           `yaml:"batch_docs"`
+	FetchConcurrency   int           `yaml:"fetch_concurrency"`
+	Heartbeat          time.Duration `yaml:"heartbeat"`
+	CheckpointPrefix   string        `yaml:"checkpoint_prefix"`
+	UseCheckpoints     bool          `yaml:"use_checkpoints"`
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+	SourceReadOnly     bool          `yaml:"source_read_only"`
+	SlowRequest        time.Duration `yaml:"slow_request"`
+	ConnectTimeout     time.Duration `yaml:"connect_timeout"`
+	RequestTimeout     time.Duration `yaml:"request_timeout"`
+	ChangesTimeout     time.Duration `yaml:"changes_timeout"`
+
+	Attachments attachmentsConfig `yaml:"attachments"`
+
+	Plan     bool          `yaml:"-"` // print the plan instead of replicating
+	Progress time.Duration `yaml:"progress"`
+	LogLevel string        `yaml:"log_level"`
+}
+
+// attachmentsConfig are the name and content type patterns
+// of the replicated attachments, e.g. deny: ["*.mp4"]
+type attachmentsConfig struct {
+	Allow             []string `yaml:"allow"`
+	AllowContentTypes []string `yaml:"allow_content_types"`
+	Deny              []string `yaml:"deny"`
+	DenyContentTypes  []string `yaml:"deny_content_types"`
+}
+
+// remoteConfig is a source or target database
+type remoteConfig struct {
+	URL      string            `yaml:"url"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Token    string            `yaml:"token"` // bearer token (JWT)
+	Headers  map[string]string `yaml:"headers"`
+	RPS      float64           `yaml:"rps"`           // requests per second, unlimited if 0
+	Burst    int               `yaml:"burst"`         // requests sent at once
+	InFlight int               `yaml:"max_in_flight"` // requests waiting for a response, unlimited if 0
+	Proxy    string            `yaml:"proxy"`         // HTTP(S) or SOCKS5 proxy URL
+	TLS      tlsConfig         `yaml:"tls"`
+	SigV4    *sigV4Config      `yaml:"sigv4"` // AWS SigV4 signed requests
+	HMAC     *hmacConfig       `yaml:"hmac"`  // HMAC signed requests
+}
+
+// sigV4Config are the AWS credentials the requests are signed with
+type sigV4Config struct {
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	Region          string `yaml:"region"`
+	Service         string `yaml:"service"` // e.g. execute-api
+}
+
+// hmacConfig is the shared secret the requests are signed with
+type hmacConfig struct {
+	KeyID  string `yaml:"key_id"`
+	Secret string `yaml:"secret"`
+}
+
+// tlsConfig are the PEM files of the TLS connection
+type tlsConfig struct {
+	CA       string `yaml:"ca"`   // CA bundle trusted in addition to the system pool
+	Cert     string `yaml:"cert"` // client certificate for mutual TLS
+	Key      string `yaml:"key"`
+	Insecure bool   `yaml:"insecure"` // skip the certificate verification
+}
+
+func (rc remoteConfig) remote() *client.Remote {
+	remote := &client.Remote{URL: rc.URL, Headers: rc.Headers, ProxyURL: rc.Proxy}
+	switch {
+	case rc.Token != "":
+		remote.Auth = client.NewJWTAuth(rc.Token)
+	case rc.Username != "":
+		remote.Auth = &client.BasicAuth{Username: rc.Username, Password: rc.Password}
+	}
+	switch {
+	case rc.SigV4 != nil:
+		remote.Signer = &client.SigV4Signer{
+			AccessKeyID:     rc.SigV4.AccessKeyID,
+			SecretAccessKey: rc.SigV4.SecretAccessKey,
+			SessionToken:    rc.SigV4.SessionToken,
+			Region:          rc.SigV4.Region,
+			Service:         rc.SigV4.Service,
+		}
+	case rc.HMAC != nil:
+		remote.Signer = &client.HMACSigner{KeyID: rc.HMAC.KeyID, Secret: []byte(rc.HMAC.Secret)}
+	}
+	if rc.TLS != (tlsConfig{}) {
+		remote.TLS = &client.TLSConfig{
+			CAFile:             rc.TLS.CA,
+			CertFile:           rc.TLS.Cert,
+			KeyFile:            rc.TLS.Key,
+			InsecureSkipVerify: rc.TLS.Insecure,
+		}
+	}
+	if rc.RPS > 0 || rc.InFlight > 0 {
+		remote.Limiter = client.NewRateLimiter(rc.RPS, rc.Burst)
+		remote.Limiter.SetMaxInFlight(rc.InFlight)
+	}
+	return remote
+}
+
+// mapFlag collects repeated key=value flags
+type mapFlag struct {
+	m *map[string]string
+}
+
+func (f mapFlag) String() string {
+	if f.m == nil {
+		return ""
+	}
+	var pairs []string
+	for key, value := range *f.m {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f mapFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	if *f.m == nil {
+		*f.m = make(map[string]string)
+	}
+	(*f.m)[parts[0]] = parts[1]
+	return nil
+}
+
+// runFlags binds the flags to the config, the current
+// values of the config are used as defaults
+func runFlags(cfg *runConfig, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.StringVar(configPath, "config", *configPath, "YAML config file, flags override its values")
+	fs.StringVar(&cfg.Name, "name", cfg.Name, "name of the replicator used for the replication id, defaults to the hostname")
+	fs.StringVar(&cfg.Source.URL, "source", cfg.Source.URL, "url of the source database")
+	fs.StringVar(&cfg.Source.Username, "source-user", cfg.Source.Username, "username of the source")
+	fs.StringVar(&cfg.Source.Password, "source-password", cfg.Source.Password, "password of the source")
+	fs.StringVar(&cfg.Source.Token, "source-token", cfg.Source.Token, "bearer token of the source")
+	fs.Float64Var(&cfg.Source.RPS, "source-rps", cfg.Source.RPS, "requests per second sent to the source, unlimited if 0")
+	fs.IntVar(&cfg.Source.InFlight, "source-max-in-flight", cfg.Source.InFlight, "requests waiting for a response of the source, unlimited if 0")
+	fs.StringVar(&cfg.Source.Proxy, "source-proxy", cfg.Source.Proxy, "proxy URL of the source requests")
+	fs.StringVar(&cfg.Source.TLS.CA, "source-ca", cfg.Source.TLS.CA, "PEM file of the CA certificates of the source")
+	fs.StringVar(&cfg.Source.TLS.Cert, "source-cert", cfg.Source.TLS.Cert, "PEM file of the client certificate for the source")
+	fs.StringVar(&cfg.Source.TLS.Key, "source-key", cfg.Source.TLS.Key, "PEM file of the client key for the source")
+	fs.BoolVar(&cfg.Source.TLS.Insecure, "source-insecure", cfg.Source.TLS.Insecure, "skip the TLS certificate verification of the source")
+	fs.StringVar(&cfg.Target.URL, "target", cfg.Target.URL, "url of the target database")
+	fs.StringVar(&cfg.Target.Username, "target-user", cfg.Target.Username, "username of the target")
+	fs.StringVar(&cfg.Target.Password, "target-password", cfg.Target.Password, "password of the target")
+	fs.StringVar(&cfg.Target.Token, "target-token", cfg.Target.Token, "bearer token of the target")
+	fs.Float64Var(&cfg.Target.RPS, "target-rps", cfg.Target.RPS, "requests per second sent to the target, unlimited if 0")
+	fs.IntVar(&cfg.Target.InFlight, "target-max-in-flight", cfg.Target.InFlight, "requests waiting for a response of the target, unlimited if 0")
+	fs.StringVar(&cfg.Target.Proxy, "target-proxy", cfg.Target.Proxy, "proxy URL of the target requests")
+	fs.StringVar(&cfg.Target.TLS.CA, "target-ca", cfg.Target.TLS.CA, "PEM file of the CA certificates of the target")
+	fs.StringVar(&cfg.Target.TLS.Cert, "target-cert", cfg.Target.TLS.Cert, "PEM file of the client certificate for the target")
+	fs.StringVar(&cfg.Target.TLS.Key, "target-key", cfg.Target.TLS.Key, "PEM file of the client key for the target")
+	fs.BoolVar(&cfg.Target.TLS.Insecure, "target-insecure", cfg.Target.TLS.Insecure, "skip the TLS certificate verification of the target")
+	fs.BoolVar(&cfg.Continuous, "continuous", cfg.Continuous, "follow the changes of the source until interrupted")
+	fs.BoolVar(&cfg.CreateTarget, "create-target", cfg.CreateTarget, "create the target database if it doesn't exist")
+	fs.StringVar(&cfg.Since, "since", cfg.Since, "start sequence until a checkpoint is recorded, \"now\" only replicates new changes")
+	fs.StringVar(&cfg.Filter, "filter", cfg.Filter, "filter function of the changes, e.g. ddoc/name")
+	fs.Var(mapFlag{&cfg.QueryParams}, "param", "query parameter of the filter function as key=value, repeatable")
+	fs.StringVar(&cfg.Selector, "selector", cfg.Selector, "mango selector of the changes as JSON")
+	fs.StringVar(&cfg.DesignDocs, "design-docs", cfg.DesignDocs, "replicate the design documents, \"exclude\" skips them, \"only\" replicates only them")
+	fs.StringVar(&cfg.Profile, "profile", cfg.Profile, "defaults of the batch, retry and throttle options (aggressive-lan, conservative-hosted or low-memory-edge)")
+	fs.Int64Var(&cfg.BatchSizeBytes, "batch-size", cfg.BatchSizeBytes, "bytes written to the target per bulk request, defaults to 10 MB")
+	fs.IntVar(&cfg.BatchDocs, "batch-docs", cfg.BatchDocs, "documents written to the target per bulk request, unlimited if 0")
+	fs.IntVar(&cfg.FetchConcurrency, "fetch-concurrency", cfg.FetchConcurrency, "documents fetched from the source in parallel")
+	fs.DurationVar(&cfg.Heartbeat, "heartbeat", cfg.Heartbeat, "heartbeat of the continuous changes feed")
+	fs.StringVar(&cfg.CheckpointPrefix, "checkpoint-prefix", cfg.CheckpointPrefix, "prefix of the checkpoint document ids")
+	fs.BoolVar(&cfg.UseCheckpoints, "use-checkpoints", cfg.UseCheckpoints, "record checkpoints, false replicates without writing _local documents")
+	fs.BoolVar(&cfg.SourceReadOnly, "source-read-only", cfg.SourceReadOnly, "record the checkpoints on the target only if the source refuses them")
+	fs.DurationVar(&cfg.CheckpointInterval, "checkpoint-interval", cfg.CheckpointInterval, "interval of the intermediate checkpoints, 0 only checkpoints after every batch")
+	fs.DurationVar(&cfg.SlowRequest, "slow-request", cfg.SlowRequest, "log requests taking longer than the duration as warning, 0 disables it")
+	fs.DurationVar(&cfg.ConnectTimeout, "connect-timeout", cfg.ConnectTimeout, "timeout to connect to source and target, defaults to 30s, negative disables it")
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", cfg.RequestTimeout, "timeout until the response headers are received, defaults to 5m, negative disables it")
+	fs.DurationVar(&cfg.ChangesTimeout, "changes-timeout", cfg.ChangesTimeout, "timeout between two reads of the changes feed, defaults to 2m, negative disables it")
+	fs.BoolVar(&cfg.Plan, "plan", cfg.Plan, "print the resolved configuration as JSON without replicating")
+	fs.DurationVar(&cfg.Progress, "progress", cfg.Progress, "interval of the progress output, 0 disables it")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level (debug, info, warning or error)")
+	return fs
+}
+
+// loadRunConfig reads the flags and the config file they point to
+func loadRunConfig(args []string) (*runConfig, error) {
+	cfg := &runConfig{UseCheckpoints: true, Progress: 5 * time.Second, LogLevel: "warning"}
+	var configPath string
+	_ = runFlags(cfg, &configPath).Parse(args)
+	if configPath == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg = &runConfig{UseCheckpoints: true, Progress: 5 * time.Second, LogLevel: "warning"}
+	err = yaml.UnmarshalStrict(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", configPath, err)
+	}
+
+	// flags take precedence over the file
+	_ = runFlags(cfg, &configPath).Parse(args)
+	return cfg, nil
+}
+
+func (cfg *runConfig) job() (*replicator.Job, error) {
+	job := &replicator.Job{
+		Source:         cfg.Source.remote(),
+		Target:         cfg.Target.remote(),
+		Continuous:     cfg.Continuous,
+		CreateTarget:   cfg.CreateTarget,
+		SinceSeq:       cfg.Since,
+		Filter:         cfg.Filter,
+		QueryParams:    cfg.QueryParams,
+		DesignDocs:     replicator.DesignDocs(cfg.DesignDocs),
+		UseCheckpoints: &cfg.UseCheckpoints,
+		Config: replicator.Config{
+			Profile:            replicator.Profile(cfg.Profile),
+			Heartbeat:          cfg.Heartbeat,
+			BatchSizeBytes:     cfg.BatchSizeBytes,
+			BatchDocLimit:      cfg.BatchDocs,
+			FetchConcurrency:   cfg.FetchConcurrency,
+			CheckpointPrefix:   cfg.CheckpointPrefix,
+			CheckpointInterval: cfg.CheckpointInterval,
+			SourceReadOnly:     cfg.SourceReadOnly,
+
+			SlowRequestThreshold: cfg.SlowRequest,
+			Timeouts: client.Timeouts{
+				Connect:     cfg.ConnectTimeout,
+				Request:     cfg.RequestTimeout,
+				ChangesRead: cfg.ChangesTimeout,
+			},
+		},
+	}
+	if a := cfg.Attachments; len(a.Allow)+len(a.AllowContentTypes)+len(a.Deny)+len(a.DenyContentTypes) > 0 {
+		job.AttachmentFilter = &client.AttachmentFilter{
+			Allow:             a.Allow,
+			AllowContentTypes: a.AllowContentTypes,
+			Deny:              a.Deny,
+			DenyContentTypes:  a.DenyContentTypes,
+		}
+	}
+	if cfg.Selector != "" {
+		if !json.Valid([]byte(cfg.Selector)) {
+			return nil, fmt.Errorf("selector is not valid JSON: %s", cfg.Selector)
+		}
+		job.Selector = json.RawMessage(cfg.Selector)
+	}
+	return job, nil
+}
+
+// run replicates the source to the target once, or continuously
+// until interrupted
+func run(ctx context.Context, args []string) error {
+	cfg, err := loadRunConfig(args)
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	if cfg.Source.URL == "" || cfg.Target.URL == "" {
+		return &exitError{code: exitUsage, err: fmt.Errorf("source and target are required")}
+	}
+	level, err := logger.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+
+	job, err := cfg.job()
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	name := cfg.Name
+	if name == "" {
+		name, err = os.Hostname()
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := replicator.NewReplicator(name, job)
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	r.SetLogger(logger.WithLevel(new(logger.Stdout), level))
+	if cfg.Plan {
+		plan, err := r.Plan(ctx)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+	if cfg.Progress > 0 {
+		r.SetProgress(printProgress, cfg.Progress)
+	}
+
+	// an interrupt stops the replication gracefully,
+	// the final checkpoint is still recorded
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Cancel()
+		case <-done:
+		}
+	}()
+
+	err = r.Run(context.Background())
+	result, stats := r.Result(), r.Stats()
+	fmt.Printf("read %d, written %d, write failures %d, skipped %d, checkpoint %s\n",
+		stats.DocsRead, stats.DocsWritten, stats.DocWriteFailures, result.DocsSkipped, stats.Seq)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case result.Stopped:
+		return &exitError{code: exitInterrupted, err: fmt.Errorf("interrupted at checkpoint %s", stats.Seq)}
+	case result.DocsSkipped > 0 || stats.DocWriteFailures > 0:
+		return &exitError{code: exitIncomplete, err: fmt.Errorf("%d documents skipped, %d writes failed", result.DocsSkipped, stats.DocWriteFailures)}
+	case result.Checkpoint.Failures > 0:
+		return &exitError{code: exitIncomplete, err: fmt.Errorf("%d checkpoints failed: %v", result.Checkpoint.Failures, result.Checkpoint.Err)}
+	}
+	return nil
+}
+
+func printProgress(stats replicator.Stats) {
+	fmt.Fprintf(os.Stderr, "seq %s: read %d, written %d, pending %d, %.1f docs/s\n",
+		stats.LastSeq, stats.DocsRead, stats.DocsWritten, stats.DocsPending, stats.DocsWrittenPerSec)
+}
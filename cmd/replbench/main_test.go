@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/memdb"
+	"github.com/goydb/replicator/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountDocs(t *testing.T) {
+	db := memdb.New("bench-source")
+	for i := 0; i < 3; i++ {
+		_, err := db.Put(fmt.Sprintf("doc-%d", i), map[string]interface{}{"n": i})
+		assert.NoError(t, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/bench-source/", http.StripPrefix("/bench-source", server.NewSourceHandler(db)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	docs, err := countDocs(context.Background(), &client.Remote{URL: srv.URL + "/bench-source/"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, docs)
+}
+
+func TestBenchConfigs(t *testing.T) {
+	configs, err := benchConfigs("1024,2048", "0", "1, 4", 2)
+	assert.NoError(t, err)
+	if assert.Len(t, configs, 8) {
+		assert.Equal(t, "size=1024,docs=0,fetch=1-1", configs[0].Name)
+		assert.Equal(t, "size=2048,docs=0,fetch=4-2", configs[7].Name)
+		assert.Equal(t, int64(2048), configs[7].Config.BatchSizeBytes)
+		assert.Equal(t, 4, configs[7].Config.FetchConcurrency)
+		assert.Zero(t, configs[7].Config.BatchDocLimit)
+	}
+
+	_, err = benchConfigs("1024", "x", "1", 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/goydb/replicator/client"
+)
+
+// CompareReport lists the differences between two databases
+type CompareReport struct {
+	SourceDocs int // documents of the source
+	TargetDocs int // documents of the target
+
+	MissingOnTarget []string // documents only stored on the source
+	MissingOnSource []string // documents only stored on the target
+
+	// RevMismatches the winning revisions differ
+	RevMismatches []RevMismatch
+	// ConflictMismatches the winning revisions are equal,
+	// but the conflicting revisions differ
+	ConflictMismatches []RevMismatch
+}
+
+// Equal returns true if no differences were found
+func (r *CompareReport) Equal() bool {
+	return len(r.MissingOnTarget) == 0 && len(r.MissingOnSource) == 0 &&
+		len(r.RevMismatches) == 0 && len(r.ConflictMismatches) == 0
+}
+
+// RevMismatch is a document with different revisions on source and target
+type RevMismatch struct {
+	ID              string
+	SourceRev       string
+	TargetRev       string
+	SourceConflicts []string
+	TargetConflicts []string
+}
+
+// Compare walks the documents of both databases and reports the
+// differences, independent of any replication checkpoint. The documents
+// are compared by their winning and conflicting revisions, deleted
+// documents aren't listed by the databases and are reported as missing.
+func Compare(ctx context.Context, source, target *client.Client) (*CompareReport, error) {
+	src := newDocCursor(source)
+	tgt := newDocCursor(target)
+	report := new(CompareReport)
+
+	for {
+		s, err := src.peek(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t, err := tgt.peek(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// both databases list the documents ordered by id
+		switch {
+		case s == nil && t == nil:
+			return report, nil
+		case t == nil || (s != nil && s.ID < t.ID):
+			report.SourceDocs++
+			report.MissingOnTarget = append(report.MissingOnTarget, s.ID)
+			src.next()
+		case s == nil || t.ID < s.ID:
+			report.TargetDocs++
+			report.MissingOnSource = append(report.MissingOnSource, t.ID)
+			tgt.next()
+		default:
+			report.SourceDocs++
+			report.TargetDocs++
+			mismatch := RevMismatch{
+				ID:              s.ID,
+				SourceRev:       s.Rev,
+				TargetRev:       t.Rev,
+				SourceConflicts: s.Conflicts,
+				TargetConflicts: t.Conflicts,
+			}
+			if s.Rev != t.Rev {
+				report.RevMismatches = append(report.RevMismatches, mismatch)
+			} else if !equalRevs(s.Conflicts, t.Conflicts) {
+				report.ConflictMismatches = append(report.ConflictMismatches, mismatch)
+			}
+			src.next()
+			tgt.next()
+		}
+	}
+}
+
+// equalRevs returns true if both lists contain the same revisions
+func equalRevs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compareDoc are the revisions of a listed document
+type compareDoc struct {
+	ID        string   `json:"_id"`
+	Rev       string   `json:"_rev"`
+	Conflicts []string `json:"_conflicts"`
+}
+
+// docCursor pages through the documents of a database
+type docCursor struct {
+	c    *client.Client
+	opts client.AllDocsOptions
+	docs []compareDoc
+	done bool
+}
+
+func newDocCursor(c *client.Client) *docCursor {
+	return &docCursor{
+		c: c,
+		opts: client.AllDocsOptions{
+			LocalDocsOptions: client.LocalDocsOptions{
+				Limit:       client.DefaultLocalDocsPageSize,
+				IncludeDocs: true,
+			},
+			Conflicts: true,
+		},
+	}
+}
+
+// peek returns the current document or nil if all documents were read
+func (dc *docCursor) peek(ctx context.Context) (*compareDoc, error) {
+	if len(dc.docs) == 0 && !dc.done {
+		err := dc.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(dc.docs) == 0 {
+		return nil, nil
+	}
+	return &dc.docs[0], nil
+}
+
+func (dc *docCursor) next() {
+	dc.docs = dc.docs[1:]
+}
+
+// fetch reads the next page of documents
+func (dc *docCursor) fetch(ctx context.Context) error {
+	resp, err := dc.c.AllDocs(ctx, dc.opts)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range resp.Rows {
+		doc := compareDoc{ID: row.ID, Rev: row.Value.Rev}
+		if len(row.Doc) > 0 {
+			err = json.Unmarshal(row.Doc, &doc)
+			if err != nil {
+				return err
+			}
+		}
+		dc.docs = append(dc.docs, doc)
+	}
+
+	if len(resp.Rows) < dc.opts.Limit {
+		dc.done = true
+		return nil
+	}
+
+	// continue after the last key of the page
+	dc.opts.StartKey = resp.Rows[len(resp.Rows)-1].Key
+	dc.opts.Skip = 1
+	return nil
+}
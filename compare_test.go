@@ -0,0 +1,56 @@
+package replicator_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	dbs := map[string]string{
+		"source": `{"rows":[
+			{"id":"a","key":"a","value":{"rev":"1-a"},"doc":{"_id":"a","_rev":"1-a"}},
+			{"id":"b","key":"b","value":{"rev":"2-b"},"doc":{"_id":"b","_rev":"2-b"}},
+			{"id":"c","key":"c","value":{"rev":"1-c"},"doc":{"_id":"c","_rev":"1-c","_conflicts":["1-x"]}},
+			{"id":"d","key":"d","value":{"rev":"1-d"},"doc":{"_id":"d","_rev":"1-d"}}
+		]}`,
+		"target": `{"rows":[
+			{"id":"a","key":"a","value":{"rev":"1-a"},"doc":{"_id":"a","_rev":"1-a"}},
+			{"id":"b","key":"b","value":{"rev":"1-b"},"doc":{"_id":"b","_rev":"1-b"}},
+			{"id":"c","key":"c","value":{"rev":"1-c"},"doc":{"_id":"c","_rev":"1-c"}},
+			{"id":"e","key":"e","value":{"rev":"1-e"},"doc":{"_id":"e","_rev":"1-e"}}
+		]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/_all_docs")
+		assert.Equal(t, "true", r.URL.Query().Get("conflicts"))
+		fmt.Fprint(w, dbs[db])
+	}))
+	defer srv.Close()
+
+	source, err := client.NewClient(&client.Remote{URL: srv.URL + "/source"})
+	assert.NoError(t, err)
+	target, err := client.NewClient(&client.Remote{URL: srv.URL + "/target"})
+	assert.NoError(t, err)
+
+	report, err := replicator.Compare(context.Background(), source, target)
+	assert.NoError(t, err)
+	assert.False(t, report.Equal())
+	assert.Equal(t, 4, report.SourceDocs)
+	assert.Equal(t, 4, report.TargetDocs)
+	assert.Equal(t, []string{"d"}, report.MissingOnTarget)
+	assert.Equal(t, []string{"e"}, report.MissingOnSource)
+	if assert.Len(t, report.RevMismatches, 1) {
+		assert.Equal(t, "b", report.RevMismatches[0].ID)
+	}
+	if assert.Len(t, report.ConflictMismatches, 1) {
+		assert.Equal(t, []string{"1-x"}, report.ConflictMismatches[0].SourceConflicts)
+	}
+}
@@ -0,0 +1,59 @@
+package replicator
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goydb/replicator/client"
+)
+
+// maxReportedConflicts limits the conflicts kept in the Result
+const maxReportedConflicts = 1000
+
+// detectConflicts asks the target which of the written documents (id
+// and written revision) have conflicting revisions and resolves them
+// if there is a resolver. Failing to do so doesn't fail the replication.
+func (r *Replicator) detectConflicts(ctx context.Context, written map[string]string) {
+	finder, ok := r.target.(ConflictFinder)
+	if !r.job.detectConflicts() || !ok || len(written) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(written))
+	for id := range written {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var conflicts []client.DocConflicts
+	err := r.trace(ctx, "FindConflicts", func(ctx context.Context) error {
+		var err error
+		conflicts, err = finder.FindConflicts(ctx, ids)
+		return err
+	})
+	if err != nil {
+		r.logger.Warningf("Failed to detect conflicts of %d documents: %v", len(ids), err)
+		return
+	}
+
+	for _, conflict := range conflicts {
+		r.logger.Infof("Document %q has conflicting revisions %v", conflict.ID, conflict.Conflicts)
+		r.result.ConflictsFound++
+		if len(r.result.Conflicts) < maxReportedConflicts {
+			r.result.Conflicts = append(r.result.Conflicts, conflict)
+		}
+		r.hooks.OnConflictDetected(conflict)
+
+		if r.job.ConflictResolver == nil {
+			continue
+		}
+		err = r.resolveConflict(ctx, &ConflictedDoc{
+			ID:        conflict.ID,
+			SourceRev: written[conflict.ID],
+			Revs:      append([]string{conflict.Rev}, conflict.Conflicts...),
+		})
+		if err != nil {
+			r.logger.Warningf("Failed to resolve the conflict of document %q: %v", conflict.ID, err)
+		}
+	}
+}
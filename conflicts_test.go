@@ -0,0 +1,102 @@
+package replicator_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/memdb"
+	"github.com/stretchr/testify/assert"
+)
+
+type conflictHooks struct {
+	replicator.NopHooks
+	conflicts []client.DocConflicts
+}
+
+func (h *conflictHooks) OnConflictDetected(conflict client.DocConflicts) {
+	h.conflicts = append(h.conflicts, conflict)
+}
+
+func TestDetectConflicts(t *testing.T) {
+	source, target := memdb.New("source"), memdb.New("target")
+
+	// the document is edited on both sides
+	for side, db := range map[string]*memdb.DB{"source": source, "target": target} {
+		rev, err := db.Put("x", map[string]interface{}{"v": 1})
+		assert.NoError(t, err)
+		_, err = db.Put("x", map[string]interface{}{"_rev": rev, "v": side})
+		assert.NoError(t, err)
+	}
+	_, err := source.Put("y", map[string]interface{}{"v": 1})
+	assert.NoError(t, err)
+
+	job := &replicator.Job{
+		Source: &client.Remote{URL: "mem://source"},
+		Target: &client.Remote{URL: "mem://target"},
+		Config: replicator.Config{DetectConflicts: true},
+	}
+	r, err := replicator.NewReplicatorWithPeers("mem", job, source, target)
+	assert.NoError(t, err)
+	hooks := new(conflictHooks)
+	r.SetHooks(hooks)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, r.Run(ctx))
+
+	result := r.Result()
+	assert.Equal(t, 1, result.ConflictsFound)
+	if assert.Len(t, result.Conflicts, 1) {
+		assert.Equal(t, "x", result.Conflicts[0].ID)
+		assert.Len(t, result.Conflicts[0].Conflicts, 1)
+	}
+	assert.Equal(t, result.Conflicts, hooks.conflicts)
+}
+
+func TestResolveConflicts(t *testing.T) {
+	for name, tc := range map[string]struct {
+		resolver replicator.Resolver
+		winner   string
+	}{
+		"source-wins": {replicator.SourceWins, "source"},
+		"target-wins": {replicator.TargetWins, "target"},
+		"latest-wins": {replicator.LatestWins{Field: "ts"}, "target"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			source, target := memdb.New("source"), memdb.New("target")
+			for side, ts := range map[string]string{"source": "2021-01-01T00:00:00Z", "target": "2021-06-01T00:00:00Z"} {
+				db := source
+				if side == "target" {
+					db = target
+				}
+				rev, err := db.Put("x", map[string]interface{}{"v": 1})
+				assert.NoError(t, err)
+				_, err = db.Put("x", map[string]interface{}{"_rev": rev, "v": side, "ts": ts})
+				assert.NoError(t, err)
+			}
+
+			job := &replicator.Job{
+				Source: &client.Remote{URL: "mem://source"},
+				Target: &client.Remote{URL: "mem://target"},
+				Config: replicator.Config{ConflictResolver: tc.resolver},
+			}
+			r, err := replicator.NewReplicatorWithPeers("mem", job, source, target)
+			assert.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			assert.NoError(t, r.Run(ctx))
+			assert.Equal(t, 1, r.Result().ConflictsResolved)
+
+			doc, err := target.Get("x")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.winner, doc["v"])
+			conflicts, err := target.FindConflicts(ctx, []string{"x"})
+			assert.NoError(t, err)
+			assert.Empty(t, conflicts)
+		})
+	}
+}
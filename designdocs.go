@@ -0,0 +1,123 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goydb/replicator/client"
+)
+
+// ErrUnknownDesignDocs is returned for jobs with an invalid DesignDocs policy
+var ErrUnknownDesignDocs = errors.New("unknown design documents policy")
+
+// DesignDocs selects if the design documents (_design/*) are replicated,
+// _local documents are never part of the changes and never replicated
+type DesignDocs string
+
+const (
+	// DesignDocsInclude replicates design documents like all others
+	DesignDocsInclude DesignDocs = ""
+	// DesignDocsExclude replicates all documents except the design
+	// documents, e.g. to copy the data between environments that
+	// deploy their own views and validation functions
+	DesignDocsExclude DesignDocs = "exclude"
+	// DesignDocsOnly replicates only the design documents
+	DesignDocsOnly DesignDocs = "only"
+)
+
+// validateDesignDocs checks the DesignDocs policy of the job
+func (j *Job) validateDesignDocs() error {
+	switch j.DesignDocs {
+	case DesignDocsInclude, DesignDocsExclude, DesignDocsOnly:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownDesignDocs, j.DesignDocs)
+}
+
+// selectsDesignDoc returns true if the document is replicated
+// according to the DesignDocs policy of the job
+func (j *Job) selectsDesignDoc(docID string) bool {
+	switch j.DesignDocs {
+	case DesignDocsExclude:
+		return !isDesignDoc(docID)
+	case DesignDocsOnly:
+		return isDesignDoc(docID)
+	}
+	return true
+}
+
+func isDesignDoc(docID string) bool {
+	return strings.HasPrefix(docID, "_design/")
+}
+
+// isRefused returns true if the target refused to store the document,
+// e.g. by a validate_doc_update function or as design documents
+// require the _admin role
+func isRefused(failure client.BulkDocsResult) bool {
+	return failure.Error == "forbidden" || failure.Error == "unauthorized"
+}
+
+// writeProtectedDocs writes the documents refused by the target again
+// with the protected docs target, see Job.ProtectedDocs. The failures
+// that remain are returned.
+func (r *Replicator) writeProtectedDocs(ctx context.Context, stack client.Stack, failures []client.BulkDocsResult) []client.BulkDocsResult {
+	if !r.job.ProtectedDocs || r.protectedTarget == nil {
+		return failures
+	}
+
+	refused := make(map[string]bool, len(failures))
+	var remaining []client.BulkDocsResult
+	for _, failure := range failures {
+		if isRefused(failure) {
+			refused[failure.ID] = true
+		} else {
+			remaining = append(remaining, failure)
+		}
+	}
+	if len(refused) == 0 {
+		return failures
+	}
+
+	var protected client.Stack
+	for _, doc := range stack {
+		if refused[doc.ID] {
+			protected = append(protected, doc)
+		}
+	}
+
+	var retryFailures []client.BulkDocsResult
+	err := r.trace(ctx, "BulkDocsProtected", func(ctx context.Context) error {
+		var err error
+		retryFailures, err = r.protectedTarget.BulkDocs(ctx, &protected)
+		return err
+	})
+	if err != nil {
+		r.logger.Warningf("Failed to write %d protected documents as %q: %v", len(protected), r.job.UserCtx.Name, err)
+		return failures
+	}
+
+	r.result.ProtectedDocsWritten += len(protected) - len(retryFailures)
+	return append(remaining, retryFailures...)
+}
+
+// newProtectedDocsTarget returns a client of the target that
+// authenticates as the UserCtx of the job by proxy authentication
+func newProtectedDocsTarget(job *Job) (*client.Client, error) {
+	remote := *job.Target
+	remote.Auth = &client.ProxyAuth{
+		Username: job.UserCtx.Name,
+		Roles:    job.UserCtx.Roles,
+		Secret:   job.ProxyAuthSecret,
+	}
+	target, err := client.NewClient(&remote)
+	if err != nil {
+		return nil, err
+	}
+	target.SetRetryPolicy(job.Retry)
+	target.SetTimeouts(job.Timeouts)
+	target.SetResponseLimits(job.ResponseLimits)
+	target.SetSlowRequestThreshold(job.SlowRequestThreshold)
+	return target, nil
+}
@@ -0,0 +1,8 @@
+// Package replicator implements the CouchDB replication protocol
+// https://docs.couchdb.org/en/stable/replication/protocol.html
+//
+// The HTTP peers and the protocol types (Info, ChangesResponse,
+// ReplicationLog, ...) are provided by the client package, sequences are
+// opaque strings. Custom peers implement the Source and Target interfaces,
+// which are satisfied by client.Client.
+package replicator
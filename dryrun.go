@@ -0,0 +1,85 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/goydb/replicator/client"
+)
+
+// DryRunEntry is a document that would be transferred by the replication
+type DryRunEntry struct {
+	ID             string   `json:"id"`
+	Missing        []string `json:"missing"`         // revisions missing on the target
+	EstimatedBytes int64    `json:"estimated_bytes"` // size of the revisions without attachments
+}
+
+// DryRun locates all changed documents and reports them to the
+// DryRunReport of the job without transferring any data
+func (r *Replicator) DryRun(ctx context.Context) error {
+	var enc *json.Encoder
+	if r.job.DryRunReport != nil {
+		enc = json.NewEncoder(r.job.DryRunReport)
+	}
+
+	// sizes are only estimated if the source supports it
+	sizer, _ := r.source.(DocumentSizer)
+
+	for {
+		r.currentHistory = &client.History{
+			StartLastSeq: r.sourceLastSeq,
+		}
+
+		lastSeq, err := r.LocateChangedDocuments(ctx)
+		if errors.Is(err, ErrReplicationCompleted) {
+			r.logger.Infof("Dry run completed, %d documents (~%d bytes) would be transferred",
+				r.result.DocsMissing, r.result.EstimatedBytes)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// stable report order
+		ids := make([]string, 0, len(r.diffResp))
+		for docID := range r.diffResp {
+			ids = append(ids, docID)
+		}
+		sort.Strings(ids)
+
+		for _, docID := range ids {
+			entry := DryRunEntry{
+				ID:      docID,
+				Missing: r.diffResp[docID].Missing,
+			}
+
+			for _, rev := range entry.Missing {
+				if sizer == nil {
+					break
+				}
+				size, err := sizer.DocumentSize(ctx, docID, rev)
+				if errors.Is(err, client.ErrNotFound) || errors.Is(err, client.ErrSizeUnknown) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				entry.EstimatedBytes += size
+			}
+
+			r.result.DocsMissing++
+			r.result.EstimatedBytes += entry.EstimatedBytes
+
+			if enc != nil {
+				err = enc.Encode(entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		r.sourceLastSeq = lastSeq
+	}
+}
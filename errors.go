@@ -0,0 +1,111 @@
+package replicator
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Phase is the step of the replication an error occurred in
+type Phase string
+
+const (
+	PhaseAcquireLock            Phase = "acquire lock"
+	PhaseRenewLock              Phase = "renew lock"
+	PhaseVerifyPeers            Phase = "verify peers"
+	PhaseGetPeersInformation    Phase = "get peers information"
+	PhaseCapacityCheck          Phase = "capacity check"
+	PhaseFindCommonAncestry     Phase = "find common ancestry"
+	PhaseDryRun                 Phase = "dry run"
+	PhaseLocateChangedDocuments Phase = "locate changed documents"
+	PhaseReplicateChanges       Phase = "replicate changes"
+	PhasePropagatePurges        Phase = "propagate purges"
+	PhaseChangesOfDocuments     Phase = "changes of documents"
+	PhaseCompareRevisions       Phase = "compare revisions"
+	PhaseReplicateDocuments     Phase = "replicate documents"
+)
+
+// ReplErr is returned by Run and ReplicateDocs, it carries the context
+// of the failure so callers can render it without parsing the message.
+// Use errors.As to access it, the cause with Unwrap.
+type ReplErr struct {
+	Phase    Phase
+	DocID    string // document that failed, empty if not document specific
+	Seq      string // sequence of the document's change, or the one the failed batch started at
+	Endpoint string // method and URL (password redacted) of the failed request
+	Err      error
+}
+
+func (e *ReplErr) Error() string {
+	if e.DocID != "" {
+		return fmt.Sprintf("%s failed: document %q: %v", e.Phase, e.DocID, e.Err)
+	}
+	return fmt.Sprintf("%s failed: %v", e.Phase, e.Err)
+}
+
+func (e *ReplErr) Unwrap() error {
+	return e.Err
+}
+
+// Redacted returns the message without document id, endpoint and the
+// message of the cause, which may contain document contents, e.g. to
+// report the error to systems that must not see them
+func (e *ReplErr) Redacted() string {
+	msg := string(e.Phase) + " failed"
+	if e.Seq != "" {
+		msg += fmt.Sprintf(" at seq %q", e.Seq)
+	}
+
+	var httpErr *client.HTTPError
+	switch {
+	case errors.As(e.Err, &httpErr):
+		msg += ": " + httpErr.Status
+	case e.DocID != "":
+		msg += ": document error"
+	}
+	return msg
+}
+
+// docErr adds the document id to the error
+func docErr(docID string, err error) error {
+	return &ReplErr{DocID: docID, Err: err}
+}
+
+// fail logs the error and returns it with the context of the phase
+func (r *Replicator) fail(phase Phase, err error) error {
+	e := &ReplErr{Phase: phase, Seq: r.sourceLastSeq, Err: err}
+
+	var inner *ReplErr
+	if de, ok := err.(*ReplErr); ok && de.Phase == "" {
+		e.DocID, e.Err = de.DocID, de.Err
+	} else if errors.As(err, &inner) {
+		e.DocID = inner.DocID
+	}
+	if seq := r.tracker.seqOf(e.DocID); seq != "" {
+		e.Seq = seq
+	}
+	e.Endpoint = endpoint(e.Err)
+
+	r.logger.Error(e.Error())
+	return e
+}
+
+// endpoint returns the request of the error, if any
+func endpoint(err error) string {
+	var httpErr *client.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Endpoint
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		u, perr := url.Parse(urlErr.URL)
+		if perr != nil {
+			return urlErr.Op
+		}
+		return urlErr.Op + " " + u.Redacted()
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package replicator_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/memdb"
+	"github.com/stretchr/testify/assert"
+)
+
+var errBrokenFilter = errors.New("broken filter")
+
+type brokenFilter struct{}
+
+func (brokenFilter) Match(doc map[string]interface{}) (bool, error) {
+	return false, errBrokenFilter
+}
+
+func TestReplErr(t *testing.T) {
+	source, target := memdb.New("source"), memdb.New("target")
+	_, err := source.Put("x", map[string]interface{}{"secret": true})
+	assert.NoError(t, err)
+
+	job := &replicator.Job{
+		Source: &client.Remote{URL: "mem://source"},
+		Target: &client.Remote{URL: "mem://target"},
+		Config: replicator.Config{LocalFilter: brokenFilter{}},
+	}
+	r, err := replicator.NewReplicatorWithPeers("mem", job, source, target)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = r.Run(ctx)
+
+	var replErr *replicator.ReplErr
+	if assert.ErrorAs(t, err, &replErr) {
+		assert.Equal(t, replicator.PhaseReplicateChanges, replErr.Phase)
+		assert.Equal(t, "x", replErr.DocID)
+		assert.NotEmpty(t, replErr.Seq)
+		assert.Equal(t, `replicate changes failed: document "x": local filter failed: broken filter`, err.Error())
+		assert.NotContains(t, replErr.Redacted(), `"x"`)
+	}
+	assert.ErrorIs(t, err, errBrokenFilter)
+}
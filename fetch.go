@@ -0,0 +1,316 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// fetchedDoc is a document fetched from the source
+type fetchedDoc struct {
+	id       string
+	revs     []string
+	doc      *client.CompleteDoc
+	err      error
+	duration time.Duration
+}
+
+// closeFetched releases the fetched documents
+func closeFetched(fetched []fetchedDoc) {
+	for _, f := range fetched {
+		if f.doc != nil {
+			f.doc.Close() // nolint: errcheck
+		}
+	}
+}
+
+// fetchDocuments fetches the missing revisions of the documents and
+// passes them to fn in the given order. With
+// FetchConcurrency the documents are fetched in parallel, fn is always
+// called from the calling goroutine, in the order the documents arrive.
+// Documents fetched as multiple revisions are passed once per revision.
+func (r *Replicator) fetchDocuments(ctx context.Context, order []string, fn func(docID string, revs []string, doc *client.CompleteDoc, err error) error) error {
+	handle := func(fetched []fetchedDoc) error {
+		// the document is replicated once all revisions are
+		if len(fetched) > 1 {
+			r.tracker.expect(fetched[0].id, len(fetched))
+		}
+		for i, f := range fetched {
+			r.window.Fetch += f.duration
+			err := fn(f.id, f.revs, f.doc, f.err)
+			if err != nil {
+				closeFetched(fetched[i+1:])
+				return err
+			}
+		}
+		return nil
+	}
+
+	if bg := r.bulkGetter(); bg != nil {
+		return r.fetchDocumentsBulk(ctx, bg, order, handle)
+	}
+	return r.fetchEach(ctx, order, handle)
+}
+
+// bulkGetter returns the source if the documents are fetched
+// with _bulk_get, nil if they are fetched one by one
+func (r *Replicator) bulkGetter() BulkGetter {
+	// streamed attachments require a response per document
+	if bg, ok := r.source.(BulkGetter); ok && !r.noBulkGet && r.job.AttachmentStreamThreshold <= 0 {
+		return bg
+	}
+	return nil
+}
+
+// fetchEach fetches the documents with one request per document
+func (r *Replicator) fetchEach(ctx context.Context, order []string, handle func(fetched []fetchedDoc) error) error {
+	workers := r.job.FetchConcurrency
+	if workers <= 1 || len(order) <= 1 {
+		for _, docID := range order {
+			err := handle(r.fetchDocument(ctx, docID, r.diffResp[docID]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ids := make(chan string)
+	go func() {
+		defer close(ids)
+		for _, docID := range order {
+			select {
+			case ids <- docID:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan []fetchedDoc)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for docID := range ids {
+				fetched := r.fetchDocument(ctx, docID, r.diffResp[docID])
+				select {
+				case results <- fetched:
+				case <-ctx.Done():
+					closeFetched(fetched)
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for fetched := range results {
+		err := handle(fetched)
+		if err != nil {
+			// stop the workers and release the fetched documents
+			cancel()
+			for fetched := range results {
+				closeFetched(fetched)
+			}
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// fetchDocument fetches the missing revisions of the document. Sources
+// returning the revisions separately are asked for at most
+// MaxRevsPerFetch revisions per request. Requests exceeding the
+// MaxURLLength are sent with _bulk_get or split.
+func (r *Replicator) fetchDocument(ctx context.Context, docID string, diff *client.Diff) []fetchedDoc {
+	rg, ok := r.source.(RevisionsGetter)
+	if !ok || len(diff.Missing) <= 1 {
+		revs := append([]string(nil), diff.Missing...)
+
+		start := time.Now()
+		doc, err := r.source.GetDocumentComplete(ctx, docID, diff)
+		if errors.Is(err, client.ErrURLTooLong) {
+			var results []client.BulkGetResult
+			results, err = r.bulkGetRevisions(ctx, docID, revs, err)
+			if err == nil {
+				return revisionsFetched(docID, results, time.Since(start))
+			}
+		}
+
+		return []fetchedDoc{{
+			id:       docID,
+			revs:     revs,
+			doc:      doc,
+			err:      err,
+			duration: time.Since(start),
+		}}
+	}
+
+	var fetched []fetchedDoc
+	size := r.job.MaxRevsPerFetchOrFallback()
+	for start := 0; start < len(diff.Missing); start += size {
+		end := start + size
+		if end > len(diff.Missing) {
+			end = len(diff.Missing)
+		}
+		revs := diff.Missing[start:end]
+
+		begin := time.Now()
+		results, err := r.getRevisions(ctx, rg, docID, revs)
+		if err == nil && len(results) == 0 {
+			err = client.ErrNotFound
+		}
+		if err != nil {
+			return append(fetched, fetchedDoc{id: docID, revs: revs, err: err, duration: time.Since(begin)})
+		}
+
+		fetched = append(fetched, revisionsFetched(docID, results, time.Since(begin))...)
+	}
+	return fetched
+}
+
+// revisionsFetched converts the revisions of the document,
+// the duration is accounted to the first one
+func revisionsFetched(docID string, results []client.BulkGetResult, duration time.Duration) []fetchedDoc {
+	fetched := make([]fetchedDoc, 0, len(results))
+	for _, result := range results {
+		fetched = append(fetched, fetchedDoc{
+			id:       docID,
+			revs:     []string{result.Rev},
+			doc:      result.Doc,
+			err:      result.Err,
+			duration: duration,
+		})
+		duration = 0
+	}
+	return fetched
+}
+
+// getRevisions fetches the revisions with open_revs, if the URL is too
+// long they are fetched with _bulk_get or the revisions are split
+func (r *Replicator) getRevisions(ctx context.Context, rg RevisionsGetter, docID string, revs []string) ([]client.BulkGetResult, error) {
+	results, err := rg.GetDocumentRevisions(ctx, docID, revs)
+	if !errors.Is(err, client.ErrURLTooLong) {
+		return results, err
+	}
+	results, err = r.bulkGetRevisions(ctx, docID, revs, err)
+	if !errors.Is(err, client.ErrURLTooLong) || len(revs) == 1 {
+		return results, err
+	}
+
+	half := len(revs) / 2
+	first, err := r.getRevisions(ctx, rg, docID, revs[:half])
+	if err != nil {
+		return nil, err
+	}
+	second, err := r.getRevisions(ctx, rg, docID, revs[half:])
+	if err != nil {
+		for _, result := range first {
+			if result.Doc != nil {
+				result.Doc.Close() // nolint: errcheck
+			}
+		}
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// bulkGetRevisions fetches the revisions of a document whose open_revs
+// URL is too long with _bulk_get. The cause is returned if the source
+// doesn't support _bulk_get.
+func (r *Replicator) bulkGetRevisions(ctx context.Context, docID string, revs []string, cause error) ([]client.BulkGetResult, error) {
+	bg, ok := r.source.(BulkGetter)
+	if !ok || r.noBulkGet {
+		return nil, cause
+	}
+
+	docs := make([]client.BulkGetRequest, len(revs))
+	for i, rev := range revs {
+		docs[i] = client.BulkGetRequest{ID: docID, Rev: rev}
+	}
+	results, err := bg.BulkGet(ctx, docs)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil, cause
+	}
+	if err == nil && len(results) == 0 {
+		err = client.ErrNotFound
+	}
+	return results, err
+}
+
+// fetchDocumentsBulk fetches the documents in batches with _bulk_get.
+// If the source doesn't support _bulk_get the remaining documents are
+// fetched individually.
+func (r *Replicator) fetchDocumentsBulk(ctx context.Context, bg BulkGetter, order []string, handle func(fetched []fetchedDoc) error) error {
+	size := r.bulkGetBatchSize()
+	for start := 0; start < len(order); start += size {
+		end := start + size
+		if end > len(order) {
+			end = len(order)
+		}
+		ids := order[start:end]
+
+		var docs []client.BulkGetRequest
+		for _, docID := range ids {
+			for _, rev := range r.diffResp[docID].Missing {
+				docs = append(docs, client.BulkGetRequest{ID: docID, Rev: rev})
+			}
+		}
+
+		begin := time.Now()
+		results, err := bg.BulkGet(ctx, docs)
+		if errors.Is(err, client.ErrNotFound) {
+			r.logger.Info("Source doesn't support _bulk_get, fetching documents individually")
+			r.noBulkGet = true
+			return r.fetchEach(ctx, order[start:], handle)
+		}
+		if err != nil {
+			return err
+		}
+		r.window.Fetch += time.Since(begin)
+
+		fetched := make(map[string][]fetchedDoc, len(ids))
+		for _, result := range results {
+			fetched[result.ID] = append(fetched[result.ID], fetchedDoc{
+				id:   result.ID,
+				revs: []string{result.Rev},
+				doc:  result.Doc,
+				err:  result.Err,
+			})
+		}
+
+		for _, docID := range ids {
+			f, ok := fetched[docID]
+			if !ok {
+				// not in the response
+				f = r.fetchDocument(ctx, docID, r.diffResp[docID])
+			}
+			delete(fetched, docID)
+
+			err := handle(f)
+			if err != nil {
+				for _, f := range fetched {
+					closeFetched(f)
+				}
+				return err
+			}
+		}
+		for _, f := range fetched {
+			closeFetched(f) // not requested
+		}
+	}
+
+	return nil
+}
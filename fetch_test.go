@@ -0,0 +1,229 @@
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchDocumentsConcurrent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/db/")
+		if id == "_bulk_get" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+		pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+		fmt.Fprintf(pw, `{"_id":%q,"_rev":"1-a"}`, id)
+		_ = mw.Close()
+	}))
+	defer srv.Close()
+
+	source, err := client.NewClient(&client.Remote{URL: srv.URL + "/db/"})
+	assert.NoError(t, err)
+
+	r := &Replicator{
+		job:      &Job{Config: Config{FetchConcurrency: 4}},
+		source:   source,
+		logger:   new(logger.Noop),
+		window:   new(WindowTiming),
+		diffResp: make(client.DiffResponse),
+	}
+	var ids []string
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("doc-%02d", i)
+		ids = append(ids, id)
+		r.diffResp[id] = &client.Diff{Missing: []string{"1-a"}}
+	}
+	r.tracker = newSeqTracker(nil, r.diffResp)
+
+	var fetched []string
+	err = r.fetchDocuments(context.Background(), r.tracker.order(), func(docID string, revs []string, doc *client.CompleteDoc, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"1-a"}, revs)
+		assert.Equal(t, docID, doc.Data["_id"])
+		fetched = append(fetched, docID)
+		return nil
+	})
+	assert.NoError(t, err)
+	sort.Strings(fetched)
+	assert.Equal(t, ids, fetched)
+
+	// an error stops the fetching
+	for _, diff := range r.diffResp {
+		diff.Missing = []string{"1-a"}
+	}
+	errStop := errors.New("stop")
+	var calls int
+	err = r.fetchDocuments(context.Background(), r.tracker.order(), func(docID string, revs []string, doc *client.CompleteDoc, err error) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestFetchDocumentsBulk(t *testing.T) {
+	var bulkGets, gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+		defer mw.Close() // nolint: errcheck
+
+		if r.URL.Path != "/db/_bulk_get" {
+			gets++
+			pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+			fmt.Fprintf(pw, `{"_id":%q,"_rev":"2-b"}`, strings.TrimPrefix(r.URL.Path, "/db/"))
+			return
+		}
+
+		bulkGets++
+		var req struct {
+			Docs []client.BulkGetRequest `json:"docs"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		for _, doc := range req.Docs {
+			switch doc.ID {
+			case "absent":
+			case "missing":
+				pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{`application/json; error="true"`}})
+				fmt.Fprintf(pw, `{"id":%q,"rev":%q,"error":"not_found","reason":"missing"}`, doc.ID, doc.Rev)
+			case "attachment":
+				var related bytes.Buffer
+				rw := multipart.NewWriter(&related)
+				pw, _ := rw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+				fmt.Fprintf(pw, `{"_id":%q,"_rev":%q,"_attachments":{"a.txt":{"content_type":"text/plain","follows":true,"length":5}}}`, doc.ID, doc.Rev)
+				pw, _ = rw.CreatePart(textproto.MIMEHeader{"Content-Disposition": []string{`attachment; filename="a.txt"`}})
+				fmt.Fprint(pw, "hello")
+				_ = rw.Close()
+				pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{`multipart/related; boundary="` + rw.Boundary() + `"`}})
+				_, _ = related.WriteTo(pw)
+			default:
+				pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+				fmt.Fprintf(pw, `{"_id":%q,"_rev":%q}`, doc.ID, doc.Rev)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	source, err := client.NewClient(&client.Remote{URL: srv.URL + "/db/"})
+	assert.NoError(t, err)
+
+	r := &Replicator{
+		job:    &Job{Config: Config{BulkGetBatchSize: 2}},
+		source: source,
+		logger: new(logger.Noop),
+		window: new(WindowTiming),
+		diffResp: client.DiffResponse{
+			"a":          {Missing: []string{"1-a"}},
+			"attachment": {Missing: []string{"1-a"}},
+			"missing":    {Missing: []string{"1-a"}},
+			"conflicts":  {Missing: []string{"2-a", "2-b"}},
+			"absent":     {Missing: []string{"1-a"}},
+		},
+	}
+	r.tracker = newSeqTracker(nil, r.diffResp)
+
+	fetched := make(map[string][]*client.CompleteDoc)
+	err = r.fetchDocuments(context.Background(), r.tracker.order(), func(docID string, revs []string, doc *client.CompleteDoc, err error) error {
+		defer r.tracker.done(docID)
+		if docID == "missing" {
+			assert.ErrorIs(t, err, client.ErrNotFound)
+			return nil
+		}
+		assert.NoError(t, err)
+		fetched[docID] = append(fetched[docID], doc)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, fetched, 4)
+	assert.True(t, fetched["attachment"][0].HasChangedAttachments())
+	if assert.Len(t, fetched["conflicts"], 2) {
+		assert.Equal(t, "2-b", fetched["conflicts"][1].Rev())
+	}
+	assert.Equal(t, 3, bulkGets)
+	assert.Equal(t, 1, gets) // not in the response
+	assert.Equal(t, 0, r.tracker.remaining())
+}
+
+func TestFetchDocumentURLLength(t *testing.T) {
+	var bulkGet bool
+	var bulkGets, gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var revs []string
+		if r.URL.Path == "/db/_bulk_get" {
+			if !bulkGet {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			bulkGets++
+			var req struct {
+				Docs []client.BulkGetRequest `json:"docs"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			for _, doc := range req.Docs {
+				revs = append(revs, doc.Rev)
+			}
+		} else {
+			gets++
+			assert.NoError(t, json.Unmarshal([]byte(r.URL.Query().Get("open_revs")), &revs))
+		}
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+		for _, rev := range revs {
+			pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+			fmt.Fprintf(pw, `{"_id":"doc","_rev":%q}`, rev)
+		}
+		_ = mw.Close()
+	}))
+	defer srv.Close()
+
+	source, err := client.NewClient(&client.Remote{URL: srv.URL + "/db/"})
+	assert.NoError(t, err)
+	// fits two revisions
+	source.SetMaxURLLength(len(srv.URL) + 110)
+
+	missing := []string{"2-aaaaaaaaaaaaaaaa", "2-bbbbbbbbbbbbbbbb", "2-cccccccccccccccc", "2-dddddddddddddddd"}
+	r := &Replicator{
+		job:       &Job{},
+		source:    source,
+		logger:    new(logger.Noop),
+		noBulkGet: true,
+	}
+
+	fetched := r.fetchDocument(context.Background(), "doc", &client.Diff{Missing: missing})
+	var revs []string
+	for _, f := range fetched {
+		assert.NoError(t, f.err)
+		revs = append(revs, f.doc.Rev())
+	}
+	closeFetched(fetched)
+	assert.Equal(t, missing, revs)
+	assert.Equal(t, 2, gets) // split once
+	assert.Equal(t, 0, bulkGets)
+
+	// with _bulk_get
+	bulkGet, gets = true, 0
+	r.noBulkGet = false
+	fetched = r.fetchDocument(context.Background(), "doc", &client.Diff{Missing: missing})
+	assert.Len(t, fetched, 4)
+	closeFetched(fetched)
+	assert.Equal(t, 0, gets)
+	assert.Equal(t, 1, bulkGets)
+}
@@ -0,0 +1,456 @@
+// Package fstarget implements a replication target that exports the
+// documents to a local directory, e.g. to backup a database:
+//
+//	<dir>/<doc id>.json              winning revision of the document
+//	<dir>/<doc id>.attachments/<name> attachments of the document
+//	<dir>/_local/<replication id>.json checkpoints of the replications
+//
+// Document ids and attachment names are path escaped. Only the winning
+// revision of a document is stored, conflicting revisions are ignored.
+package fstarget
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5" // nolint: gosec
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+)
+
+const (
+	docExt        = ".json"
+	attachmentExt = ".attachments"
+	localDir      = "_local"
+)
+
+// Target writes the replicated documents to a directory
+type Target struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var (
+	_ replicator.Target            = (*Target)(nil)
+	_ replicator.DocReader         = (*Target)(nil)
+	_ replicator.CheckpointRemover = (*Target)(nil)
+)
+
+// New creates a target exporting to the directory, the
+// directory is created if the job has CreateTarget set
+func New(dir string) *Target {
+	return &Target{dir: dir}
+}
+
+func (t *Target) Check(ctx context.Context) error {
+	fi, err := os.Stat(t.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return client.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("target %q is not a directory", t.dir)
+	}
+	return nil
+}
+
+func (t *Target) Create(ctx context.Context) error {
+	return os.MkdirAll(filepath.Join(t.dir, localDir), 0o755)
+}
+
+// Info returns the name of the directory and the number of documents
+func (t *Target) Info(ctx context.Context) (*client.Info, error) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &client.Info{DbName: filepath.Base(t.dir), UpdateSeq: "0"}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), docExt) {
+			info.DocCount++
+		}
+	}
+	return info, nil
+}
+
+func (t *Target) GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error) {
+	var repLog client.ReplicationLog
+	err := readJSON(t.localPath(id), &repLog)
+	if err != nil {
+		return nil, err
+	}
+	return &repLog, nil
+}
+
+func (t *Target) RecordReplicationCheckpoint(ctx context.Context, repLog *client.ReplicationLog, id string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old, err := t.GetReplicationLog(ctx, id)
+	if err != nil && !errors.Is(err, client.ErrNotFound) {
+		return "", err
+	}
+	var gen int
+	if old != nil {
+		if old.Rev != repLog.Rev {
+			return "", fmt.Errorf("%w: %q", client.ErrConflict, id)
+		}
+		gen, _ = strconv.Atoi(strings.TrimPrefix(old.Rev, "0-"))
+	}
+	repLog.Rev = "0-" + strconv.Itoa(gen+1)
+
+	err = os.MkdirAll(filepath.Join(t.dir, localDir), 0o755)
+	if err != nil {
+		return "", err
+	}
+	err = writeJSON(t.localPath(id), repLog)
+	if err != nil {
+		return "", err
+	}
+	return repLog.Rev, nil
+}
+
+func (t *Target) RemoveReplicationCheckpoint(ctx context.Context, id, rev string) error {
+	err := os.Remove(t.localPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// RevDiff reports the revisions as missing that would replace the
+// stored winning revision, losing revisions are never transferred
+func (t *Target) RevDiff(ctx context.Context, r client.RevDiffRequest) (client.DiffResponse, error) {
+	diff := make(client.DiffResponse)
+	for docID, revs := range r {
+		stored, err := t.stored(docID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rev := range revs {
+			if stored == nil || (rev != stored.rev && (stored.deleted || revWins(rev, stored.rev))) {
+				if diff[docID] == nil {
+					diff[docID] = new(client.Diff)
+				}
+				diff[docID].Missing = append(diff[docID].Missing, rev)
+			}
+		}
+	}
+	return diff, nil
+}
+
+// BulkDocs writes the documents with their inlined attachments
+func (t *Target) BulkDocs(ctx context.Context, stack *client.Stack) ([]client.BulkDocsResult, error) {
+	var failures []client.BulkDocsResult
+	for _, doc := range *stack {
+		err := t.write(doc, nil)
+		if err != nil {
+			failures = append(failures, client.BulkDocsResult{
+				ID:     doc.ID,
+				Error:  "write_failed",
+				Reason: err.Error(),
+			})
+		}
+	}
+	return failures, nil
+}
+
+// UploadDocumentWithAttachments writes the document and its attachments
+func (t *Target) UploadDocumentWithAttachments(ctx context.Context, doc *client.CompleteDoc) error {
+	atts, err := doc.Attachments()
+	if err != nil {
+		return err
+	}
+	return t.write(doc, atts)
+}
+
+// EnsureFullCommit does nothing, the files are synced when written
+func (t *Target) EnsureFullCommit(ctx context.Context) error {
+	return nil
+}
+
+// GetDoc reads the stored revision of the document, only
+// the winning revision is available
+func (t *Target) GetDoc(ctx context.Context, id, rev string, v interface{}) error {
+	stored, err := t.stored(id)
+	if err != nil {
+		return err
+	}
+	if stored == nil || (rev != "" && rev != stored.rev) {
+		return client.ErrNotFound
+	}
+	return readJSON(t.docPath(id), v)
+}
+
+// storedDoc is the revision of a stored document
+type storedDoc struct {
+	rev     string
+	deleted bool
+}
+
+// stored returns the revision of the stored document or nil
+func (t *Target) stored(docID string) (*storedDoc, error) {
+	var doc struct {
+		Rev     string `json:"_rev"`
+		Deleted bool   `json:"_deleted"`
+	}
+	err := readJSON(t.docPath(docID), &doc)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &storedDoc{rev: doc.Rev, deleted: doc.Deleted}, nil
+}
+
+// write stores the document if it is the winning revision, the inlined
+// attachments and the passed attachments are written to files
+func (t *Target) write(doc *client.CompleteDoc, atts []client.Attachment) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stored, err := t.stored(doc.ID)
+	if err != nil {
+		return err
+	}
+	if !replaces(doc.Data, stored) {
+		return nil
+	}
+
+	attDir := t.attachmentsPath(doc.ID)
+	stubs := make(map[string]interface{})
+	if attsObj, ok := doc.Data["_attachments"].(map[string]interface{}); ok {
+		err = os.MkdirAll(attDir, 0o755)
+		if err != nil {
+			return err
+		}
+		for name, att := range attsObj {
+			attObj, ok := att.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid attachment data in json for %q", name)
+			}
+			stub, err := t.writeInlineAttachment(attDir, name, attObj)
+			if err != nil {
+				return err
+			}
+			stubs[name] = stub
+		}
+	}
+	for _, att := range atts {
+		err = os.MkdirAll(attDir, 0o755)
+		if err != nil {
+			return err
+		}
+
+		// the files contain the decoded attachments
+		var r io.Reader = att
+		if att.Encoding == "gzip" {
+			gr, err := gzip.NewReader(att)
+			if err != nil {
+				return fmt.Errorf("unable to read attachment %q from gzip: %w", att.Filename, err)
+			}
+			r = gr
+			if stub, ok := stubs[att.Filename].(map[string]interface{}); ok {
+				delete(stub, "encoding")
+				delete(stub, "encoded_length")
+			}
+		}
+
+		err = writeFile(filepath.Join(attDir, url.PathEscape(att.Filename)), r)
+		if err != nil {
+			return err
+		}
+	}
+
+	// remove the attachments of previous revisions
+	entries, err := os.ReadDir(attDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		name, err := url.PathUnescape(entry.Name())
+		if _, ok := stubs[name]; ok && err == nil {
+			continue
+		}
+		err = os.Remove(filepath.Join(attDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	if len(stubs) == 0 {
+		err = os.Remove(attDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	data := make(map[string]interface{}, len(doc.Data))
+	for key, value := range doc.Data {
+		switch key {
+		case "_revisions", "_attachments":
+		default:
+			data[key] = value
+		}
+	}
+	if len(stubs) > 0 {
+		data["_attachments"] = stubs
+	}
+	return writeJSON(t.docPath(doc.ID), data)
+}
+
+// writeInlineAttachment writes the base64 data of the attachment and
+// returns its stub, stubs of unchanged attachments are returned as is
+func (t *Target) writeInlineAttachment(dir, name string, att map[string]interface{}) (map[string]interface{}, error) {
+	stub := make(map[string]interface{}, len(att))
+	for key, value := range att {
+		switch key {
+		case "data", "follows":
+		default:
+			stub[key] = value
+		}
+	}
+	stub["stub"] = true
+
+	encoded, ok := att["data"].(string)
+	if !ok {
+		return stub, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attachment data of %q: %w", name, err)
+	}
+	err = writeFile(filepath.Join(dir, url.PathEscape(name)), bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := md5.Sum(raw) // nolint: gosec
+	stub["length"] = len(raw)
+	stub["digest"] = "md5-" + base64.StdEncoding.EncodeToString(sum[:])
+	return stub, nil
+}
+
+func (t *Target) docPath(docID string) string {
+	return filepath.Join(t.dir, url.PathEscape(docID)+docExt)
+}
+
+func (t *Target) attachmentsPath(docID string) string {
+	return filepath.Join(t.dir, url.PathEscape(docID)+attachmentExt)
+}
+
+func (t *Target) localPath(id string) string {
+	return filepath.Join(t.dir, localDir, url.PathEscape(id)+docExt)
+}
+
+// replaces returns true if the revision of the document replaces the
+// stored revision, it is a descendant or wins the conflict like CouchDB
+// picks the winning revision: not deleted before deleted revisions
+func replaces(data map[string]interface{}, stored *storedDoc) bool {
+	if stored == nil {
+		return true
+	}
+
+	rev, _ := data["_rev"].(string)
+	deleted, _ := data["_deleted"].(bool)
+	if isAncestor(data, stored.rev) {
+		return true
+	}
+	if deleted != stored.deleted {
+		return !deleted
+	}
+	return revWins(rev, stored.rev)
+}
+
+// isAncestor returns true if the rev is part of the revision
+// history (_revisions) of the document
+func isAncestor(data map[string]interface{}, rev string) bool {
+	revisions, _ := data["_revisions"].(map[string]interface{})
+	start, _ := revisions["start"].(float64)
+	ids, _ := revisions["ids"].([]interface{})
+	for i, id := range ids {
+		if fmt.Sprintf("%d-%v", int(start)-i, id) == rev {
+			return true
+		}
+	}
+	return false
+}
+
+// revWins returns true if revision a wins over b, the higher
+// generation wins, on the same generation the greater hash
+func revWins(a, b string) bool {
+	genA, hashA := splitRev(a)
+	genB, hashB := splitRev(b)
+	if genA != genB {
+		return genA > genB
+	}
+	return hashA > hashB
+}
+
+func splitRev(rev string) (int, string) {
+	parts := strings.SplitN(rev, "-", 2)
+	gen, _ := strconv.Atoi(parts[0])
+	if len(parts) < 2 {
+		return gen, ""
+	}
+	return gen, parts[1]
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return client.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, bytes.NewReader(data))
+}
+
+// writeFile replaces the file atomically
+func writeFile(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close() // nolint: errcheck
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,74 @@
+package fstarget_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/fstarget"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarget(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "backup")
+	target := fstarget.New(dir)
+
+	assert.ErrorIs(t, target.Check(ctx), client.ErrNotFound)
+	assert.NoError(t, target.Create(ctx))
+
+	stack := client.Stack{
+		client.NewDoc(map[string]interface{}{
+			"_id": "a/b", "_rev": "1-a", "v": 1.0,
+			"_attachments": map[string]interface{}{
+				"hello.txt": map[string]interface{}{"content_type": "text/plain", "data": "aGVsbG8="},
+			},
+		}),
+	}
+	failures, err := target.BulkDocs(ctx, &stack)
+	assert.NoError(t, err)
+	assert.Len(t, failures, 0)
+
+	data, err := os.ReadFile(filepath.Join(dir, "a%2Fb.attachments", "hello.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	var doc map[string]interface{}
+	assert.NoError(t, target.GetDoc(ctx, "a/b", "1-a", &doc))
+	assert.Equal(t, true, doc["_attachments"].(map[string]interface{})["hello.txt"].(map[string]interface{})["stub"])
+
+	// losing revisions are not transferred, newer ones replace the document
+	diff, err := target.RevDiff(ctx, client.RevDiffRequest{"a/b": {"1-a", "1-0", "2-b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2-b"}, diff["a/b"].Missing)
+
+	stack = client.Stack{
+		client.NewDoc(map[string]interface{}{
+			"_id": "a/b", "_rev": "2-b", "_deleted": true,
+			"_revisions": map[string]interface{}{"start": 2.0, "ids": []interface{}{"b", "a"}},
+		}),
+	}
+	_, err = target.BulkDocs(ctx, &stack)
+	assert.NoError(t, err)
+	assert.NoError(t, target.GetDoc(ctx, "a/b", "2-b", &doc))
+	_, err = os.Stat(filepath.Join(dir, "a%2Fb.attachments"))
+	assert.True(t, os.IsNotExist(err))
+
+	// checkpoints
+	repLog := &client.ReplicationLog{SourceLastSeq: "5"}
+	rev, err := target.RecordReplicationCheckpoint(ctx, repLog, "rep")
+	assert.NoError(t, err)
+	assert.Equal(t, "0-1", rev)
+	_, err = target.RecordReplicationCheckpoint(ctx, &client.ReplicationLog{}, "rep")
+	assert.ErrorIs(t, err, client.ErrConflict)
+
+	stored, err := target.GetReplicationLog(ctx, "rep")
+	assert.NoError(t, err)
+	assert.Equal(t, "5", stored.SourceLastSeq)
+
+	info, err := target.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, info.DocCount)
+}
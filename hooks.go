@@ -0,0 +1,65 @@
+package replicator
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Hooks are notified about the lifecycle of a replication, e.g. to wire
+// alerting or custom bookkeeping. The hooks are called from the
+// replication goroutine and should return quickly. Embed NopHooks to
+// implement only some of them.
+type Hooks interface {
+	// OnCheckpoint is called after the checkpoint was recorded
+	OnCheckpoint(seq string)
+	// OnCheckpointError is called if the checkpoint couldn't be
+	// recorded, see CheckpointPolicy
+	OnCheckpointError(seq string, err error)
+	// OnBatchUploaded is called after a bulk upload to the target,
+	// failures is the number of documents the target refused
+	OnBatchUploaded(docs, failures int)
+	// OnDocumentError is called for every document that was skipped
+	// because of an error
+	OnDocumentError(doc SkippedDoc)
+	// OnConflict is called for documents the target refused because
+	// of a conflict
+	OnConflict(docID string, err error)
+	// OnConflictDetected is called for written documents that have
+	// conflicting revisions on the target, see Config.DetectConflicts
+	OnConflictDetected(conflict client.DocConflicts)
+	// OnComplete is called when Run returns
+	OnComplete(result Result, err error)
+	// OnTakeover is called when a standby replication acquired the
+	// lease the previous owner stopped renewing, see Config.Standby
+	OnTakeover(previousOwner string)
+	// OnRetry is called before a failed request to source or target is retried
+	OnRetry(req *http.Request, attempt int, wait time.Duration, err error)
+}
+
+// NopHooks implements Hooks without doing anything
+type NopHooks struct{}
+
+func (NopHooks) OnCheckpoint(seq string)                                               {}
+func (NopHooks) OnCheckpointError(seq string, err error)                               {}
+func (NopHooks) OnBatchUploaded(docs, failures int)                                    {}
+func (NopHooks) OnDocumentError(doc SkippedDoc)                                        {}
+func (NopHooks) OnConflict(docID string, err error)                                    {}
+func (NopHooks) OnConflictDetected(conflict client.DocConflicts)                       {}
+func (NopHooks) OnComplete(result Result, err error)                                   {}
+func (NopHooks) OnTakeover(previousOwner string)                                       {}
+func (NopHooks) OnRetry(req *http.Request, attempt int, wait time.Duration, err error) {}
+
+// SetHooks sets the hooks notified about the replication
+func (r *Replicator) SetHooks(hooks Hooks) {
+	if hooks == nil {
+		hooks = NopHooks{}
+	}
+	r.hooks = hooks
+}
+
+// onRetry forwards the retries of the clients to the hooks
+func (r *Replicator) onRetry(req *http.Request, attempt int, wait time.Duration, err error) {
+	r.hooks.OnRetry(req, attempt, wait, err)
+}
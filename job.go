@@ -2,8 +2,14 @@ package replicator
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
 	"time"
 
 	"github.com/goydb/replicator/client"
@@ -17,23 +23,408 @@ type Job struct {
 	Target       *client.Remote `json:"target"`
 	CreateTarget bool           `json:"create_target"`
 	Continuous   bool           `json:"continuous"`
-	Owner        string         `json:"owner"`
+	// Bidirectional replicates the target back to the source as well,
+	// the scheduler runs both directions in one slot, see Bidirectional
+	Bidirectional bool   `json:"bidirectional,omitempty"`
+	Owner         string `json:"owner"`
+	// SinceSeq is the sequence the replication starts at instead of the
+	// beginning, "now" (client.SinceNow) only replicates new changes.
+	// It is part of the replication id, once the replication recorded a
+	// checkpoint restarts resume from the checkpoint.
+	SinceSeq string `json:"since_seq,omitempty"`
+	// UseCheckpoints set to false replicates without recording
+	// checkpoints, no _local documents are written (e.g. if the
+	// credentials lack write access to the source). Checkpoints of
+	// earlier runs are still resumed from. Defaults to true.
+	UseCheckpoints *bool `json:"use_checkpoints,omitempty"`
+	// CheckpointIntervalMS is the CheckpointInterval in milliseconds
+	// as in CouchDB replication documents, the Config takes precedence
+	CheckpointIntervalMS int `json:"checkpoint_interval,omitempty"`
+
+	// CreateTargetParams are passed as query parameters when creating
+	// the target, e.g. {"q": "8", "placement": "metro-dc-a:2"}
+	CreateTargetParams map[string]string `json:"create_target_params,omitempty"`
+	// CreateTargetHeaders are added to the request creating the target
+	CreateTargetHeaders map[string]string `json:"create_target_headers,omitempty"`
+
+	// Filter is the filter function on the source ("ddoc/name")
+	// used to select the changes, see QueryParams for its arguments
+	Filter      string            `json:"filter,omitempty"`
+	QueryParams map[string]string `json:"query_params,omitempty"`
+
+	// Selector is a mango selector (e.g. {"type": "order"}) that is used
+	// to select the changes, it can't be combined with Filter
+	Selector json.RawMessage `json:"selector,omitempty"`
+
+	// ShardCount splits the documents by the hash of their id into
+	// shards, the job only replicates the ShardIndex shard. Shards use
+	// their own checkpoints and can run on different instances, see
+	// SplitJob.
+	ShardCount int `json:"shard_count,omitempty"`
+	ShardIndex int `json:"shard_index,omitempty"`
+
+	// DesignDocs selects if the design documents are replicated, all
+	// documents are replicated by default
+	DesignDocs DesignDocs `json:"design_docs,omitempty"`
+	// ProtectedDocs writes the documents refused by the target (e.g. by
+	// a validate_doc_update function) again as the UserCtx, usually with
+	// the _admin role. Only documents written with _bulk_docs are
+	// retried, see Config.ProtectedDocsTarget.
+	ProtectedDocs bool `json:"protected_docs,omitempty"`
 
 	Config
 }
 
+// CheckpointPolicy defines how failed checkpoint writes are handled
+type CheckpointPolicy string
+
+const (
+	// CheckpointFail fails the replication
+	CheckpointFail CheckpointPolicy = ""
+	// CheckpointWarn logs a warning and continues the replication,
+	// the checkpoint is recorded again with the next batch
+	CheckpointWarn CheckpointPolicy = "warn"
+)
+
+// Mode restricts which changes a replication processes
+type Mode string
+
+const (
+	// ModeDefault replicates all changes since the last checkpoint
+	ModeDefault Mode = ""
+	// ModeBackfill replicates the changes up to the update sequence the
+	// source had when the replication started and stops, even if continuous
+	ModeBackfill Mode = "backfill"
+	// ModeLive doesn't replicate the history, it starts at the current
+	// update sequence (since=now), or the last checkpoint if there is a
+	// common ancestry, and follows the changes, even if not continuous
+	ModeLive Mode = "live"
+)
+
 type Config struct {
 	// Heartbeat For Continuous Replication the heartbeat parameter defines the heartbeat period in milliseconds. The RECOMMENDED value by default is 10000 (10 seconds).
 	Heartbeat time.Duration
+	// ChangesTimeout requests the changes with the timeout parameter
+	// instead of the heartbeat, some proxies handle request timeouts
+	// better than heartbeat keep-alives
+	ChangesTimeout time.Duration
+
+	// ForceFull ignores the common ancestry of source and target and
+	// replicates from the very first sequence. Fresh checkpoints are
+	// recorded afterwards, replacing the existing replication history.
+	ForceFull bool
+
+	// AncestryStrategy defines which part of the replication logs is
+	// trusted to find the common ancestry, see AncestryStrategy.
+	AncestryStrategy AncestryStrategy
+
+	// AncestryMinSessions is the number of sessions the histories of
+	// source and target need to have in common to trust a session found
+	// in the history. Defaults to 1.
+	AncestryMinSessions int
+
+	// PropagatePurges applies the purges recorded on the source
+	// (_purged_infos) to the target using the _purge API. Purges don't
+	// show up on the changes feed, they are propagated after each batch
+	// and while waiting for changes.
+	PropagatePurges bool
+
+	// CheckpointPrefix is prepended to the replication id to build the
+	// _local document id of the checkpoints (e.g. "goydb-replicator-"),
+	// so they can be told apart from the checkpoints of other replicators.
+	CheckpointPrefix string
+
+	// DryRun locates the changed documents without transferring them,
+	// no database or checkpoint is created or modified. A dry run stops
+	// once the source was read completely, even if continuous.
+	DryRun bool
+
+	// DryRunReport receives a DryRunEntry as JSON per line (NDJSON) for
+	// every document that would be transferred, optional.
+	DryRunReport io.Writer
+
+	// Mode restricts the replication to the history or live changes
+	Mode Mode
+
+	// Sink receives the changed documents if the job has no target,
+	// checkpoints are only recorded on the source.
+	Sink Sink
+
+	// Profile sets the batch sizes, concurrency, retries and throttling
+	// that aren't set explicitly, e.g. ProfileConservativeHosted. Zero
+	// values are taken from the profile, a negative Retry.MaxRetries
+	// disables the retries of the profile.
+	Profile Profile
+
+	// BatchSizeBytes is the size of the documents written to the target
+	// with one _bulk_docs request, documents with attachments bigger than
+	// the batch are uploaded one by one. Defaults to 10 MB.
+	BatchSizeBytes int64
+
+	// BatchDocLimit is the number of documents written to the target
+	// with one _bulk_docs request, unlimited if 0.
+	BatchDocLimit int
+
+	// CapacityLimit is the size (sizes.external) in bytes the target may
+	// grow to. One-shot replications are refused if the source and target
+	// together exceed the limit, 0 disables the check.
+	CapacityLimit int64
+
+	// CapacityWarnOnly logs a warning instead of refusing the replication
+	// if the CapacityLimit would be exceeded.
+	CapacityWarnOnly bool
+
+	// AttachmentSpillThreshold attachments bigger than the threshold
+	// (in bytes) are buffered in temporary files in the
+	// AttachmentSpillDir instead of memory, 0 disables it.
+	AttachmentSpillThreshold int64
+	AttachmentSpillDir       string
+
+	// MaxAttachmentSize documents with attachments bigger than the size
+	// (in bytes) are skipped, or if SkipLargeAttachments is set are
+	// replicated without the attachment. 0 disables the limit.
+	MaxAttachmentSize    int64
+	SkipLargeAttachments bool
+
+	// AttachmentFilter replicates only the allowed attachments of the
+	// documents read from the source, e.g. to skip "*.mp4". The other
+	// attachments are removed from the documents.
+	AttachmentFilter *client.AttachmentFilter
+
+	// AttachmentProcessors rewrite the changed attachments by content
+	// type before they are written, e.g. to recompress images. Length
+	// and digest of the attachments are updated.
+	AttachmentProcessors client.AttachmentProcessors `json:"-"`
+
+	// PreserveAttachmentEncoding transfers gzip encoded attachments
+	// compressed instead of decompressing them to inline them. The
+	// documents are uploaded as multipart, or inlined with encoding
+	// for targets implementing EncodedAttachmentsTarget.
+	PreserveAttachmentEncoding bool
+
+	// AttachmentStreamThreshold documents whose changed attachments
+	// exceed the threshold (in bytes) are piped from the source response
+	// to the target upload without buffering. Documents are fetched one
+	// by one instead of using _bulk_get, 0 disables streaming.
+	AttachmentStreamThreshold int64
+
+	// MaxDocSize limits the size (in bytes) of a document read from the
+	// source including its attachments, MaxDocParts the multipart parts
+	// and DocParseTimeout the time to read it. Broken or malicious
+	// sources fail the document instead of stalling the replication.
+	MaxDocSize      int64
+	MaxDocParts     int
+	DocParseTimeout time.Duration
+
+	// Retry defines how requests to source and target that failed with
+	// a network error or a transient status code are retried
+	Retry client.RetryPolicy
+
+	// Timeouts limit connecting, waiting for the response and reading
+	// the changes feed of source and target, so a hung server fails
+	// the request instead of stalling the replication
+	Timeouts client.Timeouts
+
+	// ResponseLimits cap the size and nesting of the JSON responses of
+	// source and target, the nesting limit applies to documents too
+	ResponseLimits client.ResponseLimits
+
+	// SlowRequestThreshold requests to source and target that took
+	// longer than the threshold are logged as warning, 0 disables it
+	SlowRequestThreshold time.Duration
+
+	// MaxRevsPerFetch limits the missing revisions of a document
+	// requested at once (open_revs), documents with more revisions are
+	// fetched in chunks and written together. Defaults to 100.
+	MaxRevsPerFetch int
+
+	// MaxURLLength limits the URL of the document requests to the
+	// source, longer open_revs requests are sent with _bulk_get or
+	// split, preventing 414 responses of strict proxies. Defaults to
+	// 7000 like CouchDB, negative disables the limit.
+	MaxURLLength int
+
+	// CapabilityCacheTTL is how long the source capabilities (version,
+	// _bulk_get support) recorded with the checkpoints are used instead
+	// of probing the server at the start of every run. Defaults to 24
+	// hours, negative disables the cache.
+	CapabilityCacheTTL time.Duration
+
+	// SeqRangeSize limits a run to the changes of the next SeqRangeSize
+	// update sequences (the numeric part of clustered sequences). Once
+	// the range is replicated it is recorded as completed in the
+	// replication logs and the run returns, even if continuous. Huge
+	// backfills can be spread over time, e.g. one range per night.
+	SeqRangeSize int64
+
+	// ChangesLimit is the number of changes read from the source per
+	// window, unlimited if 0
+	ChangesLimit int
+
+	// AdaptiveWindow retries a window that failed with a timeout with
+	// half the changes and smaller _bulk_get and _bulk_docs batches, so
+	// the replication gets past huge documents. The following windows
+	// grow back once replicated. A window of one change that times out
+	// fails the replication.
+	AdaptiveWindow bool
+
+	// BulkGetBatchSize is the number of documents fetched with one
+	// _bulk_get request from sources supporting it, defaults to 100.
+	// FetchConcurrency applies to sources without _bulk_get.
+	BulkGetBatchSize int
+
+	// SoftDocErrors skips documents that failed to be fetched or
+	// uploaded individually instead of aborting the replication, the
+	// documents are reported in the Result.
+	SoftDocErrors bool
+
+	// PriorityDocs are path.Match patterns of document ids (e.g.
+	// "config:*") whose changes are fetched and written ahead of the
+	// other changes of a window, so they converge quickly even during
+	// large backfills
+	PriorityDocs []string
+
+	// FetchConcurrency is the number of documents fetched from the source
+	// in parallel, the documents are still written in order of arrival
+	// and the checkpoint is recorded once all of them are written.
+	// Defaults to 1 (sequential).
+	FetchConcurrency int
+
+	// CheckpointInterval records intermediate checkpoints while a batch
+	// of changes is replicated, at most once per interval. Long running
+	// replications resume close to where they stopped after a crash.
+	// The checkpoint at the end of a batch is always recorded.
+	CheckpointInterval time.Duration
+	// CheckpointDocs records an intermediate checkpoint after the
+	// given number of documents was written
+	CheckpointDocs int
+	// CheckpointPolicy defines if the replication fails if a checkpoint
+	// can't be recorded (e.g. read-only peer), defaults to CheckpointFail
+	CheckpointPolicy CheckpointPolicy
+	// SourceReadOnly tolerates checkpoint writes refused by the source
+	// (401 or 403), e.g. a public replica, the checkpoints are recorded
+	// on the target only. The common ancestry is found using the target
+	// log, the log of the source may be missing or outdated.
+	SourceReadOnly bool
+
+	// VerifySamples documents replicated by continuous replications are
+	// sampled randomly and compared with the target once per
+	// VerifyInterval (defaults to 1 hour). Documents that differ silently
+	// are counted as DocsDivergent in the Stats. 0 disables it.
+	VerifySamples  int
+	VerifyInterval time.Duration
+
+	// Locker guards the replication against concurrent runs of other
+	// instances with the same replication id. The lease is held for
+	// LockTTL (defaults to 30 seconds) and renewed while running, the
+	// replication fails with ErrLockLost if it can't be renewed.
+	Locker  Locker
+	LockTTL time.Duration
+	// Standby waits for the lease instead of failing with ErrLocked and
+	// takes over the replication, resuming from the shared checkpoint,
+	// once the active instance stops renewing it. A lost lease puts the
+	// replication back into standby. Requires a Locker.
+	Standby bool
+
+	// DetectConflicts asks the target after every write which of the
+	// written documents have conflicting revisions, they are reported
+	// to the hooks and in the Result. Requires a ConflictFinder target.
+	DetectConflicts bool
+	// ConflictResolver resolves the detected conflicts, the losing
+	// revisions are deleted on the target. Enables DetectConflicts,
+	// requires a DocReader and DocDeleter target.
+	ConflictResolver Resolver
+
+	// ProtectedDocsTarget writes the documents refused by the target if
+	// Job.ProtectedDocs is set. NewReplicator defaults to a client of the
+	// target authenticated as the Job.UserCtx with client.ProxyAuth.
+	ProtectedDocsTarget Target
+	// ProxyAuthSecret is the proxy authentication secret of the target
+	// used by the default ProtectedDocsTarget
+	ProxyAuthSecret string
+
+	// LocalFilter is evaluated for every fetched document, documents that
+	// don't match are skipped. It allows filtering for sources that can't
+	// run filter functions server side, see the jsfilter module.
+	LocalFilter DocFilter
+}
+
+// DocFilter decides if a document is replicated
+type DocFilter interface {
+	Match(doc map[string]interface{}) (bool, error)
 }
 
 func (c Config) HeartbeatOrFallback() time.Duration {
+	if c.ChangesTimeout > 0 {
+		return 0
+	}
 	if c.Heartbeat == 0 {
 		return time.Second * 10
 	}
 	return c.Heartbeat
 }
 
+func (c Config) VerifyIntervalOrFallback() time.Duration {
+	if c.VerifyInterval <= 0 {
+		return time.Hour
+	}
+	return c.VerifyInterval
+}
+
+func (c Config) LockTTLOrFallback() time.Duration {
+	if c.LockTTL <= 0 {
+		return 30 * time.Second
+	}
+	return c.LockTTL
+}
+
+func (c Config) MaxRevsPerFetchOrFallback() int {
+	if c.MaxRevsPerFetch <= 0 {
+		return 100
+	}
+	return c.MaxRevsPerFetch
+}
+
+func (c Config) MaxURLLengthOrFallback() int {
+	if c.MaxURLLength == 0 {
+		return 7000
+	}
+	return c.MaxURLLength
+}
+
+func (c Config) CapabilityCacheTTLOrFallback() time.Duration {
+	if c.CapabilityCacheTTL == 0 {
+		return 24 * time.Hour
+	}
+	return c.CapabilityCacheTTL
+}
+
+func (c Config) BulkGetBatchSizeOrFallback() int {
+	if c.BulkGetBatchSize <= 0 {
+		return 100
+	}
+	return c.BulkGetBatchSize
+}
+
+func (c Config) detectConflicts() bool {
+	return c.DetectConflicts || c.ConflictResolver != nil
+}
+
+func (c Config) BatchSizeBytesOrFallback() int64 {
+	if c.BatchSizeBytes <= 0 {
+		return MB10
+	}
+	return c.BatchSizeBytes
+}
+
+// batchFull returns true if the stack should be written to the target
+func (c Config) batchFull(stack client.Stack) bool {
+	if c.BatchDocLimit > 0 && len(stack) >= c.BatchDocLimit {
+		return true
+	}
+	return stack.Size() >= c.BatchSizeBytesOrFallback()
+}
+
 // GenerateReplicationID generates a replication id
 // using the given name, name could be a hostame.
 // https://docs.couchdb.org/en/stable/replication/protocol.html#generate-replication-id
@@ -54,7 +445,14 @@ func (j *Job) GenerateReplicationID(name string) string {
 	if err != nil {
 		panic(err)
 	}
-	j.Target.GenerateReplicationID(b)
+	if j.Target != nil {
+		j.Target.GenerateReplicationID(b)
+	} else {
+		_, err = b.WriteString("sink")
+		if err != nil {
+			panic(err)
+		}
+	}
 	_, err = b.WriteString("|")
 	if err != nil {
 		panic(err)
@@ -84,12 +482,112 @@ func (j *Job) GenerateReplicationID(name string) string {
 		}
 	}
 
+	// filtered replications don't share the checkpoints with
+	// unfiltered ones or ones using different arguments
+	if j.Filter != "" {
+		_, err = b.WriteString("|" + j.Filter)
+		if err != nil {
+			panic(err)
+		}
+
+		var keys []string
+		for key := range j.QueryParams {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			_, err = b.WriteString("|" + key + "=" + j.QueryParams[key])
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	if len(j.Selector) > 0 {
+		// compact, so formatting doesn't change the id
+		var selector bytes.Buffer
+		err = json.Compact(&selector, j.Selector)
+		if err != nil {
+			selector.Reset()
+			selector.Write(j.Selector)
+		}
+		_, err = b.WriteString("|" + client.SelectorFilter + "|" + selector.String())
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// the checkpoints of a start sequence apply only to it
+	if j.SinceSeq != "" {
+		_, err = b.WriteString("|since|" + j.SinceSeq)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// every shard has its own checkpoints
+	if j.ShardCount > 1 {
+		_, err = fmt.Fprintf(b, "|shard|%d/%d", j.ShardIndex, j.ShardCount)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// the design documents select a different set of documents
+	if j.DesignDocs != DesignDocsInclude {
+		_, err = b.WriteString("|design_docs|" + string(j.DesignDocs))
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	b.Flush()
 
 	final := hash.Sum(nil)
 	return hex.EncodeToString(final)
 }
 
+// useCheckpoints returns false if the checkpoints are disabled
+func (j *Job) useCheckpoints() bool {
+	return j.UseCheckpoints == nil || *j.UseCheckpoints
+}
+
+// checkpointInterval returns the interval of the intermediate checkpoints
+func (j *Job) checkpointInterval() time.Duration {
+	if j.CheckpointInterval > 0 {
+		return j.CheckpointInterval
+	}
+	return time.Duration(j.CheckpointIntervalMS) * time.Millisecond
+}
+
+// inShard returns true if the document belongs to the shard of the job
+func (j *Job) inShard(docID string) bool {
+	if j.ShardCount <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(docID))
+	return int(h.Sum32()%uint32(j.ShardCount)) == j.ShardIndex
+}
+
+// SplitJob returns n jobs that together replicate the documents of the
+// job, each one a disjoint shard of the document ids. The jobs are
+// named "<id>-shard-<index>". Stored in a job store shared by multiple
+// schedulers with a Locker (see Scheduler.SetLocker) every shard is
+// replicated by one instance at a time.
+func SplitJob(job *Job, n int) []*Job {
+	jobs := make([]*Job, n)
+	for i := range jobs {
+		shard := *job
+		shard.ID = fmt.Sprintf("%s-shard-%d", job.ID, i)
+		shard.Rev = ""
+		shard.ShardCount = n
+		shard.ShardIndex = i
+		jobs[i] = &shard
+	}
+	return jobs
+}
+
 type UserCtx struct {
 	Name  string   `json:"name"`
 	Roles []string `json:"roles"`
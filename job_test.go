@@ -0,0 +1,80 @@
+package replicator_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/memdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateReplicationIDFilter(t *testing.T) {
+	job := func(filter string, params map[string]string) *replicator.Job {
+		return &replicator.Job{
+			Source:      &client.Remote{URL: "http://localhost:5984/source/"},
+			Target:      &client.Remote{URL: "http://localhost:5984/target/"},
+			Filter:      filter,
+			QueryParams: params,
+		}
+	}
+
+	unfiltered := job("", nil).GenerateReplicationID("host")
+	filtered := job("app/by_type", map[string]string{"type": "a"}).GenerateReplicationID("host")
+
+	assert.NotEqual(t, unfiltered, filtered)
+	assert.Equal(t, unfiltered, job("", map[string]string{"type": "a"}).GenerateReplicationID("host"))
+	assert.Equal(t, filtered, job("app/by_type", map[string]string{"type": "a"}).GenerateReplicationID("host"))
+	assert.NotEqual(t, filtered, job("app/by_type", map[string]string{"type": "b"}).GenerateReplicationID("host"))
+}
+
+func TestGenerateReplicationIDProxy(t *testing.T) {
+	job := &replicator.Job{
+		Source: &client.Remote{URL: "http://localhost:5984/source/"},
+		Target: &client.Remote{URL: "http://localhost:5984/target/"},
+	}
+	direct := job.GenerateReplicationID("host")
+
+	job.Source.ProxyURL = "http://proxy:3128"
+	assert.NotEqual(t, direct, job.GenerateReplicationID("host"))
+}
+
+func TestSplitJob(t *testing.T) {
+	source, target := memdb.New("source"), memdb.New("target")
+	for i := 0; i < 20; i++ {
+		_, err := source.Put(fmt.Sprintf("doc-%d", i), map[string]interface{}{"i": i})
+		assert.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job := &replicator.Job{
+		ID:     "job",
+		Source: &client.Remote{URL: "mem://source"},
+		Target: &client.Remote{URL: "mem://target"},
+	}
+	shards := replicator.SplitJob(job, 2)
+	assert.Equal(t, "job-shard-1", shards[1].ID)
+	assert.NotEqual(t, shards[0].GenerateReplicationID("mem"), shards[1].GenerateReplicationID("mem"))
+
+	written := 0
+	for _, shard := range shards {
+		r, err := replicator.NewReplicatorWithPeers("mem", shard, source, target)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Run(ctx))
+
+		// every shard replicates a part of the documents
+		assert.Greater(t, r.Stats().DocsWritten, 0)
+		assert.Less(t, r.Stats().DocsWritten, 20)
+		written += r.Stats().DocsWritten
+	}
+	assert.Equal(t, 20, written)
+	for i := 0; i < 20; i++ {
+		_, err := target.Get(fmt.Sprintf("doc-%d", i))
+		assert.NoError(t, err)
+	}
+}
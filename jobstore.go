@@ -0,0 +1,435 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+var ErrNoStore = errors.New("scheduler has no job store")
+
+// JobStore persists the jobs of a scheduler and their replication state,
+// the jobs are stored in the format of the CouchDB _replicator database
+type JobStore interface {
+	// Jobs returns the stored jobs with their last recorded state
+	Jobs(ctx context.Context) ([]StoredJob, error)
+	// PutJob stores the job and updates its revision
+	PutJob(ctx context.Context, job *Job) error
+	// DeleteJob removes the job
+	DeleteJob(ctx context.Context, job *Job) error
+	// UpdateState records the replication state of the job
+	UpdateState(ctx context.Context, id string, state ReplicationState) error
+}
+
+// StoredJob is a job loaded from a JobStore
+type StoredJob struct {
+	Job   *Job
+	State ReplicationState
+}
+
+// ReplicationState is the state of a job as written by CouchDB
+// to the documents of the _replicator database
+type ReplicationState struct {
+	State     JobState          `json:"_replication_state,omitempty"`
+	StateTime string            `json:"_replication_state_time,omitempty"` // RFC 3339
+	Reason    string            `json:"_replication_state_reason,omitempty"`
+	Stats     *ReplicationStats `json:"_replication_stats,omitempty"`
+}
+
+// ReplicationStats are the statistics of the last replication of a job
+type ReplicationStats struct {
+	RevisionsChecked      int    `json:"revisions_checked"`
+	MissingRevisionsFound int    `json:"missing_revisions_found"`
+	DocsRead              int    `json:"docs_read"`
+	DocsWritten           int    `json:"docs_written"`
+	DocWriteFailures      int    `json:"doc_write_failures"`
+	CheckpointedSourceSeq string `json:"checkpointed_source_seq,omitempty"`
+}
+
+// newReplicationState returns the state of the job status
+func newReplicationState(status JobStatus, stats Stats, now time.Time) ReplicationState {
+	state := ReplicationState{
+		State:     status.State,
+		StateTime: now.UTC().Format(time.RFC3339),
+		Stats: &ReplicationStats{
+			RevisionsChecked:      stats.MissingChecked,
+			MissingRevisionsFound: stats.MissingFound,
+			DocsRead:              stats.DocsRead,
+			DocsWritten:           stats.DocsWritten,
+			DocWriteFailures:      stats.DocWriteFailures,
+			CheckpointedSourceSeq: stats.Seq,
+		},
+	}
+	if status.Err != nil {
+		state.Reason = status.Err.Error()
+	}
+	return state
+}
+
+// replicatorDoc is a job in the format of the _replicator database,
+// the replicator specific Config isn't stored
+type replicatorDoc struct {
+	ID                  string            `json:"_id"`
+	Rev                 string            `json:"_rev,omitempty"`
+	Source              *client.Remote    `json:"source"`
+	Target              *client.Remote    `json:"target,omitempty"`
+	CreateTarget        bool              `json:"create_target,omitempty"`
+	CreateTargetParams  map[string]string `json:"create_target_params,omitempty"`
+	CreateTargetHeaders map[string]string `json:"create_target_headers,omitempty"`
+	Continuous          bool              `json:"continuous,omitempty"`
+	Bidirectional       bool              `json:"bidirectional,omitempty"`
+	Owner               string            `json:"owner,omitempty"`
+	UserCtx             *UserCtx          `json:"user_ctx,omitempty"`
+	SinceSeq            string            `json:"since_seq,omitempty"`
+	UseCheckpoints      *bool             `json:"use_checkpoints,omitempty"`
+	CheckpointInterval  int               `json:"checkpoint_interval,omitempty"`
+	Filter              string            `json:"filter,omitempty"`
+	QueryParams         map[string]string `json:"query_params,omitempty"`
+	Selector            json.RawMessage   `json:"selector,omitempty"`
+	ShardCount          int               `json:"shard_count,omitempty"`
+	ShardIndex          int               `json:"shard_index,omitempty"`
+	DesignDocs          DesignDocs        `json:"design_docs,omitempty"`
+	ProtectedDocs       bool              `json:"protected_docs,omitempty"`
+
+	ReplicationState
+}
+
+func newReplicatorDoc(job *Job) *replicatorDoc {
+	doc := &replicatorDoc{
+		ID:                  job.ID,
+		Rev:                 job.Rev,
+		Source:              job.Source,
+		Target:              job.Target,
+		CreateTarget:        job.CreateTarget,
+		CreateTargetParams:  job.CreateTargetParams,
+		CreateTargetHeaders: job.CreateTargetHeaders,
+		Continuous:          job.Continuous,
+		Bidirectional:       job.Bidirectional,
+		Owner:               job.Owner,
+		SinceSeq:            job.SinceSeq,
+		UseCheckpoints:      job.UseCheckpoints,
+		CheckpointInterval:  job.CheckpointIntervalMS,
+		Filter:              job.Filter,
+		QueryParams:         job.QueryParams,
+		Selector:            job.Selector,
+		ShardCount:          job.ShardCount,
+		ShardIndex:          job.ShardIndex,
+		DesignDocs:          job.DesignDocs,
+		ProtectedDocs:       job.ProtectedDocs,
+	}
+	if job.UserCtx.Name != "" || len(job.UserCtx.Roles) > 0 {
+		doc.UserCtx = &job.UserCtx
+	}
+	return doc
+}
+
+func (d *replicatorDoc) job() StoredJob {
+	job := &Job{
+		ID:                   d.ID,
+		Rev:                  d.Rev,
+		Source:               d.Source,
+		Target:               d.Target,
+		CreateTarget:         d.CreateTarget,
+		CreateTargetParams:   d.CreateTargetParams,
+		CreateTargetHeaders:  d.CreateTargetHeaders,
+		Continuous:           d.Continuous,
+		Bidirectional:        d.Bidirectional,
+		Owner:                d.Owner,
+		SinceSeq:             d.SinceSeq,
+		UseCheckpoints:       d.UseCheckpoints,
+		CheckpointIntervalMS: d.CheckpointInterval,
+		Filter:               d.Filter,
+		QueryParams:          d.QueryParams,
+		Selector:             d.Selector,
+		ShardCount:           d.ShardCount,
+		ShardIndex:           d.ShardIndex,
+		DesignDocs:           d.DesignDocs,
+		ProtectedDocs:        d.ProtectedDocs,
+	}
+	if d.UserCtx != nil {
+		job.UserCtx = *d.UserCtx
+	}
+	return StoredJob{Job: job, State: d.ReplicationState}
+}
+
+// ReplicatorDB stores the jobs in a CouchDB _replicator database,
+// design documents are ignored. Changes of the documents by other
+// tools are detected by their revision.
+type ReplicatorDB struct {
+	c *client.Client
+}
+
+var _ JobStore = (*ReplicatorDB)(nil)
+
+// NewReplicatorDB creates a job store using the database at remote
+func NewReplicatorDB(remote *client.Remote) (*ReplicatorDB, error) {
+	c, err := client.NewClient(remote)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplicatorDB{c: c}, nil
+}
+
+func (s *ReplicatorDB) Jobs(ctx context.Context) ([]StoredJob, error) {
+	opts := client.LocalDocsOptions{IncludeDocs: true, Limit: client.DefaultLocalDocsPageSize}
+	var jobs []StoredJob
+	for {
+		resp, err := s.c.AllDocs(ctx, client.AllDocsOptions{LocalDocsOptions: opts})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range resp.Rows {
+			if strings.HasPrefix(row.ID, "_design/") {
+				continue
+			}
+			var doc replicatorDoc
+			err = json.Unmarshal(row.Doc, &doc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid job %q: %w", row.ID, err)
+			}
+			jobs = append(jobs, doc.job())
+		}
+
+		if len(resp.Rows) < opts.Limit {
+			return jobs, nil
+		}
+		opts.StartKey = resp.Rows[len(resp.Rows)-1].Key
+		opts.Skip = 1
+	}
+}
+
+func (s *ReplicatorDB) PutJob(ctx context.Context, job *Job) error {
+	rev, err := s.c.PutDoc(ctx, job.ID, newReplicatorDoc(job))
+	if err != nil {
+		return err
+	}
+	job.Rev = rev
+	return nil
+}
+
+// DeleteJob deletes the current revision of the job, the
+// revision changes with every update of the state
+func (s *ReplicatorDB) DeleteJob(ctx context.Context, job *Job) error {
+	var doc struct {
+		Rev string `json:"_rev"`
+	}
+	err := s.c.GetDoc(ctx, job.ID, "", &doc)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.c.DeleteDoc(ctx, job.ID, doc.Rev)
+}
+
+// maxStateConflicts limits the retries of a state update
+// if the document was changed concurrently
+const maxStateConflicts = 3
+
+// UpdateState writes the state fields to the current revision of
+// the document, the other fields of the document are preserved
+func (s *ReplicatorDB) UpdateState(ctx context.Context, id string, state ReplicationState) error {
+	var err error
+	for i := 0; i < maxStateConflicts; i++ {
+		var doc map[string]interface{}
+		err = s.c.GetDoc(ctx, id, "", &doc)
+		if err != nil {
+			return err
+		}
+
+		err = setState(doc, state)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.c.PutDoc(ctx, id, doc)
+		if !errors.Is(err, client.ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+// setState replaces the state fields of the document
+func setState(doc map[string]interface{}, state ReplicationState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	delete(doc, "_replication_state")
+	delete(doc, "_replication_state_time")
+	delete(doc, "_replication_state_reason")
+	delete(doc, "_replication_stats")
+	return json.Unmarshal(data, &doc)
+}
+
+// FileJobStore stores the jobs as JSON file, the documents have the
+// format of the _replicator database. The file is replaced atomically.
+type FileJobStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+var _ JobStore = (*FileJobStore)(nil)
+
+// NewFileJobStore creates a job store using the file at path,
+// the file is created with the first job
+func NewFileJobStore(path string) *FileJobStore {
+	return &FileJobStore{path: path}
+}
+
+func (s *FileJobStore) Jobs(ctx context.Context) ([]StoredJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]StoredJob, 0, len(docs))
+	for _, doc := range docs {
+		jobs = append(jobs, doc.job())
+	}
+	return jobs, nil
+}
+
+func (s *FileJobStore) PutJob(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	doc := newReplicatorDoc(job)
+	if old, ok := docs[job.ID]; ok {
+		if old.Rev != job.Rev {
+			return fmt.Errorf("%w: %q", client.ErrConflict, job.ID)
+		}
+		doc.ReplicationState = old.ReplicationState
+	} else if job.Rev != "" {
+		return fmt.Errorf("%w: %q", client.ErrConflict, job.ID)
+	}
+	doc.Rev = nextRev(job.Rev)
+	docs[job.ID] = doc
+
+	err = s.write(docs)
+	if err != nil {
+		return err
+	}
+	job.Rev = doc.Rev
+	return nil
+}
+
+func (s *FileJobStore) DeleteJob(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.read()
+	if err != nil {
+		return err
+	}
+	if old, ok := docs[job.ID]; ok && old.Rev != job.Rev {
+		return fmt.Errorf("%w: %q", client.ErrConflict, job.ID)
+	}
+	delete(docs, job.ID)
+
+	return s.write(docs)
+}
+
+func (s *FileJobStore) UpdateState(ctx context.Context, id string, state ReplicationState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.read()
+	if err != nil {
+		return err
+	}
+	doc, ok := docs[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", client.ErrNotFound, id)
+	}
+	doc.ReplicationState = state
+
+	return s.write(docs)
+}
+
+// read returns the documents of the file by their id
+func (s *FileJobStore) read() (map[string]*replicatorDoc, error) {
+	docs := make(map[string]*replicatorDoc)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return docs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*replicatorDoc
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid job store %q: %w", s.path, err)
+	}
+	for _, doc := range list {
+		docs[doc.ID] = doc
+	}
+	return docs, nil
+}
+
+// write replaces the file with the documents ordered by their id
+func (s *FileJobStore) write(docs map[string]*replicatorDoc) error {
+	list := make([]*replicatorDoc, 0, len(docs))
+	for _, doc := range docs {
+		list = append(list, doc)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close() // nolint: errcheck
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// nextRev returns the revision following rev, the
+// file store only counts the generations
+func nextRev(rev string) string {
+	var gen int
+	fmt.Sscanf(rev, "%d-", &gen) // nolint: errcheck
+	return fmt.Sprintf("%d-%s", gen+1, storeRevSuffix)
+}
+
+const storeRevSuffix = "jobstore"
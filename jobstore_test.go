@@ -0,0 +1,146 @@
+package replicator_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileJobStore(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/db/")
+		switch {
+		case path == "" && r.Method == http.MethodHead:
+		case path == "":
+			fmt.Fprint(w, `{"db_name":"db","update_seq":"0"}`)
+		case path == "_changes":
+			fmt.Fprint(w, `{"results":[],"last_seq":"0"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store := replicator.NewFileJobStore(filepath.Join(t.TempDir(), "jobs.json"))
+	job := &replicator.Job{
+		ID:     "a",
+		Source: &client.Remote{URL: srv.URL + "/db/"},
+		Target: &client.Remote{URL: srv.URL + "/db/"},
+	}
+	assert.NoError(t, store.PutJob(ctx, job))
+	assert.Equal(t, "1-jobstore", job.Rev)
+
+	// the jobs survive a restart of the scheduler
+	s := replicator.NewScheduler("test")
+	s.SetStore(store)
+	assert.NoError(t, s.Load(ctx))
+	go s.Run(ctx) // nolint: errcheck
+
+	for ctx.Err() == nil {
+		jobs, err := store.Jobs(ctx)
+		assert.NoError(t, err)
+		if jobs[0].State.State == replicator.JobCompleted {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	jobs, err := store.Jobs(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, srv.URL+"/db/", jobs[0].Job.Source.URL)
+		assert.Equal(t, replicator.JobCompleted, jobs[0].State.State)
+		assert.NotNil(t, jobs[0].State.Stats)
+	}
+
+	assert.NoError(t, s.Delete(ctx, "a"))
+	jobs, err = store.Jobs(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 0)
+}
+
+func TestReplicatorDB(t *testing.T) {
+	doc := map[string]interface{}{
+		"_id":    "a",
+		"_rev":   "1-a",
+		"source": "http://localhost:5984/source",
+		"target": map[string]interface{}{"url": "http://localhost:5984/target"},
+		"custom": "kept",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_replicator/_all_docs":
+			data, _ := json.Marshal(doc)
+			fmt.Fprintf(w, `{"rows":[{"id":"_design/x","key":"_design/x","value":{"rev":"1-x"},"doc":{}},{"id":"a","key":"a","value":{"rev":"1-a"},"doc":%s}]}`, data)
+		case r.Method == http.MethodGet:
+			assert.NoError(t, json.NewEncoder(w).Encode(doc))
+		case r.Method == http.MethodPut:
+			doc = nil
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&doc))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"ok":true,"id":"a","rev":"2-a"}`)
+		}
+	}))
+	defer srv.Close()
+
+	store, err := replicator.NewReplicatorDB(&client.Remote{URL: srv.URL + "/_replicator"})
+	assert.NoError(t, err)
+
+	jobs, err := store.Jobs(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, "http://localhost:5984/source", jobs[0].Job.Source.URL)
+		assert.Equal(t, "http://localhost:5984/target", jobs[0].Job.Target.URL)
+	}
+
+	err = store.UpdateState(context.Background(), "a", replicator.ReplicationState{
+		State: replicator.JobCrashing,
+		Stats: &replicator.ReplicationStats{DocsWritten: 5},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "kept", doc["custom"])
+	assert.Equal(t, "crashing", doc["_replication_state"])
+	assert.Equal(t, 5.0, doc["_replication_stats"].(map[string]interface{})["docs_written"])
+}
+
+func TestJobStoreRoundTrip(t *testing.T) {
+	noCheckpoints := false
+	tests := map[string]*replicator.Job{
+		"shard":         {ShardCount: 4, ShardIndex: 2},
+		"bidirectional": {Bidirectional: true},
+		"design_docs":   {DesignDocs: replicator.DesignDocsExclude},
+		"protected":     {ProtectedDocs: true, UserCtx: replicator.UserCtx{Name: "admin", Roles: []string{"_admin"}}},
+		"create_target": {CreateTarget: true, CreateTargetHeaders: map[string]string{"X-Placement": "metro"}},
+		"checkpoints":   {UseCheckpoints: &noCheckpoints, CheckpointIntervalMS: 5000},
+	}
+	for name, job := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := replicator.NewFileJobStore(filepath.Join(t.TempDir(), "jobs.json"))
+			job.ID = name
+			job.Source = &client.Remote{URL: "http://localhost:5984/source"}
+			job.Target = &client.Remote{URL: "http://localhost:5984/target"}
+			assert.NoError(t, store.PutJob(ctx, job))
+
+			jobs, err := store.Jobs(ctx)
+			assert.NoError(t, err)
+			if assert.Len(t, jobs, 1) {
+				assert.Equal(t, job, jobs[0].Job)
+			}
+		})
+	}
+}
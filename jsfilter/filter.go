@@ -0,0 +1,97 @@
+// Package jsfilter evaluates CouchDB filter functions (function(doc, req))
+// locally using an embedded JavaScript engine. It is used for sources that
+// can't run design document filters server side, the filter semantics
+// match CouchDB: a document is replicated if the function returns a
+// truthy value.
+//
+// A Filter implements the replicator.DocFilter interface:
+//
+//	f, err := jsfilter.New(`function(doc, req) { return doc.type === req.query.type }`,
+//		jsfilter.Request(map[string]string{"type": "order"}))
+//	job.LocalFilter = f
+package jsfilter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+var (
+	ErrNotAFunction  = errors.New("filter is not a function")
+	ErrFilterMissing = errors.New("filter not found in design document")
+)
+
+// Filter is a compiled filter function, it is safe for concurrent use
+type Filter struct {
+	mu  sync.Mutex
+	vm  *goja.Runtime
+	fn  goja.Callable
+	req goja.Value
+}
+
+// New compiles the filter function source, req is passed as second
+// argument to every call (see Request)
+func New(source string, req map[string]interface{}) (*Filter, error) {
+	vm := goja.New()
+
+	v, err := vm.RunString("(" + source + ")")
+	if err != nil {
+		return nil, fmt.Errorf("compile filter: %w", err)
+	}
+
+	fn, ok := goja.AssertFunction(v)
+	if !ok {
+		return nil, ErrNotAFunction
+	}
+
+	return &Filter{
+		vm:  vm,
+		fn:  fn,
+		req: vm.ToValue(req),
+	}, nil
+}
+
+// NewFromDesignDoc compiles the filter with the given name of the
+// design document (the "filters" object of the document)
+func NewFromDesignDoc(ddoc map[string]interface{}, name string, req map[string]interface{}) (*Filter, error) {
+	filters, ok := ddoc["filters"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrFilterMissing, name)
+	}
+
+	source, ok := filters[name].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrFilterMissing, name)
+	}
+
+	return New(source, req)
+}
+
+// Request returns the request object passed to the filter
+// function with the given query parameters (req.query)
+func Request(query map[string]string) map[string]interface{} {
+	q := make(map[string]interface{}, len(query))
+	for key, value := range query {
+		q[key] = value
+	}
+
+	return map[string]interface{}{
+		"query": q,
+	}
+}
+
+// Match calls the filter function with the document
+func (f *Filter) Match(doc map[string]interface{}) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, err := f.fn(goja.Undefined(), f.vm.ToValue(doc), f.req)
+	if err != nil {
+		return false, fmt.Errorf("filter failed: %w", err)
+	}
+
+	return v.ToBoolean(), nil
+}
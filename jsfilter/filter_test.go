@@ -0,0 +1,44 @@
+package jsfilter_test
+
+import (
+	"testing"
+
+	"github.com/goydb/replicator/jsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := jsfilter.New(`function(doc, req) {
+		return doc.type === req.query.type && !doc._deleted
+	}`, jsfilter.Request(map[string]string{"type": "order"}))
+	assert.NoError(t, err)
+
+	ok, err := f.Match(map[string]interface{}{"_id": "a", "type": "order"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = f.Match(map[string]interface{}{"_id": "b", "type": "invoice"})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = f.Match(map[string]interface{}{"_id": "c", "type": "order", "_deleted": true})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestFilterErrors(t *testing.T) {
+	_, err := jsfilter.New(`42`, nil)
+	assert.ErrorIs(t, err, jsfilter.ErrNotAFunction)
+
+	_, err = jsfilter.NewFromDesignDoc(map[string]interface{}{}, "missing", nil)
+	assert.ErrorIs(t, err, jsfilter.ErrFilterMissing)
+
+	f, err := jsfilter.NewFromDesignDoc(map[string]interface{}{
+		"filters": map[string]interface{}{
+			"throws": `function(doc, req) { throw "boom" }`,
+		},
+	}, "throws", nil)
+	assert.NoError(t, err)
+	_, err = f.Match(map[string]interface{}{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,250 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+var (
+	// ErrLocked is returned if another instance holds the
+	// lease of the replication
+	ErrLocked = errors.New("replication is locked by another instance")
+	// ErrLockLost is returned if the lease couldn't be renewed,
+	// the replication is aborted as another instance may take over
+	ErrLockLost = errors.New("lock of the replication lost")
+)
+
+// LockedError is returned by the DocLocker if another
+// instance holds the lease, it matches ErrLocked
+type LockedError struct {
+	Owner   string    // owner of the lease, empty if unknown
+	Expires time.Time // expiry of the lease, zero if unknown
+}
+
+func (e *LockedError) Error() string {
+	if e.Owner == "" {
+		return ErrLocked.Error()
+	}
+	return fmt.Sprintf("%v: %q until %s", ErrLocked, e.Owner, e.Expires.Format(time.RFC3339))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Locker guards a replication id against concurrent runs of multiple
+// replicator instances, e.g. in HA deployments. The lease expires
+// if it isn't renewed, so a crashed instance doesn't block the others.
+type Locker interface {
+	// Lock acquires or renews the lease of the id for the owner until
+	// ttl passed, it returns ErrLocked if another owner holds the
+	// lease, a LockedError tells standby instances who is active
+	Lock(ctx context.Context, id, owner string, ttl time.Duration) error
+	// Unlock releases the lease if it is held by the owner
+	Unlock(ctx context.Context, id, owner string) error
+}
+
+// lockDoc is the lease stored by the DocLocker
+type lockDoc struct {
+	ID      string    `json:"_id"`
+	Rev     string    `json:"_rev,omitempty"`
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// DocLocker stores the leases as _local documents ("_local/lock-<id>")
+// of a database, usually the source or target of the replication. The
+// expiry is compared with the clock of the instances, large clock skews
+// shorten or extend the leases.
+type DocLocker struct {
+	c *client.Client
+}
+
+// NewDocLocker creates a locker storing the leases in the database
+func NewDocLocker(remote *client.Remote) (*DocLocker, error) {
+	c, err := client.NewClient(remote)
+	if err != nil {
+		return nil, err
+	}
+	return &DocLocker{c: c}, nil
+}
+
+func lockDocID(id string) string {
+	return client.LocalDocPrefix + "lock-" + id
+}
+
+func (l *DocLocker) Lock(ctx context.Context, id, owner string, ttl time.Duration) error {
+	doc := lockDoc{ID: lockDocID(id)}
+	err := l.c.GetDoc(ctx, doc.ID, "", &doc)
+	if err != nil && !errors.Is(err, client.ErrNotFound) {
+		return err
+	}
+
+	now := time.Now()
+	if doc.Owner != "" && doc.Owner != owner && now.Before(doc.Expires) {
+		return &LockedError{Owner: doc.Owner, Expires: doc.Expires}
+	}
+
+	doc.Owner = owner
+	doc.Expires = now.Add(ttl)
+	_, err = l.c.PutDoc(ctx, doc.ID, doc)
+	if errors.Is(err, client.ErrConflict) {
+		// another instance took the lease in between
+		return &LockedError{}
+	}
+	return err
+}
+
+func (l *DocLocker) Unlock(ctx context.Context, id, owner string) error {
+	var doc lockDoc
+	err := l.c.GetDoc(ctx, lockDocID(id), "", &doc)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if doc.Owner != owner {
+		return nil
+	}
+	return l.c.DeleteDoc(ctx, doc.ID, doc.Rev)
+}
+
+// runLocked runs the replication while holding the lease of the
+// replication id, the lease is renewed every third of the ttl. In
+// standby mode the replication waits for the lease and stands by again
+// if it is lost.
+func (r *Replicator) runLocked(ctx context.Context) error {
+	if r.job.Locker == nil || r.job.DryRun {
+		return r.run(ctx)
+	}
+
+	r.buildReplicationID()
+	id := r.checkpointID()
+	ttl := r.job.LockTTLOrFallback()
+	for {
+		acquired, err := r.acquireLock(ctx, id, ttl)
+		if err != nil {
+			return r.fail(PhaseAcquireLock, err)
+		}
+		if !acquired {
+			return nil // stopped while standing by
+		}
+
+		err = r.runLease(ctx, id, ttl)
+		if errors.Is(err, ErrLockLost) && r.job.Standby && ctx.Err() == nil && !r.stopRequested() {
+			r.logger.Warningf("Standing by, %v", err)
+			continue
+		}
+		return err
+	}
+}
+
+// acquireLock takes the lease, in standby mode it waits until the
+// active instance releases the lease or stops renewing it. It returns
+// false if the replication was stopped while standing by.
+func (r *Replicator) acquireLock(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	var holder string
+	for {
+		err := r.job.Locker.Lock(ctx, id, r.lockOwner, ttl)
+		if err == nil {
+			r.logger.Debugf("Acquired lock %q as %q", id, r.lockOwner)
+			if holder != "" {
+				r.logger.Infof("Took over replication %q from %q", id, holder)
+				r.hooks.OnTakeover(holder)
+			}
+			return true, nil
+		}
+
+		var le *LockedError
+		if !r.job.Standby || !errors.As(err, &le) {
+			return false, err
+		}
+		if le.Owner != "" && le.Owner != holder {
+			r.logger.Infof("Replication %q is active on %q, standing by", id, le.Owner)
+			holder = le.Owner
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(ttl / 3):
+		}
+		if r.stopRequested() {
+			r.result = &Result{Stopped: true}
+			return false, nil
+		}
+	}
+}
+
+// runLease runs the replication while renewing the lease,
+// the lease is released once the replication returned
+func (r *Replicator) runLease(ctx context.Context, id string, ttl time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var (
+		wg   sync.WaitGroup
+		lost error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		lost = r.renewLock(ctx, id, ttl)
+		if lost != nil {
+			cancel()
+		}
+	}()
+
+	err := r.run(ctx)
+	cancel()
+	wg.Wait()
+
+	uerr := r.job.Locker.Unlock(context.Background(), id, r.lockOwner)
+	if uerr != nil {
+		r.logger.Warningf("Failed to release lock %q: %v", id, uerr)
+	}
+
+	if lost != nil {
+		return r.fail(PhaseRenewLock, fmt.Errorf("%w: %v", ErrLockLost, lost))
+	}
+	return err
+}
+
+// renewLock renews the lease until the context is done, it returns an
+// error if the lease was taken over or couldn't be renewed while a
+// renew interval was still left on the lease, so that the run stops
+// before another instance can take over
+func (r *Replicator) renewLock(ctx context.Context, id string, ttl time.Duration) error {
+	interval := ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		err := r.job.Locker.Lock(ctx, id, r.lockOwner, ttl)
+		switch {
+		case err == nil:
+			renewed = time.Now()
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, ErrLocked):
+			return err
+		case time.Since(renewed) > ttl-interval-interval/2:
+			// the next tick may come too late, half an interval
+			// tolerates the latency of the ticks and renewals
+			return fmt.Errorf("lease expires: %w", err)
+		default:
+			r.logger.Warningf("Failed to renew lock %q: %v", id, err)
+		}
+	}
+}
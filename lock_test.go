@@ -0,0 +1,241 @@
+package replicator_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocLocker(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		doc map[string]interface{}
+		rev int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Equal(t, "/db/_local/lock-rep", r.URL.Path)
+		current := fmt.Sprintf("0-%d", rev)
+		switch r.Method {
+		case http.MethodGet:
+			if doc == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(doc)
+		case http.MethodPut:
+			var update map[string]interface{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+			if doc != nil && update["_rev"] != current {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			rev++
+			update["_rev"] = fmt.Sprintf("0-%d", rev)
+			doc = update
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"ok":true,"rev":"0-%d"}`, rev)
+		case http.MethodDelete:
+			assert.Equal(t, current, r.URL.Query().Get("rev"))
+			doc = nil
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	locker, err := replicator.NewDocLocker(&client.Remote{URL: srv.URL + "/db"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, locker.Lock(ctx, "rep", "a", time.Minute))
+	assert.NoError(t, locker.Lock(ctx, "rep", "a", 50*time.Millisecond)) // renew
+	assert.ErrorIs(t, locker.Lock(ctx, "rep", "b", time.Minute), replicator.ErrLocked)
+
+	// the lease of a expired
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, locker.Lock(ctx, "rep", "b", time.Minute))
+
+	// a can't release the lease of b
+	assert.NoError(t, locker.Unlock(ctx, "rep", "a"))
+	assert.ErrorIs(t, locker.Lock(ctx, "rep", "a", time.Minute), replicator.ErrLocked)
+
+	assert.NoError(t, locker.Unlock(ctx, "rep", "b"))
+	assert.Nil(t, doc)
+}
+
+// stealingLocker grants the lease a number of times, then
+// another owner takes it over
+type stealingLocker struct {
+	mu       sync.Mutex
+	grants   int
+	unlocked bool
+}
+
+func (l *stealingLocker) Lock(ctx context.Context, id, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.grants == 0 {
+		return fmt.Errorf("%w: by other", replicator.ErrLocked)
+	}
+	l.grants--
+	return nil
+}
+
+func (l *stealingLocker) Unlock(ctx context.Context, id, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.unlocked = true
+	return nil
+}
+
+func TestReplicatorLock(t *testing.T) {
+	newReplicator := func(locker replicator.Locker) *replicator.Replicator {
+		job := &replicator.Job{
+			Source:     &client.Remote{URL: "mem://source"},
+			Target:     &client.Remote{URL: "mem://target"},
+			Continuous: true,
+			Config: replicator.Config{
+				Locker:  locker,
+				LockTTL: 30 * time.Millisecond,
+			},
+		}
+		source := newMemPeer(map[string]interface{}{"_id": "a", "_rev": "1-a"})
+		r, err := replicator.NewReplicatorWithPeers("mem", job, source, newMemPeer())
+		assert.NoError(t, err)
+		return r
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// locked by another instance
+	locker := new(stealingLocker)
+	err := newReplicator(locker).Run(ctx)
+	assert.ErrorIs(t, err, replicator.ErrLocked)
+	assert.False(t, locker.unlocked)
+
+	// the lease is taken over while running
+	locker = &stealingLocker{grants: 2}
+	err = newReplicator(locker).Run(ctx)
+	assert.ErrorIs(t, err, replicator.ErrLockLost)
+	assert.True(t, locker.unlocked)
+}
+
+// failingLocker grants the lease once, renewing it fails
+type failingLocker struct {
+	mu      sync.Mutex
+	granted time.Time
+}
+
+func (l *failingLocker) Lock(ctx context.Context, id, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.granted.IsZero() {
+		return errors.New("locker unavailable")
+	}
+	l.granted = time.Now()
+	return nil
+}
+
+func (l *failingLocker) Unlock(ctx context.Context, id, owner string) error {
+	return nil
+}
+
+func TestReplicatorLockRenewFails(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	locker := new(failingLocker)
+	job := &replicator.Job{
+		Source:     &client.Remote{URL: "mem://source"},
+		Target:     &client.Remote{URL: "mem://target"},
+		Continuous: true,
+		Config: replicator.Config{
+			Locker:  locker,
+			LockTTL: ttl,
+		},
+	}
+	r, err := replicator.NewReplicatorWithPeers("mem", job, newMemPeer(), newMemPeer())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = r.Run(ctx)
+	assert.ErrorIs(t, err, replicator.ErrLockLost)
+
+	// the run is canceled before the lease expired
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	assert.Less(t, int64(time.Since(locker.granted)), int64(ttl))
+}
+
+// memLocker keeps the leases in memory
+type memLocker struct {
+	mu     sync.Mutex
+	leases map[string]replicator.LockedError
+}
+
+func (l *memLocker) Lock(ctx context.Context, id, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lease, ok := l.leases[id]; ok && lease.Owner != owner && time.Now().Before(lease.Expires) {
+		return &lease
+	}
+	l.leases[id] = replicator.LockedError{Owner: owner, Expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (l *memLocker) Unlock(ctx context.Context, id, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.leases[id].Owner == owner {
+		delete(l.leases, id)
+	}
+	return nil
+}
+
+type takeoverHooks struct {
+	replicator.NopHooks
+	previous string
+}
+
+func (h *takeoverHooks) OnTakeover(previousOwner string) {
+	h.previous = previousOwner
+}
+
+func TestReplicatorStandby(t *testing.T) {
+	source := newMemPeer(map[string]interface{}{"_id": "a", "_rev": "1-a"})
+	target := newMemPeer()
+	job := &replicator.Job{
+		Source: &client.Remote{URL: "mem://source"},
+		Target: &client.Remote{URL: "mem://target"},
+		Config: replicator.Config{
+			Locker:  &memLocker{leases: make(map[string]replicator.LockedError)},
+			LockTTL: 30 * time.Millisecond,
+			Standby: true,
+		},
+	}
+	r, err := replicator.NewReplicatorWithPeers("mem", job, source, target)
+	assert.NoError(t, err)
+	hooks := new(takeoverHooks)
+	r.SetHooks(hooks)
+
+	// the active instance stops renewing its lease
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, job.Locker.Lock(ctx, job.GenerateReplicationID("mem"), "active", 100*time.Millisecond))
+
+	err = r.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "active", hooks.previous)
+	assert.Len(t, target.docs, 1)
+}
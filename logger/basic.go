@@ -3,6 +3,7 @@ package logger
 import (
 	"fmt"
 	"log"
+	"strings"
 )
 
 var (
@@ -14,36 +15,51 @@ var (
 
 // Stdout logs to stdout using Go's log package
 type Stdout struct {
+	fields string // formatted key/value pairs
 }
 
 func (s *Stdout) Debug(args ...interface{}) {
-	log.Println(ldebug + fmt.Sprint(args...))
+	log.Println(ldebug + fmt.Sprint(args...) + s.fields)
 }
 
 func (s *Stdout) Info(args ...interface{}) {
-	log.Println(linfo + fmt.Sprint(args...))
+	log.Println(linfo + fmt.Sprint(args...) + s.fields)
 }
 
 func (s *Stdout) Warning(args ...interface{}) {
-	log.Println(lwarn + fmt.Sprint(args...))
+	log.Println(lwarn + fmt.Sprint(args...) + s.fields)
 }
 
 func (s *Stdout) Error(args ...interface{}) {
-	log.Println(lerror + fmt.Sprint(args...))
+	log.Println(lerror + fmt.Sprint(args...) + s.fields)
 }
 
 func (s *Stdout) Debugf(format string, args ...interface{}) {
-	log.Printf(ldebug+format, args...)
+	log.Println(ldebug + fmt.Sprintf(format, args...) + s.fields)
 }
 
 func (s *Stdout) Infof(format string, args ...interface{}) {
-	log.Printf(lwarn+format, args...)
+	log.Println(linfo + fmt.Sprintf(format, args...) + s.fields)
 }
 
 func (s *Stdout) Warningf(format string, args ...interface{}) {
-	log.Printf(lwarn+format, args...)
+	log.Println(lwarn + fmt.Sprintf(format, args...) + s.fields)
 }
 
 func (s *Stdout) Errorf(format string, args ...interface{}) {
-	log.Printf(lerror+format, args...)
+	log.Println(lerror + fmt.Sprintf(format, args...) + s.fields)
+}
+
+// With appends the key/value pairs as key=value to the entries
+func (s *Stdout) With(keysAndValues ...interface{}) Logger {
+	var b strings.Builder
+	b.WriteString(s.fields)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(&b, " !BADKEY=%v", keysAndValues[i])
+		}
+	}
+	return &Stdout{fields: b.String()}
 }
@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel parses the level name (debug, info, warning or error)
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelDebug, fmt.Errorf("unknown log level %q", s)
+}
+
+// Leveled drops the entries below the level
+type Leveled struct {
+	Logger Logger
+	Level  Level
+}
+
+// WithLevel returns a logger that only logs entries at or above the level
+func WithLevel(l Logger, level Level) *Leveled {
+	return &Leveled{Logger: l, Level: level}
+}
+
+func (l *Leveled) Debug(args ...interface{}) {
+	if l.Level <= LevelDebug {
+		l.Logger.Debug(args...)
+	}
+}
+
+func (l *Leveled) Info(args ...interface{}) {
+	if l.Level <= LevelInfo {
+		l.Logger.Info(args...)
+	}
+}
+
+func (l *Leveled) Warning(args ...interface{}) {
+	if l.Level <= LevelWarning {
+		l.Logger.Warning(args...)
+	}
+}
+
+func (l *Leveled) Error(args ...interface{}) {
+	l.Logger.Error(args...)
+}
+
+func (l *Leveled) Debugf(format string, args ...interface{}) {
+	if l.Level <= LevelDebug {
+		l.Logger.Debugf(format, args...)
+	}
+}
+
+func (l *Leveled) Infof(format string, args ...interface{}) {
+	if l.Level <= LevelInfo {
+		l.Logger.Infof(format, args...)
+	}
+}
+
+func (l *Leveled) Warningf(format string, args ...interface{}) {
+	if l.Level <= LevelWarning {
+		l.Logger.Warningf(format, args...)
+	}
+}
+
+func (l *Leveled) Errorf(format string, args ...interface{}) {
+	l.Logger.Errorf(format, args...)
+}
+
+func (l *Leveled) With(keysAndValues ...interface{}) Logger {
+	return &Leveled{Logger: l.Logger.With(keysAndValues...), Level: l.Level}
+}
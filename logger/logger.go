@@ -16,4 +16,8 @@ type Logger interface {
 	Warningf(format string, args ...interface{})
 
 	Errorf(format string, args ...interface{})
+
+	// With returns a logger that adds the key/value pairs
+	// (e.g. "job", id) to every entry as structured fields
+	With(keysAndValues ...interface{}) Logger
 }
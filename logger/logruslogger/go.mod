@@ -0,0 +1,12 @@
+module github.com/goydb/replicator/logger/logruslogger
+
+go 1.25.0
+
+require (
+	github.com/goydb/replicator v0.0.0
+	github.com/sirupsen/logrus v1.8.1
+)
+
+require golang.org/x/sys v0.45.0 // indirect
+
+replace github.com/goydb/replicator => ../..
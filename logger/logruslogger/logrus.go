@@ -0,0 +1,69 @@
+// Package logruslogger implements the replicator logger on top of logrus:
+//
+//	r.SetLogger(logruslogger.New(logrus.StandardLogger()))
+package logruslogger
+
+import (
+	"fmt"
+
+	"github.com/goydb/replicator/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Logger logs to a logrus logger
+type Logger struct {
+	l logrus.FieldLogger
+}
+
+var _ logger.Logger = (*Logger)(nil)
+
+// New creates a logger using l, e.g. a *logrus.Logger or *logrus.Entry
+func New(l logrus.FieldLogger) *Logger {
+	return &Logger{l: l}
+}
+
+func (r *Logger) Debug(args ...interface{}) {
+	r.l.Debug(args...)
+}
+
+func (r *Logger) Info(args ...interface{}) {
+	r.l.Info(args...)
+}
+
+func (r *Logger) Warning(args ...interface{}) {
+	r.l.Warning(args...)
+}
+
+func (r *Logger) Error(args ...interface{}) {
+	r.l.Error(args...)
+}
+
+func (r *Logger) Debugf(format string, args ...interface{}) {
+	r.l.Debugf(format, args...)
+}
+
+func (r *Logger) Infof(format string, args ...interface{}) {
+	r.l.Infof(format, args...)
+}
+
+func (r *Logger) Warningf(format string, args ...interface{}) {
+	r.l.Warningf(format, args...)
+}
+
+func (r *Logger) Errorf(format string, args ...interface{}) {
+	r.l.Errorf(format, args...)
+}
+
+// With adds the key/value pairs as logrus fields, keys
+// are formatted as strings
+func (r *Logger) With(keysAndValues ...interface{}) logger.Logger {
+	fields := make(logrus.Fields, len(keysAndValues)/2)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keysAndValues[i])
+		}
+		fields[key] = keysAndValues[i+1]
+	}
+	return &Logger{l: r.l.WithFields(fields)}
+}
@@ -0,0 +1,26 @@
+package logruslogger_test
+
+import (
+	"testing"
+
+	"github.com/goydb/replicator/logger/logruslogger"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogger(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	l.SetLevel(logrus.InfoLevel)
+
+	log := logruslogger.New(l).With("job", "a")
+	log.Debugf("skipped %d", 1)
+	log.Warningf("retry %d", 2)
+
+	if len(hook.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(hook.Entries))
+	}
+	entry := hook.LastEntry()
+	if entry.Message != "retry 2" || entry.Data["job"] != "a" || entry.Level != logrus.WarnLevel {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
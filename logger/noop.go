@@ -27,3 +27,7 @@ func (s *Noop) Warningf(format string, args ...interface{}) {
 
 func (s *Noop) Errorf(format string, args ...interface{}) {
 }
+
+func (s *Noop) With(keysAndValues ...interface{}) Logger {
+	return s
+}
@@ -0,0 +1,62 @@
+//go:build go1.21
+
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Slog logs to a log/slog logger, warnings use slog.LevelWarn
+type Slog struct {
+	l *slog.Logger
+}
+
+// NewSlog creates a logger using l, or slog.Default if nil
+func NewSlog(l *slog.Logger) *Slog {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &Slog{l: l}
+}
+
+func (s *Slog) log(level slog.Level, msg string) {
+	s.l.Log(context.Background(), level, msg)
+}
+
+func (s *Slog) Debug(args ...interface{}) {
+	s.log(slog.LevelDebug, fmt.Sprint(args...))
+}
+
+func (s *Slog) Info(args ...interface{}) {
+	s.log(slog.LevelInfo, fmt.Sprint(args...))
+}
+
+func (s *Slog) Warning(args ...interface{}) {
+	s.log(slog.LevelWarn, fmt.Sprint(args...))
+}
+
+func (s *Slog) Error(args ...interface{}) {
+	s.log(slog.LevelError, fmt.Sprint(args...))
+}
+
+func (s *Slog) Debugf(format string, args ...interface{}) {
+	s.log(slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (s *Slog) Infof(format string, args ...interface{}) {
+	s.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (s *Slog) Warningf(format string, args ...interface{}) {
+	s.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (s *Slog) Errorf(format string, args ...interface{}) {
+	s.log(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+func (s *Slog) With(keysAndValues ...interface{}) Logger {
+	return &Slog{l: s.l.With(keysAndValues...)}
+}
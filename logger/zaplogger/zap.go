@@ -0,0 +1,57 @@
+// Package zaplogger implements the replicator logger on top of zap:
+//
+//	r.SetLogger(zaplogger.New(zap.L()))
+package zaplogger
+
+import (
+	"github.com/goydb/replicator/logger"
+	"go.uber.org/zap"
+)
+
+// Logger logs to a zap logger
+type Logger struct {
+	l *zap.SugaredLogger
+}
+
+var _ logger.Logger = (*Logger)(nil)
+
+// New creates a logger using l
+func New(l *zap.Logger) *Logger {
+	return &Logger{l: l.Sugar()}
+}
+
+func (z *Logger) Debug(args ...interface{}) {
+	z.l.Debug(args...)
+}
+
+func (z *Logger) Info(args ...interface{}) {
+	z.l.Info(args...)
+}
+
+func (z *Logger) Warning(args ...interface{}) {
+	z.l.Warn(args...)
+}
+
+func (z *Logger) Error(args ...interface{}) {
+	z.l.Error(args...)
+}
+
+func (z *Logger) Debugf(format string, args ...interface{}) {
+	z.l.Debugf(format, args...)
+}
+
+func (z *Logger) Infof(format string, args ...interface{}) {
+	z.l.Infof(format, args...)
+}
+
+func (z *Logger) Warningf(format string, args ...interface{}) {
+	z.l.Warnf(format, args...)
+}
+
+func (z *Logger) Errorf(format string, args ...interface{}) {
+	z.l.Errorf(format, args...)
+}
+
+func (z *Logger) With(keysAndValues ...interface{}) logger.Logger {
+	return &Logger{l: z.l.With(keysAndValues...)}
+}
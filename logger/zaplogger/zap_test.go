@@ -0,0 +1,25 @@
+package zaplogger_test
+
+import (
+	"testing"
+
+	"github.com/goydb/replicator/logger/zaplogger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	l := zaplogger.New(zap.New(core)).With("job", "a")
+
+	l.Debugf("skipped %d", 1)
+	l.Warningf("retry %d", 2)
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Message != "retry 2" || entries[0].ContextMap()["job"] != "a" {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+}
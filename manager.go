@@ -0,0 +1,155 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/logger"
+)
+
+var ErrJobExists = errors.New("job already exists")
+
+// replicationIDRegexp matches the replication ids generated by
+// Job.GenerateReplicationID (hex encoded sha256)
+var replicationIDRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Manager manages a set of replication jobs which share
+// the name used to generate the replication ids
+type Manager struct {
+	name string
+
+	mu   sync.RWMutex
+	jobs map[string]*Job
+
+	logger logger.Logger
+}
+
+func NewManager(name string) *Manager {
+	return &Manager{
+		name:   name,
+		jobs:   make(map[string]*Job),
+		logger: new(logger.Noop),
+	}
+}
+
+func (m *Manager) SetLogger(logger logger.Logger) {
+	m.logger = logger
+}
+
+// AddJob adds the job to the manager, the job is identified by its ID
+func (m *Manager) AddJob(job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.jobs[job.ID]; ok {
+		return ErrJobExists
+	}
+	m.jobs[job.ID] = job
+
+	return nil
+}
+
+// RemoveJob removes the job with the given id from the manager
+func (m *Manager) RemoveJob(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.jobs, id)
+}
+
+// Job returns the job with the given id
+func (m *Manager) Job(id string) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// Jobs returns all jobs ordered by their id
+func (m *Manager) Jobs() []*Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	return jobs
+}
+
+// CollectCheckpoints removes the replication logs from the database at remote
+// that are not referenced by any job of the manager. Only _local documents
+// that look like checkpoints of this package (checkpoint prefix of a job
+// followed by a replication id) are considered. If dryRun is set, nothing
+// is deleted. The ids of the (to be) removed documents are returned.
+func (m *Manager) CollectCheckpoints(ctx context.Context, remote *client.Remote, dryRun bool) ([]string, error) {
+	c, err := client.NewClient(remote)
+	if err != nil {
+		return nil, err
+	}
+	c.SetLogger(m.logger)
+
+	// all checkpoints in use and the known prefixes
+	referenced := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for _, job := range m.Jobs() {
+		for _, id := range job.checkpointIDs(m.name) {
+			referenced[client.LocalDocPrefix+id] = true
+		}
+		prefixes[job.CheckpointPrefix] = true
+	}
+
+	var removed []string
+	err = c.EachLocalDoc(ctx, client.LocalDocsOptions{}, func(row client.LocalDocsRow) error {
+		if referenced[row.ID] || !isCheckpointID(row.ID, prefixes) {
+			return nil
+		}
+
+		if !dryRun {
+			m.logger.Infof("Removing unreferenced checkpoint %q", row.ID)
+			err := c.RemoveReplicationCheckpoint(ctx, strings.TrimPrefix(row.ID, client.LocalDocPrefix), row.Value.Rev)
+			if err != nil {
+				return err
+			}
+		}
+		removed = append(removed, row.ID)
+
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	return removed, nil
+}
+
+// checkpointIDs returns the ids (without the _local/ prefix) of the
+// checkpoints of the job, bidirectional jobs checkpoint both directions.
+// Shards have their own replication ids, see SplitJob.
+func (j *Job) checkpointIDs(name string) []string {
+	ids := []string{j.CheckpointPrefix + j.GenerateReplicationID(name)}
+	if j.Bidirectional {
+		ids = append(ids, j.CheckpointPrefix+j.reverse().GenerateReplicationID(name))
+	}
+	return ids
+}
+
+func isCheckpointID(id string, prefixes map[string]bool) bool {
+	id = strings.TrimPrefix(id, client.LocalDocPrefix)
+	for prefix := range prefixes {
+		if strings.HasPrefix(id, prefix) && replicationIDRegexp.MatchString(id[len(prefix):]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package replicator_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// localDocsServer serves the _local documents of a database
+type localDocsServer struct {
+	mu      sync.Mutex
+	docs    map[string]string // id -> rev
+	deleted []string
+}
+
+func (s *localDocsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/db/_local_docs":
+		ids := make([]string, 0, len(s.docs))
+		for id := range s.docs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		var resp client.LocalDocsResponse
+		for _, id := range ids {
+			row := client.LocalDocsRow{ID: id, Key: id}
+			row.Value.Rev = s.docs[id]
+			resp.Rows = append(resp.Rows, row)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/db/_local/"):
+		id := strings.TrimPrefix(r.URL.Path, "/db/")
+		if s.docs[id] != r.URL.Query().Get("rev") {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		delete(s.docs, id)
+		s.deleted = append(s.deleted, id)
+		_ = json.NewEncoder(w).Encode(client.BulkDocsResult{ID: id, OK: true})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestCollectCheckpoints(t *testing.T) {
+	a := &client.Remote{URL: "http://a/db"}
+	b := &client.Remote{URL: "http://b/db"}
+	c := &client.Remote{URL: "http://c/db"}
+
+	m := replicator.NewManager("test")
+	assert.NoError(t, m.AddJob(&replicator.Job{ID: "push", Source: a, Target: b}))
+	assert.NoError(t, m.AddJob(&replicator.Job{ID: "sync", Source: a, Target: c, Bidirectional: true}))
+
+	push := "_local/" + (&replicator.Job{Source: a, Target: b}).GenerateReplicationID("test")
+	syncAC := "_local/" + (&replicator.Job{Source: a, Target: c, Bidirectional: true}).GenerateReplicationID("test")
+	syncCA := "_local/" + (&replicator.Job{Source: c, Target: a, Bidirectional: true}).GenerateReplicationID("test")
+	orphan := "_local/" + (&replicator.Job{Source: b, Target: c}).GenerateReplicationID("test")
+
+	srv := &localDocsServer{docs: map[string]string{
+		push:           "0-1",
+		syncAC:         "0-1",
+		syncCA:         "0-1",
+		orphan:         "0-3",
+		"_local/other": "0-1",
+	}}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	remote := &client.Remote{URL: hs.URL + "/db"}
+	ctx := context.Background()
+
+	// the dry run deletes nothing
+	removed, err := m.CollectCheckpoints(ctx, remote, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{orphan}, removed)
+	assert.Empty(t, srv.deleted)
+
+	removed, err = m.CollectCheckpoints(ctx, remote, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{orphan}, removed)
+	assert.Equal(t, []string{orphan}, srv.deleted)
+
+	// both directions of the bidirectional job are kept
+	assert.Contains(t, srv.docs, syncAC)
+	assert.Contains(t, srv.docs, syncCA)
+	assert.Contains(t, srv.docs, push)
+	assert.Contains(t, srv.docs, "_local/other")
+}
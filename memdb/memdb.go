@@ -0,0 +1,639 @@
+// Package memdb implements an in-memory database that can be used as
+// source and target of a replication, e.g. to test replication driven
+// applications without CouchDB:
+//
+//	source, target := memdb.New("source"), memdb.New("target")
+//	_, _ = source.Put("a", map[string]interface{}{"v": 1})
+//	r, _ := replicator.NewReplicatorWithPeers("test", job, source, target)
+//	_ = r.Run(ctx)
+//
+// The database keeps the revision tree of the documents, sequences are
+// consecutive numbers and revisions are derived from their content, so
+// replications are deterministic.
+package memdb
+
+import (
+	"context"
+	"crypto/md5" // nolint: gosec
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+)
+
+// ErrNotSupported is returned for change options the database can't apply
+var ErrNotSupported = errors.New("not supported by memdb")
+
+// DB is an in-memory database, safe for concurrent use
+type DB struct {
+	name string
+
+	mu    sync.Mutex
+	seq   int
+	docs  map[string]*document
+	local map[string]*client.ReplicationLog
+}
+
+var (
+	_ replicator.Source            = (*DB)(nil)
+	_ replicator.Target            = (*DB)(nil)
+	_ replicator.DocReader         = (*DB)(nil)
+	_ replicator.ConflictFinder    = (*DB)(nil)
+	_ replicator.DocDeleter        = (*DB)(nil)
+	_ replicator.DocumentSizer     = (*DB)(nil)
+	_ replicator.CheckpointRemover = (*DB)(nil)
+)
+
+// New creates an empty database
+func New(name string) *DB {
+	return &DB{
+		name:  name,
+		docs:  make(map[string]*document),
+		local: make(map[string]*client.ReplicationLog),
+	}
+}
+
+// document is the revision tree of a document
+type document struct {
+	id   string
+	seq  int                  // sequence of the last update
+	revs map[string]*revision // all known revisions
+}
+
+// revision is a node of the revision tree, the body
+// is only known for revisions that were stored
+type revision struct {
+	rev     string
+	parent  string
+	deleted bool
+	body    map[string]interface{}
+	leaf    bool
+}
+
+// leafs returns the leaf revisions, the winning revision first
+func (d *document) leafs() []*revision {
+	var leafs []*revision
+	for _, rev := range d.revs {
+		if rev.leaf {
+			leafs = append(leafs, rev)
+		}
+	}
+	sort.Slice(leafs, func(i, j int) bool {
+		return wins(leafs[i], leafs[j])
+	})
+	return leafs
+}
+
+func (d *document) winner() *revision {
+	return d.leafs()[0]
+}
+
+// wins returns true if revision a wins over b, like CouchDB: not
+// deleted revisions first, then the higher generation and the greater hash
+func wins(a, b *revision) bool {
+	if a.deleted != b.deleted {
+		return !a.deleted
+	}
+	genA, hashA := splitRev(a.rev)
+	genB, hashB := splitRev(b.rev)
+	if genA != genB {
+		return genA > genB
+	}
+	return hashA > hashB
+}
+
+func splitRev(rev string) (int, string) {
+	parts := strings.SplitN(rev, "-", 2)
+	gen, _ := strconv.Atoi(parts[0])
+	if len(parts) < 2 {
+		return gen, ""
+	}
+	return gen, parts[1]
+}
+
+// add inserts the revision with its history, the
+// history is ordered from the revision to the root
+func (d *document) add(history []string, deleted bool, body map[string]interface{}) {
+	for i, rev := range history {
+		r, ok := d.revs[rev]
+		if !ok {
+			r = &revision{rev: rev, leaf: i == 0}
+			d.revs[rev] = r
+		}
+		if i+1 < len(history) {
+			r.parent = history[i+1]
+		}
+		if i == 0 {
+			r.deleted = deleted
+			r.body = body
+		} else {
+			r.leaf = false
+		}
+	}
+}
+
+// history returns the revision and its ancestors
+func (d *document) history(rev string) []string {
+	var history []string
+	for rev != "" {
+		history = append(history, rev)
+		r, ok := d.revs[rev]
+		if !ok {
+			break
+		}
+		rev = r.parent
+	}
+	return history
+}
+
+// data returns the document data of the revision including
+// the revision history like a replication reads it
+func (d *document) data(r *revision) map[string]interface{} {
+	data := make(map[string]interface{}, len(r.body)+4)
+	for key, value := range r.body {
+		data[key] = value
+	}
+	data["_id"] = d.id
+	data["_rev"] = r.rev
+	if r.deleted {
+		data["_deleted"] = true
+	}
+
+	history := d.history(r.rev)
+	start, _ := splitRev(r.rev)
+	ids := make([]interface{}, 0, len(history))
+	for _, rev := range history {
+		_, hash := splitRev(rev)
+		ids = append(ids, hash)
+	}
+	data["_revisions"] = map[string]interface{}{"start": start, "ids": ids}
+
+	return data
+}
+
+// body returns the user data of the document, underscore fields are
+// removed except for the attachments
+func body(data map[string]interface{}) map[string]interface{} {
+	body := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if strings.HasPrefix(key, "_") && key != "_attachments" {
+			continue
+		}
+		body[key] = value
+	}
+	return body
+}
+
+// newRev derives the revision from the parent and the content
+func newRev(parent string, deleted bool, body map[string]interface{}) (string, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	gen, _ := splitRev(parent)
+	sum := md5.Sum([]byte(fmt.Sprintf("%s|%v|%s", parent, deleted, data))) // nolint: gosec
+	return strconv.Itoa(gen+1) + "-" + hex.EncodeToString(sum[:]), nil
+}
+
+// Put stores a new revision of the document, data has to contain the
+// current revision (_rev) of existing documents, otherwise
+// client.ErrConflict is returned. The new revision is returned.
+func (db *DB) Put(id string, data map[string]interface{}) (string, error) {
+	rev, _ := data["_rev"].(string)
+	deleted, _ := data["_deleted"].(bool)
+	var b map[string]interface{}
+	err := roundTrip(body(data), &b)
+	if err != nil {
+		return "", err
+	}
+	return db.update(id, rev, deleted, b)
+}
+
+// Delete deletes the revision of the document, rev has to be
+// the current revision or a conflicting one
+func (db *DB) Delete(id, rev string) (string, error) {
+	return db.update(id, rev, true, map[string]interface{}{})
+}
+
+// DeleteDoc deletes the revision of the document like Delete
+func (db *DB) DeleteDoc(ctx context.Context, id, rev string) error {
+	_, err := db.Delete(id, rev)
+	return err
+}
+
+func (db *DB) update(id, rev string, deleted bool, body map[string]interface{}) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	doc, ok := db.docs[id]
+	var parent string
+	if ok {
+		winner := doc.winner()
+		r, known := doc.revs[rev]
+		switch {
+		case rev == "" && winner.deleted:
+			// deleted documents can be recreated without revision
+			parent = winner.rev
+		case known && r.leaf && !r.deleted:
+			// the winning or a conflicting revision
+			parent = rev
+		default:
+			return "", fmt.Errorf("%w: %q", client.ErrConflict, id)
+		}
+	} else if rev != "" {
+		return "", fmt.Errorf("%w: %q", client.ErrConflict, id)
+	}
+
+	next, err := newRev(parent, deleted, body)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		doc = &document{id: id, revs: make(map[string]*revision)}
+		db.docs[id] = doc
+	}
+	doc.add(append([]string{next}, doc.history(parent)...), deleted, body)
+	db.seq++
+	doc.seq = db.seq
+
+	return next, nil
+}
+
+// Get returns the winning revision of the document
+func (db *DB) Get(id string) (map[string]interface{}, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	doc, ok := db.docs[id]
+	if !ok || doc.winner().deleted {
+		return nil, fmt.Errorf("%w: %q", client.ErrNotFound, id)
+	}
+	data := doc.data(doc.winner())
+	delete(data, "_revisions")
+	var cp map[string]interface{}
+	err := roundTrip(data, &cp)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Conflicts returns the conflicting revisions of the
+// document, the losing leaf revisions that are not deleted
+func (db *DB) Conflicts(id string) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	doc, ok := db.docs[id]
+	if !ok {
+		return nil
+	}
+	var conflicts []string
+	for _, leaf := range doc.leafs()[1:] {
+		if !leaf.deleted {
+			conflicts = append(conflicts, leaf.rev)
+		}
+	}
+	return conflicts
+}
+
+// FindConflicts returns the documents with conflicting revisions
+func (db *DB) FindConflicts(ctx context.Context, ids []string) ([]client.DocConflicts, error) {
+	var conflicts []client.DocConflicts
+	for _, id := range ids {
+		revs := db.Conflicts(id)
+		if len(revs) == 0 {
+			continue
+		}
+		db.mu.Lock()
+		rev := db.docs[id].winner().rev
+		db.mu.Unlock()
+		conflicts = append(conflicts, client.DocConflicts{ID: id, Rev: rev, Conflicts: revs})
+	}
+	return conflicts, nil
+}
+
+// Check always succeeds, the database exists
+func (db *DB) Check(ctx context.Context) error {
+	return nil
+}
+
+// Create does nothing, the database exists
+func (db *DB) Create(ctx context.Context) error {
+	return nil
+}
+
+func (db *DB) Info(ctx context.Context) (*client.Info, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	info := &client.Info{
+		DbName:    db.name,
+		UpdateSeq: strconv.Itoa(db.seq),
+		PurgeSeq:  "0",
+	}
+	for _, doc := range db.docs {
+		if doc.winner().deleted {
+			info.DocDelCount++
+		} else {
+			info.DocCount++
+		}
+	}
+	return info, nil
+}
+
+func (db *DB) GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	repLog, ok := db.local[id]
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+
+	// copy, the log is modified by the replicator
+	var cp client.ReplicationLog
+	err := roundTrip(repLog, &cp)
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (db *DB) RecordReplicationCheckpoint(ctx context.Context, repLog *client.ReplicationLog, id string) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var gen int
+	if old, ok := db.local[id]; ok {
+		if old.Rev != repLog.Rev {
+			return "", fmt.Errorf("%w: %q", client.ErrConflict, id)
+		}
+		gen, _ = strconv.Atoi(strings.TrimPrefix(old.Rev, "0-"))
+	}
+
+	var cp client.ReplicationLog
+	err := roundTrip(repLog, &cp)
+	if err != nil {
+		return "", err
+	}
+	cp.Rev = "0-" + strconv.Itoa(gen+1)
+	db.local[id] = &cp
+	repLog.Rev = cp.Rev
+
+	return cp.Rev, nil
+}
+
+func (db *DB) RemoveReplicationCheckpoint(ctx context.Context, id, rev string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.local, id)
+	return nil
+}
+
+// Changes returns the leaf revisions of the documents changed since
+// the sequence, filter functions and selectors are not supported
+func (db *DB) Changes(ctx context.Context, opts client.ChangeOptions) (*client.ChangesResponse, error) {
+	if opts.Filter != "" || len(opts.Selector) > 0 {
+		return nil, fmt.Errorf("%w: changes filter", ErrNotSupported)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	since := db.seq
+	if opts.Since != client.SinceNow {
+		since, _ = strconv.Atoi(opts.Since)
+	}
+	docIDs := make(map[string]bool, len(opts.DocIDs))
+	for _, id := range opts.DocIDs {
+		docIDs[id] = true
+	}
+
+	var docs []*document
+	for _, doc := range db.docs {
+		if doc.seq > since && (len(docIDs) == 0 || docIDs[doc.id]) {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if opts.Descending {
+			return docs[i].seq > docs[j].seq
+		}
+		return docs[i].seq < docs[j].seq
+	})
+	if opts.Limit > 0 && len(docs) > opts.Limit {
+		docs = docs[:opts.Limit]
+	}
+
+	changes := &client.ChangesResponse{LastSeq: strconv.Itoa(since)}
+	for _, doc := range docs {
+		leafs := doc.leafs()
+		result := client.Results{
+			Seq:     strconv.Itoa(doc.seq),
+			ID:      doc.id,
+			Deleted: leafs[0].deleted,
+		}
+		for _, leaf := range leafs {
+			result.Changes = append(result.Changes, client.Changes{Rev: leaf.rev})
+		}
+		changes.Results = append(changes.Results, result)
+		changes.LastSeq = result.Seq
+	}
+	if opts.Descending || len(docs) == 0 {
+		changes.LastSeq = strconv.Itoa(db.seq)
+	}
+
+	return changes, nil
+}
+
+// GetDocumentComplete returns the winning revision of the missing revisions
+func (db *DB) GetDocumentComplete(ctx context.Context, docid string, diff *client.Diff) (*client.CompleteDoc, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	doc, ok := db.docs[docid]
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+
+	var found *revision
+	for _, rev := range diff.Missing {
+		r, ok := doc.revs[strings.Trim(rev, `"`)]
+		if ok && r.body != nil && (found == nil || wins(r, found)) {
+			found = r
+		}
+	}
+	if found == nil {
+		return nil, client.ErrNotFound
+	}
+
+	data := doc.data(found)
+	var cp map[string]interface{}
+	err := roundTrip(data, &cp)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewDoc(cp), nil
+}
+
+// DocumentSize returns the JSON size of the revision
+func (db *DB) DocumentSize(ctx context.Context, docid, rev string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	doc, ok := db.docs[docid]
+	if !ok {
+		return 0, client.ErrNotFound
+	}
+	r, ok := doc.revs[rev]
+	if !ok || r.body == nil {
+		return 0, client.ErrNotFound
+	}
+	data, err := json.Marshal(doc.data(r))
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// GetDoc reads the revision of the document, the winning revision if rev is empty
+func (db *DB) GetDoc(ctx context.Context, id, rev string, v interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	doc, ok := db.docs[id]
+	if !ok {
+		return client.ErrNotFound
+	}
+	r := doc.winner()
+	if rev != "" {
+		r, ok = doc.revs[rev]
+		if !ok || r.body == nil {
+			return client.ErrNotFound
+		}
+	}
+	data := doc.data(r)
+	delete(data, "_revisions")
+	return roundTrip(data, v)
+}
+
+func (db *DB) RevDiff(ctx context.Context, r client.RevDiffRequest) (client.DiffResponse, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	diff := make(client.DiffResponse)
+	for docID, revs := range r {
+		doc := db.docs[docID]
+		for _, rev := range revs {
+			// ancestors of stored revisions are known
+			if doc != nil && doc.revs[rev] != nil {
+				continue
+			}
+			if diff[docID] == nil {
+				diff[docID] = new(client.Diff)
+			}
+			diff[docID].Missing = append(diff[docID].Missing, rev)
+		}
+	}
+	return diff, nil
+}
+
+// BulkDocs stores the replicated revisions (new_edits=false)
+func (db *DB) BulkDocs(ctx context.Context, stack *client.Stack) ([]client.BulkDocsResult, error) {
+	var failures []client.BulkDocsResult
+	for _, doc := range *stack {
+		err := db.store(doc.Data)
+		if err != nil {
+			failures = append(failures, client.BulkDocsResult{
+				ID:     doc.ID,
+				Error:  "bad_request",
+				Reason: err.Error(),
+			})
+		}
+	}
+	return failures, nil
+}
+
+// UploadDocumentWithAttachments stores the replicated revision with
+// the attachments inlined
+func (db *DB) UploadDocumentWithAttachments(ctx context.Context, doc *client.CompleteDoc) error {
+	err := doc.InlineAttachments()
+	if err != nil {
+		return err
+	}
+	return db.store(doc.Data)
+}
+
+// EnsureFullCommit does nothing
+func (db *DB) EnsureFullCommit(ctx context.Context) error {
+	return nil
+}
+
+// store adds the replicated revision and its history to the revision tree
+func (db *DB) store(data map[string]interface{}) error {
+	id, _ := data["_id"].(string)
+	rev, _ := data["_rev"].(string)
+	if id == "" || rev == "" {
+		return errors.New("document id and revision required")
+	}
+	deleted, _ := data["_deleted"].(bool)
+
+	history := []string{rev}
+	if revisions, ok := data["_revisions"].(map[string]interface{}); ok {
+		start, _ := revisions["start"].(float64)
+		if n, ok := revisions["start"].(int); ok {
+			start = float64(n)
+		}
+		ids, _ := revisions["ids"].([]interface{})
+		history = history[:0]
+		for i, hash := range ids {
+			history = append(history, fmt.Sprintf("%d-%v", int(start)-i, hash))
+		}
+		if len(history) == 0 || history[0] != rev {
+			return fmt.Errorf("invalid revision history of %q", id)
+		}
+	}
+
+	var b map[string]interface{}
+	err := roundTrip(body(data), &b)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	doc, ok := db.docs[id]
+	if !ok {
+		doc = &document{id: id, revs: make(map[string]*revision)}
+		db.docs[id] = doc
+	}
+	if r, ok := doc.revs[rev]; ok && r.body != nil {
+		return nil // already stored
+	}
+	doc.add(history, deleted, b)
+	db.seq++
+	doc.seq = db.seq
+
+	return nil
+}
+
+// roundTrip copies the value using JSON, so the stored
+// data isn't shared with the caller
+func roundTrip(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,61 @@
+package memdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/memdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	source, target := memdb.New("source"), memdb.New("target")
+
+	rev, err := source.Put("a", map[string]interface{}{"v": 1})
+	assert.NoError(t, err)
+	_, err = source.Put("a", map[string]interface{}{"_rev": rev, "v": 2})
+	assert.NoError(t, err)
+	_, err = source.Put("a", map[string]interface{}{"v": 3})
+	assert.ErrorIs(t, err, client.ErrConflict)
+	rev, err = source.Put("b", map[string]interface{}{"v": 1})
+	assert.NoError(t, err)
+	_, err = source.Delete("b", rev)
+	assert.NoError(t, err)
+
+	// conflicting edit on the target, revisions are deterministic
+	targetRev, err := target.Put("a", map[string]interface{}{"v": 1})
+	assert.NoError(t, err)
+	_, err = target.Put("a", map[string]interface{}{"_rev": targetRev, "v": 4})
+	assert.NoError(t, err)
+
+	job := &replicator.Job{
+		Source: &client.Remote{URL: "memdb://source"},
+		Target: &client.Remote{URL: "memdb://target"},
+	}
+	r, err := replicator.NewReplicatorWithPeers("test", job, source, target)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Run(ctx))
+
+	doc, err := target.Get("a")
+	assert.NoError(t, err)
+	assert.Len(t, target.Conflicts("a"), 1)
+	sourceDoc, err := source.Get("a")
+	assert.NoError(t, err)
+	assert.Contains(t, []interface{}{sourceDoc["_rev"], target.Conflicts("a")[0]}, doc["_rev"])
+	_, err = target.Get("b")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+
+	// the next run resumes at the checkpoint
+	_, err = source.Put("c", map[string]interface{}{"v": 1})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Run(ctx))
+	assert.Equal(t, 1, r.Stats().DocsWritten)
+
+	info, err := target.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, info.DocCount)
+	assert.Equal(t, 1, info.DocDelCount)
+}
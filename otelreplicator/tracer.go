@@ -0,0 +1,105 @@
+// Package otelreplicator traces replications with OpenTelemetry. Every
+// step of the replication protocol (VerifyPeers, GetPeersInformation,
+// Changes, RevDiff, BulkDocs, Checkpoint, ...) and every HTTP request to
+// source and target becomes a span, the trace context is propagated to
+// the servers using the configured propagator.
+//
+// A Tracer implements the replicator.Tracer interface:
+//
+//	r.SetTracer(otelreplicator.New(otel.GetTracerProvider()))
+package otelreplicator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the spans
+const ScopeName = "github.com/goydb/replicator"
+
+// Tracer creates the spans of a replication
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New creates a tracer using the provider, the trace
+// context is propagated using the global propagator
+func New(provider trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer:     provider.Tracer(ScopeName),
+		propagator: otel.GetTextMapPropagator(),
+	}
+}
+
+// SetPropagator sets the propagator of the trace headers
+func (t *Tracer) SetPropagator(propagator propagation.TextMapPropagator) {
+	t.propagator = propagator
+}
+
+// Start starts a span for the replication step
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, func(err error)) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, func(err error) {
+		end(span, err)
+	}
+}
+
+// Transport wraps the transport with client spans
+// and injects the trace headers into the requests
+func (t *Tracer) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &transport{tracer: t, next: rt}
+}
+
+type transport struct {
+	tracer *Tracer
+	next   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.tracer.Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+
+	// the request must not be modified, headers are set on a clone
+	req = req.Clone(ctx)
+	t.tracer.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		end(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		end(span, fmt.Errorf("%s", resp.Status))
+	} else {
+		end(span, nil)
+	}
+	return resp, nil
+}
+
+// end ends the span with the error status if err is set
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,56 @@
+package otelreplicator_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goydb/replicator/otelreplicator"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := otelreplicator.New(provider)
+	tracer.SetPropagator(propagation.TraceContext{})
+
+	ctx, end := tracer.Start(context.Background(), "Changes")
+	hc := &http.Client{Transport: tracer.Transport(nil)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/db/_changes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	end(errors.New("failed"))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	httpSpan, stepSpan := spans[0], spans[1]
+	if httpSpan.Name() != "HTTP GET" || httpSpan.Parent().SpanID() != stepSpan.SpanContext().SpanID() {
+		t.Errorf("unexpected http span %q", httpSpan.Name())
+	}
+	if stepSpan.Status().Code != codes.Error {
+		t.Errorf("expected error status, got %v", stepSpan.Status())
+	}
+	if traceparent == "" {
+		t.Error("trace context not propagated")
+	}
+}
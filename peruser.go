@@ -0,0 +1,192 @@
+package replicator
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/logger"
+)
+
+// UserDocPrefix prefix of the user documents in the _users database
+const UserDocPrefix = "org.couchdb.user:"
+
+// UserDBPrefix prefix of the per user databases created by couch_peruser
+const UserDBPrefix = "userdb-"
+
+// UserDBName returns the name of the per user database (userdb-{hex name})
+func UserDBName(username string) string {
+	return UserDBPrefix + hex.EncodeToString([]byte(username))
+}
+
+// PerUser keeps the per user databases of two clusters in sync, like they
+// are created by couch_peruser for every user in the _users database.
+// For every user a bidirectional replication is maintained, the
+// replications are started and stopped as users appear and disappear.
+type PerUser struct {
+	name  string
+	users *client.Remote
+	a, b  *client.Remote
+
+	// PollInterval is the interval the users database is checked for
+	// new or removed users and failed replications are restarted
+	PollInterval time.Duration
+	// Config of the per user replications
+	Config Config
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	wg      sync.WaitGroup
+
+	logger logger.Logger
+}
+
+// NewPerUser creates a new per user sync engine, the users remote points to
+// the _users database, a and b to the root of the two clusters.
+func NewPerUser(name string, users, a, b *client.Remote) *PerUser {
+	return &PerUser{
+		name:         name,
+		users:        users,
+		a:            a,
+		b:            b,
+		PollInterval: time.Second * 10,
+		running:      make(map[string]context.CancelFunc),
+		logger:       new(logger.Noop),
+	}
+}
+
+func (p *PerUser) SetLogger(logger logger.Logger) {
+	p.logger = logger
+}
+
+// Users returns the names of the users that are currently replicated
+func (p *PerUser) Users() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users := make([]string, 0, len(p.running))
+	for user := range p.running {
+		users = append(users, user)
+	}
+	return users
+}
+
+// Run follows the users database until the context is canceled,
+// all replications are stopped before Run returns
+func (p *PerUser) Run(ctx context.Context) error {
+	defer p.wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c, err := client.NewClient(p.users)
+	if err != nil {
+		return err
+	}
+	c.SetLogger(p.logger)
+
+	since := NoVersion
+	for {
+		changes, err := c.Changes(ctx, client.ChangeOptions{Since: since})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		for _, change := range changes.Results {
+			if !strings.HasPrefix(change.ID, UserDocPrefix) {
+				continue
+			}
+
+			user := strings.TrimPrefix(change.ID, UserDocPrefix)
+			if change.Deleted {
+				p.stop(user)
+			} else {
+				p.start(ctx, user)
+			}
+		}
+		since = changes.LastSeq
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(p.PollInterval):
+		}
+	}
+}
+
+func (p *PerUser) start(ctx context.Context, user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.running[user]; ok {
+		return
+	}
+
+	p.logger.Infof("Starting replication of user %q", user)
+	ctx, cancel := context.WithCancel(ctx)
+	p.running[user] = cancel
+
+	db := UserDBName(user)
+	a, b := dbRemote(p.a, db), dbRemote(p.b, db)
+	p.wg.Add(2)
+	go p.replicate(ctx, user, a, b)
+	go p.replicate(ctx, user, b, a)
+}
+
+func (p *PerUser) stop(user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cancel, ok := p.running[user]
+	if !ok {
+		return
+	}
+
+	p.logger.Infof("Stopping replication of user %q", user)
+	cancel()
+	delete(p.running, user)
+}
+
+// replicate runs a continuous replication and restarts it on failure
+func (p *PerUser) replicate(ctx context.Context, user string, source, target *client.Remote) {
+	defer p.wg.Done()
+
+	job := &Job{
+		ID:           user + "|" + source.URL + "|" + target.URL,
+		Source:       source,
+		Target:       target,
+		CreateTarget: true,
+		Continuous:   true,
+		Config:       p.Config,
+	}
+
+	for ctx.Err() == nil {
+		r, err := NewReplicator(p.name, job)
+		if err == nil {
+			r.SetLogger(p.logger)
+			err = r.Run(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			p.logger.Errorf("Replication of user %q (%s -> %s) failed: %v", user, source.URL, target.URL, err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.PollInterval):
+		}
+	}
+}
+
+// dbRemote returns the remote of the database on the server, with
+// the options (auth, proxy, TLS, limiter, ...) of the server
+func dbRemote(server *client.Remote, db string) *client.Remote {
+	r := *server
+	r.URL = strings.TrimRight(server.URL, "/") + "/" + db
+	return &r
+}
@@ -0,0 +1,103 @@
+package replicator_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/memdb"
+	"github.com/goydb/replicator/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// clusterServer serves the database as source and target under its
+// name and records the signed requests
+type clusterServer struct {
+	name   string
+	source *server.SourceHandler
+	target *server.TargetHandler
+
+	mu     sync.Mutex
+	signed int
+}
+
+func newClusterServer(name string, db *memdb.DB) *clusterServer {
+	return &clusterServer{name: name, source: server.NewSourceHandler(db), target: server.NewTargetHandler(db)}
+}
+
+func (s *clusterServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Key-ID") != "" {
+		s.mu.Lock()
+		s.signed++
+		s.mu.Unlock()
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+s.name) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		return
+	}
+	r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+s.name), "/")
+	r.URL.RawPath = ""
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || strings.Contains(r.URL.Path, "/_local/") {
+		s.source.ServeHTTP(w, r)
+	} else {
+		s.target.ServeHTTP(w, r)
+	}
+}
+
+func TestPerUser(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"results":[
+			{"seq":"1","id":"_design/_auth","changes":[{"rev":"1-a"}]},
+			{"seq":"2","id":"org.couchdb.user:alice","changes":[{"rev":"1-b"}]}
+		],"last_seq":"2"}`)
+	}))
+	defer users.Close()
+
+	dbName := replicator.UserDBName("alice")
+	a, b := memdb.New(dbName), memdb.New(dbName)
+	_, err := a.Put("note", map[string]interface{}{"text": "hello"})
+	assert.NoError(t, err)
+
+	sa, sb := newClusterServer(dbName, a), newClusterServer(dbName, b)
+	srvA, srvB := httptest.NewServer(sa), httptest.NewServer(sb)
+	defer srvA.Close()
+	defer srvB.Close()
+
+	// the options of the cluster remote apply to the user databases
+	remoteA := &client.Remote{URL: srvA.URL, Signer: &client.HMACSigner{KeyID: "a", Secret: []byte("secret")}}
+	p := replicator.NewPerUser("peruser", &client.Remote{URL: users.URL + "/_users"}, remoteA, &client.Remote{URL: srvB.URL})
+	p.PollInterval = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Run(ctx)
+	}()
+
+	for ctx.Err() == nil {
+		if _, err := b.Get("note"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"alice"}, p.Users())
+	cancel()
+	assert.NoError(t, <-done)
+
+	_, err = b.Get("note")
+	assert.NoError(t, err)
+	sa.mu.Lock()
+	assert.Greater(t, sa.signed, 0)
+	sa.mu.Unlock()
+	assert.Zero(t, sb.signed)
+}
@@ -0,0 +1,156 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Plan is the effective configuration of a replication, resolved
+// against the peers without transferring data, see Replicator.Plan
+type Plan struct {
+	ReplicationID string `json:"replication_id"`
+	// CheckpointID is the _local document id of the checkpoints
+	CheckpointID string `json:"checkpoint_id"`
+	// Source and Target URLs without password, Target is
+	// empty if the changes are forwarded to the sink
+	Source string `json:"source"`
+	Target string `json:"target,omitempty"`
+	// TargetMissing is set if the target would be created
+	TargetMissing bool `json:"target_missing,omitempty"`
+
+	// Feed of the changes requests, continuous replications
+	// poll the feed with the heartbeat or timeout
+	Feed           string            `json:"feed"`
+	Continuous     bool              `json:"continuous"`
+	Mode           Mode              `json:"mode,omitempty"`
+	Heartbeat      time.Duration     `json:"heartbeat,omitempty"`
+	ChangesTimeout time.Duration     `json:"changes_timeout,omitempty"`
+	SeqInterval    int               `json:"seq_interval,omitempty"`
+	ChangesLimit   int               `json:"changes_limit,omitempty"`
+	Filter         string            `json:"filter,omitempty"`
+	QueryParams    map[string]string `json:"query_params,omitempty"`
+	Selector       json.RawMessage   `json:"selector,omitempty"`
+	ShardCount     int               `json:"shard_count,omitempty"`
+	ShardIndex     int               `json:"shard_index,omitempty"`
+	DesignDocs     DesignDocs        `json:"design_docs,omitempty"`
+
+	// Ancestry explains the StartSeq, the replication replicates the
+	// changes after it up to the EndSeq (backfill or sequence range)
+	Ancestry Ancestry `json:"ancestry"`
+	StartSeq string   `json:"start_seq"`
+	EndSeq   string   `json:"end_seq,omitempty"`
+
+	// SourceServer is nil if the server couldn't be queried
+	SourceServer *client.ServerInfo `json:"source_server,omitempty"`
+	BulkGet      bool               `json:"bulk_get"`
+
+	Profile          Profile `json:"profile,omitempty"`
+	BatchSizeBytes   int64   `json:"batch_size_bytes"`
+	BatchDocLimit    int     `json:"batch_doc_limit,omitempty"`
+	BulkGetBatchSize int     `json:"bulk_get_batch_size"`
+	FetchConcurrency int     `json:"fetch_concurrency"`
+	UseCheckpoints   bool    `json:"use_checkpoints"`
+}
+
+// Plan resolves the configuration of the replication like Run does
+// before the changes are read: the peers are verified, the source
+// server is detected and the common ancestry is found. No data is
+// transferred and neither the target nor the checkpoints are modified.
+func (r *Replicator) Plan(ctx context.Context) (*Plan, error) {
+	defer r.startRun()()
+
+	var plan *Plan
+	err := r.trace(ctx, "replicator.Plan", func(ctx context.Context) error {
+		var err error
+		plan, err = r.plan(ctx)
+		return err
+	})
+	return plan, err
+}
+
+func (r *Replicator) plan(ctx context.Context) (*Plan, error) {
+	r.result = new(Result)
+	r.sessionID = newSessionID()
+	r.planning = true
+	defer func() {
+		r.planning = false
+		r.targetMissing = false
+	}()
+
+	err := r.trace(ctx, "VerifyPeers", r.VerifyPeers)
+	if err != nil {
+		return nil, r.fail(PhaseVerifyPeers, err)
+	}
+	err = r.trace(ctx, "GetPeersInformation", r.GetPeersInformation)
+	if err != nil {
+		return nil, r.fail(PhaseGetPeersInformation, err)
+	}
+	err = r.trace(ctx, "FindCommonAncestry", r.FindCommonAncestry)
+	if err != nil {
+		return nil, r.fail(PhaseFindCommonAncestry, err)
+	}
+	r.applyMode()
+	r.startRange()
+
+	plan := &Plan{
+		ReplicationID: r.replicationID,
+		CheckpointID:  client.LocalDocPrefix + r.checkpointID(),
+		Source:        redactedURL(r.job.Source),
+		TargetMissing: r.targetMissing,
+
+		Feed:           "normal",
+		Continuous:     r.continuous(),
+		Mode:           r.job.Mode,
+		Heartbeat:      r.job.HeartbeatOrFallback(),
+		ChangesTimeout: r.job.ChangesTimeout,
+		SeqInterval:    r.seqInterval(),
+		ChangesLimit:   r.changesLimit(),
+		Filter:         r.job.Filter,
+		QueryParams:    r.job.QueryParams,
+		Selector:       r.job.Selector,
+		ShardCount:     r.job.ShardCount,
+		ShardIndex:     r.job.ShardIndex,
+		DesignDocs:     r.job.DesignDocs,
+
+		Ancestry: r.result.Ancestry,
+		StartSeq: r.sourceLastSeq,
+		EndSeq:   r.backfillSeq,
+
+		SourceServer: r.sourceServer,
+		BulkGet:      r.bulkGetter() != nil,
+
+		Profile:          r.job.Profile,
+		BatchSizeBytes:   r.job.BatchSizeBytesOrFallback(),
+		BatchDocLimit:    r.job.BatchDocLimit,
+		BulkGetBatchSize: r.job.BulkGetBatchSizeOrFallback(),
+		FetchConcurrency: r.job.FetchConcurrency,
+		UseCheckpoints:   r.job.useCheckpoints(),
+	}
+	if r.target != nil {
+		plan.Target = redactedURL(r.job.Target)
+	}
+	if r.rangeEnd > 0 {
+		plan.EndSeq = strconv.FormatInt(r.rangeEnd, 10)
+	}
+	if plan.FetchConcurrency < 1 {
+		plan.FetchConcurrency = 1
+	}
+	return plan, nil
+}
+
+// redactedURL returns the URL of the remote without password
+func redactedURL(remote *client.Remote) string {
+	if remote == nil {
+		return ""
+	}
+	u, err := url.Parse(remote.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
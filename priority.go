@@ -0,0 +1,46 @@
+package replicator
+
+import (
+	"fmt"
+	"path"
+)
+
+// isPriority returns true if the document matches the PriorityDocs
+func (c Config) isPriority(docID string) bool {
+	for _, pattern := range c.PriorityDocs {
+		if ok, _ := path.Match(pattern, docID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePriorityDocs checks the syntax of the patterns
+func (c Config) validatePriorityDocs() error {
+	for _, pattern := range c.PriorityDocs {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid priority document pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// prioritize moves the priority documents in front of the others, both
+// keep their order. The number of priority documents is returned.
+func (r *Replicator) prioritize(order []string) ([]string, int) {
+	if len(r.job.PriorityDocs) == 0 {
+		return order, 0
+	}
+
+	result := make([]string, 0, len(order))
+	var rest []string
+	for _, docID := range order {
+		if r.job.isPriority(docID) {
+			result = append(result, docID)
+		} else {
+			rest = append(rest, docID)
+		}
+	}
+	return append(result, rest...), len(result)
+}
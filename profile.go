@@ -0,0 +1,151 @@
+package replicator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// ErrUnknownProfile is returned for jobs with a Profile that doesn't exist
+var ErrUnknownProfile = errors.New("unknown configuration profile")
+
+// Profile is a named set of defaults for the batch sizes, concurrency,
+// retries and throttling of a job, see Config.Profile
+type Profile string
+
+const (
+	// ProfileNone uses the defaults of the options
+	ProfileNone Profile = ""
+	// ProfileAggressiveLAN fetches and writes big batches in parallel
+	// and retries briefly, for fast peers in the same network
+	ProfileAggressiveLAN Profile = "aggressive-lan"
+	// ProfileConservativeHosted sends small batches at a limited rate
+	// and retries patiently, for hosted services with request quotas
+	ProfileConservativeHosted Profile = "conservative-hosted"
+	// ProfileLowMemoryEdge keeps little data in memory, attachments
+	// are spilled to disk or streamed, for small devices
+	ProfileLowMemoryEdge Profile = "low-memory-edge"
+)
+
+// profileSettings are the options set by a profile
+type profileSettings struct {
+	config Config
+
+	// throttle of the remotes without Limiter, unlimited if 0
+	rps         float64
+	burst       int
+	maxInFlight int
+}
+
+var profiles = map[Profile]profileSettings{
+	ProfileAggressiveLAN: {
+		config: Config{
+			BatchSizeBytes:   50 * 1024 * 1024,
+			FetchConcurrency: 8,
+			BulkGetBatchSize: 500,
+			Retry: client.RetryPolicy{
+				MaxRetries: 2,
+				MinBackoff: 100 * time.Millisecond,
+				MaxBackoff: 2 * time.Second,
+			},
+		},
+	},
+	ProfileConservativeHosted: {
+		config: Config{
+			BatchSizeBytes:   1024 * 1024,
+			BatchDocLimit:    100,
+			FetchConcurrency: 2,
+			BulkGetBatchSize: 50,
+			ChangesLimit:     1000,
+			AdaptiveWindow:   true,
+			Retry: client.RetryPolicy{
+				MaxRetries: 10,
+				MinBackoff: time.Second,
+				MaxBackoff: time.Minute,
+			},
+		},
+		rps:         10,
+		burst:       10,
+		maxInFlight: 4,
+	},
+	ProfileLowMemoryEdge: {
+		config: Config{
+			BatchSizeBytes:            512 * 1024,
+			BatchDocLimit:             50,
+			FetchConcurrency:          1,
+			BulkGetBatchSize:          20,
+			ChangesLimit:              500,
+			AttachmentSpillThreshold:  256 * 1024,
+			AttachmentStreamThreshold: 1024 * 1024,
+			Retry: client.RetryPolicy{
+				MaxRetries: 5,
+				MinBackoff: 500 * time.Millisecond,
+				MaxBackoff: 30 * time.Second,
+			},
+		},
+	},
+}
+
+// withProfile returns a copy of the job with the options that aren't
+// set taken from the Profile, remotes without Limiter are throttled as
+// defined by the profile. The job is returned as is without Profile.
+func (j *Job) withProfile() (*Job, error) {
+	if j.Profile == ProfileNone {
+		return j, nil
+	}
+	p, ok := profiles[j.Profile]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProfile, j.Profile)
+	}
+
+	c := *j
+	if c.BatchSizeBytes == 0 {
+		c.BatchSizeBytes = p.config.BatchSizeBytes
+	}
+	if c.BatchDocLimit == 0 {
+		c.BatchDocLimit = p.config.BatchDocLimit
+	}
+	if c.FetchConcurrency == 0 {
+		c.FetchConcurrency = p.config.FetchConcurrency
+	}
+	if c.BulkGetBatchSize == 0 {
+		c.BulkGetBatchSize = p.config.BulkGetBatchSize
+	}
+	if c.ChangesLimit == 0 {
+		c.ChangesLimit = p.config.ChangesLimit
+	}
+	c.AdaptiveWindow = c.AdaptiveWindow || p.config.AdaptiveWindow
+	if c.AttachmentSpillThreshold == 0 {
+		c.AttachmentSpillThreshold = p.config.AttachmentSpillThreshold
+	}
+	if c.AttachmentStreamThreshold == 0 {
+		c.AttachmentStreamThreshold = p.config.AttachmentStreamThreshold
+	}
+	if c.Retry.MaxRetries == 0 && len(c.Retry.MaxRetriesByMethod) == 0 {
+		c.Retry.MaxRetries = p.config.Retry.MaxRetries
+	}
+	if c.Retry.MinBackoff == 0 {
+		c.Retry.MinBackoff = p.config.Retry.MinBackoff
+	}
+	if c.Retry.MaxBackoff == 0 {
+		c.Retry.MaxBackoff = p.config.Retry.MaxBackoff
+	}
+
+	c.Source = p.throttle(c.Source)
+	c.Target = p.throttle(c.Target)
+	return &c, nil
+}
+
+// throttle returns a copy of the remote with the rate limiter of the
+// profile, remotes with a Limiter are returned as they are
+func (p profileSettings) throttle(remote *client.Remote) *client.Remote {
+	if remote == nil || remote.Limiter != nil || (p.rps == 0 && p.maxInFlight == 0) {
+		return remote
+	}
+	r := *remote
+	r.Limiter = client.NewRateLimiter(p.rps, p.burst)
+	r.Limiter.SetMaxInFlight(p.maxInFlight)
+	return &r
+}
@@ -0,0 +1,40 @@
+package replicator
+
+import (
+	"testing"
+
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithProfileTwice(t *testing.T) {
+	job := &Job{
+		Source: &client.Remote{URL: "http://a/db"},
+		Target: &client.Remote{URL: "http://b/db"},
+	}
+	job.Profile = ProfileConservativeHosted
+
+	once, err := job.withProfile()
+	assert.NoError(t, err)
+	twice, err := once.withProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, once, twice)
+	assert.Same(t, once.Source.Limiter, twice.Source.Limiter)
+	assert.Same(t, once.Target.Limiter, twice.Target.Limiter)
+}
+
+func TestBidirectionalProfile(t *testing.T) {
+	job := &Job{
+		Source: &client.Remote{URL: "http://a/db"},
+		Target: &client.Remote{URL: "http://b/db"},
+	}
+	job.Profile = ProfileConservativeHosted
+
+	// both directions share the limiter of each remote
+	b, err := NewBidirectional("test", job)
+	assert.NoError(t, err)
+	assert.NotNil(t, b.Push.job.Source.Limiter)
+	assert.Same(t, b.Push.job.Source.Limiter, b.Pull.job.Target.Limiter)
+	assert.Same(t, b.Push.job.Target.Limiter, b.Pull.job.Source.Limiter)
+	assert.Nil(t, job.Source.Limiter)
+}
@@ -0,0 +1,77 @@
+// Package replbench generates synthetic databases and measures the
+// replication throughput of different replicator configurations.
+package replbench
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+)
+
+// Config is a named replicator configuration to benchmark
+type Config struct {
+	Name   string
+	Config replicator.Config
+}
+
+// Result of a single benchmark run
+type Result struct {
+	Name        string
+	Duration    time.Duration
+	BytesRead   int64
+	DocsPerSec  float64
+	BytesPerSec float64
+	Err         error
+}
+
+// Run replicates the source into an empty target for every configuration,
+// the target database is deleted before each run. docs is the number of
+// documents in the source used to calculate the rates.
+func Run(ctx context.Context, source, target *client.Remote, docs int, configs []Config) ([]Result, error) {
+	tc, err := client.NewClient(target)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(configs))
+	for _, cfg := range configs {
+		err = tc.Delete(ctx)
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			return results, err
+		}
+
+		results = append(results, runConfig(ctx, source, target, docs, cfg))
+	}
+
+	return results, nil
+}
+
+func runConfig(ctx context.Context, source, target *client.Remote, docs int, cfg Config) Result {
+	res := Result{Name: cfg.Name}
+
+	r, err := replicator.NewReplicator("replbench-"+cfg.Name, &replicator.Job{
+		Source:       source,
+		Target:       target,
+		CreateTarget: true,
+		Config:       cfg.Config,
+	})
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	start := time.Now()
+	res.Err = r.Run(ctx)
+	res.Duration = time.Since(start)
+	res.BytesRead = r.Stats().BytesRead
+
+	if secs := res.Duration.Seconds(); secs > 0 {
+		res.DocsPerSec = float64(docs) / secs
+		res.BytesPerSec = float64(res.BytesRead) / secs
+	}
+
+	return res
+}
@@ -0,0 +1,119 @@
+package replbench
+
+import (
+	"context"
+	"crypto/md5" // nolint: gosec
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Spec describes a synthetic database
+type Spec struct {
+	Docs            int     // number of documents
+	DocSize         int     // approximate size of the document body in bytes
+	AttachmentRatio float64 // fraction of documents with an attachment (0..1)
+	AttachmentSize  int     // size of each attachment in bytes
+	ConflictRatio   float64 // fraction of documents with a conflicting revision (0..1)
+	Seed            int64   // seed for the generated content
+}
+
+// generateBatchSize number of documents uploaded per bulk request
+const generateBatchSize = 500
+
+// Generate (re)creates the database at remote and fills it with
+// documents according to the spec. The content is deterministic
+// for the same spec.
+func Generate(ctx context.Context, remote *client.Remote, spec Spec) error {
+	c, err := client.NewClient(remote)
+	if err != nil {
+		return err
+	}
+
+	err = c.Delete(ctx)
+	if err != nil && !errors.Is(err, client.ErrNotFound) {
+		return err
+	}
+	err = c.Create(ctx)
+	if err != nil {
+		return err
+	}
+
+	rnd := rand.New(rand.NewSource(spec.Seed)) // nolint: gosec
+
+	var stack client.Stack
+	for i := 0; i < spec.Docs; i++ {
+		id := fmt.Sprintf("doc-%08d", i)
+		stack = append(stack, generateDoc(rnd, id, spec, "a"))
+
+		// a second revision on the same level creates the conflict
+		if rnd.Float64() < spec.ConflictRatio {
+			stack = append(stack, generateDoc(rnd, id, spec, "b"))
+		}
+
+		if len(stack) >= generateBatchSize {
+			err = upload(ctx, c, stack)
+			if err != nil {
+				return err
+			}
+			stack = nil
+		}
+	}
+
+	if len(stack) > 0 {
+		return upload(ctx, c, stack)
+	}
+
+	return nil
+}
+
+func upload(ctx context.Context, c *client.Client, stack client.Stack) error {
+	failures, err := c.BulkDocs(ctx, &stack)
+	if err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("document %q: %w", failures[0].ID, failures[0].Err())
+	}
+	return nil
+}
+
+func generateDoc(rnd *rand.Rand, id string, spec Spec, branch string) *client.CompleteDoc {
+	data := map[string]interface{}{
+		"_id":   id,
+		"_rev":  "1-" + revHash(id, branch),
+		"value": randomString(rnd, spec.DocSize),
+	}
+
+	if rnd.Float64() < spec.AttachmentRatio {
+		att := make([]byte, spec.AttachmentSize)
+		_, _ = rnd.Read(att)
+		data["_attachments"] = map[string]interface{}{
+			"data.bin": map[string]interface{}{
+				"content_type": "application/octet-stream",
+				"data":         base64.StdEncoding.EncodeToString(att),
+			},
+		}
+	}
+
+	return &client.CompleteDoc{ID: id, Data: data}
+}
+
+func revHash(id, branch string) string {
+	sum := md5.Sum([]byte(id + "|" + branch)) // nolint: gosec
+	return hex.EncodeToString(sum[:])
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomString(rnd *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[rnd.Intn(len(letters))]
+	}
+	return string(b)
+}
@@ -0,0 +1,79 @@
+package replicator
+
+import (
+	"context"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// ReplicateDocs replicates the leaf revisions of the documents missing on
+// the target, regardless of the recorded checkpoints. It can be used to
+// repair documents, e.g. after they were deleted on the target or skipped
+// by a failed write. No checkpoint is recorded.
+func (r *Replicator) ReplicateDocs(ctx context.Context, ids []string) (Result, error) {
+	defer r.startRun()()
+
+	err := r.trace(ctx, "replicator.ReplicateDocs", func(ctx context.Context) error {
+		return r.replicateDocs(ctx, ids)
+	})
+	return r.Result(), err
+}
+
+func (r *Replicator) replicateDocs(ctx context.Context, ids []string) error {
+	r.result = new(Result)
+	r.stats.reset(time.Now())
+	r.sessionID = newSessionID()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	err := r.trace(ctx, "VerifyPeers", r.VerifyPeers)
+	if err != nil {
+		return r.fail(PhaseVerifyPeers, err)
+	}
+
+	err = r.trace(ctx, "GetPeersInformation", r.GetPeersInformation)
+	if err != nil {
+		return r.fail(PhaseGetPeersInformation, err)
+	}
+	r.detectSourceFeatures(ctx)
+
+	// the history collects the statistics, it isn't recorded
+	r.currentHistory = &client.History{
+		StartTime: time.Now(),
+		SessionID: r.sessionID,
+	}
+	r.window = &WindowTiming{StartSeq: "0"}
+
+	var changes *client.ChangesResponse
+	err = r.trace(ctx, "Changes", func(ctx context.Context) error {
+		var err error
+		changes, err = r.source.Changes(ctx, client.ChangeOptions{
+			Since:  "0",
+			DocIDs: ids,
+		})
+		return err
+	})
+	if err != nil {
+		return r.fail(PhaseChangesOfDocuments, err)
+	}
+
+	err = r.compareRevisions(ctx, changes.Results)
+	if err != nil {
+		return r.fail(PhaseCompareRevisions, err)
+	}
+	r.updateStats(false)
+
+	err = r.replicateDocuments(ctx, false)
+	if err != nil {
+		return r.fail(PhaseReplicateDocuments, err)
+	}
+
+	r.window.EndSeq = changes.LastSeq
+	r.result.Timing.addWindow(*r.window)
+	r.updateStats(true)
+
+	return nil
+}
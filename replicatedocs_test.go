@@ -0,0 +1,68 @@
+package replicator_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicateDocs(t *testing.T) {
+	var checkpoints int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/db/")
+		switch {
+		case path == "":
+			fmt.Fprint(w, `{"db_name":"db","update_seq":"9"}`)
+		case path == "_changes":
+			assert.Equal(t, client.DocIDsFilter, r.URL.Query().Get("filter"))
+			var body struct {
+				DocIDs []string `json:"doc_ids"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, []string{"a", "b"}, body.DocIDs)
+			fmt.Fprint(w, `{"results":[
+				{"seq":"4","id":"a","changes":[{"rev":"2-a"}]},
+				{"seq":"7","id":"b","changes":[{"rev":"1-b"}]}
+			],"last_seq":"9"}`)
+		case strings.HasPrefix(path, "_local/"):
+			checkpoints++
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		default:
+			mw := multipart.NewWriter(w)
+			w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+			pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+			fmt.Fprintf(pw, `{"_id":%q,"_rev":"1-%s"}`, path, path)
+			_ = mw.Close()
+		}
+	}))
+	defer srv.Close()
+
+	var received []string
+	job := &replicator.Job{
+		Source: &client.Remote{URL: srv.URL + "/db/"},
+	}
+	job.Sink = replicator.SinkFunc(func(ctx context.Context, doc *client.CompleteDoc) error {
+		received = append(received, doc.ID)
+		return nil
+	})
+
+	r, err := replicator.NewReplicator("docs", job)
+	assert.NoError(t, err)
+
+	_, err = r.ReplicateDocs(context.Background(), []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, received)
+	assert.Equal(t, 2, r.Stats().DocsWritten)
+	assert.Equal(t, 0, checkpoints)
+}
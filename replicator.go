@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/goydb/replicator/client"
@@ -13,6 +14,14 @@ import (
 var (
 	ErrAbort                = errors.New("abort replication")
 	ErrReplicationCompleted = errors.New("replication completed")
+	ErrNoTarget             = errors.New("job requires a target or sink")
+	ErrFilterAndSelector    = errors.New("job can't use a filter and a selector")
+	ErrCapacityExceeded     = errors.New("target capacity exceeded")
+	ErrNotSupported         = errors.New("not supported by the peer")
+
+	// errNoChanges is returned by LocateChangedDocuments if a
+	// continuous replication is waiting for new changes
+	errNoChanges = errors.New("no changes")
 )
 
 // Replicator implements the couchdb replication protocol:
@@ -20,97 +29,386 @@ var (
 type Replicator struct {
 	// name used to generate the replication id
 	name string
+	// lockOwner identifies the instance holding the lock, see Locker
+	lockOwner string
 
 	job    *Job
-	source *client.Client
-	target *client.Client
+	source Source
+	target Target // nil if the changes are forwarded to the sink
+
+	protectedTarget Target // writes the refused documents, see Job.ProtectedDocs
 
 	sourceInfo, targetInfo *client.Info
+	targetMissing          bool               // only in dry run mode or while planning
+	planning               bool               // see Plan
+	sourceServer           *client.ServerInfo // nil if unknown
+	noBulkGet              bool               // source doesn't support _bulk_get
+	capabilitiesDetected   time.Time          // zero if the source server is unknown
 
 	replicationID string
+	sessionID     string // unique per run
 
-	sourceLastSeq string
-	diffResp      client.DiffResponse
+	sourceLastSeq  string
+	backfillSeq    string // only in backfill mode
+	sourcePurgeSeq string
+	diffResp       client.DiffResponse
+	windowChanges  int   // changes read in the current window
+	rangeEnd       int64 // end of the sequence range, 0 if unlimited
+	adaptive       adaptiveWindow
 
 	sourceRepLog, targetRepLog *client.ReplicationLog
 	currentHistory             *client.History
+	window                     *WindowTiming
+	tracker                    *seqTracker
+
+	checkpointSeq  string    // sequence of the last checkpoint
+	lastCheckpoint time.Time // time of the last checkpoint
+	checkpointDocs int       // documents written at the last checkpoint
+	sourceRefused  bool      // source refused the checkpoint, see SourceReadOnly
+
+	result  *Result
+	stats   *stats
+	lastSeq string // last sequence of the current batch of changes
+
+	hooks            Hooks
+	tracer           Tracer
+	progress         ProgressFunc
+	progressInterval time.Duration
+	lastProgress     time.Time
+
+	sampler    *verifySampler // nil if verification is disabled
+	lastVerify time.Time
+
+	waitMu  sync.Mutex
+	waiters seqWaiters
+
+	stopMu sync.Mutex
+	stop   chan struct{} // closed to stop the run gracefully
+	done   chan struct{} // closed when the run returned
 
 	logger logger.Logger
 }
 
 func NewReplicator(name string, job *Job) (*Replicator, error) {
+	job, err := job.withProfile()
+	if err != nil {
+		return nil, err
+	}
+	return newClientReplicator(name, job)
+}
+
+// newClientReplicator creates the replicator with HTTP clients of the
+// remotes, the profile was already applied to the job
+func newClientReplicator(name string, job *Job) (*Replicator, error) {
 	source, err := client.NewClient(job.Source)
 	if err != nil {
 		return nil, err
 	}
+	source.SetRetryPolicy(job.Retry)
+	source.SetTimeouts(job.Timeouts)
+	source.SetResponseLimits(job.ResponseLimits)
+	source.SetSlowRequestThreshold(job.SlowRequestThreshold)
+	source.SetMaxURLLength(job.MaxURLLengthOrFallback())
+	source.SetDocOptions(client.DocOptions{
+		SpillThreshold: job.AttachmentSpillThreshold,
+		SpillDir:       job.AttachmentSpillDir,
+
+		MaxAttachmentSize:    job.MaxAttachmentSize,
+		SkipLargeAttachments: job.SkipLargeAttachments,
+		AttachmentFilter:     job.AttachmentFilter,
+		AttachmentProcessors: job.AttachmentProcessors,
+		EncodedAttachments:   job.PreserveAttachmentEncoding,
+		StreamThreshold:      job.AttachmentStreamThreshold,
+
+		MaxDocSize:   job.MaxDocSize,
+		MaxParts:     job.MaxDocParts,
+		ParseTimeout: job.DocParseTimeout,
+		MaxJSONDepth: job.ResponseLimits.MaxJSONDepth,
+	})
 
+	// without target the changes are forwarded to the sink
+	if job.Target == nil {
+		return newReplicator(name, job, source, nil)
+	}
 	target, err := client.NewClient(job.Target)
 	if err != nil {
 		return nil, err
 	}
+	target.SetRetryPolicy(job.Retry)
+	target.SetTimeouts(job.Timeouts)
+	target.SetResponseLimits(job.ResponseLimits)
+	target.SetSlowRequestThreshold(job.SlowRequestThreshold)
 
-	return &Replicator{
-		name:   name,
-		job:    job,
-		logger: new(logger.Noop),
-		source: source,
-		target: target,
-	}, nil
+	r, err := newReplicator(name, job, source, target)
+	if err != nil {
+		return nil, err
+	}
+	if job.ProtectedDocs && r.protectedTarget == nil {
+		r.protectedTarget, err = newProtectedDocsTarget(job)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// NewReplicatorWithPeers creates a replicator between the given source
+// and target, e.g. local databases or in-memory targets. The Source and
+// Target remotes of the job still identify the databases in the
+// replication id, their URL doesn't need to be a HTTP URL. Without
+// target the changes are forwarded to the sink of the job.
+func NewReplicatorWithPeers(name string, job *Job, source Source, target Target) (*Replicator, error) {
+	job, err := job.withProfile()
+	if err != nil {
+		return nil, err
+	}
+	return newReplicator(name, job, source, target)
+}
+
+// newReplicator creates the replicator between the peers, the profile
+// was already applied to the job
+func newReplicator(name string, job *Job, source Source, target Target) (*Replicator, error) {
+	if job.Filter != "" && len(job.Selector) > 0 {
+		return nil, ErrFilterAndSelector
+	}
+	if target == nil && job.Sink == nil {
+		return nil, ErrNoTarget
+	}
+	err := job.AttachmentFilter.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = job.validatePriorityDocs()
+	if err != nil {
+		return nil, err
+	}
+	err = job.validateDesignDocs()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replicator{
+		name:      name,
+		lockOwner: name + "-" + newSessionID(),
+		job:       job,
+		result:    new(Result),
+		window:    new(WindowTiming),
+		stats:     newStats(time.Now()),
+		hooks:     NopHooks{},
+		tracer:    nopTracer{},
+		logger:    new(logger.Noop),
+		source:    source,
+		target:    target,
+
+		protectedTarget: job.ProtectedDocsTarget,
+	}
+	for _, peer := range r.peers() {
+		if c, ok := peer.(interface{ SetRetryHook(client.RetryHook) }); ok {
+			c.SetRetryHook(r.onRetry)
+		}
+	}
+	return r, nil
+}
+
+// peers returns the source and target (if any)
+func (r *Replicator) peers() []interface{} {
+	if r.target == nil {
+		return []interface{}{r.source}
+	}
+	return []interface{}{r.source, r.target}
 }
 
 func (r *Replicator) SetLogger(logger logger.Logger) {
 	r.logger = logger
-	r.source.SetLogger(logger)
-	r.target.SetLogger(logger)
+	for _, peer := range r.peers() {
+		if c, ok := peer.(loggerSetter); ok {
+			c.SetLogger(logger)
+		}
+	}
 }
 
-func (t *Replicator) logErrf(format string, args ...interface{}) error {
-	e := fmt.Errorf(format, args...)
-	t.logger.Error(e.Error())
-	return e
+// loggerSetter is implemented by peers that log, e.g. client.Client
+type loggerSetter interface {
+	SetLogger(logger logger.Logger)
 }
 
 func (r *Replicator) Run(ctx context.Context) error {
+	defer r.startRun()()
+
+	err := r.trace(ctx, "replicator.Run", r.runLocked)
+	r.hooks.OnComplete(r.Result(), err)
+	return err
+}
+
+func (r *Replicator) run(ctx context.Context) error {
+	r.result = new(Result)
+	r.stats.reset(time.Now())
+	r.sessionID = newSessionID()
+
 	r.logger.Debug("VerifyPeers")
-	err := r.VerifyPeers(ctx)
+	start := time.Now()
+	err := r.trace(ctx, "VerifyPeers", r.VerifyPeers)
 	if err != nil {
-		return r.logErrf("verify peers failed: %w", err)
+		return r.fail(PhaseVerifyPeers, err)
 	}
+	r.result.Timing.VerifyPeers = time.Since(start)
 
 	r.logger.Debug("GetPeersInformation")
-	err = r.GetPeersInformation(ctx)
+	start = time.Now()
+	err = r.trace(ctx, "GetPeersInformation", r.GetPeersInformation)
 	if err != nil {
-		return r.logErrf("get peers information failed: %w", err)
+		return r.fail(PhaseGetPeersInformation, err)
+	}
+	r.result.Timing.GetPeersInformation = time.Since(start)
+
+	err = r.CheckCapacity()
+	if err != nil {
+		return r.fail(PhaseCapacityCheck, err)
 	}
 
 	r.logger.Debug("FindCommonAncestry")
-	err = r.FindCommonAncestry(ctx)
+	start = time.Now()
+	err = r.trace(ctx, "FindCommonAncestry", r.FindCommonAncestry)
 	if err != nil {
-		return r.logErrf("find common ancestry failed: %w", err)
+		return r.fail(PhaseFindCommonAncestry, err)
 	}
+	r.result.Timing.FindCommonAncestry = time.Since(start)
 
-	for replicate := true; replicate; replicate = r.job.Continuous {
-		r.logger.Debugf("Replication will start since: %s", r.sourceLastSeq)
-		r.currentHistory = &client.History{
-			StartTime:    client.Time(time.Now()),
-			StartLastSeq: r.sourceLastSeq,
-			SessionID:    r.replicationID,
+	r.applyMode()
+	r.startRange()
+
+	if r.job.DryRun {
+		r.logger.Debug("DryRun")
+		err = r.DryRun(ctx)
+		if err != nil {
+			return r.fail(PhaseDryRun, err)
+		}
+		return nil
+	}
+
+	// the session is recorded as one history entry, updated with every checkpoint
+	r.currentHistory = &client.History{
+		StartTime:    time.Now(),
+		StartLastSeq: r.sourceLastSeq,
+		SessionID:    r.sessionID,
+	}
+	r.checkpointSeq = r.sourceLastSeq
+	r.lastCheckpoint = time.Now()
+	r.checkpointDocs = 0
+	r.sourceRefused = false
+	r.adaptive = adaptiveWindow{}
+
+	r.sampler = nil
+	if _, ok := r.target.(DocReader); ok && r.job.VerifySamples > 0 && r.continuous() {
+		r.sampler = newVerifySampler(r.job.VerifySamples)
+		r.lastVerify = time.Now()
+	}
+
+	for {
+		if r.stopRequested() {
+			r.logger.Info("Replication stopped")
+			r.result.Stopped = true
+			return nil
 		}
 
+		r.logger.Debugf("Replication will start since: %s", r.sourceLastSeq)
+		r.window = &WindowTiming{StartSeq: r.sourceLastSeq}
+
 		r.logger.Debug("LocateChangedDocuments")
 		lastSeq, err := r.LocateChangedDocuments(ctx)
+		if errors.Is(err, ErrReplicationCompleted) || errors.Is(err, errNoChanges) {
+			// purges don't show up on the changes feed
+			if r.job.PropagatePurges {
+				perr := r.PropagatePurges(ctx)
+				if perr != nil {
+					return r.fail(PhasePropagatePurges, perr)
+				}
+			}
+			if errors.Is(err, errNoChanges) {
+				continue
+			}
+			r.logger.Info("Replication completed")
+			return nil
+		}
+		if errors.Is(err, errStopped) {
+			err = r.idleStopCheckpoint(ctx)
+			if !errors.Is(err, errStopped) {
+				return r.fail(PhaseLocateChangedDocuments, err)
+			}
+			r.logger.Infof("Replication stopped at %q", r.checkpointSeq)
+			r.result.Stopped = true
+			return nil
+		}
+		if r.shrinkWindow(err) {
+			continue
+		}
 		if err != nil {
-			return r.logErrf("locate changed documents failed: %w", err)
+			return r.fail(PhaseLocateChangedDocuments, err)
 		}
 
 		r.logger.Debugf("ReplicateChanges (lastSeq: %q)", lastSeq)
 		err = r.ReplicateChanges(ctx, lastSeq)
+		if errors.Is(err, errStopped) {
+			r.window.EndSeq = r.checkpointSeq
+			r.result.Timing.addWindow(*r.window)
+			r.logger.Infof("Replication stopped at %q", r.checkpointSeq)
+			r.result.Stopped = true
+			return nil
+		}
+		if r.shrinkWindow(err) {
+			continue
+		}
+		if err != nil {
+			return r.fail(PhaseReplicateChanges, err)
+		}
+		r.adaptive.grow()
+		r.sourceLastSeq = lastSeq
+		r.window.EndSeq = lastSeq
+		r.result.Timing.addWindow(*r.window)
+		r.updateStats(true)
+		r.verifyDue(ctx)
+
+		if r.job.PropagatePurges {
+			r.logger.Debug("PropagatePurges")
+			err = r.PropagatePurges(ctx)
+			if err != nil {
+				return r.fail(PhasePropagatePurges, err)
+			}
+		}
+
+		if r.job.Mode == ModeBackfill && seqReached(lastSeq, r.backfillSeq) {
+			r.logger.Info("Backfill completed")
+			return nil
+		}
+
+		done, err := r.rangeCompleted(ctx, lastSeq)
 		if err != nil {
-			return r.logErrf("replicate changes failed: %w", err)
+			return r.fail(PhaseReplicateChanges, err)
+		}
+		if done {
+			r.logger.Infof("Sequence range completed at %q", lastSeq)
+			return nil
 		}
 	}
+}
 
-	return nil
+// Result returns the result of the last (or currently running) replication
+func (r *Replicator) Result() Result {
+	return r.result.copy()
+}
+
+// applyMode sets the start and end of the changes of the Mode
+func (r *Replicator) applyMode() {
+	switch r.job.Mode {
+	case ModeLive:
+		if r.sourceLastSeq == NoVersion {
+			r.logger.Info("Live mode, replicating changes since now")
+			r.sourceLastSeq = client.SinceNow
+		}
+	case ModeBackfill:
+		r.backfillSeq = r.sourceInfo.UpdateSeq
+		r.logger.Infof("Backfill mode, replicating changes up to %q", r.backfillSeq)
+	}
 }
 
 // VerifyPeers
@@ -122,6 +420,11 @@ func (r *Replicator) VerifyPeers(ctx context.Context) error {
 		return err
 	}
 
+	// Changes are forwarded to the sink
+	if r.target == nil {
+		return nil
+	}
+
 	// Check Target Existence
 	err = r.target.Check(ctx)
 	if err == nil { // 200 OK
@@ -138,7 +441,23 @@ func (r *Replicator) VerifyPeers(ctx context.Context) error {
 		return err
 	}
 
+	// A dry run or plan never modifies the target
+	if r.job.DryRun || r.planning {
+		r.logger.Info("Target doesn't exist and would be created")
+		r.targetMissing = true
+		return nil
+	}
+
 	// Create Target
+	if creator, ok := r.target.(TargetCreator); ok {
+		return creator.CreateWithOptions(ctx, client.CreateOptions{
+			Params:  r.job.CreateTargetParams,
+			Headers: r.job.CreateTargetHeaders,
+		})
+	}
+	if len(r.job.CreateTargetParams) > 0 || len(r.job.CreateTargetHeaders) > 0 {
+		return fmt.Errorf("%w: target creation options", ErrNotSupported)
+	}
 	return r.target.Create(ctx)
 }
 
@@ -154,6 +473,10 @@ func (r *Replicator) GetPeersInformation(ctx context.Context) error {
 	}
 
 	// Get Target Information
+	if r.target == nil || r.targetMissing {
+		r.targetInfo = new(client.Info)
+		return nil
+	}
 	r.targetInfo, err = r.target.Info(ctx)
 	if err != nil {
 		return err
@@ -162,11 +485,110 @@ func (r *Replicator) GetPeersInformation(ctx context.Context) error {
 	return nil
 }
 
+// changesSeqInterval is the seq_interval of the changes feed of
+// servers supporting it
+const changesSeqInterval = 100
+
+// detectSourceFeatures queries the source server once to choose the
+// requests it supports, e.g. _bulk_get and seq_interval. If the server
+// can't be queried (e.g. no access to the root) the defaults are used.
+// Capabilities cached in the replication logs are used if still valid.
+func (r *Replicator) detectSourceFeatures(ctx context.Context, repLogs ...*client.ReplicationLog) {
+	si, ok := r.source.(ServerInformer)
+	if !ok || r.sourceServer != nil || r.restoreCapabilities(repLogs) {
+		return
+	}
+
+	server, err := si.ServerInfo(ctx)
+	if err != nil {
+		r.logger.Infof("Failed to detect the features of the source server: %v", err)
+		return
+	}
+	r.logger.Infof("Source server %s %s, features: %v", server.Vendor.Name, server.Version, server.Features)
+	r.sourceServer = server
+	r.noBulkGet = !server.SupportsBulkGet()
+	r.capabilitiesDetected = time.Now()
+}
+
+// restoreCapabilities uses the newest capabilities of the replication
+// logs that are younger than the CapabilityCacheTTL
+func (r *Replicator) restoreCapabilities(repLogs []*client.ReplicationLog) bool {
+	ttl := r.job.CapabilityCacheTTLOrFallback()
+	if ttl < 0 {
+		return false
+	}
+
+	var cached *client.Capabilities
+	for _, repLog := range repLogs {
+		caps := repLog.SourceCapabilities
+		if caps == nil || caps.Server == nil || time.Since(caps.Detected) >= ttl {
+			continue
+		}
+		if cached == nil || caps.Detected.After(cached.Detected) {
+			cached = caps
+		}
+	}
+	if cached == nil {
+		return false
+	}
+
+	r.logger.Debugf("Source server %s %s, detected at %s", cached.Server.Vendor.Name, cached.Server.Version, cached.Detected)
+	r.sourceServer = cached.Server
+	r.noBulkGet = cached.NoBulkGet
+	r.capabilitiesDetected = cached.Detected
+	return true
+}
+
+// sourceCapabilities returns the capabilities recorded with
+// the checkpoints, nil if the source server is unknown
+func (r *Replicator) sourceCapabilities() *client.Capabilities {
+	if r.sourceServer == nil {
+		return nil
+	}
+	return &client.Capabilities{
+		Server:    r.sourceServer,
+		NoBulkGet: r.noBulkGet,
+		Detected:  r.capabilitiesDetected,
+	}
+}
+
+// seqInterval returns the seq_interval of the changes requests
+func (r *Replicator) seqInterval() int {
+	if r.sourceServer == nil || !r.sourceServer.SupportsSeqInterval() {
+		return 0
+	}
+	return changesSeqInterval
+}
+
+// CheckCapacity compares the size of the source with the capacity limit
+// of the target before a one-shot replication. The source size is added
+// to the target size, documents that exist on both are counted twice.
+func (r *Replicator) CheckCapacity() error {
+	if r.job.CapacityLimit <= 0 || r.target == nil || r.continuous() {
+		return nil
+	}
+
+	projected := r.targetInfo.Sizes.External + r.sourceInfo.Sizes.External
+	if projected <= r.job.CapacityLimit {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: source %d bytes and target %d bytes exceed the limit of %d bytes",
+		ErrCapacityExceeded, r.sourceInfo.Sizes.External, r.targetInfo.Sizes.External, r.job.CapacityLimit)
+	if r.job.CapacityWarnOnly || r.job.DryRun {
+		r.logger.Warning(err.Error())
+		return nil
+	}
+
+	return err
+}
+
 // FindCommonAncestry
 // https://docs.couchdb.org/en/stable/replication/protocol.html#find-common-ancestry
 func (r *Replicator) FindCommonAncestry(ctx context.Context) error {
 	// Generate Replication ID
-	id := r.buildReplicationID()
+	r.buildReplicationID()
+	id := r.checkpointID()
 
 	// Get Replication Log from Source
 	sourceRepLog, err := r.source.GetReplicationLog(ctx, id)
@@ -177,23 +599,64 @@ func (r *Replicator) FindCommonAncestry(ctx context.Context) error {
 		sourceRepLog = new(client.ReplicationLog)
 	}
 
-	// Get Replication Log from Target
-	targetRepLog, err := r.target.GetReplicationLog(ctx, id)
-	if err != nil && !errors.Is(err, client.ErrNotFound) {
-		return err
+	// Get Replication Log from Target, without log
+	// of the sink the source log is trusted
+	var targetRepLog *client.ReplicationLog
+	if r.target != nil {
+		targetRepLog, err = r.target.GetReplicationLog(ctx, id)
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			return err
+		}
+	} else if sl, ok := r.job.Sink.(SinkLogReader); ok {
+		targetRepLog, err = sl.GetReplicationLog(ctx, id)
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			return err
+		}
+	} else {
+		targetRepLog = sourceRepLog
 	}
 	if targetRepLog == nil {
 		targetRepLog = new(client.ReplicationLog)
 	}
 
-	// Compare Replication Logs
-	err = r.CompareReplicationLogs(ctx, sourceRepLog, targetRepLog)
-	if err != nil {
-		return err
+	var ancestry Ancestry
+	if r.job.ForceFull {
+		// Ignore the common ancestry, the existing history is not trusted
+		ancestry = Ancestry{Reason: AncestryForced, Seq: NoVersion}
+		sourceRepLog.History = nil
+		targetRepLog.History = nil
+	} else {
+		// Compare Replication Logs
+		// the log of a read-only source is missing or outdated
+		compareLog := sourceRepLog
+		if r.job.SourceReadOnly {
+			compareLog = targetRepLog
+		}
+		ancestry, err = r.CompareReplicationLogs(ctx, compareLog, targetRepLog)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the start sequence applies until a checkpoint was recorded
+	matched := ancestry.Reason == AncestrySessionMatch || ancestry.Reason == AncestryHistoryMatch
+	if r.job.SinceSeq != "" && !matched {
+		ancestry.Reason = AncestrySinceSeq
+		ancestry.Seq = r.job.SinceSeq
+	}
+
+	r.logger.Infof("Common ancestry: %s", ancestry)
+	r.sourceLastSeq = ancestry.Seq
+	r.result.Ancestry = ancestry
+
+	// the common ancestry is checkpointed on both peers
+	if matched {
+		r.checkpointed(ancestry.Seq)
 	}
 
 	r.sourceRepLog = sourceRepLog
 	r.targetRepLog = targetRepLog
+	r.detectSourceFeatures(ctx, sourceRepLog, targetRepLog)
 
 	return nil
 }
@@ -201,87 +664,254 @@ func (r *Replicator) FindCommonAncestry(ctx context.Context) error {
 // Locate Changed Documents
 // https://docs.couchdb.org/en/stable/replication/protocol.html#locate-changed-documents
 func (r *Replicator) LocateChangedDocuments(ctx context.Context) (string, error) {
-start:
-	time.Sleep(time.Second)
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-r.stopSignal():
+		return "", errStopped
+	case <-time.After(time.Second):
+	}
 
 	// Listen to Changes Feed
-	changes, err := r.source.Changes(ctx, client.ChangeOptions{
-		Since:     r.sourceLastSeq,
-		Heartbeat: r.job.HeartbeatOrFallback(),
+	start := time.Now()
+	r.windowChanges = 0
+	var changes *client.ChangesResponse
+	err := r.trace(ctx, "Changes", func(ctx context.Context) error {
+		var err error
+		changes, err = r.source.Changes(ctx, client.ChangeOptions{
+			Since:       r.sourceLastSeq,
+			Heartbeat:   r.job.HeartbeatOrFallback(),
+			Timeout:     r.job.ChangesTimeout,
+			Filter:      r.job.Filter,
+			QueryParams: r.job.QueryParams,
+			Selector:    r.job.Selector,
+			SeqInterval: r.seqInterval(),
+			Limit:       r.changesLimit(),
+		})
+		return err
 	})
 	if err != nil {
 		return "", err
 	}
+	r.window.Changes = time.Since(start)
+	r.windowChanges = len(changes.Results)
+
+	// other shards are replicated by other jobs, design
+	// documents may be excluded
+	if r.job.ShardCount > 1 || r.job.DesignDocs != DesignDocsInclude {
+		results := changes.Results[:0]
+		for _, change := range changes.Results {
+			if r.job.inShard(change.ID) && r.job.selectsDesignDoc(change.ID) {
+				results = append(results, change)
+			}
+		}
+		changes.Results = results
+	}
 
-	// No more changes
+	// No more changes, pages filtered out entirely aren't the end
 	r.logger.Debugf("Changes: %d", len(changes.Results))
+	if r.windowChanges == 0 {
+		// resolves since=now to a sequence, no changes are missed
+		if changes.LastSeq != "" {
+			r.sourceLastSeq = changes.LastSeq
+		}
+
+		if r.continuous() {
+			return "", errNoChanges
+		}
+		return "", ErrReplicationCompleted // Replication Completed
+	}
+
+	// a page without changes of the job only advances the checkpoint
 	if len(changes.Results) == 0 {
-		if r.job.Continuous {
-			goto start
-		} else {
-			return "", ErrReplicationCompleted // Replication Completed
+		r.diffResp = make(client.DiffResponse)
+		r.tracker = newSeqTracker(nil, nil)
+	} else {
+		err = r.compareRevisions(ctx, changes.Results)
+		if err != nil {
+			return "", err
 		}
 	}
+	r.lastSeq = changes.LastSeq
+	r.updateStats(false)
+	return changes.LastSeq, nil
+}
 
-	// Read Batch of Changes
-	diff := make(client.RevDiffRequest)
-	for _, change := range changes.Results {
+// compareRevisions asks the target which revisions of the changes are
+// missing, the missing revisions are replicated by ReplicateChanges
+func (r *Replicator) compareRevisions(ctx context.Context, results []client.Results) error {
+	// Read Batch of Changes, the rows of rapidly updated documents are
+	// collapsed, the last row lists all leaves (style=all_docs)
+	latest := make(map[string]int, len(results))
+	for i, change := range results {
+		latest[change.ID] = i
+	}
+	diff := make(client.RevDiffRequest, len(latest))
+	for i, change := range results {
+		if latest[change.ID] != i {
+			r.result.ChangesCollapsed++
+			continue
+		}
 		for _, rev := range change.Changes {
 			diff[change.ID] = append(diff[change.ID], rev.Rev)
 		}
 	}
-	r.currentHistory.MissingFound += len(diff)
+	r.currentHistory.MissingChecked += diff.Revisions()
 
 	// Compare Documents Revisions
-	diffResp, err := r.target.RevDiff(ctx, diff)
-	if err != nil {
-		return "", err
+	start := time.Now()
+	var diffResp client.DiffResponse
+	if r.target == nil || r.targetMissing {
+		// all revisions are missing
+		diffResp = make(client.DiffResponse)
+		for docID, revs := range diff {
+			diffResp[docID] = &client.Diff{Missing: revs}
+		}
+	} else {
+		err := r.trace(ctx, "RevDiff", func(ctx context.Context) error {
+			var err error
+			diffResp, err = r.target.RevDiff(ctx, diff)
+			return err
+		})
+		if err != nil {
+			return err
+		}
 	}
-	r.currentHistory.MissingChecked += len(diffResp)
+	r.currentHistory.MissingFound += diffResp.Missing()
+	r.window.RevsDiff = time.Since(start)
 
 	// Any Differences Found?
+	// No differences will only advance the checkpoint
 	r.logger.Debugf("Differences: %d", len(diffResp))
-	if len(diffResp) == 0 { // No
-		goto start
-	}
-
 	r.diffResp = diffResp
-	return changes.LastSeq, nil
+	r.tracker = newSeqTracker(results, diffResp)
+	return nil
 }
 
 // MB10 10 MB
-const MB10 = 10 * (1024 ^ 2)
+const MB10 = 10 * 1024 * 1024
 
 // ReplicateChanges
 // https://docs.couchdb.org/en/stable/replication/protocol.html#replicate-changes
 func (r *Replicator) ReplicateChanges(ctx context.Context, lastSeq string) error {
+	err := r.replicateDocuments(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	// Record a checkpoint if the sequence advanced
+	if lastSeq != r.checkpointSeq {
+		return r.checkpoint(ctx, lastSeq)
+	}
+
+	return nil
+}
+
+// replicateDocuments fetches the missing revisions of the changed
+// documents and writes them to the target, with checkpoints the
+// intermediate and stop checkpoints are recorded
+func (r *Replicator) replicateDocuments(ctx context.Context, checkpoints bool) error {
 	var stack client.Stack
 
-	for docID, diff := range r.diffResp {
-		// Fetch Next Changed Document
-		doc, err := r.source.GetDocumentComplete(ctx, docID, diff)
+	// Fetch Next Changed Document
+	handle := func(docID string, revs []string, doc *client.CompleteDoc, err error) error {
+		if errors.Is(err, client.ErrNotFound) {
+			// document was removed (e.g. purged) after the changes were read
+			r.skipDocument(docID, revs, SkipNotFound, err)
+			return nil
+		}
+		if errors.Is(err, client.ErrAttachmentTooLarge) {
+			r.skipDocument(docID, revs, SkipAttachmentTooLarge, err)
+			return nil
+		}
+		if err != nil && r.job.SoftDocErrors && ctx.Err() == nil {
+			r.skipDocument(docID, revs, SkipFetchFailed, err)
+			return nil
+		}
 		if err != nil {
-			return err
+			return docErr(docID, err)
+		}
+		if skipped := doc.SkippedAttachments(); len(skipped) > 0 {
+			r.logger.Warningf("Document %q replicated without the attachments %v: exceeding %d bytes", docID, skipped, r.job.MaxAttachmentSize)
+			r.result.AttachmentsSkipped += len(skipped)
+		}
+		if filtered := doc.FilteredAttachments(); len(filtered) > 0 {
+			r.logger.Debugf("Document %q replicated without the filtered attachments %v", docID, filtered)
+			r.result.AttachmentsFiltered += len(filtered)
 		}
 		r.currentHistory.DocsRead++
+		r.stats.read(1, doc.Size(), time.Now())
+
+		// stacked documents have their attachments inlined, the
+		// spilled attachments are released in any case
+		defer doc.Close() // nolint: errcheck
+
 		r.logger.Debugf("Document size: %d has attachments: %v revision: %q", doc.Size(), doc.HasChangedAttachments(), doc.Data["_rev"])
 
+		// Evaluate the Local Filter
+		if r.job.LocalFilter != nil {
+			ok, err := r.job.LocalFilter.Match(doc.Data)
+			if err != nil {
+				return docErr(docID, fmt.Errorf("local filter failed: %w", err))
+			}
+			if !ok {
+				r.skipDocument(docID, revs, SkipFiltered, nil)
+				return nil
+			}
+		}
+
+		// Forward Document to the Sink
+		if r.target == nil {
+			start := time.Now()
+			err = r.job.Sink.Receive(ctx, doc)
+			r.window.Upload += time.Since(start)
+			if err != nil {
+				r.currentHistory.DocWriteFailures++
+				return docErr(docID, err)
+			}
+			r.currentHistory.DocsWritten++
+			r.stats.written(1, doc.Size(), time.Now())
+			r.tracker.done(docID)
+			return nil
+		}
+
+		r.sampler.add(doc)
+
 		// Document Has Changed Attachments?
 		if doc.HasChangedAttachments() {
+			// compressed attachments are uploaded as they are,
+			// inlined only if the target keeps the encoding
+			encoded := r.job.PreserveAttachmentEncoding && doc.HasEncodedAttachments()
+			_, inlineEncoded := r.target.(EncodedAttachmentsTarget)
+
 			// Are They Big Enough?
-			if doc.Size() > MB10 {
+			if doc.Size() > r.job.BatchSizeBytesOrFallback() || (encoded && !inlineEncoded) {
 				// Update Document on Target
+				start := time.Now()
 				err := r.target.UploadDocumentWithAttachments(ctx, doc)
+				r.window.Upload += time.Since(start)
 				if err != nil {
 					r.currentHistory.DocWriteFailures++
-					return err
+					if r.job.SoftDocErrors && ctx.Err() == nil {
+						r.skipDocument(docID, revs, SkipWriteFailed, err)
+						return nil
+					}
+					return docErr(docID, err)
 				}
 				r.currentHistory.DocsWritten++
-				continue
+				r.stats.written(1, doc.Size(), time.Now())
+				r.tracker.done(docID)
+				r.detectConflicts(ctx, map[string]string{docID: doc.Rev()})
+				return nil
+			} else if encoded {
+				err := doc.InlineEncodedAttachments()
+				if err != nil {
+					return docErr(docID, err)
+				}
 			} else {
 				err := doc.InlineAttachments()
 				if err != nil {
-					return err
+					return docErr(docID, err)
 				}
 			}
 		}
@@ -290,58 +920,198 @@ func (r *Replicator) ReplicateChanges(ctx context.Context, lastSeq string) error
 		stack = append(stack, doc)
 
 		// Stack is Full?
-		if stack.Size() > MB10 {
+		if r.batchFull(stack) {
 			err := r.replicateChangesBulk(ctx, stack)
 			if err != nil {
 				return err
 			}
+			stack = nil
 		}
+
+		return nil
+	}
+	fetch := func(order []string) error {
+		return r.fetchDocuments(ctx, order, func(docID string, revs []string, doc *client.CompleteDoc, err error) error {
+			err = handle(docID, revs, doc, err)
+			if err != nil {
+				return err
+			}
+			r.updateStats(false)
+			if !checkpoints {
+				return nil
+			}
+			if r.stopRequested() {
+				return errStopped
+			}
+			return r.intermediateCheckpoint(ctx, &stack)
+		})
 	}
 
-	// stack too small but changes available? push rest
-	if len(stack) > 0 {
-		err := r.replicateChangesBulk(ctx, stack)
-		if err != nil {
-			return err
+	// the priority documents are written before the others are fetched
+	order, priority := r.prioritize(r.tracker.order())
+	var err error
+	if priority > 0 {
+		r.logger.Debugf("Replicating %d priority documents", priority)
+		err = fetch(order[:priority])
+		if err == nil && len(stack) > 0 {
+			err = r.replicateChangesBulk(ctx, stack)
+			stack = nil
 		}
 	}
+	if err == nil {
+		err = fetch(order[priority:])
+	}
+	if errors.Is(err, errStopped) {
+		return r.stopCheckpoint(ctx, stack)
+	}
 
-	r.currentHistory.SessionID = r.replicationID
-	r.currentHistory.EndLastSeq = lastSeq
-	r.currentHistory.EndTime = client.Time(time.Now())
+	// stack too small but changes available? push rest
+	if err == nil && len(stack) > 0 {
+		err = r.replicateChangesBulk(ctx, stack)
+	}
 
-	if r.currentHistory.DocsWritten > 0 {
-		err := r.recordReplicationCheckpoint(ctx, r.sourceRepLog, lastSeq)
-		if err != nil {
-			return err
+	// the written part of the batch isn't replicated again
+	if err != nil && checkpoints {
+		return r.partialCheckpoint(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// maxPurgeDocs is the default limit of document ids
+// per purge request of couchdb (purge_max_document_id_number)
+const maxPurgeDocs = 100
+
+// PropagatePurges applies the purged revisions of the source to the target.
+// Servers that don't expose _purged_infos are skipped with a warning.
+func (r *Replicator) PropagatePurges(ctx context.Context) error {
+	if r.target == nil {
+		return nil
+	}
+
+	info, err := r.source.Info(ctx)
+	if err != nil {
+		return err
+	}
+
+	// nothing was purged since the last propagation
+	if info.PurgeSeq == r.sourcePurgeSeq {
+		return nil
+	}
+
+	source, sok := r.source.(PurgeSource)
+	_, tok := r.target.(PurgeTarget)
+	if !sok || !tok {
+		r.logger.Warning("Peers don't support purges, purges are not propagated")
+		r.sourcePurgeSeq = info.PurgeSeq
+		return nil
+	}
+
+	// only the purges after the last propagated ones
+	infos, err := source.PurgedInfos(ctx, r.sourcePurgeSeq)
+	if errors.Is(err, client.ErrNotFound) {
+		r.logger.Warning("Source doesn't support _purged_infos, purges are not propagated")
+		r.sourcePurgeSeq = info.PurgeSeq
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	req := make(client.PurgeRequest)
+	for _, pi := range infos.PurgedInfos {
+		req[pi.ID] = append(req[pi.ID], pi.Revs...)
+
+		if len(req) == maxPurgeDocs {
+			err = r.purgeTarget(ctx, req)
+			if err != nil {
+				return err
+			}
+			req = make(client.PurgeRequest)
 		}
-		err = r.recordReplicationCheckpoint(ctx, r.targetRepLog, lastSeq)
+	}
+
+	if len(req) > 0 {
+		err = r.purgeTarget(ctx, req)
 		if err != nil {
 			return err
 		}
 	}
 
-	r.currentHistory = nil
+	r.sourcePurgeSeq = info.PurgeSeq
 
 	return nil
 }
 
-// Reset resets the replicator state at the source and target database
-func (r *Replicator) Reset(ctx context.Context) error {
-	id := r.buildReplicationID()
-
-	err := r.source.RemoveReplicationCheckpoint(ctx, id)
+func (r *Replicator) purgeTarget(ctx context.Context, req client.PurgeRequest) error {
+	purged, err := r.target.(PurgeTarget).Purge(ctx, req)
 	if err != nil {
 		return err
 	}
-	err = r.target.RemoveReplicationCheckpoint(ctx, id)
-	if err != nil {
-		return err
+
+	// revisions that are not on the target are ignored by the server
+	for docID, revs := range purged {
+		r.logger.Debugf("Purged %q revisions: %v", docID, revs)
 	}
 
 	return nil
 }
 
+// Reset resets the replicator state at the source and target database,
+// the replication logs are deleted and the next run replicates all
+// changes again. It must not be called while the replicator runs.
+func (r *Replicator) Reset(ctx context.Context) error {
+	r.buildReplicationID()
+	id := r.checkpointID()
+
+	for _, peer := range r.peers() {
+		remover, ok := peer.(CheckpointRemover)
+		if !ok {
+			return fmt.Errorf("%w: remove replication checkpoint", ErrNotSupported)
+		}
+
+		// CouchDB only deletes the log with its current revision
+		var rev string
+		if getter, ok := peer.(interface {
+			GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error)
+		}); ok {
+			repLog, err := getter.GetReplicationLog(ctx, id)
+			if err != nil && !errors.Is(err, client.ErrNotFound) {
+				return err
+			}
+			if repLog == nil {
+				continue
+			}
+			rev = repLog.Rev
+		}
+
+		err := remover.RemoveReplicationCheckpoint(ctx, id, rev)
+		if err != nil {
+			return err
+		}
+	}
+
+	// forget the checkpoint of previous runs
+	r.sourceRepLog, r.targetRepLog = nil, nil
+	r.checkpointSeq = ""
+	r.lastCheckpoint = time.Time{}
+	r.checkpointDocs = 0
+
+	return nil
+}
+
+// continuous returns true if the replication follows the changes feed
+// after all changes were replicated
+func (r *Replicator) continuous() bool {
+	if r.job.DryRun || r.job.Mode == ModeBackfill {
+		return false
+	}
+	return r.job.Continuous || r.job.Mode == ModeLive
+}
+
 func (r *Replicator) buildReplicationID() string {
 	if r.replicationID == "" {
 		id := r.job.GenerateReplicationID(r.name)
@@ -351,14 +1121,56 @@ func (r *Replicator) buildReplicationID() string {
 	return r.replicationID
 }
 
+// checkpointID returns the _local document id (without the _local/ prefix)
+// used to store the replication logs
+func (r *Replicator) checkpointID() string {
+	return r.job.CheckpointPrefix + r.replicationID
+}
+
 func (r *Replicator) replicateChangesBulk(ctx context.Context, stack client.Stack) error {
 	// Upload Stack of Documents to Target
-	err := r.target.BulkDocs(ctx, &stack)
+	start := time.Now()
+	var failures []client.BulkDocsResult
+	err := r.trace(ctx, "BulkDocs", func(ctx context.Context) error {
+		var err error
+		failures, err = r.target.BulkDocs(ctx, &stack)
+		return err
+	})
+	r.window.Upload += time.Since(start)
 	if err != nil {
 		r.currentHistory.DocWriteFailures += len(stack)
 		return err
 	}
-	r.currentHistory.DocsWritten += len(stack)
+
+	for _, doc := range stack {
+		r.tracker.done(doc.ID)
+	}
+
+	failures = r.writeProtectedDocs(ctx, stack, failures)
+
+	// Documents refused by the target don't abort the replication
+	failed := make(map[string]bool, len(failures))
+	for _, failure := range failures {
+		if failure.Error == "conflict" {
+			r.hooks.OnConflict(failure.ID, failure.Err())
+		}
+		r.skipDocument(failure.ID, nil, SkipWriteFailed, failure.Err())
+		failed[failure.ID] = true
+	}
+	r.currentHistory.DocWriteFailures += len(failures)
+	r.currentHistory.DocsWritten += len(stack) - len(failures)
+	r.stats.written(len(stack)-len(failures), stack.Size(), time.Now())
+	r.hooks.OnBatchUploaded(len(stack), len(failures))
+
+	if r.job.detectConflicts() {
+		written := make(map[string]string, len(stack))
+		for _, doc := range stack {
+			if !failed[doc.ID] {
+				written[doc.ID] = doc.Rev()
+			}
+		}
+		r.detectConflicts(ctx, written)
+	}
 
 	// Ensure in Commit
 	err = r.target.EnsureFullCommit(ctx)
@@ -369,15 +1181,34 @@ func (r *Replicator) replicateChangesBulk(ctx context.Context, stack client.Stac
 	return nil
 }
 
-func (r *Replicator) recordReplicationCheckpoint(ctx context.Context, repLog *client.ReplicationLog, lastSeq string) error {
-	repLog.ID = r.replicationID
+// maxHistory is the number of sessions kept in the replication log,
+// newest first
+const maxHistory = 50
+
+// replicationLogPeer stores replication logs, source and target
+type replicationLogPeer interface {
+	RecordReplicationCheckpoint(ctx context.Context, repLog *client.ReplicationLog, id string) (string, error)
+}
+
+func (r *Replicator) recordReplicationCheckpoint(ctx context.Context, peer replicationLogPeer, repLog *client.ReplicationLog, lastSeq string) error {
+	repLog.ID = client.LocalDocPrefix + r.checkpointID()
 	repLog.ReplicationIDVersion = 3
-	repLog.SessionID = r.replicationID
+	repLog.SessionID = r.sessionID
 	repLog.SourceLastSeq = lastSeq
-	repLog.History = append(r.targetRepLog.History, r.currentHistory)
+	repLog.SourceCapabilities = r.sourceCapabilities()
+	if len(repLog.History) > 0 && repLog.History[0].SessionID == r.currentHistory.SessionID {
+		// update the entry of the session
+		repLog.History[0] = r.currentHistory
+	} else {
+		repLog.History = append([]*client.History{r.currentHistory}, repLog.History...)
+	}
+	if len(repLog.History) > maxHistory {
+		repLog.History = repLog.History[:maxHistory]
+	}
 
-	// Record Replication Checkpoint
-	err := r.source.RecordReplicationCheckpoint(ctx, repLog, r.replicationID)
+	// Record Replication Checkpoint, the client updates the
+	// revision of the log for the next checkpoint
+	_, err := peer.RecordReplicationCheckpoint(ctx, repLog, r.checkpointID())
 	if err != nil {
 		return err
 	}
@@ -386,33 +1217,3 @@ func (r *Replicator) recordReplicationCheckpoint(ctx context.Context, repLog *cl
 }
 
 const NoVersion = "0"
-
-// 2.4.2.3.3. Compare Replication Logs
-func (r *Replicator) CompareReplicationLogs(ctx context.Context, source, target *client.ReplicationLog) error {
-	// 	If the Replication Logs are successfully retrieved from both Source and Target then the Replicator MUST determine their common ancestry by following the next algorithm:
-	if source == nil || target == nil {
-		r.sourceLastSeq = NoVersion
-		return nil
-	}
-
-	//     Compare session_id values for the chronological last session - if they match both Source and Target have a common Replication history and it seems to be valid. Use 	source_last_seq value for the startup Checkpoint
-	if source.SessionID == target.SessionID && source.SourceLastSeq != "" {
-		r.sourceLastSeq = source.SourceLastSeq
-		return nil
-	}
-
-	//     In case of mismatch, iterate over the history collection to search for the latest (chronologically) common session_id for Source and Target. Use value of recorded_seq field as startup Checkpoint
-	for _, sl := range source.History {
-		for _, tl := range target.History {
-			if sl.SessionID == tl.SessionID {
-				r.sourceLastSeq = sl.RecordedSeq
-				return nil
-			}
-		}
-	}
-
-	// If Source and Target has no common ancestry, the Replicator MUST run Full Replication.
-	r.sourceLastSeq = NoVersion
-
-	return nil
-}
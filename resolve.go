@@ -0,0 +1,141 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConflictedDoc is a document with conflicting revisions on the target
+type ConflictedDoc struct {
+	ID        string
+	SourceRev string   // revision written by the replication
+	Revs      []string // leaf revisions, the current winner first
+
+	reader DocReader
+}
+
+// Read reads the revision of the document from the target
+func (d *ConflictedDoc) Read(ctx context.Context, rev string) (map[string]interface{}, error) {
+	if d.reader == nil {
+		return nil, ErrNotSupported
+	}
+	var data map[string]interface{}
+	err := d.reader.GetDoc(ctx, d.ID, rev, &data)
+	return data, err
+}
+
+// Resolver picks the winning revision of a conflicted document, the
+// other revisions are deleted on the target
+type Resolver interface {
+	Resolve(ctx context.Context, doc *ConflictedDoc) (string, error)
+}
+
+// ResolverFunc implements Resolver with a function
+type ResolverFunc func(ctx context.Context, doc *ConflictedDoc) (string, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, doc *ConflictedDoc) (string, error) {
+	return f(ctx, doc)
+}
+
+var (
+	// SourceWins keeps the revision written by the replication
+	SourceWins Resolver = ResolverFunc(func(ctx context.Context, doc *ConflictedDoc) (string, error) {
+		return doc.SourceRev, nil
+	})
+
+	// TargetWins keeps the revision the target had before
+	TargetWins Resolver = ResolverFunc(func(ctx context.Context, doc *ConflictedDoc) (string, error) {
+		for _, rev := range doc.Revs {
+			if rev != doc.SourceRev {
+				return rev, nil
+			}
+		}
+		return doc.SourceRev, nil
+	})
+)
+
+// LatestWins keeps the revision with the latest value of the Field, a
+// RFC 3339 timestamp or a number. Without Field the revision with the
+// highest generation (revpos) wins. Ties are won by the current winner.
+type LatestWins struct {
+	Field string
+}
+
+func (l LatestWins) Resolve(ctx context.Context, doc *ConflictedDoc) (string, error) {
+	var (
+		winner string
+		latest float64
+	)
+	for _, rev := range doc.Revs {
+		var value float64
+		if l.Field == "" {
+			gen, _ := strconv.Atoi(strings.SplitN(rev, "-", 2)[0])
+			value = float64(gen)
+		} else {
+			data, err := doc.Read(ctx, rev)
+			if err != nil {
+				return "", err
+			}
+			value = sortValue(data[l.Field])
+		}
+
+		if winner == "" || value > latest {
+			winner, latest = rev, value
+		}
+	}
+	return winner, nil
+}
+
+// sortValue converts timestamps and numbers into comparable values,
+// other values are older than all of them
+func sortValue(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err == nil {
+			return float64(t.UnixNano())
+		}
+	}
+	return -1 << 63
+}
+
+// resolveConflict applies the resolver to the conflicted document,
+// the losing revisions are deleted on the target
+func (r *Replicator) resolveConflict(ctx context.Context, doc *ConflictedDoc) error {
+	deleter, ok := r.target.(DocDeleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	doc.reader, _ = r.target.(DocReader)
+
+	winner, err := r.job.ConflictResolver.Resolve(ctx, doc)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, rev := range doc.Revs {
+		found = found || rev == winner
+	}
+	if !found {
+		return fmt.Errorf("resolver picked %q, not a revision of the document", winner)
+	}
+
+	for _, rev := range doc.Revs {
+		if rev == winner {
+			continue
+		}
+		err = deleter.DeleteDoc(ctx, doc.ID, rev)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.logger.Infof("Resolved conflict of document %q, revision %q wins", doc.ID, winner)
+	r.result.ConflictsResolved++
+	return nil
+}
@@ -0,0 +1,178 @@
+package replicator
+
+import (
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Result summarizes a replication run
+type Result struct {
+	// Ancestry explains where the replication started
+	Ancestry Ancestry
+	// Timing shows where the replication spends its time
+	Timing Timing
+	// Stopped is set if the replication was stopped using Stop or Cancel
+	Stopped bool
+	// Checkpoint is the status of the checkpoints
+	Checkpoint CheckpointStatus
+
+	// DocsSkipped number of documents that were not replicated
+	DocsSkipped int
+	// Skipped documents that were not replicated and why
+	Skipped []SkippedDoc
+	// AttachmentsSkipped number of attachments removed from the
+	// replicated documents as they exceeded the MaxAttachmentSize
+	AttachmentsSkipped int
+	// AttachmentsFiltered number of attachments removed from the
+	// replicated documents by the AttachmentFilter
+	AttachmentsFiltered int
+	// SeqRange is the range of sequences of the run, nil unless
+	// Config.SeqRangeSize is set. Completed is zero if the run
+	// returned before the end of the range.
+	SeqRange *client.SeqRange
+
+	// WindowsShrunk number of windows retried with fewer changes
+	// after a timeout, see Config.AdaptiveWindow
+	WindowsShrunk int
+	// ChangesCollapsed number of change rows skipped as a later row
+	// of the same window lists the current revisions of the document
+	ChangesCollapsed int
+
+	// ConflictsFound number of documents that have conflicting
+	// revisions on the target after they were written, see
+	// Config.DetectConflicts
+	ConflictsFound int
+	// Conflicts the first maxReportedConflicts of the documents with
+	// conflicting revisions
+	Conflicts []client.DocConflicts
+	// ConflictsResolved number of conflicts resolved by the
+	// Config.ConflictResolver
+	ConflictsResolved int
+
+	// ProtectedDocsWritten number of documents refused by the target
+	// and written as the UserCtx, see Job.ProtectedDocs
+	ProtectedDocsWritten int
+
+	// DocsMissing number of documents that would be transferred (dry run)
+	DocsMissing int
+	// EstimatedBytes of the documents that would be transferred (dry run)
+	EstimatedBytes int64
+}
+
+// CheckpointStatus is the status of the checkpoints of a replication
+type CheckpointStatus struct {
+	Seq      string    // sequence of the last recorded checkpoint
+	Time     time.Time // time of the last recorded checkpoint
+	Failures int       // failed checkpoint writes
+	Err      error     // error of the last write, nil once a checkpoint was recorded again
+}
+
+// SkipReason explains why a document was not replicated
+type SkipReason string
+
+const (
+	// SkipNotFound the document was removed from the source
+	// after the change was read from the changes feed
+	SkipNotFound SkipReason = "not found on source"
+	// SkipFiltered the document didn't match the local filter
+	SkipFiltered SkipReason = "filtered"
+	// SkipWriteFailed the target refused to store the document
+	SkipWriteFailed SkipReason = "write failed"
+	// SkipFetchFailed the document couldn't be read from the source
+	SkipFetchFailed SkipReason = "fetch failed"
+	// SkipAttachmentTooLarge an attachment exceeded the MaxAttachmentSize
+	SkipAttachmentTooLarge SkipReason = "attachment too large"
+)
+
+// SkippedDoc is a document that was not replicated
+type SkippedDoc struct {
+	ID     string
+	Seq    string // sequence of the change, empty if unknown
+	Revs   []string
+	Reason SkipReason
+	Err    error
+}
+
+// Timing contains the durations of the replication phases
+type Timing struct {
+	VerifyPeers         time.Duration
+	GetPeersInformation time.Duration
+	FindCommonAncestry  time.Duration
+
+	// Totals of all changes windows
+	Changes    time.Duration
+	RevsDiff   time.Duration
+	Fetch      time.Duration
+	Upload     time.Duration
+	Checkpoint time.Duration
+
+	// Windows contains the timing of the last changes windows
+	Windows []WindowTiming
+}
+
+// WindowTiming contains the durations of the phases of one
+// batch of changes
+type WindowTiming struct {
+	StartSeq string
+	EndSeq   string
+
+	Changes    time.Duration // reading the changes feed
+	RevsDiff   time.Duration // comparing the revisions with the target
+	Fetch      time.Duration // fetching the documents from the source
+	Upload     time.Duration // writing the documents to the target or sink
+	Checkpoint time.Duration // recording the checkpoints
+}
+
+// maxTimingWindows limits the windows kept for continuous replications
+const maxTimingWindows = 100
+
+func (t *Timing) addWindow(w WindowTiming) {
+	t.Changes += w.Changes
+	t.RevsDiff += w.RevsDiff
+	t.Fetch += w.Fetch
+	t.Upload += w.Upload
+	t.Checkpoint += w.Checkpoint
+
+	t.Windows = append(t.Windows, w)
+	if len(t.Windows) > maxTimingWindows {
+		t.Windows = append([]WindowTiming(nil), t.Windows[len(t.Windows)-maxTimingWindows:]...)
+	}
+}
+
+func (r *Result) copy() Result {
+	c := *r
+	c.Skipped = append([]SkippedDoc(nil), r.Skipped...)
+	c.Conflicts = append([]client.DocConflicts(nil), r.Conflicts...)
+	c.Timing.Windows = append([]WindowTiming(nil), r.Timing.Windows...)
+	if r.SeqRange != nil {
+		seqRange := *r.SeqRange
+		c.SeqRange = &seqRange
+	}
+	return c
+}
+
+// skipDocument records and reports the document as skipped
+func (r *Replicator) skipDocument(docID string, revs []string, reason SkipReason, err error) {
+	if err != nil {
+		r.logger.Warningf("Skipped document %q revs %v: %s (%v)", docID, revs, reason, err)
+	} else {
+		r.logger.Warningf("Skipped document %q revs %v: %s", docID, revs, reason)
+	}
+
+	r.tracker.done(docID)
+	r.sampler.remove(docID)
+
+	doc := SkippedDoc{
+		ID:     docID,
+		Seq:    r.tracker.seqOf(docID),
+		Revs:   revs,
+		Reason: reason,
+		Err:    err,
+	}
+	r.result.DocsSkipped++
+	r.result.Skipped = append(r.result.Skipped, doc)
+	if err != nil {
+		r.hooks.OnDocumentError(doc)
+	}
+}
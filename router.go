@@ -0,0 +1,223 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/logger"
+)
+
+var ErrUnknownRoute = errors.New("unknown route target")
+
+// RouteFunc returns the name of the target the document is replicated to,
+// an empty name skips the document
+type RouteFunc func(doc *client.CompleteDoc) string
+
+// Router splits one source across multiple target databases, e.g. to shard
+// a monolithic database by tenant. The changes of the source are read once,
+// every target receives its own bulk batches and checkpoints.
+type Router struct {
+	name    string
+	source  *client.Remote
+	targets map[string]*client.Remote
+	route   RouteFunc
+
+	// CreateTargets creates missing target databases
+	CreateTargets bool
+	// Config of the replication of the source
+	Config Config
+
+	logger logger.Logger
+	r      *Replicator // of the last run
+}
+
+func NewRouter(name string, source *client.Remote, targets map[string]*client.Remote, route RouteFunc) *Router {
+	return &Router{
+		name:    name,
+		source:  source,
+		targets: targets,
+		route:   route,
+		logger:  new(logger.Noop),
+	}
+}
+
+func (rt *Router) SetLogger(logger logger.Logger) {
+	rt.logger = logger
+}
+
+// Run replicates the source into the targets, the checkpoint of the
+// source is recorded after all targets received the batch
+func (rt *Router) Run(ctx context.Context) error {
+	sink := &routingSink{
+		config:  rt.Config,
+		route:   rt.route,
+		targets: make(map[string]*routeTarget, len(rt.targets)),
+		logger:  rt.logger,
+	}
+
+	for name, remote := range rt.targets {
+		c, err := client.NewClient(remote)
+		if err != nil {
+			return err
+		}
+		c.SetLogger(rt.logger)
+		c.SetRetryPolicy(rt.Config.Retry)
+		c.SetTimeouts(rt.Config.Timeouts)
+		c.SetResponseLimits(rt.Config.ResponseLimits)
+		c.SetSlowRequestThreshold(rt.Config.SlowRequestThreshold)
+
+		err = c.Check(ctx)
+		if errors.Is(err, client.ErrNotFound) && rt.CreateTargets {
+			err = c.Create(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("target %q: %w", name, err)
+		}
+
+		sink.targets[name] = &routeTarget{name: name, client: c}
+	}
+
+	job := &Job{
+		Source: rt.source,
+		Config: rt.Config,
+	}
+	job.Sink = sink
+
+	r, err := NewReplicator(rt.name, job)
+	if err != nil {
+		return err
+	}
+	r.SetLogger(rt.logger)
+	sink.r = r
+	rt.r = r
+
+	return r.Run(ctx)
+}
+
+// Result returns the result of the last (or currently running) run
+func (rt *Router) Result() Result {
+	if rt.r == nil {
+		return Result{}
+	}
+	return rt.r.Result()
+}
+
+type routeTarget struct {
+	name   string
+	client *client.Client
+	stack  client.Stack
+	repLog *client.ReplicationLog
+}
+
+// routingSink distributes the documents to the targets
+type routingSink struct {
+	config  Config
+	route   RouteFunc
+	targets map[string]*routeTarget
+	logger  logger.Logger
+	r       *Replicator // records the checkpoints of the targets
+}
+
+var _ SinkLogReader = (*routingSink)(nil)
+
+// GetReplicationLog reads the replication logs of the targets, the log
+// of the target with the oldest checkpoint is returned. Every target
+// resumes at or before its own checkpoint.
+func (s *routingSink) GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error) {
+	var oldest *client.ReplicationLog
+	missing := false
+	for _, t := range s.targets {
+		repLog, err := t.client.GetReplicationLog(ctx, id)
+		if errors.Is(err, client.ErrNotFound) {
+			repLog, err = new(client.ReplicationLog), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("target %q: %w", t.name, err)
+		}
+		t.repLog = repLog
+
+		if len(repLog.History) == 0 {
+			missing = true
+		} else if oldest == nil || repLog.History[0].EndTime.Before(oldest.History[0].EndTime) {
+			oldest = repLog
+		}
+	}
+
+	// targets without checkpoint are replicated from the beginning
+	if missing || oldest == nil {
+		return nil, client.ErrNotFound
+	}
+	return oldest, nil
+}
+
+func (s *routingSink) Receive(ctx context.Context, doc *client.CompleteDoc) error {
+	name := s.route(doc)
+	if name == "" {
+		s.logger.Debugf("Document %q not routed", doc.ID)
+		return nil
+	}
+
+	t, ok := s.targets[name]
+	if !ok {
+		return fmt.Errorf("%w: %q for document %q", ErrUnknownRoute, name, doc.ID)
+	}
+
+	// big attachments are uploaded directly
+	if doc.HasChangedAttachments() {
+		if doc.Size() > s.config.BatchSizeBytesOrFallback() {
+			return t.client.UploadDocumentWithAttachments(ctx, doc)
+		}
+
+		err := doc.InlineAttachments()
+		if err != nil {
+			return err
+		}
+	}
+
+	t.stack = append(t.stack, doc)
+	if s.config.batchFull(t.stack) {
+		return s.flush(ctx, t)
+	}
+
+	return nil
+}
+
+func (s *routingSink) flush(ctx context.Context, t *routeTarget) error {
+	if len(t.stack) == 0 {
+		return nil
+	}
+
+	failures, err := t.client.BulkDocs(ctx, &t.stack)
+	if err != nil {
+		return fmt.Errorf("target %q: %w", t.name, err)
+	}
+	for _, failure := range failures {
+		s.r.skipDocument(failure.ID, nil, SkipWriteFailed, fmt.Errorf("target %q: %w", t.name, failure.Err()))
+	}
+	t.stack = nil
+
+	return t.client.EnsureFullCommit(ctx)
+}
+
+// Checkpoint uploads the remaining batches and records
+// the checkpoint on every target
+func (s *routingSink) Checkpoint(ctx context.Context, lastSeq string) error {
+	for _, t := range s.targets {
+		err := s.flush(ctx, t)
+		if err != nil {
+			return err
+		}
+
+		if t.repLog == nil {
+			t.repLog = new(client.ReplicationLog)
+		}
+		err = s.r.recordReplicationCheckpoint(ctx, t.client, t.repLog, lastSeq)
+		if err != nil {
+			return fmt.Errorf("target %q: %w", t.name, err)
+		}
+	}
+
+	return nil
+}
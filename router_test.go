@@ -0,0 +1,49 @@
+package replicator_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/memdb"
+	"github.com/goydb/replicator/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	source := memdb.New("source")
+	_, err := source.Put("t1:a", map[string]interface{}{})
+	assert.NoError(t, err)
+	_, err = source.Put("t2:b", map[string]interface{}{})
+	assert.NoError(t, err)
+	srv := httptest.NewServer(server.NewSourceHandler(source))
+	defer srv.Close()
+
+	dbs := map[string]*memdb.DB{"t1": memdb.New("t1"), "t2": memdb.New("t2")}
+	targets := make(map[string]*client.Remote)
+	for name, db := range dbs {
+		ts := httptest.NewServer(server.NewTargetHandler(db))
+		defer ts.Close()
+		targets[name] = &client.Remote{URL: ts.URL + "/"}
+	}
+
+	rt := replicator.NewRouter("router", &client.Remote{URL: srv.URL + "/"}, targets, func(doc *client.CompleteDoc) string {
+		return strings.SplitN(doc.ID, ":", 2)[0]
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, rt.Run(ctx))
+	_, err = dbs["t1"].Get("t1:a")
+	assert.NoError(t, err)
+	_, err = dbs["t2"].Get("t2:b")
+	assert.NoError(t, err)
+
+	// the restart resumes from the checkpoints of the targets
+	assert.NoError(t, rt.Run(ctx))
+	assert.Equal(t, replicator.AncestrySessionMatch, rt.Result().Ancestry.Reason)
+}
@@ -0,0 +1,579 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goydb/replicator/logger"
+)
+
+// ErrJobRunning is returned if a running job is reset
+var ErrJobRunning = errors.New("job is running")
+
+// JobState is the state of a job in the scheduler
+type JobState string
+
+const (
+	// JobPending the job waits for a free slot
+	JobPending JobState = "pending"
+	// JobRunning the replication of the job is running
+	JobRunning JobState = "running"
+	// JobPaused the job was paused and isn't scheduled until resumed
+	JobPaused JobState = "paused"
+	// JobCrashing the replication failed, it is restarted after a backoff
+	JobCrashing JobState = "crashing"
+	// JobCompleted the one-shot replication completed
+	JobCompleted JobState = "completed"
+)
+
+// JobStatus is the status of a job in the scheduler
+type JobStatus struct {
+	ID       string
+	State    JobState
+	Err      error     // error of the last failed run
+	Failures int       // consecutive failures
+	Started  time.Time // start of the last run
+	Retry    time.Time // next start of a crashing job
+	Result   Result    // result of the last run
+}
+
+// Scheduler runs a set of jobs, similar to the _replicator scheduler of
+// CouchDB: at most MaxJobs replications run concurrently, continuous
+// jobs are rotated round-robin if other jobs are waiting and failed
+// replications are restarted with exponential backoff.
+type Scheduler struct {
+	name string
+
+	// MaxJobs limits the concurrently running replications, 0 is unlimited
+	MaxJobs int
+	// Interval is the time a continuous job runs before it is stopped
+	// for a waiting job, defaults to 1 minute
+	Interval time.Duration
+	// MinBackoff and MaxBackoff limit the wait before a failed job is
+	// restarted, default to 5s and 10 minutes
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mu     sync.Mutex
+	jobs   map[string]*scheduledJob
+	wakeup chan struct{}
+
+	store  JobStore
+	locker Locker
+	logger logger.Logger
+}
+
+type scheduledJob struct {
+	job    *Job
+	status JobStatus
+	stats  Stats // stats of the last replication
+
+	r         jobRunner
+	stop      bool // the running replication is stopped
+	removed   bool
+	resetting bool     // not started until the reset finished
+	persisted JobState // state recorded in the store
+}
+
+// jobRunner runs the replication of a job, a Replicator
+// or a Bidirectional pair of replicators
+type jobRunner interface {
+	Run(ctx context.Context) error
+	Reset(ctx context.Context) error
+	Cancel()
+	Result() Result
+	Stats() Stats
+	SetLogger(logger logger.Logger)
+	continuous() bool
+}
+
+// SetLocker sets the locker used by jobs without one, schedulers of
+// multiple instances sharing a job store run every job on one instance
+// at a time. A job locked by another instance is retried like a failed
+// one, together with MaxJobs the instances share the jobs, e.g. the
+// shards of SplitJob.
+func (s *Scheduler) SetLocker(locker Locker) {
+	s.locker = locker
+}
+
+// newJobRunner creates the replication of the job
+func (s *Scheduler) newJobRunner(job *Job) (jobRunner, error) {
+	if job.Locker == nil && s.locker != nil {
+		// a copy, the locker isn't part of the stored job
+		locked := *job
+		locked.Locker = s.locker
+		job = &locked
+	}
+	if job.Bidirectional {
+		return NewBidirectional(s.name, job)
+	}
+	return NewReplicator(s.name, job)
+}
+
+// NewScheduler creates a scheduler, the name is used
+// to generate the replication ids
+func NewScheduler(name string) *Scheduler {
+	return &Scheduler{
+		name:   name,
+		jobs:   make(map[string]*scheduledJob),
+		wakeup: make(chan struct{}, 1),
+		logger: new(logger.Noop),
+	}
+}
+
+func (s *Scheduler) SetLogger(logger logger.Logger) {
+	s.logger = logger
+}
+
+// SetStore sets the store the jobs are loaded from (see Load), the
+// state of the jobs is recorded in the store while the scheduler runs
+func (s *Scheduler) SetStore(store JobStore) {
+	s.store = store
+}
+
+func (s *Scheduler) intervalOrFallback() time.Duration {
+	if s.Interval <= 0 {
+		return time.Minute
+	}
+	return s.Interval
+}
+
+// backoff returns the wait before the restart after the failures
+func (s *Scheduler) backoff(failures int) time.Duration {
+	minWait, maxWait := s.MinBackoff, s.MaxBackoff
+	if minWait <= 0 {
+		minWait = 5 * time.Second
+	}
+	if maxWait <= 0 {
+		maxWait = 10 * time.Minute
+	}
+
+	wait := minWait
+	for i := 1; i < failures && wait < maxWait; i++ {
+		wait *= 2
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	return wait
+}
+
+// notify wakes up the scheduling loop
+func (s *Scheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Add adds the job to the scheduler, it is started by Run
+func (s *Scheduler) Add(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; ok {
+		return fmt.Errorf("%w: %q", ErrJobExists, job.ID)
+	}
+	s.jobs[job.ID] = &scheduledJob{
+		job:    job,
+		status: JobStatus{ID: job.ID, State: JobPending},
+	}
+	s.notify()
+
+	return nil
+}
+
+// Load adds the jobs of the store, completed jobs
+// are not started again
+func (s *Scheduler) Load(ctx context.Context) error {
+	if s.store == nil {
+		return ErrNoStore
+	}
+	jobs, err := s.store.Jobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range jobs {
+		if _, ok := s.jobs[stored.Job.ID]; ok {
+			continue
+		}
+		state := JobPending
+		if stored.State.State == JobCompleted {
+			state = JobCompleted
+		}
+		s.jobs[stored.Job.ID] = &scheduledJob{
+			job:       stored.Job,
+			status:    JobStatus{ID: stored.Job.ID, State: state},
+			persisted: stored.State.State,
+		}
+	}
+	s.notify()
+
+	return nil
+}
+
+// Create stores the job in the store and adds it to the scheduler
+func (s *Scheduler) Create(ctx context.Context, job *Job) error {
+	if s.store == nil {
+		return ErrNoStore
+	}
+	if _, ok := s.Status(job.ID); ok {
+		return fmt.Errorf("%w: %q", ErrJobExists, job.ID)
+	}
+	err := s.store.PutJob(ctx, job)
+	if err != nil {
+		return err
+	}
+	return s.Add(job)
+}
+
+// Delete removes the job from the scheduler and the store
+func (s *Scheduler) Delete(ctx context.Context, id string) error {
+	if s.store == nil {
+		return ErrNoStore
+	}
+	s.mu.Lock()
+	sj, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	}
+
+	err := s.store.DeleteJob(ctx, sj.job)
+	if err != nil {
+		return err
+	}
+	return s.Remove(id)
+}
+
+// Remove stops the replication of the job and removes it
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sj, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	}
+	sj.removed = true
+	if sj.r != nil {
+		sj.stop = true
+	} else {
+		delete(s.jobs, id)
+	}
+	s.notify()
+
+	return nil
+}
+
+// Pause stops the replication of the job, it isn't
+// scheduled again until it is resumed
+func (s *Scheduler) Pause(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sj, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	}
+	sj.status.State = JobPaused
+	if sj.r != nil {
+		sj.stop = true
+	}
+	s.notify()
+
+	return nil
+}
+
+// Resume schedules the paused job again
+func (s *Scheduler) Resume(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sj, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	}
+	if sj.status.State == JobPaused {
+		sj.status.State = JobPending
+		sj.status.Failures = 0
+	}
+	s.notify()
+
+	return nil
+}
+
+// Reset removes the replication logs of the job from the source and
+// target, the next run replicates all changes again. The status of a
+// completed or crashing job is reset to pending, running jobs have to
+// be paused first.
+func (s *Scheduler) Reset(ctx context.Context, id string) error {
+	s.mu.Lock()
+	sj, ok := s.jobs[id]
+	switch {
+	case !ok:
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	case sj.r != nil || sj.resetting:
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %q", ErrJobRunning, id)
+	}
+	sj.resetting = true
+	s.mu.Unlock()
+
+	r, err := s.newJobRunner(sj.job)
+	if err == nil {
+		r.SetLogger(s.logger.With("job", sj.job.ID))
+		err = r.Reset(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.notify()
+
+	sj.resetting = false
+	if err != nil {
+		return err
+	}
+	sj.status.Result = Result{}
+	sj.status.Err = nil
+	sj.status.Failures = 0
+	sj.stats = Stats{}
+	if sj.status.State == JobCompleted || sj.status.State == JobCrashing {
+		sj.status.State = JobPending
+	}
+	return nil
+}
+
+// Status returns the status of the job
+func (s *Scheduler) Status(id string) (JobStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sj, ok := s.jobs[id]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return sj.status, true
+}
+
+// Statuses returns the status of all jobs ordered by their id
+func (s *Scheduler) Statuses() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, sj := range s.jobs {
+		statuses = append(statuses, sj.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+// schedulerTick is the interval the scheduler checks the jobs
+const schedulerTick = time.Second
+
+// Run schedules the jobs until the context is canceled,
+// the running replications are stopped gracefully
+func (s *Scheduler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	for {
+		s.schedule(ctx, &wg, time.Now())
+		s.persist(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for _, sj := range s.jobs {
+				if sj.r != nil {
+					sj.r.Cancel()
+				}
+			}
+			s.mu.Unlock()
+			wg.Wait()
+			return ctx.Err()
+		case <-s.wakeup:
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule stops and starts the replications
+func (s *Scheduler) schedule(ctx context.Context, wg *sync.WaitGroup, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var running int
+	var waiting []*scheduledJob
+	for _, sj := range s.jobs {
+		switch {
+		case sj.resetting:
+		case sj.r != nil:
+			running++
+			// repeated as the replication might not have been started
+			if sj.stop {
+				sj.r.Cancel()
+			}
+		case sj.status.State == JobPending,
+			sj.status.State == JobCrashing && !now.Before(sj.status.Retry):
+			waiting = append(waiting, sj)
+		}
+	}
+
+	// least recently started first
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].status.Started.Before(waiting[j].status.Started)
+	})
+
+	// free slots for waiting jobs by rotating continuous jobs
+	if s.MaxJobs > 0 && running >= s.MaxJobs && len(waiting) > 0 {
+		s.rotate(len(waiting), now)
+	}
+
+	for _, sj := range waiting {
+		if s.MaxJobs > 0 && running >= s.MaxJobs {
+			break
+		}
+		err := s.start(ctx, wg, sj, now)
+		if err != nil {
+			s.failed(sj, err, now)
+			continue
+		}
+		running++
+	}
+}
+
+// rotate stops up to n continuous jobs that ran longer than the interval
+func (s *Scheduler) rotate(n int, now time.Time) {
+	var candidates []*scheduledJob
+	for _, sj := range s.jobs {
+		if sj.r != nil && !sj.stop && sj.r.continuous() &&
+			now.Sub(sj.status.Started) >= s.intervalOrFallback() {
+			candidates = append(candidates, sj)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].status.Started.Before(candidates[j].status.Started)
+	})
+
+	for i, sj := range candidates {
+		if i >= n {
+			break
+		}
+		s.logger.Infof("Rotating job %q", sj.job.ID)
+		sj.stop = true
+		sj.r.Cancel()
+	}
+}
+
+// start runs the replication of the job in a goroutine
+func (s *Scheduler) start(ctx context.Context, wg *sync.WaitGroup, sj *scheduledJob, now time.Time) error {
+	r, err := s.newJobRunner(sj.job)
+	if err != nil {
+		return err
+	}
+	r.SetLogger(s.logger.With("job", sj.job.ID))
+
+	sj.r = r
+	sj.stop = false
+	sj.status.State = JobRunning
+	sj.status.Started = now
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := r.Run(ctx)
+		s.finished(sj, err)
+	}()
+
+	return nil
+}
+
+// finished updates the job after the replication returned
+func (s *Scheduler) finished(sj *scheduledJob, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.notify()
+
+	sj.status.Result = sj.r.Result()
+	sj.stats = sj.r.Stats()
+	stopped := sj.stop || sj.status.Result.Stopped
+	sj.r = nil
+	sj.stop = false
+
+	switch {
+	case sj.removed:
+		delete(s.jobs, sj.job.ID)
+	case sj.status.State == JobPaused:
+	case err != nil:
+		s.failed(sj, err, time.Now())
+	case stopped:
+		// rotated, scheduled again
+		sj.status.State = JobPending
+	default:
+		sj.status.State = JobCompleted
+		sj.status.Failures = 0
+		sj.status.Err = nil
+	}
+}
+
+// failed schedules the restart of the job
+func (s *Scheduler) failed(sj *scheduledJob, err error, now time.Time) {
+	sj.status.Failures++
+	sj.status.Err = err
+	sj.status.State = JobCrashing
+	sj.status.Retry = now.Add(s.backoff(sj.status.Failures))
+	s.logger.Warningf("Job %q failed (%d times), restart at %s: %v",
+		sj.job.ID, sj.status.Failures, sj.status.Retry.Format(time.RFC3339), err)
+}
+
+// persist records the changed states of the jobs in the store
+func (s *Scheduler) persist(ctx context.Context) {
+	if s.store == nil {
+		return
+	}
+
+	type update struct {
+		sj    *scheduledJob
+		state ReplicationState
+	}
+	var updates []update
+	now := time.Now()
+	s.mu.Lock()
+	for _, sj := range s.jobs {
+		if sj.removed || sj.status.State == sj.persisted {
+			continue
+		}
+		stats := sj.stats
+		if sj.r != nil {
+			stats = sj.r.Stats()
+		}
+		updates = append(updates, update{sj, newReplicationState(sj.status, stats, now)})
+	}
+	s.mu.Unlock()
+
+	// the store is accessed without holding the lock
+	for _, u := range updates {
+		err := s.store.UpdateState(ctx, u.sj.job.ID, u.state)
+		if err != nil {
+			s.logger.Warningf("Recording the state of job %q failed: %v", u.sj.job.ID, err)
+			continue
+		}
+		s.mu.Lock()
+		u.sj.persisted = u.state.State
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,102 @@
+package replicator_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	var running, maxRunning int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/db/")
+		switch {
+		case path == "" && r.Method == http.MethodHead:
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+		case path == "":
+			fmt.Fprint(w, `{"db_name":"db","update_seq":"0"}`)
+		case path == "_changes":
+			running--
+			fmt.Fprint(w, `{"results":[],"last_seq":"0"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		}
+	}))
+	defer srv.Close()
+
+	s := replicator.NewScheduler("test")
+	s.MaxJobs = 1
+	s.MinBackoff = time.Hour
+	for _, id := range []string{"a", "b"} {
+		job := &replicator.Job{ID: id, Source: &client.Remote{URL: srv.URL + "/db/"}}
+		job.Sink = replicator.SinkFunc(func(ctx context.Context, doc *client.CompleteDoc) error {
+			return nil
+		})
+		assert.NoError(t, s.Add(job))
+	}
+	failing := &replicator.Job{ID: "c", Source: &client.Remote{URL: srv.URL + "/db/"}}
+	assert.NoError(t, s.Add(failing))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go s.Run(ctx) // nolint: errcheck
+
+	for ctx.Err() == nil {
+		statuses := s.Statuses()
+		if statuses[0].State == replicator.JobCompleted && statuses[1].State == replicator.JobCompleted {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	statuses := s.Statuses()
+	assert.Equal(t, replicator.JobCompleted, statuses[0].State)
+	assert.Equal(t, replicator.JobCompleted, statuses[1].State)
+	assert.Equal(t, replicator.JobCrashing, statuses[2].State)
+	assert.ErrorIs(t, statuses[2].Err, replicator.ErrNoTarget)
+	assert.Equal(t, 1, maxRunning)
+}
+
+func TestSchedulerReset(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/db/")
+		switch {
+		case strings.HasPrefix(path, "_local/") && r.Method == http.MethodGet:
+			fmt.Fprintf(w, `{"_id":%q,"_rev":"0-3","history":[]}`, path)
+		case strings.HasPrefix(path, "_local/") && r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Query().Get("rev"))
+			fmt.Fprint(w, `{"ok":true}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		}
+	}))
+	defer srv.Close()
+
+	s := replicator.NewScheduler("test")
+	job := &replicator.Job{ID: "a", Source: &client.Remote{URL: srv.URL + "/db/"}}
+	job.Sink = replicator.SinkFunc(func(ctx context.Context, doc *client.CompleteDoc) error {
+		return nil
+	})
+	assert.NoError(t, s.Add(job))
+
+	assert.NoError(t, s.Reset(context.Background(), "a"))
+	assert.Equal(t, []string{"0-3"}, deleted)
+	status, _ := s.Status("a")
+	assert.Equal(t, replicator.JobPending, status.State)
+
+	assert.ErrorIs(t, s.Reset(context.Background(), "b"), replicator.ErrJobNotFound)
+}
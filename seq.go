@@ -0,0 +1,38 @@
+package replicator
+
+import (
+	"strconv"
+	"strings"
+)
+
+// seqNumber returns the numeric part of an update sequence. Sequences of
+// clustered servers have the form "<number>-<opaque>", where the number is
+// the sum of the shard sequences and grows with every change.
+func seqNumber(seq string) (int64, bool) {
+	if i := strings.IndexByte(seq, '-'); i >= 0 {
+		seq = seq[:i]
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// seqReached returns true if the sequence is at or past the target
+// sequence, sequences without a numeric part are compared for equality
+func seqReached(seq, target string) bool {
+	if seq == target {
+		return true
+	}
+
+	n, ok := seqNumber(seq)
+	if !ok {
+		return false
+	}
+	t, ok := seqNumber(target)
+	if !ok {
+		return false
+	}
+	return n >= t
+}
@@ -0,0 +1,72 @@
+package replicator
+
+import (
+	"context"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// maxSeqRanges is the number of completed ranges kept in the replication logs
+const maxSeqRanges = 100
+
+// startRange determines the end of the sequence range of the run,
+// see Config.SeqRangeSize
+func (r *Replicator) startRange() {
+	r.rangeEnd = 0
+	if r.job.SeqRangeSize <= 0 {
+		return
+	}
+
+	start, ok := seqNumber(r.sourceLastSeq)
+	if !ok {
+		r.logger.Warningf("Sequence %q isn't numeric, replicating without range", r.sourceLastSeq)
+		return
+	}
+	r.rangeEnd = start + r.job.SeqRangeSize
+	r.result.SeqRange = &client.SeqRange{Start: r.sourceLastSeq}
+	r.logger.Infof("Replicating the sequences after %d up to %d", start, r.rangeEnd)
+}
+
+// rangeLimit limits the changes of the window to the rest of the range,
+// every change increases the sequence number at least by one
+func (r *Replicator) rangeLimit(limit int) int {
+	if r.rangeEnd == 0 {
+		return limit
+	}
+	seq, ok := seqNumber(r.sourceLastSeq)
+	if !ok || seq >= r.rangeEnd {
+		return limit
+	}
+	if rest := r.rangeEnd - seq; limit <= 0 || rest < int64(limit) {
+		return int(rest)
+	}
+	return limit
+}
+
+// rangeCompleted records the range in the replication logs once the
+// sequence reached its end, true is returned if the run is done
+func (r *Replicator) rangeCompleted(ctx context.Context, seq string) (bool, error) {
+	if r.rangeEnd == 0 {
+		return false, nil
+	}
+	n, ok := seqNumber(seq)
+	if !ok || n < r.rangeEnd {
+		return false, nil
+	}
+
+	r.result.SeqRange.End = seq
+	r.result.SeqRange.Completed = time.Now()
+	repLogs := []*client.ReplicationLog{r.sourceRepLog}
+	if r.target != nil {
+		repLogs = append(repLogs, r.targetRepLog)
+	}
+	for _, repLog := range repLogs {
+		repLog.SeqRanges = append(repLog.SeqRanges, *r.result.SeqRange)
+		if len(repLog.SeqRanges) > maxSeqRanges {
+			repLog.SeqRanges = repLog.SeqRanges[len(repLog.SeqRanges)-maxSeqRanges:]
+		}
+	}
+
+	return true, r.checkpoint(ctx, seq)
+}
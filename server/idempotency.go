@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/goydb/replicator/client"
+)
+
+// DefaultReplayCacheSize is the number of writes the TargetHandler
+// remembers to answer replayed requests
+const DefaultReplayCacheSize = 1000
+
+// replayCache remembers the responses of the recent writes by their
+// idempotency key, the oldest are evicted first
+type replayCache struct {
+	mu      sync.Mutex
+	size    int
+	keys    []string // in order of insertion
+	entries map[string]replayEntry
+}
+
+type replayEntry struct {
+	status int
+	body   interface{}
+}
+
+func newReplayCache(size int) *replayCache {
+	if size <= 0 {
+		return nil
+	}
+	return &replayCache{
+		size:    size,
+		entries: make(map[string]replayEntry, size),
+	}
+}
+
+func (c *replayCache) get(key string) (replayEntry, bool) {
+	if c == nil || key == "" {
+		return replayEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *replayCache) add(key string, entry replayEntry) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	if len(c.keys) >= c.size {
+		delete(c.entries, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+	c.keys = append(c.keys, key)
+	c.entries[key] = entry
+}
+
+// replayKey returns the idempotency key of the write request,
+// prefixed with the endpoint as the responses differ
+func replayKey(endpoint string, r *http.Request) string {
+	key := r.Header.Get(client.IdempotencyKeyHeader)
+	if key == "" {
+		return ""
+	}
+	return endpoint + ":" + key
+}
+
+// replay answers a replayed write with the cached response
+func (h *TargetHandler) replay(w http.ResponseWriter, key string) bool {
+	entry, ok := h.replays.get(key)
+	if !ok {
+		return false
+	}
+	w.Header().Set(client.IdempotentReplayHeader, "true")
+	writeJSON(w, entry.status, entry.body)
+	return true
+}
+
+// written responds to a successful write and remembers the response
+func (h *TargetHandler) written(w http.ResponseWriter, key string, status int, body interface{}) {
+	h.replays.add(key, replayEntry{status: status, body: body})
+	writeJSON(w, status, body)
+}
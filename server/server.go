@@ -0,0 +1,80 @@
+// Package server exposes the replication protocol over HTTP, so that
+// CouchDB compatible replicators (CouchDB, PouchDB, this package) can
+// replicate from and to arbitrary Go storage.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Error is the CouchDB error response body
+type Error struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, Error{Error: "not_found", Reason: "missing"})
+	case errors.Is(err, client.ErrConflict):
+		writeJSON(w, http.StatusConflict, Error{Error: "conflict", Reason: "Document update conflict."})
+	default:
+		writeJSON(w, http.StatusInternalServerError, Error{Error: "internal_server_error", Reason: err.Error()})
+	}
+}
+
+func writeBadRequest(w http.ResponseWriter, reason string) {
+	writeJSON(w, http.StatusBadRequest, Error{Error: "bad_request", Reason: reason})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, Error{Error: "method_not_allowed", Reason: "Only the replication protocol is supported"})
+}
+
+// splitPath returns the first path segment (e.g. _revs_diff) and the
+// document id for document paths, _local/ and _design/ ids keep their
+// prefix. Escaped slashes (%2F) are part of the document id.
+func splitPath(r *http.Request) (string, string, error) {
+	p := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	if p == "" {
+		return "", "", nil
+	}
+
+	segment := p
+	rest := ""
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		segment, rest = p[:i], p[i+1:]
+	}
+
+	switch segment {
+	case "_local", "_design":
+		id, err := url.PathUnescape(rest)
+		if err != nil {
+			return "", "", err
+		}
+		return segment, segment + "/" + id, nil
+	}
+
+	if strings.HasPrefix(segment, "_") {
+		return segment, "", nil
+	}
+
+	id, err := url.PathUnescape(p)
+	if err != nil {
+		return "", "", err
+	}
+	return "", id, nil
+}
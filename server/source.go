@@ -0,0 +1,374 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+)
+
+// SourceHandler implements the source half of the replication protocol
+// for a single database on top of a replicator.Source. The handler
+// expects the database to be the root of the path, use http.StripPrefix
+// to mount it:
+//
+//	http.Handle("/db/", http.StripPrefix("/db", server.NewSourceHandler(source)))
+type SourceHandler struct {
+	source replicator.Source
+}
+
+func NewSourceHandler(source replicator.Source) *SourceHandler {
+	return &SourceHandler{source: source}
+}
+
+func (h *SourceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segment, docID, err := splitPath(r)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	switch {
+	case segment == "" && docID == "":
+		h.database(w, r)
+	case segment == "_changes" && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+		h.changes(w, r)
+	case segment == "_local":
+		h.local(w, r, docID)
+	case docID != "" && r.Method == http.MethodGet:
+		h.getDocument(w, r, docID)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (h *SourceHandler) database(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+		err := h.source.Check(r.Context())
+		if errors.Is(err, client.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		info, err := h.source.Info(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// changes serves the changes as normal feed, continuous and
+// longpoll feeds are answered with the current changes. Options the
+// handler can't pass to the source are rejected with 400.
+func (h *SourceHandler) changes(w http.ResponseWriter, r *http.Request) {
+	opts, mainOnly, err := parseChangeOptions(r)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	changes, err := h.source.Changes(r.Context(), opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if changes.Results == nil {
+		changes.Results = []client.Results{}
+	}
+	// the winning revision is listed first
+	if mainOnly {
+		for i := range changes.Results {
+			if len(changes.Results[i].Changes) > 1 {
+				changes.Results[i].Changes = changes.Results[i].Changes[:1]
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// changesBody is the body of POST _changes requests
+type changesBody struct {
+	Selector json.RawMessage `json:"selector"`
+	DocIDs   []string        `json:"doc_ids"`
+}
+
+// changesParams are the parameters of the changes feed, all other
+// parameters are only accepted as parameters of a filter function
+var changesParams = map[string]bool{
+	"since": true, "feed": true, "style": true, "heartbeat": true,
+	"timeout": true, "filter": true, "doc_ids": true, "limit": true,
+	"descending": true, "seq_interval": true,
+}
+
+// unsupportedChangesParams are rejected unless they are false
+var unsupportedChangesParams = []string{
+	"include_docs", "conflicts", "attachments", "att_encoding_info",
+}
+
+// parseChangeOptions returns the options of the changes request and if
+// only the winning revisions are requested (style=main_only)
+func parseChangeOptions(r *http.Request) (client.ChangeOptions, bool, error) {
+	q := r.URL.Query()
+	opts := client.ChangeOptions{
+		Since:  q.Get("since"),
+		Filter: q.Get("filter"),
+	}
+	if opts.Since == "" {
+		opts.Since = replicator.NoVersion
+	}
+
+	switch feed := q.Get("feed"); feed {
+	case "", "normal", "longpoll", "continuous":
+	default:
+		return opts, false, fmt.Errorf("unsupported feed %q", feed)
+	}
+
+	mainOnly := false
+	switch style := q.Get("style"); style {
+	case "", "main_only":
+		mainOnly = true
+	case "all_docs":
+	default:
+		return opts, false, fmt.Errorf("unsupported style %q", style)
+	}
+
+	for _, key := range unsupportedChangesParams {
+		if value := q.Get(key); value != "" && value != "false" {
+			return opts, false, fmt.Errorf("unsupported parameter %s", key)
+		}
+	}
+
+	var err error
+	opts.Heartbeat, err = queryMilliseconds(q, "heartbeat")
+	if err != nil {
+		return opts, false, err
+	}
+	opts.Timeout, err = queryMilliseconds(q, "timeout")
+	if err != nil {
+		return opts, false, err
+	}
+	if opts.Heartbeat > 0 && opts.Timeout > 0 {
+		return opts, false, client.ErrHeartbeatAndTimeout
+	}
+	opts.Limit, err = queryInt(q, "limit")
+	if err != nil {
+		return opts, false, err
+	}
+	opts.SeqInterval, err = queryInt(q, "seq_interval")
+	if err != nil {
+		return opts, false, err
+	}
+	if value := q.Get("descending"); value != "" {
+		opts.Descending, err = strconv.ParseBool(value)
+		if err != nil {
+			return opts, false, fmt.Errorf("invalid descending: %v", err)
+		}
+	}
+
+	if value := q.Get("doc_ids"); value != "" {
+		err = json.Unmarshal([]byte(value), &opts.DocIDs)
+		if err != nil {
+			return opts, false, fmt.Errorf("invalid doc_ids: %v", err)
+		}
+	}
+	if r.Method == http.MethodPost {
+		var body changesBody
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&body)
+		if err != nil && err != io.EOF {
+			return opts, false, fmt.Errorf("invalid body: %v", err)
+		}
+		opts.Selector = body.Selector
+		if len(body.DocIDs) > 0 {
+			opts.DocIDs = body.DocIDs
+		}
+	}
+
+	// the builtin filters take the selector or the document ids,
+	// all other filters take the remaining parameters
+	switch opts.Filter {
+	case client.SelectorFilter:
+		if len(opts.Selector) == 0 {
+			return opts, false, errors.New("filter _selector requires a selector")
+		}
+		opts.Filter = ""
+	case client.DocIDsFilter:
+		if len(opts.DocIDs) == 0 {
+			return opts, false, errors.New("filter _doc_ids requires doc_ids")
+		}
+		opts.Filter = ""
+	case "":
+		if len(opts.Selector) > 0 || len(opts.DocIDs) > 0 {
+			return opts, false, errors.New("selector and doc_ids require a filter")
+		}
+		for key := range q {
+			if !changesParams[key] && !isUnsupportedChangesParam(key) {
+				return opts, false, fmt.Errorf("unsupported parameter %s", key)
+			}
+		}
+	default:
+		if len(opts.Selector) > 0 || len(opts.DocIDs) > 0 {
+			return opts, false, fmt.Errorf("filter %s can't be combined with selector or doc_ids", opts.Filter)
+		}
+		for key := range q {
+			if changesParams[key] || isUnsupportedChangesParam(key) {
+				continue
+			}
+			if opts.QueryParams == nil {
+				opts.QueryParams = make(map[string]string)
+			}
+			opts.QueryParams[key] = q.Get(key)
+		}
+	}
+
+	return opts, mainOnly, nil
+}
+
+func isUnsupportedChangesParam(key string) bool {
+	for _, unsupported := range unsupportedChangesParams {
+		if key == unsupported {
+			return true
+		}
+	}
+	return false
+}
+
+func queryInt(q url.Values, key string) (int, error) {
+	value := q.Get(key)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+	return n, nil
+}
+
+func queryMilliseconds(q url.Values, key string) (time.Duration, error) {
+	ms, err := queryInt(q, key)
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+func (h *SourceHandler) getDocument(w http.ResponseWriter, r *http.Request, docID string) {
+	openRevs := r.URL.Query().Get("open_revs")
+	if openRevs == "" {
+		writeBadRequest(w, "only open_revs requests are supported")
+		return
+	}
+
+	var diff client.Diff
+	if openRevs == "all" {
+		diff.Missing = []string{"all"}
+	} else {
+		err := json.Unmarshal([]byte(openRevs), &diff.Missing)
+		if err != nil {
+			writeBadRequest(w, "invalid open_revs: "+err.Error())
+			return
+		}
+	}
+
+	doc, err := h.source.GetDocumentComplete(r.Context(), docID, &diff)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer doc.Close() // nolint: errcheck
+
+	if !strings.Contains(r.Header.Get("Accept"), "multipart/mixed") {
+		err = doc.InlineAttachments()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, []map[string]interface{}{{"ok": doc.Data}})
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	if !doc.HasChangedAttachments() {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": []string{"application/json"},
+		})
+		if err != nil {
+			return
+		}
+		err = json.NewEncoder(pw).Encode(doc.Data)
+		if err != nil {
+			return
+		}
+	} else {
+		dr, boundary, err := doc.Reader()
+		if err != nil {
+			return
+		}
+		defer dr.Close()
+
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": []string{`multipart/related; boundary="` + boundary + `"`},
+		})
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(pw, dr)
+		if err != nil {
+			return
+		}
+	}
+
+	_ = mw.Close()
+}
+
+func (h *SourceHandler) local(w http.ResponseWriter, r *http.Request, docID string) {
+	id := strings.TrimPrefix(docID, client.LocalDocPrefix)
+
+	switch r.Method {
+	case http.MethodGet:
+		repLog, err := h.source.GetReplicationLog(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, repLog)
+	case http.MethodPut:
+		var repLog client.ReplicationLog
+		err := json.NewDecoder(r.Body).Decode(&repLog)
+		if err != nil {
+			writeBadRequest(w, err.Error())
+			return
+		}
+
+		rev, err := h.source.RecordReplicationCheckpoint(r.Context(), &repLog, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, client.BulkDocsResult{ID: docID, Rev: rev, OK: true})
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
@@ -0,0 +1,217 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	docs map[string]map[string]interface{}
+	logs map[string]*client.ReplicationLog
+	opts client.ChangeOptions
+}
+
+func (s *fakeSource) Check(ctx context.Context) error { return nil }
+func (s *fakeSource) Info(ctx context.Context) (*client.Info, error) {
+	return &client.Info{DbName: "fake", DocCount: len(s.docs)}, nil
+}
+
+func (s *fakeSource) GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error) {
+	rl, ok := s.logs[id]
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+	return rl, nil
+}
+
+func (s *fakeSource) RecordReplicationCheckpoint(ctx context.Context, repLog *client.ReplicationLog, id string) (string, error) {
+	if current, ok := s.logs[id]; ok && current.Rev != repLog.Rev {
+		return "", client.ErrConflict
+	}
+	repLog.Rev = fmt.Sprintf("0-%d", len(s.logs)+1)
+	s.logs[id] = repLog
+	return repLog.Rev, nil
+}
+
+func (s *fakeSource) Changes(ctx context.Context, opts client.ChangeOptions) (*client.ChangesResponse, error) {
+	s.opts = opts
+	resp := &client.ChangesResponse{LastSeq: "1"}
+	if opts.Since != "0" {
+		return resp, nil
+	}
+	for id, doc := range s.docs {
+		resp.Results = append(resp.Results, client.Results{
+			Seq:     "1",
+			ID:      id,
+			Changes: []client.Changes{{Rev: doc["_rev"].(string)}},
+		})
+		if conflict, ok := doc["_conflict"].(string); ok {
+			last := &resp.Results[len(resp.Results)-1]
+			last.Changes = append(last.Changes, client.Changes{Rev: conflict})
+		}
+	}
+	return resp, nil
+}
+
+func (s *fakeSource) GetDocumentComplete(ctx context.Context, docid string, diff *client.Diff) (*client.CompleteDoc, error) {
+	doc, ok := s.docs[docid]
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+	return client.NewDoc(doc), nil
+}
+
+func TestSourceHandler(t *testing.T) {
+	ctx := context.Background()
+	source := &fakeSource{
+		docs: map[string]map[string]interface{}{
+			"a": {"_id": "a", "_rev": "1-a", "value": "x"},
+		},
+		logs: make(map[string]*client.ReplicationLog),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/db/", http.StripPrefix("/db", server.NewSourceHandler(source)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db/"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Check(ctx))
+
+	changes, err := c.Changes(ctx, client.ChangeOptions{Since: "0"})
+	assert.NoError(t, err)
+	if assert.Len(t, changes.Results, 1) {
+		assert.Equal(t, "a", changes.Results[0].ID)
+	}
+
+	doc, err := c.GetDocumentComplete(ctx, "a", &client.Diff{Missing: []string{"1-a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "x", doc.Data["value"])
+	assert.NoError(t, doc.Close())
+
+	_, err = c.GetDocumentComplete(ctx, "b", &client.Diff{Missing: []string{"1-b"}})
+	assert.ErrorIs(t, err, client.ErrNotFound)
+
+	rev, err := c.RecordReplicationCheckpoint(ctx, &client.ReplicationLog{
+		ID:            "_local/rep",
+		SourceLastSeq: "1",
+	}, "rep")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rev)
+	rl, err := c.GetReplicationLog(ctx, "rep")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", rl.SourceLastSeq)
+}
+
+func TestSourceHandlerChanges(t *testing.T) {
+	testCases := map[string]struct {
+		method string
+		query  string
+		body   string
+		status int
+		opts   client.ChangeOptions
+	}{
+		"since": {
+			query: "since=3", status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "3"},
+		},
+		"limit and descending": {
+			query: "limit=5&descending=true", status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "0", Limit: 5, Descending: true},
+		},
+		"seq_interval": {
+			query: "seq_interval=10", status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "0", SeqInterval: 10},
+		},
+		"heartbeat": {
+			query: "feed=longpoll&heartbeat=1000", status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "0", Heartbeat: time.Second},
+		},
+		"timeout": {
+			query: "timeout=500", status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "0", Timeout: 500 * time.Millisecond},
+		},
+		"filter": {
+			query: "filter=app/by_type&type=order", status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "0", Filter: "app/by_type", QueryParams: map[string]string{"type": "order"}},
+		},
+		"doc_ids query": {
+			query: `filter=_doc_ids&doc_ids=["a","b"]`, status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "0", DocIDs: []string{"a", "b"}},
+		},
+		"doc_ids body": {
+			method: http.MethodPost, query: "filter=_doc_ids", body: `{"doc_ids":["a"]}`, status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "0", DocIDs: []string{"a"}},
+		},
+		"selector body": {
+			method: http.MethodPost, query: "filter=_selector", body: `{"selector":{"type":"order"}}`, status: http.StatusOK,
+			opts: client.ChangeOptions{Since: "0", Selector: json.RawMessage(`{"type":"order"}`)},
+		},
+		"unknown feed":             {query: "feed=eventsource", status: http.StatusBadRequest},
+		"unknown style":            {query: "style=winners", status: http.StatusBadRequest},
+		"include_docs":             {query: "include_docs=true", status: http.StatusBadRequest},
+		"unknown parameter":        {query: "type=order", status: http.StatusBadRequest},
+		"invalid limit":            {query: "limit=-1", status: http.StatusBadRequest},
+		"invalid descending":       {query: "descending=maybe", status: http.StatusBadRequest},
+		"invalid doc_ids":          {query: "filter=_doc_ids&doc_ids=a", status: http.StatusBadRequest},
+		"heartbeat and timeout":    {query: "heartbeat=1000&timeout=1000", status: http.StatusBadRequest},
+		"selector without body":    {query: "filter=_selector", status: http.StatusBadRequest},
+		"selector without filter":  {method: http.MethodPost, body: `{"selector":{}}`, status: http.StatusBadRequest},
+		"unknown body field":       {method: http.MethodPost, query: "filter=_selector", body: `{"fields":[]}`, status: http.StatusBadRequest},
+		"filter and doc_ids":       {query: `filter=app/f&doc_ids=["a"]`, status: http.StatusBadRequest},
+		"doc_ids filter empty ids": {method: http.MethodPost, query: "filter=_doc_ids", body: `{}`, status: http.StatusBadRequest},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			source := &fakeSource{logs: make(map[string]*client.ReplicationLog)}
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			req := httptest.NewRequest(method, "/_changes?"+tc.query, strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			server.NewSourceHandler(source).ServeHTTP(rec, req)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.status == http.StatusOK {
+				assert.Equal(t, tc.opts, source.opts)
+			}
+		})
+	}
+}
+
+func TestSourceHandlerChangesStyle(t *testing.T) {
+	source := &fakeSource{
+		docs: map[string]map[string]interface{}{
+			"a": {"_id": "a", "_rev": "2-b", "_conflict": "2-a"},
+		},
+		logs: make(map[string]*client.ReplicationLog),
+	}
+	handler := server.NewSourceHandler(source)
+
+	for style, revs := range map[string]int{"all_docs": 2, "main_only": 1, "": 1} {
+		req := httptest.NewRequest(http.MethodGet, "/_changes?since=0&style="+style, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var changes client.ChangesResponse
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&changes))
+		if assert.Len(t, changes.Results, 1) {
+			assert.Len(t, changes.Results[0].Changes, revs, style)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+)
+
+// TargetHandler implements the target half of the replication protocol
+// for a single database on top of a replicator.Target. The handler
+// expects the database to be the root of the path, use http.StripPrefix
+// to mount it:
+//
+//	http.Handle("/db/", http.StripPrefix("/db", server.NewTargetHandler(target)))
+//
+// Writes carrying an idempotency key (client.IdempotencyKeyHeader) are
+// remembered, replays are answered without writing the documents again.
+type TargetHandler struct {
+	target  replicator.Target
+	replays *replayCache
+}
+
+func NewTargetHandler(target replicator.Target) *TargetHandler {
+	return &TargetHandler{
+		target:  target,
+		replays: newReplayCache(DefaultReplayCacheSize),
+	}
+}
+
+// SetReplayCacheSize sets the number of writes remembered to
+// answer replayed requests, 0 disables the deduplication
+func (h *TargetHandler) SetReplayCacheSize(n int) {
+	h.replays = newReplayCache(n)
+}
+
+func (h *TargetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segment, docID, err := splitPath(r)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	switch {
+	case segment == "" && docID == "":
+		h.database(w, r)
+	case segment == "_revs_diff" && r.Method == http.MethodPost:
+		h.revsDiff(w, r)
+	case segment == "_bulk_docs" && r.Method == http.MethodPost:
+		h.bulkDocs(w, r)
+	case segment == "_ensure_full_commit" && r.Method == http.MethodPost:
+		h.ensureFullCommit(w, r)
+	case segment == "_local":
+		h.local(w, r, docID)
+	case docID != "" && r.Method == http.MethodPut:
+		h.putDocument(w, r, docID)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (h *TargetHandler) database(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+		err := h.target.Check(r.Context())
+		if errors.Is(err, client.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		info, err := h.target.Info(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	case http.MethodPut:
+		err := h.target.Create(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]bool{"ok": true})
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (h *TargetHandler) revsDiff(w http.ResponseWriter, r *http.Request) {
+	var req client.RevDiffRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	resp, err := h.target.RevDiff(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *TargetHandler) bulkDocs(w http.ResponseWriter, r *http.Request) {
+	key := replayKey("_bulk_docs", r)
+	if h.replay(w, key) {
+		return
+	}
+
+	var body struct {
+		Docs     []map[string]interface{} `json:"docs"`
+		NewEdits *bool                    `json:"new_edits"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	// only replication writes are supported
+	if body.NewEdits == nil || *body.NewEdits {
+		writeBadRequest(w, "only new_edits=false is supported")
+		return
+	}
+
+	stack := make(client.Stack, 0, len(body.Docs))
+	for _, data := range body.Docs {
+		stack = append(stack, client.NewDoc(data))
+	}
+
+	failures, err := h.target.BulkDocs(r.Context(), &stack)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if failures == nil {
+		failures = []client.BulkDocsResult{}
+	}
+	h.written(w, key, http.StatusCreated, failures)
+}
+
+func (h *TargetHandler) putDocument(w http.ResponseWriter, r *http.Request, docID string) {
+	if r.URL.Query().Get("new_edits") != "false" {
+		writeBadRequest(w, "only new_edits=false is supported")
+		return
+	}
+	key := replayKey(docID, r)
+	if h.replay(w, key) {
+		return
+	}
+
+	doc, err := client.ParseCompleteDoc(docID, r.Header.Get("Content-Type"), r.Body)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	err = h.target.UploadDocumentWithAttachments(r.Context(), doc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rev, _ := doc.Data["_rev"].(string)
+	h.written(w, key, http.StatusCreated, client.BulkDocsResult{ID: docID, Rev: rev, OK: true})
+}
+
+func (h *TargetHandler) ensureFullCommit(w http.ResponseWriter, r *http.Request) {
+	err := h.target.EnsureFullCommit(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"ok":                  true,
+		"instance_start_time": "0",
+	})
+}
+
+func (h *TargetHandler) local(w http.ResponseWriter, r *http.Request, docID string) {
+	id := strings.TrimPrefix(docID, client.LocalDocPrefix)
+
+	switch r.Method {
+	case http.MethodGet:
+		repLog, err := h.target.GetReplicationLog(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, repLog)
+	case http.MethodPut:
+		var repLog client.ReplicationLog
+		err := json.NewDecoder(r.Body).Decode(&repLog)
+		if err != nil {
+			writeBadRequest(w, err.Error())
+			return
+		}
+
+		rev, err := h.target.RecordReplicationCheckpoint(r.Context(), &repLog, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, client.BulkDocsResult{ID: docID, Rev: rev, OK: true})
+	case http.MethodDelete:
+		remover, ok := h.target.(replicator.CheckpointRemover)
+		if !ok {
+			writeMethodNotAllowed(w)
+			return
+		}
+
+		rev := r.URL.Query().Get("rev")
+		err := remover.RemoveReplicationCheckpoint(r.Context(), id, rev)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, client.BulkDocsResult{ID: docID, Rev: rev, OK: true})
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
@@ -0,0 +1,192 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/goydb/replicator/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTarget struct {
+	docs      map[string]map[string]interface{}
+	logs      map[string]*client.ReplicationLog
+	committed bool
+}
+
+func (t *fakeTarget) Check(ctx context.Context) error  { return nil }
+func (t *fakeTarget) Create(ctx context.Context) error { return nil }
+func (t *fakeTarget) Info(ctx context.Context) (*client.Info, error) {
+	return &client.Info{DbName: "fake", DocCount: len(t.docs)}, nil
+}
+
+func (t *fakeTarget) GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error) {
+	rl, ok := t.logs[id]
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+	return rl, nil
+}
+
+func (t *fakeTarget) RecordReplicationCheckpoint(ctx context.Context, repLog *client.ReplicationLog, id string) (string, error) {
+	if current, ok := t.logs[id]; ok && current.Rev != repLog.Rev {
+		return "", client.ErrConflict
+	}
+	repLog.Rev = fmt.Sprintf("0-%d", len(t.logs)+1)
+	t.logs[id] = repLog
+	return repLog.Rev, nil
+}
+
+func (t *fakeTarget) RemoveReplicationCheckpoint(ctx context.Context, id, rev string) error {
+	current, ok := t.logs[id]
+	if !ok {
+		return client.ErrNotFound
+	}
+	if rev != "" && current.Rev != rev {
+		return client.ErrConflict
+	}
+	delete(t.logs, id)
+	return nil
+}
+
+func (t *fakeTarget) RevDiff(ctx context.Context, r client.RevDiffRequest) (client.DiffResponse, error) {
+	resp := make(client.DiffResponse)
+	for id, revs := range r {
+		doc, ok := t.docs[id]
+		for _, rev := range revs {
+			if !ok || doc["_rev"] != rev {
+				if resp[id] == nil {
+					resp[id] = new(client.Diff)
+				}
+				resp[id].Missing = append(resp[id].Missing, rev)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (t *fakeTarget) BulkDocs(ctx context.Context, stack *client.Stack) ([]client.BulkDocsResult, error) {
+	var failures []client.BulkDocsResult
+	for _, doc := range *stack {
+		if doc.Data["forbidden"] == true {
+			failures = append(failures, client.BulkDocsResult{ID: doc.ID, Error: "forbidden", Reason: "test"})
+			continue
+		}
+		t.docs[doc.ID] = doc.Data
+	}
+	return failures, nil
+}
+
+func (t *fakeTarget) UploadDocumentWithAttachments(ctx context.Context, doc *client.CompleteDoc) error {
+	t.docs[doc.ID] = doc.Data
+	return nil
+}
+
+func (t *fakeTarget) EnsureFullCommit(ctx context.Context) error {
+	t.committed = true
+	return nil
+}
+
+func TestTargetHandler(t *testing.T) {
+	ctx := context.Background()
+	target := &fakeTarget{
+		docs: map[string]map[string]interface{}{
+			"a": {"_id": "a", "_rev": "1-a"},
+		},
+		logs: make(map[string]*client.ReplicationLog),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/db/", http.StripPrefix("/db", server.NewTargetHandler(target)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c, err := client.NewClient(&client.Remote{URL: srv.URL + "/db/"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Check(ctx))
+	info, err := c.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "fake", info.DbName)
+
+	diff, err := c.RevDiff(ctx, client.RevDiffRequest{
+		"a":           {"1-a"},
+		"b":           {"1-b"},
+		"_design/foo": {"1-c"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, diff, 2)
+	assert.Equal(t, []string{"1-b"}, diff["b"].Missing)
+
+	stack := client.Stack{
+		client.NewDoc(map[string]interface{}{"_id": "b", "_rev": "1-b"}),
+		client.NewDoc(map[string]interface{}{"_id": "c", "_rev": "1-c", "forbidden": true}),
+	}
+	failures, err := c.BulkDocs(ctx, &stack)
+	assert.NoError(t, err)
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "c", failures[0].ID)
+	}
+	assert.Contains(t, target.docs, "b")
+
+	// a replay with the same idempotency key isn't written again
+	delete(target.docs, "b")
+	failures, err = c.BulkDocs(ctx, &stack)
+	assert.NoError(t, err)
+	assert.Len(t, failures, 1)
+	assert.NotContains(t, target.docs, "b")
+
+	assert.NoError(t, c.EnsureFullCommit(ctx))
+	assert.True(t, target.committed)
+
+	_, err = c.GetReplicationLog(ctx, "rep")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	rev, err := c.RecordReplicationCheckpoint(ctx, &client.ReplicationLog{
+		ID:            "_local/rep",
+		SessionID:     "session",
+		SourceLastSeq: "42",
+	}, "rep")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rev)
+	rl, err := c.GetReplicationLog(ctx, "rep")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", rl.SourceLastSeq)
+
+	// the checkpoint is only removed with its current revision
+	assert.Error(t, c.RemoveReplicationCheckpoint(ctx, "rep", "0-0"))
+	assert.NoError(t, c.RemoveReplicationCheckpoint(ctx, "rep", rev))
+	_, err = c.GetReplicationLog(ctx, "rep")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+}
+
+// checkpointTarget hides the optional capabilities of the target
+type checkpointTarget struct {
+	replicator.Target
+}
+
+func TestTargetHandlerRemoveCheckpoint(t *testing.T) {
+	target := &fakeTarget{logs: map[string]*client.ReplicationLog{
+		"rep": {ID: "_local/rep", Rev: "0-1"},
+	}}
+
+	// targets that can't remove checkpoints
+	req := httptest.NewRequest(http.MethodDelete, "/_local/rep?rev=0-1", nil)
+	rec := httptest.NewRecorder()
+	server.NewTargetHandler(checkpointTarget{target}).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Contains(t, target.logs, "rep")
+
+	rec = httptest.NewRecorder()
+	server.NewTargetHandler(target).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, target.logs, "rep")
+
+	rec = httptest.NewRecorder()
+	server.NewTargetHandler(target).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
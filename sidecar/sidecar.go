@@ -0,0 +1,335 @@
+// Package sidecar exposes a Scheduler over HTTP: a _replicate endpoint
+// compatible with the one of CouchDB and a REST API to manage the jobs,
+// so the replicator can run as standalone process triggered by other
+// systems.
+//
+//	POST   /_replicate          start (or cancel) a replication
+//	GET    /jobs                list the jobs
+//	POST   /jobs                create a job
+//	GET    /jobs/{id}           status of the job
+//	DELETE /jobs/{id}           delete the job
+//	POST   /jobs/{id}/_pause    pause the job
+//	POST   /jobs/{id}/_resume   resume the job
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+)
+
+// Error is the CouchDB error response body
+type Error struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// ReplicateRequest is the body of POST /_replicate
+type ReplicateRequest struct {
+	replicator.Job
+
+	// Cancel stops the replication with the same source, target and
+	// filter, or the given ReplicationID
+	Cancel        bool   `json:"cancel"`
+	ReplicationID string `json:"replication_id"`
+
+	// Proxy is used for source and target, unless they have their own
+	Proxy       string `json:"proxy"`
+	SourceProxy string `json:"source_proxy"`
+	TargetProxy string `json:"target_proxy"`
+}
+
+// applyProxies sets the proxies of the request on the remotes
+func (req *ReplicateRequest) applyProxies() {
+	for _, p := range []struct {
+		remote *client.Remote
+		proxy  string
+	}{{req.Source, req.SourceProxy}, {req.Target, req.TargetProxy}} {
+		if p.remote == nil || p.remote.ProxyURL != "" {
+			continue
+		}
+		p.remote.ProxyURL = p.proxy
+		if p.proxy == "" {
+			p.remote.ProxyURL = req.Proxy
+		}
+	}
+}
+
+// ReplicateResponse is the response of POST /_replicate
+type ReplicateResponse struct {
+	OK            bool   `json:"ok"`
+	LocalID       string `json:"_local_id,omitempty"`
+	SourceLastSeq string `json:"source_last_seq,omitempty"`
+	DocsSkipped   int    `json:"docs_skipped,omitempty"`
+}
+
+// JobStatus is the status of a job in the responses of the jobs API
+type JobStatus struct {
+	ID            string    `json:"id"`
+	State         string    `json:"state"`
+	Error         string    `json:"error,omitempty"`
+	Failures      int       `json:"failures,omitempty"`
+	Started       time.Time `json:"started,omitempty"`
+	Retry         time.Time `json:"retry,omitempty"`
+	CheckpointSeq string    `json:"checkpoint_seq,omitempty"`
+	DocsSkipped   int       `json:"docs_skipped,omitempty"`
+}
+
+func newJobStatus(status replicator.JobStatus) JobStatus {
+	js := JobStatus{
+		ID:            status.ID,
+		State:         string(status.State),
+		Failures:      status.Failures,
+		Started:       status.Started,
+		Retry:         status.Retry,
+		CheckpointSeq: status.Result.Checkpoint.Seq,
+		DocsSkipped:   status.Result.DocsSkipped,
+	}
+	if status.Err != nil {
+		js.Error = status.Err.Error()
+	}
+	return js
+}
+
+// pollInterval is the interval the status of one-shot replications
+// started by _replicate is checked
+const pollInterval = 100 * time.Millisecond
+
+// Handler serves the _replicate endpoint and the jobs API, the
+// scheduler has to be run separately
+type Handler struct {
+	s *replicator.Scheduler
+}
+
+// New creates a handler that adds the jobs to the scheduler
+func New(s *replicator.Scheduler) *Handler {
+	return &Handler{s: s}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.Trim(r.URL.EscapedPath(), "/")
+	switch {
+	case p == "_replicate":
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w)
+			return
+		}
+		h.replicate(w, r)
+	case p == "jobs":
+		switch r.Method {
+		case http.MethodGet:
+			h.listJobs(w)
+		case http.MethodPost:
+			h.createJob(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	case strings.HasPrefix(p, "jobs/"):
+		parts := strings.SplitN(strings.TrimPrefix(p, "jobs/"), "/", 2)
+		id, err := url.PathUnescape(parts[0])
+		if err != nil {
+			writeBadRequest(w, err.Error())
+			return
+		}
+		action := ""
+		if len(parts) == 2 {
+			action = parts[1]
+		}
+		h.job(w, r, id, action)
+	default:
+		writeJSON(w, http.StatusNotFound, Error{Error: "not_found", Reason: "missing"})
+	}
+}
+
+// replicate starts a replication like CouchDB: one-shot replications
+// respond once completed, continuous replications once scheduled
+func (h *Handler) replicate(w http.ResponseWriter, r *http.Request) {
+	var req ReplicateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	req.applyProxies()
+	job := &req.Job
+	id := req.ReplicationID
+	if id == "" {
+		if job.Source == nil || job.Target == nil {
+			writeBadRequest(w, "source and target are required")
+			return
+		}
+		id = job.GenerateReplicationID("_replicate")
+		if job.Continuous {
+			id += "+continuous"
+		}
+	}
+
+	if req.Cancel {
+		err = h.s.Remove(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ReplicateResponse{OK: true, LocalID: id})
+		return
+	}
+
+	if job.Source == nil || job.Target == nil {
+		writeBadRequest(w, "source and target are required")
+		return
+	}
+	job.ID = id
+	err = h.s.Add(job)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if job.Continuous {
+		writeJSON(w, http.StatusAccepted, ReplicateResponse{OK: true, LocalID: id})
+		return
+	}
+
+	// one-shot replications are removed once they returned
+	status, err := h.wait(r.Context(), id)
+	_ = h.s.Remove(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ReplicateResponse{
+		OK:            true,
+		LocalID:       id,
+		SourceLastSeq: status.Result.Checkpoint.Seq,
+		DocsSkipped:   status.Result.DocsSkipped,
+	})
+}
+
+// wait waits until the one-shot replication completed or failed
+func (h *Handler) wait(ctx context.Context, id string) (replicator.JobStatus, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, ok := h.s.Status(id)
+		switch {
+		case !ok:
+			return status, replicator.ErrJobNotFound
+		case status.State == replicator.JobCompleted:
+			return status, nil
+		case status.State == replicator.JobCrashing:
+			return status, status.Err
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) listJobs(w http.ResponseWriter) {
+	statuses := h.s.Statuses()
+	jobs := make([]JobStatus, 0, len(statuses))
+	for _, status := range statuses {
+		jobs = append(jobs, newJobStatus(status))
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// createJob adds the job, it is stored if the scheduler has a store
+func (h *Handler) createJob(w http.ResponseWriter, r *http.Request) {
+	var job replicator.Job
+	err := json.NewDecoder(r.Body).Decode(&job)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	if job.ID == "" || job.Source == nil || job.Target == nil {
+		writeBadRequest(w, "_id, source and target are required")
+		return
+	}
+
+	err = h.s.Create(r.Context(), &job)
+	if errors.Is(err, replicator.ErrNoStore) {
+		err = h.s.Add(&job)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	status, _ := h.s.Status(job.ID)
+	writeJSON(w, http.StatusCreated, newJobStatus(status))
+}
+
+func (h *Handler) job(w http.ResponseWriter, r *http.Request, id, action string) {
+	var err error
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+	case action == "" && r.Method == http.MethodDelete:
+		err = h.s.Delete(r.Context(), id)
+		if errors.Is(err, replicator.ErrNoStore) {
+			err = h.s.Remove(id)
+		}
+		if err == nil {
+			writeJSON(w, http.StatusOK, struct {
+				OK bool `json:"ok"`
+			}{true})
+			return
+		}
+	case action == "_pause" && r.Method == http.MethodPost:
+		err = h.s.Pause(id)
+	case action == "_resume" && r.Method == http.MethodPost:
+		err = h.s.Resume(id)
+	default:
+		writeMethodNotAllowed(w)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	status, ok := h.s.Status(id)
+	if !ok {
+		writeError(w, replicator.ErrJobNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newJobStatus(status))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, replicator.ErrJobNotFound):
+		writeJSON(w, http.StatusNotFound, Error{Error: "not_found", Reason: err.Error()})
+	case errors.Is(err, replicator.ErrJobExists):
+		writeJSON(w, http.StatusConflict, Error{Error: "conflict", Reason: err.Error()})
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		writeJSON(w, http.StatusRequestTimeout, Error{Error: "timeout", Reason: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, Error{Error: "replication_failed", Reason: err.Error()})
+	}
+}
+
+func writeBadRequest(w http.ResponseWriter, reason string) {
+	writeJSON(w, http.StatusBadRequest, Error{Error: "bad_request", Reason: reason})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, Error{Error: "method_not_allowed", Reason: "Method not allowed"})
+}
@@ -0,0 +1,117 @@
+package sidecar_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/sidecar"
+	"github.com/stretchr/testify/assert"
+)
+
+// emptyDB serves an empty database
+func emptyDB() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/db/")
+		switch {
+		case path == "" && r.Method == http.MethodHead:
+		case path == "":
+			fmt.Fprint(w, `{"db_name":"db","update_seq":"0"}`)
+		case path == "_changes":
+			fmt.Fprint(w, `{"results":[],"last_seq":"0"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		}
+	}))
+}
+
+func TestReplicate(t *testing.T) {
+	db := emptyDB()
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := replicator.NewScheduler("test")
+	go s.Run(ctx) // nolint: errcheck
+	srv := httptest.NewServer(sidecar.New(s))
+	defer srv.Close()
+
+	post := func(path, body string) (int, map[string]interface{}) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0, nil
+		}
+		defer resp.Body.Close() // nolint: errcheck
+		var v map[string]interface{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&v))
+		return resp.StatusCode, v
+	}
+
+	// one-shot replications respond once completed
+	body := fmt.Sprintf(`{"source":%q,"target":%q}`, db.URL+"/db/", db.URL+"/db/")
+	status, resp := post("/_replicate", body)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, resp["ok"])
+	assert.Len(t, s.Statuses(), 0)
+
+	// continuous replications run until canceled
+	body = fmt.Sprintf(`{"source":%q,"target":%q,"continuous":true}`, db.URL+"/db/", db.URL+"/db/")
+	status, resp = post("/_replicate", body)
+	assert.Equal(t, http.StatusAccepted, status)
+	id, _ := resp["_local_id"].(string)
+	assert.True(t, strings.HasSuffix(id, "+continuous"))
+
+	status, resp = post("/jobs/"+id+"/_pause", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "paused", resp["state"])
+
+	// the job is removed once its replication returned
+	status, _ = post("/_replicate", strings.TrimSuffix(body, "}")+`,"cancel":true}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Eventually(t, func() bool {
+		return len(s.Statuses()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	status, resp = post("/jobs/"+id+"/_resume", "")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "not_found", resp["error"])
+}
+
+func TestJobs(t *testing.T) {
+	s := replicator.NewScheduler("test")
+	h := sidecar.New(s)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(
+		`{"_id":"a","source":"http://localhost:5984/a","target":"http://localhost:5984/b"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(
+		`{"_id":"a","source":"http://localhost:5984/a","target":"http://localhost:5984/b"}`)))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs", nil))
+	var jobs []sidecar.JobStatus
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&jobs))
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, "a", jobs[0].ID)
+		assert.Equal(t, "pending", jobs[0].State)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/jobs/a", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, s.Statuses(), 0)
+}
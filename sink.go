@@ -0,0 +1,39 @@
+package replicator
+
+import (
+	"context"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Sink receives the changed documents of a replication without
+// target database, e.g. to index them in a search engine.
+// Changed attachments are available using doc.Attachments(), they
+// have to be read before Receive returns as the document is closed.
+type Sink interface {
+	// Receive is called for every changed document, an error aborts
+	// the replication before the checkpoint is recorded
+	Receive(ctx context.Context, doc *client.CompleteDoc) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ctx context.Context, doc *client.CompleteDoc) error
+
+func (f SinkFunc) Receive(ctx context.Context, doc *client.CompleteDoc) error {
+	return f(ctx, doc)
+}
+
+// SinkCheckpointer is implemented by sinks that buffer documents,
+// Checkpoint is called with the last sequence of the batch before
+// the checkpoint is recorded on the source
+type SinkCheckpointer interface {
+	Checkpoint(ctx context.Context, lastSeq string) error
+}
+
+// SinkLogReader is implemented by sinks that record replication logs,
+// e.g. in the databases they write to. The log is compared with the
+// one of the source to find the common ancestry, client.ErrNotFound
+// replicates from the beginning.
+type SinkLogReader interface {
+	GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error)
+}
@@ -0,0 +1,87 @@
+package replicator
+
+import (
+	"context"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Source is the peer a replication reads the changes from, it is
+// implemented by client.Client for remote databases
+type Source interface {
+	// Check returns client.ErrNotFound if the database doesn't exist
+	Check(ctx context.Context) error
+	// Info returns the database information
+	Info(ctx context.Context) (*client.Info, error)
+
+	// GetReplicationLog returns the replication log with the given
+	// _local document id or client.ErrNotFound
+	GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error)
+	// RecordReplicationCheckpoint stores the replication log and returns
+	// its new revision
+	RecordReplicationCheckpoint(ctx context.Context, repLog *client.ReplicationLog, id string) (string, error)
+
+	// Changes returns the changes since the sequence of the options
+	Changes(ctx context.Context, opts client.ChangeOptions) (*client.ChangesResponse, error)
+	// GetDocumentComplete returns the document with the missing revisions
+	// and their changed attachments or client.ErrNotFound
+	GetDocumentComplete(ctx context.Context, docid string, diff *client.Diff) (*client.CompleteDoc, error)
+}
+
+var _ Source = (*client.Client)(nil)
+
+// PurgeSource is implemented by sources that expose their
+// purged revisions, see Config.PropagatePurges
+type PurgeSource interface {
+	// PurgedInfos returns the document revisions purged after the
+	// purge sequence since, all known ones if since is empty
+	PurgedInfos(ctx context.Context, since string) (*client.PurgedInfosResponse, error)
+}
+
+// BulkGetter is implemented by sources that fetch multiple documents
+// with a single request, see Config.BulkGetBatchSize
+type BulkGetter interface {
+	// BulkGet returns the requested document revisions, ErrNotFound
+	// if the source doesn't support it
+	BulkGet(ctx context.Context, docs []client.BulkGetRequest) ([]client.BulkGetResult, error)
+}
+
+// RevisionsGetter is implemented by sources that return the fetched
+// revisions of a document separately, documents with many missing
+// revisions are fetched in chunks, see Config.MaxRevsPerFetch
+type RevisionsGetter interface {
+	// GetDocumentRevisions returns the revisions of the document
+	// with their changed attachments or client.ErrNotFound
+	GetDocumentRevisions(ctx context.Context, docid string, revs []string) ([]client.BulkGetResult, error)
+}
+
+// ServerInformer is implemented by sources that can report the
+// version and features of their server, the replicator uses them to
+// choose the requests (e.g. _bulk_get). Without it the features are
+// detected by trying.
+type ServerInformer interface {
+	ServerInfo(ctx context.Context) (*client.ServerInfo, error)
+}
+
+// DocumentSizer is implemented by sources that can estimate
+// the size of a document revision, used by dry runs
+type DocumentSizer interface {
+	// DocumentSize returns the size of the revision in bytes
+	DocumentSize(ctx context.Context, docid, rev string) (int64, error)
+}
+
+// CheckpointRemover is implemented by peers that can
+// remove replication logs, used by Replicator.Reset
+type CheckpointRemover interface {
+	// RemoveReplicationCheckpoint deletes the replication log
+	RemoveReplicationCheckpoint(ctx context.Context, id, rev string) error
+}
+
+var (
+	_ PurgeSource       = (*client.Client)(nil)
+	_ BulkGetter        = (*client.Client)(nil)
+	_ RevisionsGetter   = (*client.Client)(nil)
+	_ ServerInformer    = (*client.Client)(nil)
+	_ DocumentSizer     = (*client.Client)(nil)
+	_ CheckpointRemover = (*client.Client)(nil)
+)
@@ -0,0 +1,219 @@
+package replicator
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the progress of a running replication
+type Stats struct {
+	DocsRead           int // documents read from the source
+	DocsWritten        int // documents written to the target
+	DocWriteFailures   int // documents the target refused
+	MissingChecked     int // revisions compared with the target
+	MissingFound       int // revisions missing on the target
+	DocsPending        int // changed documents of the current batch not yet replicated
+	DocsVerified       int // sampled documents compared with the target
+	DocsDivergent      int // sampled documents that differ on the target
+	CheckpointFailures int // checkpoints that couldn't be recorded
+
+	Seq      string // sequence of the last checkpoint
+	AckedSeq string // sequence up to which all changes are replicated
+	LastSeq  string // last sequence of the current batch of changes
+
+	BytesRead    int64 // bytes of documents and attachments read from the source
+	BytesWritten int64 // bytes of documents and attachments written to the target
+
+	DocsReadPerSec     float64 // rolling rate of documents read
+	DocsWrittenPerSec  float64 // rolling rate of documents written
+	BytesReadPerSec    float64 // rolling rate of bytes read
+	BytesWrittenPerSec float64 // rolling rate of bytes written
+}
+
+// Stats returns a snapshot of the replication progress, it is
+// safe to call while the replication is running
+func (r *Replicator) Stats() Stats {
+	return r.stats.snapshot(time.Now())
+}
+
+// ProgressFunc receives the progress of a running replication
+type ProgressFunc func(stats Stats)
+
+// SetProgress registers a function that is called with the progress of
+// the replication, at most once per interval and after every batch of
+// changes. The function is called from the replication goroutine.
+func (r *Replicator) SetProgress(fn ProgressFunc, interval time.Duration) {
+	r.progress = fn
+	r.progressInterval = interval
+}
+
+// updateStats publishes the progress of the session, force
+// calls the progress function regardless of the interval
+func (r *Replicator) updateStats(force bool) {
+	now := time.Now()
+
+	r.stats.update(func(s *stats) {
+		if h := r.currentHistory; h != nil {
+			s.docsRead = h.DocsRead
+			s.docsWritten = h.DocsWritten
+			s.docWriteFailures = h.DocWriteFailures
+			s.missingChecked = h.MissingChecked
+			s.missingFound = h.MissingFound
+		}
+		s.docsPending = r.tracker.remaining()
+		s.seq = r.checkpointSeq
+		s.ackedSeq = r.checkpointSeq
+		if seq := r.tracker.seq(); seq != "" && r.tracker.remaining() > 0 {
+			s.ackedSeq = seq
+		}
+		s.lastSeq = r.lastSeq
+	})
+
+	if r.progress == nil {
+		return
+	}
+	if !force && now.Sub(r.lastProgress) < r.progressInterval {
+		return
+	}
+	r.lastProgress = now
+	r.progress(r.stats.snapshot(now))
+}
+
+// stats collects the progress of a replication, safe for concurrent use
+type stats struct {
+	mu sync.Mutex
+
+	docsRead, docsWritten, docWriteFailures   int
+	missingChecked, missingFound, docsPending int
+	docsVerified, docsDivergent               int
+	checkpointFailures                        int
+	seq, ackedSeq, lastSeq                    string
+
+	bytesRead, bytesWritten int64
+
+	docsReadRate, docsWrittenRate   meter
+	bytesReadRate, bytesWrittenRate meter
+}
+
+func newStats(now time.Time) *stats {
+	return &stats{
+		docsReadRate:     newMeter(now),
+		docsWrittenRate:  newMeter(now),
+		bytesReadRate:    newMeter(now),
+		bytesWrittenRate: newMeter(now),
+	}
+}
+
+// reset clears the stats for a new run
+func (s *stats) reset(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.docsRead, s.docsWritten, s.docWriteFailures = 0, 0, 0
+	s.missingChecked, s.missingFound, s.docsPending = 0, 0, 0
+	s.docsVerified, s.docsDivergent = 0, 0
+	s.checkpointFailures = 0
+	s.seq, s.ackedSeq, s.lastSeq = "", "", ""
+	s.bytesRead, s.bytesWritten = 0, 0
+	s.docsReadRate = newMeter(now)
+	s.docsWrittenRate = newMeter(now)
+	s.bytesReadRate = newMeter(now)
+	s.bytesWrittenRate = newMeter(now)
+}
+
+// update changes the stats while holding the lock
+func (s *stats) update(fn func(s *stats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s)
+}
+
+func (s *stats) read(docs int, bytes int64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bytesRead += bytes
+	s.docsReadRate.add(int64(docs), now)
+	s.bytesReadRate.add(bytes, now)
+}
+
+func (s *stats) written(docs int, bytes int64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bytesWritten += bytes
+	s.docsWrittenRate.add(int64(docs), now)
+	s.bytesWrittenRate.add(bytes, now)
+}
+
+func (s *stats) snapshot(now time.Time) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Stats{
+		DocsRead:           s.docsRead,
+		DocsWritten:        s.docsWritten,
+		DocWriteFailures:   s.docWriteFailures,
+		MissingChecked:     s.missingChecked,
+		MissingFound:       s.missingFound,
+		DocsPending:        s.docsPending,
+		DocsVerified:       s.docsVerified,
+		DocsDivergent:      s.docsDivergent,
+		CheckpointFailures: s.checkpointFailures,
+		Seq:                s.seq,
+		AckedSeq:           s.ackedSeq,
+		LastSeq:            s.lastSeq,
+		BytesRead:          s.bytesRead,
+		BytesWritten:       s.bytesWritten,
+		DocsReadPerSec:     s.docsReadRate.rate(now),
+		DocsWrittenPerSec:  s.docsWrittenRate.rate(now),
+		BytesReadPerSec:    s.bytesReadRate.rate(now),
+		BytesWrittenPerSec: s.bytesWrittenRate.rate(now),
+	}
+}
+
+// meterBuckets number of one second buckets of the rolling window
+const meterBuckets = 10
+
+// meter measures a rolling rate per second over the last meterBuckets seconds
+type meter struct {
+	start   time.Time
+	values  [meterBuckets]int64
+	seconds [meterBuckets]int64 // unix second the value belongs to
+}
+
+func newMeter(now time.Time) meter {
+	return meter{start: now}
+}
+
+func (m *meter) add(n int64, now time.Time) {
+	sec := now.Unix()
+	i := sec % meterBuckets
+	if m.seconds[i] != sec {
+		m.seconds[i] = sec
+		m.values[i] = 0
+	}
+	m.values[i] += n
+}
+
+func (m *meter) rate(now time.Time) float64 {
+	sec := now.Unix()
+
+	var sum int64
+	for i := range m.values {
+		if sec-m.seconds[i] < meterBuckets {
+			sum += m.values[i]
+		}
+	}
+
+	// the window is smaller while the meter is young
+	window := now.Sub(m.start).Seconds()
+	if window > meterBuckets {
+		window = meterBuckets
+	} else if window < 1 {
+		window = 1
+	}
+
+	return float64(sum) / window
+}
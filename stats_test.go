@@ -0,0 +1,28 @@
+package replicator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeterRate(t *testing.T) {
+	start := time.Unix(1000, 0)
+	m := newMeter(start)
+
+	// young meter, rate over the elapsed time
+	m.add(10, start)
+	m.add(10, start.Add(time.Second))
+	assert.Equal(t, 10.0, m.rate(start.Add(2*time.Second)))
+
+	// full window
+	for i := 2; i < 20; i++ {
+		m.add(5, start.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(t, 5.0, m.rate(start.Add(19*time.Second)))
+
+	// values outside of the window are dropped
+	assert.Equal(t, 2.5, m.rate(start.Add(24*time.Second)))
+	assert.Equal(t, 0.0, m.rate(start.Add(60*time.Second)))
+}
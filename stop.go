@@ -0,0 +1,122 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+
+	"github.com/goydb/replicator/client"
+)
+
+// errStopped aborts the replication of a batch after Stop was called
+var errStopped = errors.New("replication stopped")
+
+// Cancel requests a graceful stop of the running replication without
+// waiting for it. The in-flight documents are written and a final
+// checkpoint is recorded before Run returns.
+func (r *Replicator) Cancel() {
+	r.stopMu.Lock()
+	defer r.stopMu.Unlock()
+
+	if r.stop == nil {
+		return
+	}
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+}
+
+// Stop gracefully stops the running replication like Cancel and waits
+// until Run returned. It returns the result of the replication, or the
+// context error if the replication didn't stop in time.
+func (r *Replicator) Stop(ctx context.Context) (Result, error) {
+	r.Cancel()
+
+	r.stopMu.Lock()
+	done := r.done
+	r.stopMu.Unlock()
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		}
+	}
+
+	return r.Result(), nil
+}
+
+// startRun prepares the stop signal of a run, the returned
+// function has to be called once Run returns
+func (r *Replicator) startRun() func() {
+	r.stopMu.Lock()
+	defer r.stopMu.Unlock()
+
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	done := r.done
+	return func() {
+		close(done)
+	}
+}
+
+// stopRequested returns true if Cancel or Stop was called
+func (r *Replicator) stopRequested() bool {
+	r.stopMu.Lock()
+	stop := r.stop
+	r.stopMu.Unlock()
+
+	if stop == nil {
+		return false
+	}
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopSignal returns the channel closed by Cancel, nil outside of Run
+func (r *Replicator) stopSignal() <-chan struct{} {
+	r.stopMu.Lock()
+	defer r.stopMu.Unlock()
+	return r.stop
+}
+
+// idleStopCheckpoint records a final checkpoint of the changes read
+// while waiting for new ones (e.g. filtered out or resolved since=now),
+// no documents are in flight. It returns errStopped.
+func (r *Replicator) idleStopCheckpoint(ctx context.Context) error {
+	seq := r.sourceLastSeq
+	if seq != r.checkpointSeq && seq != NoVersion && seq != client.SinceNow {
+		err := r.checkpoint(ctx, seq)
+		if err != nil {
+			return err
+		}
+	}
+	return errStopped
+}
+
+// stopCheckpoint writes the stacked documents and records a final
+// checkpoint of the replicated changes, it returns errStopped
+func (r *Replicator) stopCheckpoint(ctx context.Context, stack client.Stack) error {
+	if len(stack) > 0 {
+		err := r.replicateChangesBulk(ctx, stack)
+		if err != nil {
+			return err
+		}
+	}
+
+	seq := r.tracker.seq()
+	if seq != "" && seq != r.checkpointSeq {
+		err := r.checkpoint(ctx, seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errStopped
+}
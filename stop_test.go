@@ -0,0 +1,169 @@
+package replicator_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type checkpointHooks struct {
+	replicator.NopHooks
+	checkpoints []string
+	completed   bool
+}
+
+func (h *checkpointHooks) OnCheckpoint(seq string) {
+	h.checkpoints = append(h.checkpoints, seq)
+}
+
+func (h *checkpointHooks) OnComplete(result replicator.Result, err error) {
+	h.completed = result.Stopped && err == nil
+}
+
+// changesServer serves the changes a, b and c (seq 1-3)
+// and stores the checkpoint
+func changesServer(t *testing.T, checkpoint *client.ReplicationLog) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/db/")
+		switch {
+		case path == "":
+			fmt.Fprint(w, `{"db_name":"db","update_seq":"3"}`)
+		case path == "_changes":
+			fmt.Fprint(w, `{"results":[
+				{"seq":"1","id":"a","changes":[{"rev":"1-a"}]},
+				{"seq":"2","id":"b","changes":[{"rev":"1-b"}]},
+				{"seq":"3","id":"c","changes":[{"rev":"1-c"}]}
+			],"last_seq":"3"}`)
+		case strings.HasPrefix(path, "_local/") && r.Method == http.MethodPut:
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(checkpoint))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"ok":true,"rev":"0-1"}`)
+		case strings.HasPrefix(path, "_local/"):
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		default:
+			mw := multipart.NewWriter(w)
+			w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+			pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+			fmt.Fprintf(pw, `{"_id":%q,"_rev":"1-%s"}`, path, path)
+			_ = mw.Close()
+		}
+	}))
+}
+
+func TestStop(t *testing.T) {
+	var checkpoint client.ReplicationLog
+	srv := changesServer(t, &checkpoint)
+	defer srv.Close()
+
+	var r *replicator.Replicator
+	var received int
+	job := &replicator.Job{
+		Source: &client.Remote{URL: srv.URL + "/db/"},
+	}
+	job.Sink = replicator.SinkFunc(func(ctx context.Context, doc *client.CompleteDoc) error {
+		received++
+		r.Cancel()
+		return nil
+	})
+
+	r, err := replicator.NewReplicator("stop", job)
+	assert.NoError(t, err)
+	var progress replicator.Stats
+	r.SetProgress(func(stats replicator.Stats) {
+		progress = stats
+	}, 0)
+	hooks := new(checkpointHooks)
+	r.SetHooks(hooks)
+
+	err = r.Run(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := r.Stop(ctx)
+	assert.NoError(t, err)
+	assert.True(t, result.Stopped)
+	assert.Equal(t, 1, received)
+	assert.Equal(t, "1", checkpoint.SourceLastSeq)
+	assert.Equal(t, []string{"1"}, hooks.checkpoints)
+	assert.True(t, hooks.completed)
+
+	stats := r.Stats()
+	assert.Equal(t, stats.DocsWritten, progress.DocsWritten)
+	assert.Equal(t, 1, stats.DocsWritten)
+	assert.Equal(t, 2, stats.DocsPending)
+	assert.Equal(t, "1", stats.Seq)
+	assert.Equal(t, "1", stats.AckedSeq)
+	assert.Equal(t, "3", stats.LastSeq)
+}
+
+func TestPartialCheckpoint(t *testing.T) {
+	var checkpoint client.ReplicationLog
+	srv := changesServer(t, &checkpoint)
+	defer srv.Close()
+
+	errSink := errors.New("sink failed")
+	job := &replicator.Job{
+		Source: &client.Remote{URL: srv.URL + "/db/"},
+	}
+	job.Sink = replicator.SinkFunc(func(ctx context.Context, doc *client.CompleteDoc) error {
+		if doc.ID == "c" {
+			return errSink
+		}
+		return nil
+	})
+
+	r, err := replicator.NewReplicator("partial", job)
+	assert.NoError(t, err)
+
+	// the changes written before the failure are checkpointed
+	err = r.Run(context.Background())
+	assert.ErrorIs(t, err, errSink)
+	assert.Equal(t, "2", checkpoint.SourceLastSeq)
+	assert.Equal(t, "2", r.Result().Checkpoint.Seq)
+}
+
+func TestCancelIdle(t *testing.T) {
+	source := newMemPeer(map[string]interface{}{"_id": "a", "_rev": "1-a"})
+	target := newMemPeer()
+
+	job := &replicator.Job{
+		Source:     &client.Remote{URL: "mem://source"},
+		Target:     &client.Remote{URL: "mem://target"},
+		Continuous: true,
+	}
+	r, err := replicator.NewReplicatorWithPeers("mem", job, source, target)
+	assert.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(context.Background())
+	}()
+
+	// waiting for new changes after the document was replicated
+	time.Sleep(2500 * time.Millisecond)
+	r.Cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("idle replication didn't stop")
+	}
+	assert.True(t, r.Result().Stopped)
+	assert.Len(t, target.docs, 1)
+	assert.Equal(t, "1", r.Result().Checkpoint.Seq)
+}
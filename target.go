@@ -0,0 +1,90 @@
+package replicator
+
+import (
+	"context"
+
+	"github.com/goydb/replicator/client"
+)
+
+// Target is the receiving peer of a replication, it is implemented
+// by client.Client for remote databases
+type Target interface {
+	// Check returns client.ErrNotFound if the database doesn't exist
+	Check(ctx context.Context) error
+	// Create creates the database
+	Create(ctx context.Context) error
+	// Info returns the database information
+	Info(ctx context.Context) (*client.Info, error)
+
+	// GetReplicationLog returns the replication log with the given
+	// _local document id or client.ErrNotFound
+	GetReplicationLog(ctx context.Context, id string) (*client.ReplicationLog, error)
+	// RecordReplicationCheckpoint stores the replication log and returns
+	// its new revision
+	RecordReplicationCheckpoint(ctx context.Context, repLog *client.ReplicationLog, id string) (string, error)
+
+	// RevDiff returns the revisions that are missing on the target
+	RevDiff(ctx context.Context, r client.RevDiffRequest) (client.DiffResponse, error)
+	// BulkDocs stores the documents with their revisions (new_edits=false)
+	// and returns the documents that couldn't be stored
+	BulkDocs(ctx context.Context, stack *client.Stack) ([]client.BulkDocsResult, error)
+	// UploadDocumentWithAttachments stores a document with its revision
+	// and attachments (new_edits=false)
+	UploadDocumentWithAttachments(ctx context.Context, doc *client.CompleteDoc) error
+	// EnsureFullCommit persists all changes
+	EnsureFullCommit(ctx context.Context) error
+}
+
+var _ Target = (*client.Client)(nil)
+
+// PurgeTarget is implemented by targets that can purge
+// document revisions, see Config.PropagatePurges
+type PurgeTarget interface {
+	// Purge removes the revisions of the documents
+	Purge(ctx context.Context, r client.PurgeRequest) (client.PurgeResponse, error)
+}
+
+// ConflictFinder is implemented by targets that report the conflicting
+// revisions of documents, see Config.DetectConflicts
+type ConflictFinder interface {
+	// FindConflicts returns the documents with conflicting revisions
+	FindConflicts(ctx context.Context, ids []string) ([]client.DocConflicts, error)
+}
+
+// DocDeleter is implemented by targets that can delete document
+// revisions, used to resolve conflicts, see Config.ConflictResolver
+type DocDeleter interface {
+	// DeleteDoc deletes the (winning or conflicting) revision
+	DeleteDoc(ctx context.Context, id, rev string) error
+}
+
+// EncodedAttachmentsTarget is implemented by targets that store inline
+// attachments with their encoding (encoding and encoded_length), see
+// Config.PreserveAttachmentEncoding
+type EncodedAttachmentsTarget interface {
+	Target
+	// AcceptsEncodedAttachments marks the capability
+	AcceptsEncodedAttachments()
+}
+
+// TargetCreator is implemented by targets that are created with
+// the Job.CreateTargetParams and Job.CreateTargetHeaders
+type TargetCreator interface {
+	// CreateWithOptions creates the database
+	CreateWithOptions(ctx context.Context, opts client.CreateOptions) error
+}
+
+// DocReader is implemented by targets that can read document
+// revisions, required by Config.VerifySamples
+type DocReader interface {
+	// GetDoc reads the revision of the document into v
+	GetDoc(ctx context.Context, id, rev string, v interface{}) error
+}
+
+var (
+	_ PurgeTarget    = (*client.Client)(nil)
+	_ TargetCreator  = (*client.Client)(nil)
+	_ DocReader      = (*client.Client)(nil)
+	_ ConflictFinder = (*client.Client)(nil)
+	_ DocDeleter     = (*client.Client)(nil)
+)
@@ -0,0 +1,137 @@
+package replicator
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/goydb/replicator/client"
+)
+
+// TemplateVars are the variables of a templated job spec, e.g.
+// {"Tenant": "acme", "Env": "prod"} for the source url
+// "https://{{.Env}}.example.com/{{.Tenant}}". Variables that are
+// not set are looked up in the environment.
+type TemplateVars map[string]string
+
+// data returns the environment overlaid with the variables
+func (v TemplateVars) data() map[string]string {
+	data := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			data[kv[:i]] = kv[i+1:]
+		}
+	}
+	for key, value := range v {
+		data[key] = value
+	}
+	return data
+}
+
+// ExpandJob returns a copy of the job spec with the templates of the id,
+// the source and target urls and headers, the filter and its query
+// parameters resolved. Unknown variables are an error.
+func ExpandJob(spec *Job, vars TemplateVars) (*Job, error) {
+	data := vars.data()
+	expand := func(field, text string) (string, error) {
+		if !strings.Contains(text, "{{") {
+			return text, nil
+		}
+
+		t, err := template.New(field).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("template of %s: %w", field, err)
+		}
+		var b strings.Builder
+		err = t.Execute(&b, data)
+		if err != nil {
+			return "", fmt.Errorf("template of %s: %w", field, err)
+		}
+		return b.String(), nil
+	}
+	expandMap := func(field string, m map[string]string) (map[string]string, error) {
+		if m == nil {
+			return nil, nil
+		}
+		result := make(map[string]string, len(m))
+		for key, value := range m {
+			v, err := expand(field+"."+key, value)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = v
+		}
+		return result, nil
+	}
+	expandRemote := func(field string, r *client.Remote) (*client.Remote, error) {
+		if r == nil {
+			return nil, nil
+		}
+		remote := *r
+		var err error
+		remote.URL, err = expand(field+".url", r.URL)
+		if err != nil {
+			return nil, err
+		}
+		remote.Headers, err = expandMap(field+".headers", r.Headers)
+		if err != nil {
+			return nil, err
+		}
+		return &remote, nil
+	}
+
+	job := *spec
+	var err error
+	job.ID, err = expand("id", spec.ID)
+	if err != nil {
+		return nil, err
+	}
+	job.Source, err = expandRemote("source", spec.Source)
+	if err != nil {
+		return nil, err
+	}
+	job.Target, err = expandRemote("target", spec.Target)
+	if err != nil {
+		return nil, err
+	}
+	job.Filter, err = expand("filter", spec.Filter)
+	if err != nil {
+		return nil, err
+	}
+	job.QueryParams, err = expandMap("query_params", spec.QueryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// AddTemplatedJobs adds one job per set of variables generated from
+// the job spec, see ExpandJob. No job is added if one of them fails.
+func (m *Manager) AddTemplatedJobs(spec *Job, vars ...TemplateVars) error {
+	jobs := make([]*Job, 0, len(vars))
+	for _, v := range vars {
+		job, err := ExpandJob(spec, v)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		if _, ok := m.jobs[job.ID]; ok || ids[job.ID] {
+			return fmt.Errorf("%w: %q", ErrJobExists, job.ID)
+		}
+		ids[job.ID] = true
+	}
+	for _, job := range jobs {
+		m.jobs[job.ID] = job
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package replicator_test
+
+import (
+	"testing"
+
+	"github.com/goydb/replicator"
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplatedJobs(t *testing.T) {
+	t.Setenv("REPLICATOR_ENV", "prod")
+
+	spec := &replicator.Job{
+		ID:     "{{.Tenant}}-backup",
+		Source: &client.Remote{URL: "https://{{.REPLICATOR_ENV}}.example.com/{{.Tenant}}"},
+		Target: &client.Remote{
+			URL:     "https://backup.example.com/{{.Tenant}}",
+			Headers: map[string]string{"X-Tenant": "{{.Tenant}}"},
+		},
+	}
+
+	m := replicator.NewManager("test")
+	err := m.AddTemplatedJobs(spec,
+		replicator.TemplateVars{"Tenant": "acme"},
+		replicator.TemplateVars{"Tenant": "initech", "REPLICATOR_ENV": "staging"},
+	)
+	assert.NoError(t, err)
+
+	jobs := m.Jobs()
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, "acme-backup", jobs[0].ID)
+		assert.Equal(t, "https://prod.example.com/acme", jobs[0].Source.URL)
+		assert.Equal(t, "acme", jobs[0].Target.Headers["X-Tenant"])
+		assert.Equal(t, "https://staging.example.com/initech", jobs[1].Source.URL)
+	}
+	// the spec is unchanged
+	assert.Equal(t, "{{.Tenant}}", spec.Target.Headers["X-Tenant"])
+
+	_, err = replicator.ExpandJob(spec, replicator.TemplateVars{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package replicator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/goydb/replicator/client"
+)
+
+var (
+	ErrInvalidEdge = errors.New("invalid topology edge")
+	ErrUnknownNode = errors.New("unknown topology node")
+)
+
+// Topology defines the replications between a set of databases in one
+// place, e.g. {"edges": ["a->b", "b->c", "a<->d"]}. The Manager
+// materializes it into one job per replication direction.
+type Topology struct {
+	// Nodes are the databases of the topology by name
+	Nodes map[string]*client.Remote `json:"nodes"`
+	// Edges are the replications between the nodes
+	Edges []Edge `json:"edges"`
+	// Defaults are shared by all jobs of the topology,
+	// source, target and id are set per edge
+	Defaults Job `json:"defaults"`
+}
+
+// Edge is a replication from one node to another, or in
+// both directions if bidirectional
+type Edge struct {
+	From, To      string
+	Bidirectional bool
+}
+
+// ParseEdge parses an edge of the form "a->b" or "a<->b"
+func ParseEdge(s string) (Edge, error) {
+	sep, bidirectional := "->", false
+	if strings.Contains(s, "<->") {
+		sep, bidirectional = "<->", true
+	}
+
+	parts := strings.Split(s, sep)
+	if len(parts) != 2 {
+		return Edge{}, fmt.Errorf("%w: %q", ErrInvalidEdge, s)
+	}
+	e := Edge{
+		From:          strings.TrimSpace(parts[0]),
+		To:            strings.TrimSpace(parts[1]),
+		Bidirectional: bidirectional,
+	}
+	if e.From == "" || e.To == "" || e.From == e.To {
+		return Edge{}, fmt.Errorf("%w: %q", ErrInvalidEdge, s)
+	}
+	return e, nil
+}
+
+func (e Edge) String() string {
+	if e.Bidirectional {
+		return e.From + "<->" + e.To
+	}
+	return e.From + "->" + e.To
+}
+
+func (e Edge) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+func (e *Edge) UnmarshalText(text []byte) error {
+	edge, err := ParseEdge(string(text))
+	if err != nil {
+		return err
+	}
+	*e = edge
+	return nil
+}
+
+// TopologyJobID returns the id of the job replicating from one node to another
+func TopologyJobID(from, to string) string {
+	return from + "-to-" + to
+}
+
+// Jobs returns the jobs of the topology ordered by their id,
+// bidirectional edges result in two jobs
+func (t *Topology) Jobs() ([]*Job, error) {
+	jobs := make(map[string]*Job)
+	add := func(from, to string) error {
+		source, ok := t.Nodes[from]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownNode, from)
+		}
+		target, ok := t.Nodes[to]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownNode, to)
+		}
+
+		job := t.Defaults
+		job.ID = TopologyJobID(from, to)
+		job.Rev = ""
+		job.Source = source
+		job.Target = target
+		jobs[job.ID] = &job
+		return nil
+	}
+
+	for _, edge := range t.Edges {
+		err := add(edge.From, edge.To)
+		if err != nil {
+			return nil, err
+		}
+		if edge.Bidirectional {
+			err = add(edge.To, edge.From)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		result = append(result, job)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// AddTopology adds the jobs of the topology to the manager, no job
+// is added if the topology is invalid or a job already exists
+func (m *Manager) AddTopology(t *Topology) error {
+	jobs, err := t.Jobs()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range jobs {
+		if _, ok := m.jobs[job.ID]; ok {
+			return fmt.Errorf("%w: %q", ErrJobExists, job.ID)
+		}
+	}
+	for _, job := range jobs {
+		m.jobs[job.ID] = job
+	}
+
+	return nil
+}
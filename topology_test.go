@@ -0,0 +1,50 @@
+package replicator_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/goydb/replicator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopology(t *testing.T) {
+	var topology replicator.Topology
+	err := json.Unmarshal([]byte(`{
+		"nodes": {
+			"a": {"url": "http://localhost:5984/a"},
+			"b": {"url": "http://localhost:5984/b"},
+			"c": {"url": "http://localhost:5984/c"},
+			"d": {"url": "http://localhost:5984/d"}
+		},
+		"edges": ["a->b", "b->c", "a<->d"],
+		"defaults": {"continuous": true, "create_target": true}
+	}`), &topology)
+	assert.NoError(t, err)
+
+	m := replicator.NewManager("test")
+	err = m.AddTopology(&topology)
+	assert.NoError(t, err)
+
+	jobs := m.Jobs()
+	var ids []string
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+		assert.True(t, job.Continuous)
+		assert.True(t, job.CreateTarget)
+	}
+	assert.Equal(t, []string{"a-to-b", "a-to-d", "b-to-c", "d-to-a"}, ids)
+	assert.Equal(t, "http://localhost:5984/d", jobs[3].Source.URL)
+	assert.Equal(t, "http://localhost:5984/a", jobs[3].Target.URL)
+
+	// adding the topology again conflicts with the existing jobs
+	err = m.AddTopology(&topology)
+	assert.ErrorIs(t, err, replicator.ErrJobExists)
+
+	topology.Edges = append(topology.Edges, replicator.Edge{From: "a", To: "x"})
+	_, err = topology.Jobs()
+	assert.ErrorIs(t, err, replicator.ErrUnknownNode)
+
+	_, err = replicator.ParseEdge("a-b")
+	assert.ErrorIs(t, err, replicator.ErrInvalidEdge)
+}
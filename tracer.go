@@ -0,0 +1,51 @@
+package replicator
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer creates spans for the steps of the replication protocol, see
+// the otelreplicator module for an OpenTelemetry implementation
+type Tracer interface {
+	// Start starts a span for the step, the returned function
+	// ends the span with the result of the step
+	Start(ctx context.Context, name string) (context.Context, func(err error))
+	// Transport wraps the transport of the source and target
+	// clients, e.g. to create spans and propagate trace headers
+	Transport(rt http.RoundTripper) http.RoundTripper
+}
+
+// nopTracer doesn't trace
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, func(err error)) {
+	return ctx, func(err error) {}
+}
+
+func (nopTracer) Transport(rt http.RoundTripper) http.RoundTripper {
+	return rt
+}
+
+// SetTracer sets the tracer of the replication and its clients
+func (r *Replicator) SetTracer(tracer Tracer) {
+	if tracer == nil {
+		tracer = nopTracer{}
+	}
+	r.tracer = tracer
+
+	hc := &http.Client{Transport: tracer.Transport(http.DefaultTransport)}
+	for _, peer := range r.peers() {
+		if c, ok := peer.(interface{ SetHTTPClient(*http.Client) }); ok {
+			c.SetHTTPClient(hc)
+		}
+	}
+}
+
+// trace runs the step in a span
+func (r *Replicator) trace(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ctx, end := r.tracer.Start(ctx, name)
+	err := fn(ctx)
+	end(err)
+	return err
+}
@@ -0,0 +1,161 @@
+package replicator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+// verifySample is a replicated document revision and the digest of its body
+type verifySample struct {
+	id, rev, digest string
+}
+
+// verifySampler selects documents uniformly from the replicated
+// documents (reservoir sampling)
+type verifySampler struct {
+	size    int
+	seen    int
+	samples []verifySample
+	rand    *rand.Rand
+}
+
+func newVerifySampler(size int) *verifySampler {
+	return &verifySampler{
+		size: size,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())), // nolint: gosec
+	}
+}
+
+// add considers the document for sampling
+func (s *verifySampler) add(doc *client.CompleteDoc) {
+	if s == nil {
+		return
+	}
+
+	s.seen++
+	i := len(s.samples)
+	if i >= s.size {
+		i = s.rand.Intn(s.seen)
+		if i >= s.size {
+			return
+		}
+	}
+
+	rev, _ := doc.Data["_rev"].(string)
+	digest, err := docDigest(doc.Data)
+	if err != nil {
+		return
+	}
+	sample := verifySample{id: doc.ID, rev: rev, digest: digest}
+	if i == len(s.samples) {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[i] = sample
+	}
+}
+
+// remove drops the document, e.g. if it wasn't written
+func (s *verifySampler) remove(docID string) {
+	if s == nil {
+		return
+	}
+	for i := 0; i < len(s.samples); i++ {
+		if s.samples[i].id == docID {
+			s.samples = append(s.samples[:i], s.samples[i+1:]...)
+			i--
+		}
+	}
+}
+
+// take returns the samples and starts a new sampling period
+func (s *verifySampler) take() []verifySample {
+	samples := s.samples
+	s.samples = nil
+	s.seen = 0
+	return samples
+}
+
+// docDigest hashes the body of the document and the digests of its
+// attachments, the fields that depend on how the document was read
+// (revision history, conflicts, attachment data) are ignored
+func docDigest(data map[string]interface{}) (string, error) {
+	body := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		switch key {
+		case "_revisions", "_conflicts", "_revs_info":
+		case "_attachments":
+			atts, _ := value.(map[string]interface{})
+			digests := make(map[string]interface{}, len(atts))
+			for name, att := range atts {
+				if att, ok := att.(map[string]interface{}); ok {
+					digests[name] = att["digest"]
+				}
+			}
+			body[key] = digests
+		default:
+			body[key] = value
+		}
+	}
+
+	// maps are encoded with sorted keys
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifyDue compares the sampled documents with the
+// target once the verification interval passed
+func (r *Replicator) verifyDue(ctx context.Context) {
+	if r.sampler == nil || time.Since(r.lastVerify) < r.job.VerifyIntervalOrFallback() {
+		return
+	}
+	r.lastVerify = time.Now()
+
+	err := r.trace(ctx, "Verify", r.verifySamples)
+	if err != nil {
+		r.logger.Warningf("Verification of the sampled documents failed: %v", err)
+	}
+}
+
+// verifySamples reads the sampled revisions from the target
+// and compares their digests
+func (r *Replicator) verifySamples(ctx context.Context) error {
+	for _, sample := range r.sampler.take() {
+		var data map[string]interface{}
+		err := r.target.(DocReader).GetDoc(ctx, sample.id, sample.rev, &data)
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			return err
+		}
+
+		var digest string
+		if err == nil {
+			digest, err = docDigest(data)
+			if err != nil {
+				return err
+			}
+		}
+
+		divergent := digest != sample.digest
+		if divergent {
+			r.logger.Warningf("Document %q revision %q differs on the target", sample.id, sample.rev)
+		}
+		r.stats.update(func(s *stats) {
+			s.docsVerified++
+			if divergent {
+				s.docsDivergent++
+			}
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySamples(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "1-x", r.URL.Query().Get("rev"))
+		switch r.URL.Path {
+		case "/target/a":
+			fmt.Fprint(w, `{"_id":"a","_rev":"1-x","v":1,"_attachments":{"f":{"stub":true,"digest":"md5-1"}}}`)
+		case "/target/b":
+			fmt.Fprint(w, `{"_id":"b","_rev":"1-x","v":2}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+		}
+	}))
+	defer srv.Close()
+
+	r, err := NewReplicator("verify", &Job{
+		Source: &client.Remote{URL: srv.URL + "/source"},
+		Target: &client.Remote{URL: srv.URL + "/target"},
+	})
+	assert.NoError(t, err)
+
+	r.sampler = newVerifySampler(3)
+	for _, doc := range []*client.CompleteDoc{
+		{ID: "a", Data: map[string]interface{}{"_id": "a", "_rev": "1-x", "v": 1.0,
+			"_revisions":   map[string]interface{}{"start": 1.0},
+			"_attachments": map[string]interface{}{"f": map[string]interface{}{"follows": true, "digest": "md5-1"}}}},
+		{ID: "b", Data: map[string]interface{}{"_id": "b", "_rev": "1-x", "v": 1.0}},
+		{ID: "c", Data: map[string]interface{}{"_id": "c", "_rev": "1-x"}},
+	} {
+		r.sampler.add(doc)
+	}
+
+	assert.NoError(t, r.verifySamples(context.Background()))
+	stats := r.Stats()
+	assert.Equal(t, 3, stats.DocsVerified)
+	assert.Equal(t, 2, stats.DocsDivergent)
+	assert.Len(t, r.sampler.samples, 0)
+}
+
+func TestVerifySampler(t *testing.T) {
+	s := newVerifySampler(2)
+	for i := 0; i < 100; i++ {
+		s.add(&client.CompleteDoc{ID: fmt.Sprint(i), Data: map[string]interface{}{}})
+	}
+	assert.Len(t, s.samples, 2)
+	assert.Equal(t, 100, s.seen)
+
+	s.remove(s.samples[0].id)
+	assert.Len(t, s.samples, 1)
+}
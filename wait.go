@@ -0,0 +1,181 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goydb/replicator/client"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// seqWaiters notifies about recorded checkpoints
+type seqWaiters struct {
+	seq     string
+	changed chan struct{} // closed and replaced with every checkpoint
+}
+
+// checkpointed publishes the sequence of a recorded checkpoint
+func (r *Replicator) checkpointed(seq string) {
+	r.waitMu.Lock()
+	defer r.waitMu.Unlock()
+
+	r.waiters.seq = seq
+	if r.waiters.changed != nil {
+		close(r.waiters.changed)
+		r.waiters.changed = nil
+	}
+}
+
+// WaitForSeq blocks until the source sequence seq (e.g. the update
+// sequence returned by a write to the source) is checkpointed on the
+// target, so that reads of the target observe the write. Sequences
+// are compared by their numeric part.
+func (r *Replicator) WaitForSeq(ctx context.Context, seq string) error {
+	for {
+		r.waitMu.Lock()
+		if r.waiters.seq != "" && seqReached(r.waiters.seq, seq) {
+			r.waitMu.Unlock()
+			return nil
+		}
+		if r.waiters.changed == nil {
+			r.waiters.changed = make(chan struct{})
+		}
+		changed := r.waiters.changed
+		r.waitMu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// defaultWaitInterval is the polling interval of Manager.WaitForSeq
+const defaultWaitInterval = time.Second
+
+// WaitForSeq blocks until the source sequence seq is checkpointed on the
+// target of the job. The replication log of the target is polled in the
+// interval (defaults to 1s), the replications are run independently of
+// the manager.
+func (m *Manager) WaitForSeq(ctx context.Context, jobID, seq string, interval time.Duration) error {
+	job, ok := m.Job(jobID)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrJobNotFound, jobID)
+	}
+	if interval <= 0 {
+		interval = defaultWaitInterval
+	}
+
+	// sink replications record their checkpoints only on the source
+	remote := job.Target
+	if remote == nil {
+		remote = job.Source
+	}
+	c, err := client.NewClient(remote)
+	if err != nil {
+		return err
+	}
+	c.SetLogger(m.logger)
+
+	id := job.CheckpointPrefix + job.GenerateReplicationID(m.name)
+	for {
+		repLog, err := c.GetReplicationLog(ctx, id)
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			return err
+		}
+		if err == nil && repLog.SourceLastSeq != "" && seqReached(repLog.SourceLastSeq, seq) {
+			return nil
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// docReplicated returns true if the revision of the document is stored on the peer
+func docReplicated(ctx context.Context, peer Target, docID, rev string) (bool, error) {
+	diff, err := peer.RevDiff(ctx, client.RevDiffRequest{docID: {rev}})
+	if err != nil {
+		return false, err
+	}
+	d, ok := diff[docID]
+	return !ok || len(d.Missing) == 0, nil
+}
+
+// WaitForDoc blocks until the revision rev of the document written on the
+// source is stored on the target. The target is checked initially and
+// after every checkpoint of the running replication.
+func (r *Replicator) WaitForDoc(ctx context.Context, docID, rev string) error {
+	if r.target == nil {
+		return ErrNoTarget
+	}
+
+	for {
+		// subscribe before checking, no checkpoint is missed
+		r.waitMu.Lock()
+		if r.waiters.changed == nil {
+			r.waiters.changed = make(chan struct{})
+		}
+		changed := r.waiters.changed
+		r.waitMu.Unlock()
+
+		ok, err := docReplicated(ctx, r.target, docID, rev)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WaitForDoc blocks until the revision rev of the document is stored on
+// the target of the job. The target is polled in the interval (defaults
+// to 1s), the replications are run independently of the manager.
+func (m *Manager) WaitForDoc(ctx context.Context, jobID, docID, rev string, interval time.Duration) error {
+	job, ok := m.Job(jobID)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrJobNotFound, jobID)
+	}
+	if job.Target == nil {
+		return ErrNoTarget
+	}
+	if interval <= 0 {
+		interval = defaultWaitInterval
+	}
+
+	c, err := client.NewClient(job.Target)
+	if err != nil {
+		return err
+	}
+	c.SetLogger(m.logger)
+
+	for {
+		ok, err := docReplicated(ctx, c, docID, rev)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goydb/replicator/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForSeq(t *testing.T) {
+	r := new(Replicator)
+	r.checkpointed("1-a")
+
+	done := make(chan error)
+	go func() {
+		done <- r.WaitForSeq(context.Background(), "3-c")
+	}()
+
+	r.checkpointed("2-b")
+	r.checkpointed("3-x")
+	assert.NoError(t, <-done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, r.WaitForSeq(ctx, "4"), context.DeadlineExceeded)
+}
+
+func TestManagerWaitForSeq(t *testing.T) {
+	var polls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls < 3 {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not_found","reason":"missing"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"_id":%q,"source_last_seq":"5-e"}`, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	m := NewManager("test")
+	err := m.AddJob(&Job{
+		ID:     "job",
+		Source: &client.Remote{URL: srv.URL + "/source"},
+		Target: &client.Remote{URL: srv.URL + "/target"},
+	})
+	assert.NoError(t, err)
+
+	err = m.WaitForSeq(context.Background(), "job", "5", time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, polls)
+
+	err = m.WaitForSeq(context.Background(), "unknown", "5", time.Millisecond)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestManagerWaitForDoc(t *testing.T) {
+	var polls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/target/_revs_diff", r.URL.Path)
+		polls++
+		if polls < 2 {
+			fmt.Fprint(w, `{"a":{"missing":["2-b"]}}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+
+	m := NewManager("test")
+	err := m.AddJob(&Job{
+		ID:     "job",
+		Source: &client.Remote{URL: srv.URL + "/source"},
+		Target: &client.Remote{URL: srv.URL + "/target"},
+	})
+	assert.NoError(t, err)
+
+	err = m.WaitForDoc(context.Background(), "job", "a", "2-b", time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, polls)
+}